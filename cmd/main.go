@@ -1,6 +1,10 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -10,33 +14,122 @@ import (
 	"github.com/unbeman/av-prac-task/internal/app"
 	"github.com/unbeman/av-prac-task/internal/config"
 	"github.com/unbeman/av-prac-task/internal/logging"
+	"github.com/unbeman/av-prac-task/internal/tracing"
 )
 
 // @title Dynamic user segments server
 // @version 1.0
 // @description Avito homework.
 // @BasePath /api/v1
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT signed with HS256 or RS256, "Bearer <token>".
 func main() {
-	cfg, err := config.GetAppConfig()
+	args := os.Args[1:]
+	if len(args) >= 2 && args[0] == "config" && args[1] == "print" {
+		printConfig(args[2:])
+		return
+	}
+
+	cfg, err := config.GetAppConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		log.Error("can't get config: ", err)
 		return
 	}
+	if err = cfg.Validate(); err != nil {
+		log.Error("can't get config: ", err)
+		return
+	}
+
+	if err = logging.InitLogger(cfg.Logger); err != nil {
+		log.Error("can't init logger: ", err)
+		return
+	}
+
+	shutdownTracer, err := tracing.InitTracer(cfg.Tracing)
+	if err != nil {
+		log.Error("can't init tracing: ", err)
+		return
+	}
+	defer func() {
+		if err := shutdownTracer(context.Background()); err != nil {
+			log.Error("can't flush traces: ", err)
+		}
+	}()
 
-	logging.InitLogger(cfg.Logger)
 	sapp, err := app.GetSegApp(cfg)
 	if err != nil {
 		log.Error(err)
 		return
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT)
 
 		<-c
+		cancel()
 		sapp.Stop()
 	}()
 
+	go watchConfig(ctx, sapp, cfg, args)
+
 	sapp.Run()
 }
+
+// watchConfig reloads the config on SIGHUP and on the config file change.
+func watchConfig(ctx context.Context, sapp *app.SegApp, cfg config.AppConfig, args []string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	changes := config.WatchFile(ctx, cfg.File, cfg.ReloadInterval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Info("config reload: SIGHUP received")
+		case <-changes:
+			log.Infof("config reload: %s changed", cfg.File)
+		}
+
+		newCfg, err := config.GetAppConfig(args)
+		if err == nil {
+			err = newCfg.Validate()
+		}
+		if err != nil {
+			log.Error("config reload: ", err)
+			continue
+		}
+		sapp.Reload(newCfg)
+	}
+}
+
+// printConfig writes the effective config with masked secrets, `config print [flags]` command.
+func printConfig(args []string) {
+	cfg, err := config.GetAppConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err = cfg.WriteYAML(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err = cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,79 @@
+// segctl is the admin command line tool for segments and memberships, it uses the server config.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"os/user"
+	"syscall"
+
+	"github.com/unbeman/av-prac-task/internal/cli"
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/logging"
+	"github.com/unbeman/av-prac-task/internal/worker"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, cli.ErrUsage) {
+			fmt.Fprintln(os.Stderr, cli.Usage())
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	cfg, rest, err := config.LoadAppConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, cli.Usage())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return fmt.Errorf("%w: command is required", cli.ErrUsage)
+	}
+	if err = cfg.Validate(); err != nil {
+		return err
+	}
+
+	// logs are written to stderr, so they don't mix with the command output
+	if err = logging.InitLogger(cfg.Logger); err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	db, err := database.GetDatabase(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	wp := worker.NewWorkersPool(cfg.WorkersPool)
+	go wp.Run()
+	defer wp.Shutdown(context.Background())
+
+	c, err := cli.NewCLI(db, wp, cfg.FileDirectory, os.Stdout, actor())
+	if err != nil {
+		return err
+	}
+	return c.Run(ctx, rest)
+}
+
+// actor returns the audit log actor of the changes made by the CLI.
+func actor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return "segctl:" + name
+}
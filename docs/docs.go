@@ -16,30 +16,76 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/segment": {
-            "post": {
-                "description": "Создает новый сегмент с заданным значением Slug и (опционально) Selection - процентом для выборки\nпользователей [0, 1). При непустом значении Selection, новый сегмент добавляется рандомно выбранным\nпользователям в количестве (AllUsersCount * Selection).",
-                "consumes": [
-                    "application/json"
+        "/audit": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "Возвращает записи аудита изменяющих запросов: кто (actor), когда, с какого адреса, с каким request id\nи по какой причине (reason) выполнил запрос. Записи отдаются от новых к старым, для следующей страницы\nпередается before_id - id последней полученной записи.\nActor берется из аутентификации или из заголовка X-Actor, reason - из поля reason тела запроса\nили заголовка X-Audit-Reason.",
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Creates new segment with given slug",
+                "summary": "Get audit log",
                 "parameters": [
                     {
-                        "description": "Segment input",
+                        "type": "string",
+                        "description": "Actor",
+                        "name": "actor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Segment slug",
                         "name": "segment",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.CreateSegmentInput"
-                        }
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "\"2023-08-01\"",
+                        "description": "From time, RFC 3339 or date",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "\"2023-08-31T12:00:00Z\"",
+                        "description": "To time, RFC 3339 or date",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Return records with id less than before_id",
+                        "name": "before_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Max records count, 100 by default, 1000 max",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK"
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.AuditRecord"
+                            }
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -47,8 +93,20 @@ const docTemplate = `{
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
                     },
-                    "409": {
-                        "description": "Conflict",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
                         "schema": {
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
@@ -62,40 +120,42 @@ const docTemplate = `{
                 }
             }
         },
-        "/segment/{slug}": {
-            "delete": {
-                "description": "Совершает \"soft delete\" - помечает сегмент и его связь с пользователями как удаленный.",
-                "produces": [
-                    "application/json"
-                ],
-                "summary": "Deletes segment with given slug",
-                "parameters": [
+        "/cache/stats": {
+            "get": {
+                "security": [
                     {
-                        "type": "string",
-                        "description": "slug",
-                        "name": "slug",
-                        "in": "path",
-                        "required": true
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
+                "description": "Возвращает количество попаданий и промахов кэша активных сегментов пользователей.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get user segments cache statistics",
                 "responses": {
                     "200": {
-                        "description": "OK"
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.CacheStats"
+                        }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "429": {
+                        "description": "Too Many Requests",
                         "schema": {
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
@@ -103,25 +163,53 @@ const docTemplate = `{
                 }
             }
         },
-        "/segments/user/history/{filename}": {
+        "/events/stream": {
             "get": {
-                "description": "Возвращает csv документ",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Отдает поток событий (Server-Sent Events) о добавлении пользователя в сегмент (add),\nудалении из сегмента (delete), удалении сегмента (segment_deleted) и его восстановлении (segment_restored).\nПоток можно отфильтровать по пользователю и сегменту. Для продолжения чтения после переподключения\nпередается заголовок Last-Event-ID (или параметр last_event_id) с id последнего полученного события.\nСобытия отдаются в порядке фиксации их транзакций, id событий в потоке не обязательно возрастают.\nСобытия сегментов, на которые у клиента нет права read, не отдаются.",
                 "produces": [
-                    "text/csv"
+                    "text/event-stream"
                 ],
-                "summary": "Get user's segments history csv file",
+                "summary": "Stream membership change events",
                 "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "user_id",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
-                        "description": "file name",
-                        "name": "filename",
-                        "in": "path",
-                        "required": true
+                        "description": "Segment slug",
+                        "name": "segment",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Last received event ID",
+                        "name": "last_event_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Last received event ID",
+                        "name": "Last-Event-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK"
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.Event"
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -129,8 +217,20 @@ const docTemplate = `{
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
                         "schema": {
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
@@ -144,30 +244,46 @@ const docTemplate = `{
                 }
             }
         },
-        "/segments/user/{user_id}": {
-            "get": {
-                "description": "Возвращает список активных сегментов пользователя",
+        "/experiments": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Создает эксперимент и сегменты его вариантов. Weight варианта - доля пользователей [0, 1], сумма\nвесов не больше 1. Пользователи распределяются по вариантам детерминированно по хэшу id и slug'а\nэксперимента, пользователь попадает не больше чем в один вариант. Сегменты вариантов динамические:\nявно добавить или удалить пользователя нельзя (409), они возвращаются в сегментах пользователя и в\nснапшоте. Grants задаются каждому варианту как при создании сегмента.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get user's active segments",
+                "summary": "Creates A/B experiment",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "User ID",
-                        "name": "user_id",
-                        "in": "path",
-                        "required": true
+                        "description": "Experiment input",
+                        "name": "experiment",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.CreateExperimentInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.ExperimentOutput"
                         }
                     },
                     "400": {
@@ -176,8 +292,32 @@ const docTemplate = `{
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
                         "schema": {
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
@@ -189,16 +329,23 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "post": {
-                "description": "Обновляет сегменты пользователя: добавляет и удаляет существующие по соответствующим спискам.\nОтдает ошибку в том числе, если списки пересекаются, если сегмента не существует, если сегмент уже удален.",
-                "consumes": [
-                    "application/json"
+            }
+        },
+        "/experiments/user/{user_id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "Возвращает вариант пользователя в каждом эксперименте, variant равен null, если пользователь\nне попал ни в один вариант эксперимента.",
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Updates user's segments",
+                "summary": "Get user's experiments variants",
                 "parameters": [
                     {
                         "type": "integer",
@@ -206,20 +353,17 @@ const docTemplate = `{
                         "name": "user_id",
                         "in": "path",
                         "required": true
-                    },
-                    {
-                        "description": "User segments input",
-                        "name": "input",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.UserSegmentsInput"
-                        }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK"
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.UserVariant"
+                            }
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -227,8 +371,20 @@ const docTemplate = `{
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
                         "schema": {
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
@@ -242,43 +398,36 @@ const docTemplate = `{
                 }
             }
         },
-        "/segments/user/{user_id}/csv": {
+        "/experiments/{slug}": {
             "get": {
-                "description": "Запускает генерацию CSV файла для истории операций с сегментами пользователя\nв заданный полуинтервал [from, to).",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает варианты эксперимента и их веса. Варианты без права read у клиента не возвращаются.",
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Get user's segments history link to download",
+                "summary": "Get experiment variants",
                 "parameters": [
-                    {
-                        "type": "integer",
-                        "description": "User ID",
-                        "name": "user_id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "format": "date",
-                        "example": "\"2023-08-01\"",
-                        "description": "From Date",
-                        "name": "from",
-                        "in": "query",
-                        "required": true
-                    },
                     {
                         "type": "string",
-                        "format": "date",
-                        "example": "\"2023-08-31\"",
-                        "description": "To Date",
-                        "name": "to",
-                        "in": "query",
+                        "description": "slug",
+                        "name": "slug",
+                        "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
-                    "202": {
-                        "description": "Accepted"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.ExperimentOutput"
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -286,12 +435,1616 @@ const docTemplate = `{
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
                         }
                     },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/experiments/{slug}/weights": {
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Изменяет веса вариантов эксперимента, веса неуказанных вариантов сохраняются. Пользователи остаются\nв своих вариантах, если вес варианта не уменьшился, освободившаяся доля отдается растущим вариантам.\nТребует право write на все варианты.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Updates experiment variants weights",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "slug",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Variants weights",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.ExperimentWeightsInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.ExperimentOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/groups": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Создает группу взаимоисключающих сегментов: пользователь может быть только в одном сегменте группы.\nПри добавлении пользователя в сегмент группы, когда он уже в другом ее сегменте, политика reject\nотклоняет изменение (409), а replace удаляет пользователя из другого сегмента. Сегмент может быть\nтолько в одной группе, динамические сегменты в группы не входят, сегменты не должны уже иметь общих\nпользователей (409). Требует право write на все сегменты.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Creates exclusion group of segments",
+                "parameters": [
+                    {
+                        "description": "Exclusion group input",
+                        "name": "group",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.CreateExclusionGroupInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.ExclusionGroupOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/groups/{slug}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает политику и сегменты группы взаимоисключающих сегментов.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get exclusion group",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "slug",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.ExclusionGroupOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Удаляет группу, ее сегменты и их пользователи сохраняются. Требует право write на все сегменты группы.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Deletes exclusion group",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "slug",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/keys": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Создает API ключ для клиента с заданными правами (scopes). Ключ возвращается только в этом ответе,\nв базе хранится его хэш. Ключ передается в заголовке X-API-Key.\nТребует права admin, первый ключ можно создать с помощью JWT с правом admin.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Creates API key",
+                "parameters": [
+                    {
+                        "description": "API key input",
+                        "name": "key",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.CreateAPIKeyInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.APIKeyOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/keys/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Отзывает API ключ.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Deletes API key",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "API key ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segment": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Создает новый сегмент с заданным значением Slug и (опционально) Selection - процентом для выборки\nпользователей [0, 1). При непустом значении Selection, новый сегмент добавляется рандомно выбранным\nпользователям в количестве (AllUsersCount * Selection). В ответе возвращается количество\nпользователей, которым был добавлен сегмент.\nGrants задают права команд на сегмент (read/write, команда \"*\" - все). Если они не заданы, команды\nсоздателя получают право write, остальные - read. Сегмент без grants доступен всем.\nRollout (доля пользователей [0, 1] по хэшу id) и Rules (условия на атрибуты пользователя, операторы\nin и not_in) задают динамический сегмент: его пользователи не хранятся, а вычисляются, явно добавить\nили удалить пользователя из такого сегмента нельзя (409). Selection с ними не используется.\nGroup добавляет сегмент в группу взаимоисключающих сегментов, Selection при этом выбирает только\nпользователей, которых нет в других сегментах группы. На сегменты группы нужно право write (403).\nPrerequisites - сегменты, в которых пользователь должен состоять, чтобы попасть в новый сегмент,\nSelection выбирает только таких пользователей. На prerequisites нужно право write (403).\nActiveFrom и ActiveUntil задают окно активности: вне его сегмент не возвращается в сегментах\nпользователя, а в ActiveUntil сегмент удаляется планировщиком (как DELETE /segment/{slug}).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Creates new segment with given slug",
+                "parameters": [
+                    {
+                        "description": "Segment input",
+                        "name": "segment",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.CreateSegmentInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.CreateSegmentOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segment/{slug}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Совершает \"soft delete\" - помечает сегмент и его связь с пользователями как удаленный.\nПользователи удаляются из зависимых сегментов с политикой cascade, зависимые сегменты\nс политикой reject, в которых есть пользователи сегмента, отклоняют удаление (409).\nТребует право write на сегмент и на зависимые сегменты, из которых удаляются пользователи.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Deletes segment with given slug",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "slug",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segment/{slug}/acl": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает права команд на сегмент. Требует право read на сегмент.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get segment ACL",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "slug",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.SegmentGrantsOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Заменяет права команд на сегмент. Требует право write на сегмент.\nПустой список делает сегмент доступным всем.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Replaces segment ACL",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "slug",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Segment grants",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.SegmentGrantsInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segment/{slug}/prerequisites": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает сегменты, в которых пользователь должен состоять, чтобы его можно было добавить в сегмент,\nи политику on_remove при удалении пользователя из них. Требует право read на сегмент.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get segment prerequisites",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "slug",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.SegmentPrerequisitesOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Заменяет prerequisites сегмента. При удалении пользователя из prerequisite политика on_remove=reject\n(по умолчанию) отклоняет удаление, если пользователь в зависимом сегменте, cascade - удаляет его и\nиз зависимого сегмента. Циклические зависимости отклоняются (409), динамические сегменты\nне используются (409). Текущие пользователи сегмента не проверяются. Требует право write на сегмент\nи на его prerequisites (403).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Replaces segment prerequisites",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "slug",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Segment prerequisites",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.SegmentPrerequisitesInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segments/snapshot": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает определения сегментов для вычисления принадлежности пользователей на стороне клиента.\nПользователи сегментов с rollout и rules вычисляются по хэшу id пользователя и его атрибутам,\nдля сегментов с explicit=true пользователи хранятся явно и запрашиваются через API.\nБез since возвращаются все активные сегменты (full=true), с since - только измененные после этой\nверсии, включая удаленные (deleted=true). Version ответа передается в since следующего запроса.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get segments definitions snapshot",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Version of the previous snapshot",
+                        "name": "since",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.SegmentsSnapshot"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segments/user/history/{filename}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает csv документ",
+                "produces": [
+                    "text/csv"
+                ],
+                "summary": "Get user's segments history csv file",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "file name",
+                        "name": "filename",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segments/user/{user_id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает список активных сегментов пользователя, на которые у клиента есть право read.\nДинамические сегменты вычисляются только по id пользователя, сегменты с rules на другие атрибуты\nв ответ не входят: их вычисляет клиент по снапшоту определений (client.Evaluator).\nВ ответе передаются заголовки ETag и Last-Modified, при совпадении If-None-Match (If-Modified-Since)\nс текущей версией сегментов пользователя возвращается 304 без тела. ETag зависит от видимых клиенту\nсегментов, ответ помечается Cache-Control: public, no-cache и Vary: Authorization, X-API-Key, поэтому\nобщий кеш перепроверяет его по ETag и не отдает одному клиенту ответ другого.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get user's active segments",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag from previous response",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last-Modified from previous response",
+                        "name": "If-Modified-Since",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Обновляет сегменты пользователя: добавляет и удаляет существующие по соответствующим спискам.\nОтдает ошибку в том числе, если списки пересекаются, если сегмента не существует, если сегмент уже удален,\nесли у клиента нет права write на какой-либо из сегментов, если сегмент динамический (409),\nесли добавляемые сегменты из одной группы взаимоисключающих сегментов или пользователь уже в другом\nсегменте группы с политикой reject (409). При политике replace пользователь удаляется из другого\nсегмента группы в той же транзакции. Добавление в сегмент отклоняется (409), если пользователь\nне состоит в его prerequisites после изменения. Удаление из prerequisite отклоняется (409) для\nзависимых сегментов пользователя с on_remove=reject и удаляет его из зависимых сегментов с cascade.\nНа сегменты, из которых пользователь удаляется по replace или cascade, тоже нужно право write (403).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Updates user's segments",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User id",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "User segments input",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.UserSegmentsInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segments/user/{user_id}/csv": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Запускает генерацию CSV файла для истории операций с сегментами пользователя\nв заданный полуинтервал [from, to).",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get user's segments history link to download",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "format": "date",
+                        "example": "\"2023-08-01\"",
+                        "description": "From Date",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "format": "date",
+                        "example": "\"2023-08-31\"",
+                        "description": "To Date",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает список подписок и состояние их доставки.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get webhook subscriptions",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.WebhookOutput"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Создает подписку на события изменения сегментов. События доставляются POST запросом\nпачками на указанный URL. Запрос подписывается HMAC-SHA256: заголовок X-Webhook-Signature содержит\n\"sha256=\" + hex(HMAC(secret, X-Webhook-Timestamp + \".\" + body)). Если secret не задан, он генерируется\nи возвращается только в ответе на создание. При ошибке доставка повторяется с экспоненциальной задержкой.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Creates webhook subscription",
+                "parameters": [
+                    {
+                        "description": "Webhook input",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.CreateWebhookInput"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.WebhookOutput"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Удаляет подписку, доставка событий по ней прекращается.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Deletes webhook subscription",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.OutputError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -303,9 +2056,250 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "github_com_unbeman_av-prac-task_internal_model.APIKeyOutput": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "key": {
+                    "type": "string",
+                    "example": "3f1c...9a"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "crm-service"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.Scope"
+                    },
+                    "example": [
+                        "memberships:read",
+                        "memberships:write"
+                    ]
+                },
+                "teams": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "crm"
+                    ]
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.AuditRecord": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string",
+                    "example": "segment.delete"
+                },
+                "actor": {
+                    "type": "string",
+                    "example": "crm-service"
+                },
+                "change": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.EventType"
+                        }
+                    ],
+                    "example": "delete"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "forwarded_for": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "method": {
+                    "type": "string",
+                    "example": "DELETE"
+                },
+                "path": {
+                    "type": "string",
+                    "example": "/api/v1/segment/PROMO_5"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "promo is over"
+                },
+                "request_id": {
+                    "type": "string",
+                    "example": "host/abcdef-000001"
+                },
+                "segments": {
+                    "type": "string",
+                    "example": "PROMO_5"
+                },
+                "source_ip": {
+                    "type": "string",
+                    "example": "10.0.0.1"
+                },
+                "status_code": {
+                    "type": "integer",
+                    "example": 200
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.CacheStats": {
+            "type": "object",
+            "properties": {
+                "hits": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "misses": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "size": {
+                    "type": "integer",
+                    "example": 90
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.CreateAPIKeyInput": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "example": "crm-service"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.Scope"
+                    },
+                    "example": [
+                        "memberships:read",
+                        "memberships:write"
+                    ]
+                },
+                "teams": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "crm"
+                    ]
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.CreateExclusionGroupInput": {
+            "type": "object",
+            "properties": {
+                "policy": {
+                    "enum": [
+                        "reject",
+                        "replace"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.GroupPolicy"
+                        }
+                    ],
+                    "example": "reject"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "discounts don't stack"
+                },
+                "segments": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "PROMO_5",
+                        "AVITO_SALES_20"
+                    ]
+                },
+                "slug": {
+                    "type": "string",
+                    "example": "PROMO_DISCOUNTS"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.CreateExperimentInput": {
+            "type": "object",
+            "properties": {
+                "grants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.SegmentGrant"
+                    }
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "new checkout flow test"
+                },
+                "slug": {
+                    "type": "string",
+                    "example": "CHECKOUT_V2"
+                },
+                "variants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.ExperimentVariantInput"
+                    }
+                }
+            }
+        },
         "github_com_unbeman_av-prac-task_internal_model.CreateSegmentInput": {
             "type": "object",
             "properties": {
+                "active_from": {
+                    "type": "string",
+                    "example": "2023-11-24T00:00:00+03:00"
+                },
+                "active_until": {
+                    "type": "string",
+                    "example": "2023-11-27T00:00:00+03:00"
+                },
+                "grants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.SegmentGrant"
+                    }
+                },
+                "group": {
+                    "type": "string",
+                    "example": "PROMO_DISCOUNTS"
+                },
+                "prerequisites": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.PrerequisiteInput"
+                    }
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "new voice messages rollout"
+                },
+                "rollout": {
+                    "type": "number",
+                    "example": 0.1
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.SegmentRule"
+                    }
+                },
                 "selection": {
                     "type": "number",
                     "example": 0.2
@@ -316,6 +2310,158 @@ const docTemplate = `{
                 }
             }
         },
+        "github_com_unbeman_av-prac-task_internal_model.CreateSegmentOutput": {
+            "type": "object",
+            "properties": {
+                "assigned_users": {
+                    "type": "integer",
+                    "example": 1000
+                },
+                "slug": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.CreateWebhookInput": {
+            "type": "object",
+            "properties": {
+                "event_types": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.EventType"
+                    },
+                    "example": [
+                        "add",
+                        "delete"
+                    ]
+                },
+                "secret": {
+                    "type": "string",
+                    "example": "s3cr3t"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://crm.example.com/hooks/segments"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.Event": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "segment": {
+                    "type": "string"
+                },
+                "type": {
+                    "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.EventType"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.EventType": {
+            "type": "string",
+            "enum": [
+                "add",
+                "delete",
+                "segment_deleted",
+                "segment_restored"
+            ],
+            "x-enum-varnames": [
+                "EventAdd",
+                "EventDelete",
+                "EventSegmentDeleted",
+                "EventSegmentRestored"
+            ]
+        },
+        "github_com_unbeman_av-prac-task_internal_model.ExclusionGroupOutput": {
+            "type": "object",
+            "properties": {
+                "policy": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.GroupPolicy"
+                        }
+                    ],
+                    "example": "reject"
+                },
+                "segments": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "AVITO_SALES_20",
+                        "PROMO_5"
+                    ]
+                },
+                "slug": {
+                    "type": "string",
+                    "example": "PROMO_DISCOUNTS"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.ExperimentOutput": {
+            "type": "object",
+            "properties": {
+                "slug": {
+                    "type": "string",
+                    "example": "CHECKOUT_V2"
+                },
+                "variants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.ExperimentVariantInput"
+                    }
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.ExperimentVariantInput": {
+            "type": "object",
+            "properties": {
+                "slug": {
+                    "type": "string",
+                    "example": "CHECKOUT_V2_A"
+                },
+                "weight": {
+                    "type": "number",
+                    "example": 0.5
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.ExperimentWeightsInput": {
+            "type": "object",
+            "properties": {
+                "reason": {
+                    "type": "string",
+                    "example": "ramp up variant B"
+                },
+                "variants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.ExperimentVariantInput"
+                    }
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.GroupPolicy": {
+            "type": "string",
+            "enum": [
+                "reject",
+                "replace"
+            ],
+            "x-enum-varnames": [
+                "GroupPolicyReject",
+                "GroupPolicyReplace"
+            ]
+        },
         "github_com_unbeman_av-prac-task_internal_model.OutputError": {
             "type": "object",
             "properties": {
@@ -325,9 +2471,242 @@ const docTemplate = `{
                 }
             }
         },
+        "github_com_unbeman_av-prac-task_internal_model.Permission": {
+            "type": "string",
+            "enum": [
+                "read",
+                "write"
+            ],
+            "x-enum-varnames": [
+                "PermissionRead",
+                "PermissionWrite"
+            ]
+        },
+        "github_com_unbeman_av-prac-task_internal_model.PrerequisiteInput": {
+            "type": "object",
+            "properties": {
+                "on_remove": {
+                    "enum": [
+                        "reject",
+                        "cascade"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.PrerequisitePolicy"
+                        }
+                    ],
+                    "example": "cascade"
+                },
+                "slug": {
+                    "type": "string",
+                    "example": "VERIFIED_PHONE"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.PrerequisitePolicy": {
+            "type": "string",
+            "enum": [
+                "reject",
+                "cascade"
+            ],
+            "x-enum-varnames": [
+                "PrerequisiteReject",
+                "PrerequisiteCascade"
+            ]
+        },
+        "github_com_unbeman_av-prac-task_internal_model.Scope": {
+            "type": "string",
+            "enum": [
+                "segments:write",
+                "memberships:read",
+                "memberships:write",
+                "history:read",
+                "events:read",
+                "webhooks:write",
+                "audit:read",
+                "admin"
+            ],
+            "x-enum-varnames": [
+                "ScopeSegmentsWrite",
+                "ScopeMembershipsRead",
+                "ScopeMembershipsWrite",
+                "ScopeHistoryRead",
+                "ScopeEventsRead",
+                "ScopeWebhooksWrite",
+                "ScopeAuditRead",
+                "ScopeAdmin"
+            ]
+        },
+        "github_com_unbeman_av-prac-task_internal_model.SegmentDefinition": {
+            "type": "object",
+            "properties": {
+                "active_from": {
+                    "type": "string"
+                },
+                "active_until": {
+                    "type": "string"
+                },
+                "deleted": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "explicit": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "rollout": {
+                    "type": "number",
+                    "example": 0.1
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.SegmentRule"
+                    }
+                },
+                "slug": {
+                    "type": "string",
+                    "example": "PROMO_5"
+                },
+                "variant": {
+                    "$ref": "#/definitions/github_com_unbeman_av-prac-task_pkg_evaluation.Variant"
+                },
+                "version": {
+                    "type": "integer",
+                    "example": 42
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.SegmentGrant": {
+            "type": "object",
+            "properties": {
+                "permission": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.Permission"
+                        }
+                    ],
+                    "example": "write"
+                },
+                "team": {
+                    "type": "string",
+                    "example": "legal"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.SegmentGrantsInput": {
+            "type": "object",
+            "properties": {
+                "grants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.SegmentGrant"
+                    }
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "legal team owns compliance flags"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.SegmentGrantsOutput": {
+            "type": "object",
+            "properties": {
+                "grants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.SegmentGrant"
+                    }
+                },
+                "slug": {
+                    "type": "string",
+                    "example": "LEGAL_HOLD"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.SegmentPrerequisitesInput": {
+            "type": "object",
+            "properties": {
+                "prerequisites": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.PrerequisiteInput"
+                    }
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "promo only for verified users"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.SegmentPrerequisitesOutput": {
+            "type": "object",
+            "properties": {
+                "prerequisites": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.PrerequisiteInput"
+                    }
+                },
+                "slug": {
+                    "type": "string",
+                    "example": "PROMO_5"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.SegmentRule": {
+            "type": "object",
+            "properties": {
+                "attribute": {
+                    "type": "string",
+                    "example": "country"
+                },
+                "operator": {
+                    "type": "string",
+                    "enum": [
+                        "in",
+                        "not_in"
+                    ],
+                    "example": "in"
+                },
+                "values": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "RU",
+                        "KZ"
+                    ]
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.SegmentsSnapshot": {
+            "type": "object",
+            "properties": {
+                "full": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "segments": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.SegmentDefinition"
+                    }
+                },
+                "version": {
+                    "type": "integer",
+                    "example": 42
+                }
+            }
+        },
         "github_com_unbeman_av-prac-task_internal_model.UserSegmentsInput": {
             "type": "object",
             "properties": {
+                "reason": {
+                    "type": "string",
+                    "example": "support ticket 123"
+                },
                 "segments_to_add": {
                     "type": "array",
                     "items": {
@@ -348,6 +2727,101 @@ const docTemplate = `{
                     ]
                 }
             }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.UserVariant": {
+            "type": "object",
+            "properties": {
+                "experiment": {
+                    "type": "string",
+                    "example": "CHECKOUT_V2"
+                },
+                "variant": {
+                    "type": "string",
+                    "example": "CHECKOUT_V2_A"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_internal_model.WebhookOutput": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "event_types": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_internal_model.EventType"
+                    },
+                    "example": [
+                        "add",
+                        "delete"
+                    ]
+                },
+                "failed_attempts": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "last_event_id": {
+                    "type": "integer",
+                    "example": 42
+                },
+                "secret": {
+                    "type": "string",
+                    "example": "s3cr3t"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://crm.example.com/hooks/segments"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_pkg_evaluation.Range": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "integer"
+                },
+                "to": {
+                    "type": "integer"
+                }
+            }
+        },
+        "github_com_unbeman_av-prac-task_pkg_evaluation.Variant": {
+            "type": "object",
+            "properties": {
+                "experiment": {
+                    "type": "string"
+                },
+                "ranges": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_unbeman_av-prac-task_pkg_evaluation.Range"
+                    }
+                },
+                "weight": {
+                    "type": "number"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        },
+        "BearerAuth": {
+            "description": "JWT signed with HS256 or RS256, \"Bearer \u003ctoken\u003e\".",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
@@ -14,13 +14,62 @@ create table segments
         constraint segments_pkey
             primary key,
     slug text,
+    rollout numeric,
+    rules text,
+    experiment_id bigint,
+    variant text,
+    group_id bigint,
+    active_from timestamp with time zone,
+    active_until timestamp with time zone,
+    version bigint,
     created_at timestamp with time zone,
+    updated_at timestamp with time zone,
     deleted_at timestamp with time zone
 );
 
 create unique index idx_segments_slug
     on segments (slug);
 
+create index idx_segments_version
+    on segments (version);
+
+create index idx_segments_experiment_id
+    on segments (experiment_id);
+
+create index idx_segments_group_id
+    on segments (group_id);
+
+create index idx_segments_active_until
+    on segments (active_until);
+
+create sequence segment_versions;
+
+create table experiments
+(
+    id bigserial not null
+        constraint experiments_pkey
+            primary key,
+    slug text,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone
+);
+
+create unique index idx_experiments_slug
+    on experiments (slug);
+
+create table exclusion_groups
+(
+    id bigserial not null
+        constraint exclusion_groups_pkey
+            primary key,
+    slug text,
+    policy text,
+    created_at timestamp with time zone
+);
+
+create unique index idx_exclusion_groups_slug
+    on exclusion_groups (slug);
+
 create table user_segments
 (
     user_id bigint
@@ -33,3 +82,152 @@ create table user_segments
     deleted_at timestamp with time zone
 );
 
+
+create table segment_prerequisites
+(
+    id bigserial not null
+        constraint segment_prerequisites_pkey
+            primary key,
+    segment_id bigint
+        constraint fk_segments_prerequisites
+            references segments,
+    prerequisite_id bigint
+        constraint fk_segment_prerequisites_prerequisite
+            references segments,
+    on_remove text,
+    created_at timestamp with time zone
+);
+
+create unique index idx_segment_prerequisite
+    on segment_prerequisites (segment_id, prerequisite_id);
+
+create index idx_segment_prerequisites_prerequisite_id
+    on segment_prerequisites (prerequisite_id);
+
+create table events
+(
+    id bigserial not null
+        constraint events_pkey
+            primary key,
+    type text,
+    user_id bigint,
+    segment_slug text,
+    created_at timestamp with time zone,
+    tx_id xid8 default pg_current_xact_id() not null
+);
+
+create index idx_events_user_id
+    on events (user_id);
+
+create index idx_events_position
+    on events (tx_id, id);
+
+create index idx_events_segment_slug
+    on events (segment_slug);
+
+create table webhook_subscriptions
+(
+    id bigserial not null
+        constraint webhook_subscriptions_pkey
+            primary key,
+    url text,
+    event_types text,
+    secret text,
+    last_event_id bigint,
+    failed_attempts bigint,
+    next_attempt_at timestamp with time zone,
+    last_error text,
+    locked_until timestamp with time zone default CURRENT_TIMESTAMP not null,
+    created_at timestamp with time zone,
+    deleted_at timestamp with time zone
+);
+
+create table api_keys
+(
+    id bigserial not null
+        constraint api_keys_pkey
+            primary key,
+    name text,
+    key_hash text,
+    scopes text,
+    teams text,
+    created_at timestamp with time zone,
+    deleted_at timestamp with time zone
+);
+
+create unique index idx_api_keys_key_hash
+    on api_keys (key_hash);
+
+create table segment_grants
+(
+    id bigserial not null
+        constraint segment_grants_pkey
+            primary key,
+    segment_id bigint
+        constraint fk_segments_grants
+            references segments,
+    team text,
+    permission text
+);
+
+create index idx_segment_grants_segment_id
+    on segment_grants (segment_id);
+
+create table audit_records
+(
+    id bigserial not null
+        constraint audit_records_pkey
+            primary key,
+    action text,
+    actor text,
+    request_id text,
+    source_ip text,
+    forwarded_for text,
+    method text,
+    path text,
+    user_id bigint,
+    segments text,
+    reason text,
+    status_code bigint,
+    change text,
+    created_at timestamp with time zone
+);
+
+create index idx_audit_records_actor
+    on audit_records (actor);
+
+create index idx_audit_records_user_id
+    on audit_records (user_id);
+
+create index idx_audit_records_created_at
+    on audit_records (created_at);
+
+create table idempotency_records
+(
+    id bigserial not null
+        constraint idempotency_records_pkey
+            primary key,
+    idempotency_key text,
+    fingerprint text not null,
+    completed boolean default false not null,
+    status_code bigint default 0 not null,
+    content_type text default ''::text not null,
+    body bytea,
+    created_at timestamp with time zone not null,
+    locked_until timestamp with time zone default CURRENT_TIMESTAMP not null,
+    expires_at timestamp with time zone not null
+);
+
+create unique index idx_idempotency_records_idempotency_key
+    on idempotency_records (idempotency_key);
+
+create index idx_idempotency_records_expires_at
+    on idempotency_records (expires_at);
+
+create table schema_migrations
+(
+    version bigint not null
+        constraint schema_migrations_pkey
+            primary key,
+    applied_at timestamp with time zone
+);
@@ -3,21 +3,42 @@ package app
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 
+	"github.com/unbeman/av-prac-task/internal/cache"
 	"github.com/unbeman/av-prac-task/internal/config"
 	"github.com/unbeman/av-prac-task/internal/database"
 	"github.com/unbeman/av-prac-task/internal/handlers"
+	"github.com/unbeman/av-prac-task/internal/metrics"
+	"github.com/unbeman/av-prac-task/internal/ratelimit"
 	"github.com/unbeman/av-prac-task/internal/services"
 	"github.com/unbeman/av-prac-task/internal/worker"
 )
 
 type SegApp struct {
-	server      http.Server
-	workersPool *worker.WorkersPool
+	// cfg is the effective config, it is guarded by reloadMu.
+	cfg              config.AppConfig
+	reloadMu         sync.Mutex
+	cache            *cache.LRUCache
+	limiter          *ratelimit.MemoryLimiter
+	db               database.IDatabase
+	server           *http.Server
+	listener         net.Listener
+	grpcServer       *grpc.Server
+	grpcListener     net.Listener
+	workersPool      *worker.WorkersPool
+	eventService     *services.EventService
+	webhookService   *services.WebhookService
+	schedulerService *services.SchedulerService
+	healthService    *services.HealthService
+	shutdownTimeout  time.Duration
+	stopped          chan struct{}
 }
 
 func GetSegApp(cfg config.AppConfig) (*SegApp, error) {
@@ -26,8 +47,15 @@ func GetSegApp(cfg config.AppConfig) (*SegApp, error) {
 		return nil, fmt.Errorf("coudnt get database: %w", err)
 	}
 
+	userCache := cache.NewLRUCache(cfg.Cache)
+	db = database.NewCachedDatabase(db, userCache)
+
 	wp := worker.NewWorkersPool(cfg.WorkersPool)
 
+	if err = metrics.Register(metrics.NewReportFilesCollector(cfg.FileDirectory)); err != nil {
+		return nil, fmt.Errorf("coudnt register report files metrics: %w", err)
+	}
+
 	uServ, err := services.NewUserService(db, wp, cfg.FileDirectory)
 	if err != nil {
 		return nil, fmt.Errorf("coudnt get user service: %w", err)
@@ -38,19 +66,97 @@ func GetSegApp(cfg config.AppConfig) (*SegApp, error) {
 		return nil, fmt.Errorf("coudnt get segment service: %w", err)
 	}
 
-	handler, err := handlers.GetHandler(uServ, sServ)
+	eServ, err := services.NewEventService(db, cfg.Events)
+	if err != nil {
+		return nil, fmt.Errorf("coudnt get event service: %w", err)
+	}
+
+	whServ, err := services.NewWebhookService(db, cfg.Webhooks)
+	if err != nil {
+		return nil, fmt.Errorf("coudnt get webhook service: %w", err)
+	}
+
+	schServ, err := services.NewSchedulerService(db, cfg.Scheduler)
+	if err != nil {
+		return nil, fmt.Errorf("coudnt get scheduler service: %w", err)
+	}
+
+	aServ, err := services.NewAuthService(db, cfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("coudnt get auth service: %w", err)
+	}
+
+	auServ, err := services.NewAuditService(db)
+	if err != nil {
+		return nil, fmt.Errorf("coudnt get audit service: %w", err)
+	}
+
+	iServ, err := services.NewIdempotencyService(db, cfg.Idempotency)
+	if err != nil {
+		return nil, fmt.Errorf("coudnt get idempotency service: %w", err)
+	}
+
+	hServ, err := services.NewHealthService(db, wp, cfg.FileDirectory, cfg.Health)
+	if err != nil {
+		return nil, fmt.Errorf("coudnt get health service: %w", err)
+	}
+
+	limiter := ratelimit.NewMemoryLimiter(cfg.RateLimit)
+
+	handler, err := handlers.GetHandler(
+		uServ,
+		sServ,
+		eServ,
+		whServ,
+		aServ,
+		auServ,
+		iServ,
+		hServ,
+		userCache,
+		limiter,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("coudnt get handler: %w", err)
 	}
 
-	server := http.Server{
+	grpcServer, err := handlers.GetGRPCServer(uServ, sServ, aServ, auServ, iServ, limiter)
+	if err != nil {
+		return nil, fmt.Errorf("coudnt get grpc server: %w", err)
+	}
+
+	server := &http.Server{
 		Addr:    cfg.Address,
 		Handler: handler,
 	}
 
+	// both servers listen before the start, so the application doesn't run without one of them
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("coudnt listen http address: %w", err)
+	}
+
+	grpcListener, err := net.Listen("tcp", cfg.GRPCAddress)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("coudnt listen grpc address: %w", err)
+	}
+
 	application := &SegApp{
-		server:      server,
-		workersPool: wp,
+		cfg:              cfg,
+		cache:            userCache,
+		limiter:          limiter,
+		db:               db,
+		server:           server,
+		listener:         listener,
+		grpcServer:       grpcServer,
+		grpcListener:     grpcListener,
+		workersPool:      wp,
+		eventService:     eServ,
+		webhookService:   whServ,
+		schedulerService: schServ,
+		healthService:    hServ,
+		shutdownTimeout:  cfg.ShutdownTimeout,
+		stopped:          make(chan struct{}),
 	}
 	return application, nil
 }
@@ -58,7 +164,7 @@ func GetSegApp(cfg config.AppConfig) (*SegApp, error) {
 func (a *SegApp) Run() {
 	wg := sync.WaitGroup{}
 
-	wg.Add(2)
+	wg.Add(5)
 
 	go func() {
 		defer wg.Done()
@@ -68,20 +174,76 @@ func (a *SegApp) Run() {
 
 	go func() {
 		defer wg.Done()
-		err := a.server.ListenAndServe()
+		a.webhookService.Run()
+		log.Info("webhook dispatcher finished")
+	}()
+
+	go func() {
+		defer wg.Done()
+		a.schedulerService.Run()
+		log.Info("scheduler finished")
+	}()
+
+	go func() {
+		defer wg.Done()
+		err := a.server.Serve(a.listener)
 		log.Infof("server closed: %s", err)
 	}()
 
-	log.Infof("application started, Addr: %s", a.server.Addr)
+	go func() {
+		defer wg.Done()
+		err := a.grpcServer.Serve(a.grpcListener)
+		log.Infof("grpc server closed: %v", err)
+	}()
+
+	log.Infof("application started, Addr: %s, gRPC Addr: %s", a.listener.Addr(), a.grpcListener.Addr())
 
 	wg.Wait()
+	<-a.stopped
 }
 
+// Stop shuts the application down in order: reports it is not ready and waits the drain delay,
+// stops accepting requests and waits for in-flight ones, stops adding tasks and drains queued ones,
+// then closes the database. Steps after the drain delay are limited by the shutdown timeout.
 func (a *SegApp) Stop() {
+	defer close(a.stopped)
+
 	log.Infof("shutting down")
-	err := a.server.Shutdown(context.TODO())
-	if err != nil {
-		log.Error(err)
+	a.healthService.Drain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
+
+	a.eventService.Shutdown()
+	if err := a.server.Shutdown(ctx); err != nil {
+		log.Errorf("http server shutdown: %v", err)
+	}
+	a.stopGRPCServer(ctx)
+
+	a.webhookService.Shutdown(ctx)
+	a.schedulerService.Shutdown()
+	if err := a.workersPool.Shutdown(ctx); err != nil {
+		log.Errorf("workers pool shutdown: %v", err)
+	}
+
+	if err := a.db.Close(); err != nil {
+		log.Errorf("database close: %v", err)
+	}
+	log.Info("application stopped")
+}
+
+// stopGRPCServer waits for in-flight calls until ctx is done and then closes them.
+func (a *SegApp) stopGRPCServer(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		a.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Errorf("grpc server shutdown: %v", ctx.Err())
+		a.grpcServer.Stop()
 	}
-	a.workersPool.Shutdown()
 }
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/logging"
+)
+
+// Reload applies runtime-tunable settings of cfg: log level, workers count, rate limits and cache TTL.
+// Changes of other settings are logged, they take effect after restart.
+func (a *SegApp) Reload(cfg config.AppConfig) {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	changed := a.cfg.Changed(cfg)
+	if len(changed) == 0 {
+		log.Info("config reload: no changes")
+		return
+	}
+
+	rateLimitApplied := false
+	for _, path := range changed {
+		var err error
+		switch {
+		case path == "log.level":
+			if err = logging.SetLevel(cfg.Logger.Level); err == nil {
+				a.cfg.Logger.Level = cfg.Logger.Level
+			}
+		case path == "workers_pool.workers_count":
+			if err = a.workersPool.Resize(cfg.WorkersPool.WorkersCount); err == nil {
+				a.cfg.WorkersPool.WorkersCount = cfg.WorkersPool.WorkersCount
+			}
+		case path == "cache.ttl":
+			a.cache.SetTTL(cfg.Cache.TTL)
+			a.cfg.Cache.TTL = cfg.Cache.TTL
+		case strings.HasPrefix(path, "rate_limit."):
+			if !rateLimitApplied {
+				a.limiter.SetConfig(cfg.RateLimit)
+				a.cfg.RateLimit = cfg.RateLimit
+				rateLimitApplied = true
+			}
+		default:
+			log.Warnf("config reload: %s can't be applied without restart", path)
+			continue
+		}
+
+		if err != nil {
+			log.Errorf("config reload: can't apply %s: %v", path, err)
+			continue
+		}
+		log.Infof("config reload: %s applied", path)
+	}
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/cache"
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/ratelimit"
+	"github.com/unbeman/av-prac-task/internal/worker"
+)
+
+func TestSegApp_Reload(t *testing.T) {
+	prevLevel := log.GetLevel()
+	hook := test.NewGlobal()
+	defer func() {
+		log.StandardLogger().ReplaceHooks(log.LevelHooks{})
+		log.SetLevel(prevLevel)
+	}()
+
+	cfg := config.NewAppConfig()
+	cfg.RateLimit.Enabled = true
+	cfg.RateLimit.Write = config.RateLimit{Rate: 1, Burst: 1}
+
+	wp := worker.NewWorkersPool(cfg.WorkersPool)
+	go wp.Run()
+	defer wp.Shutdown(context.Background())
+
+	a := &SegApp{
+		cfg:         cfg,
+		cache:       cache.NewLRUCache(cfg.Cache),
+		limiter:     ratelimit.NewMemoryLimiter(cfg.RateLimit),
+		workersPool: wp,
+	}
+	require.True(t, a.limiter.Allow(ratelimit.GroupWrite, "client").Allowed)
+	require.False(t, a.limiter.Allow(ratelimit.GroupWrite, "client").Allowed)
+
+	newCfg := cfg
+	newCfg.Logger.Level = "debug"
+	newCfg.WorkersPool.WorkersCount = 4
+	newCfg.RateLimit.Write = config.RateLimit{Rate: 100, Burst: 100}
+	newCfg.Cache.TTL = time.Nanosecond
+	newCfg.Address = "0.0.0.0:8000"
+	newCfg.DB.MaxOpenConns = 5
+
+	a.Reload(newCfg)
+
+	require.Equal(t, log.DebugLevel, log.GetLevel())
+	require.Equal(t, 100, a.limiter.Allow(ratelimit.GroupWrite, "client").Limit)
+
+	user := &model.User{}
+	user.ID = 1
+	a.cache.Set(user)
+	time.Sleep(time.Millisecond)
+	_, ok := a.cache.Get(1)
+	require.False(t, ok)
+
+	var warnings []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == log.WarnLevel {
+			warnings = append(warnings, entry.Message)
+		}
+	}
+	require.Equal(t, []string{
+		"config reload: postgres.max_open_conns can't be applied without restart",
+		"config reload: address can't be applied without restart",
+	}, warnings)
+
+	// applied settings become effective config, the rest are still reported
+	expected := cfg
+	expected.Logger.Level = "debug"
+	expected.WorkersPool.WorkersCount = 4
+	expected.RateLimit = newCfg.RateLimit
+	expected.Cache.TTL = newCfg.Cache.TTL
+	require.Equal(t, expected, a.cfg)
+	require.Equal(t, []string{"postgres.max_open_conns", "address"}, a.cfg.Changed(newCfg))
+}
@@ -0,0 +1,21 @@
+// Package cache describes caches used in front of the storage.
+package cache
+
+import (
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+// ICache describes the cache of users with their active segments.
+// Implementations must be safe for concurrent use.
+type ICache interface {
+	// Get returns cached user with active segments, ok is false on miss.
+	Get(userID uint64) (user *model.User, ok bool)
+	// Set stores user with active segments.
+	Set(user *model.User)
+	// Delete invalidates cached user.
+	Delete(userID uint64)
+	// Purge invalidates all cached users.
+	Purge()
+	// Stats returns cache usage statistics.
+	Stats() model.CacheStats
+}
@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+type lruEntry struct {
+	user      model.User
+	expiresAt time.Time
+}
+
+// LRUCache is in-process ICache implementation with limited size and entries TTL.
+type LRUCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	items   map[uint64]*list.Element
+	order   *list.List
+	hits    uint64
+	misses  uint64
+	nowFunc func() time.Time
+}
+
+// NewLRUCache returns new LRUCache.
+func NewLRUCache(cfg config.CacheConfig) *LRUCache {
+	return &LRUCache{
+		size:    cfg.Size,
+		ttl:     cfg.TTL,
+		items:   make(map[uint64]*list.Element),
+		order:   list.New(),
+		nowFunc: time.Now,
+	}
+}
+
+// Get returns copy of cached user with active segments if it is present and not expired.
+func (c *LRUCache) Get(userID uint64) (*model.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[userID]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if c.nowFunc().After(entry.expiresAt) {
+		c.removeElement(elem)
+		c.misses++
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	c.hits++
+
+	return copyUser(&entry.user), true
+}
+
+// Set stores copy of user active segments, the least recently used entry is evicted on overflow.
+func (c *LRUCache) Set(user *model.User) {
+	if c.size <= 0 {
+		return
+	}
+
+	entry := &lruEntry{user: *copyUser(user)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.expiresAt = c.nowFunc().Add(c.ttl)
+
+	if elem, ok := c.items[user.ID]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[user.ID] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// SetTTL changes TTL of the entries stored from now on.
+func (c *LRUCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl = ttl
+}
+
+// Delete removes user from cache.
+func (c *LRUCache) Delete(userID uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[userID]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// Purge removes all users from cache.
+func (c *LRUCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[uint64]*list.Element)
+	c.order.Init()
+}
+
+// Stats returns hits and misses counts and current cache size.
+func (c *LRUCache) Stats() model.CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return model.CacheStats{Hits: c.hits, Misses: c.misses, Size: c.order.Len()}
+}
+
+func (c *LRUCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*lruEntry).user.ID)
+}
+
+// copyUser returns copy of user with its own segments slice,
+// so callers can't modify cached values.
+func copyUser(user *model.User) *model.User {
+	userCopy := *user
+	userCopy.Segments = make([]model.Segment, len(user.Segments))
+	copy(userCopy.Segments, user.Segments)
+	return &userCopy
+}
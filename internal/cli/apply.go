@@ -0,0 +1,210 @@
+package cli
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/utils"
+)
+
+var ErrChangesFile = errors.New("invalid changes file")
+
+// Apply statuses.
+const (
+	StatusApplied = "applied"
+	StatusValid   = "valid"
+	StatusFailed  = "failed"
+)
+
+// applyResult describes the user membership change result.
+type applyResult struct {
+	UserID           uint64       `json:"user_id"`
+	SegmentsToAdd    []model.Slug `json:"segments_to_add"`
+	SegmentsToDelete []model.Slug `json:"segments_to_delete"`
+	Status           string       `json:"status"`
+	Error            string       `json:"error,omitempty"`
+}
+
+// applyMemberships applies users segments changes from the file, changes of every user are applied separately.
+// The command fails if any change failed.
+func (c *CLI) applyMemberships(ctx context.Context, args []string) error {
+	cmd := newCommand("user apply")
+	file := cmd.String("file", "", "changes file, .csv with user_id,segment_slug,operation columns or .json")
+	dryRun := cmd.Bool("dry-run", false, "only validate the changes")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("%w: %s: -file is required", ErrUsage, cmd.Name())
+	}
+
+	changes, err := readChanges(*file)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	results := make([]applyResult, 0, len(changes))
+	tbl := table{header: []string{"user_id", "segments_to_add", "segments_to_delete", "status", "error"}}
+	for _, change := range changes {
+		result := applyResult{
+			UserID:           change.UserID,
+			SegmentsToAdd:    change.SegmentsToAdd,
+			SegmentsToDelete: change.SegmentsToDelete,
+			Status:           StatusApplied,
+		}
+		if err = c.applyChange(ctx, change, *dryRun); err != nil {
+			failed++
+			result.Status = StatusFailed
+			result.Error = err.Error()
+		} else if *dryRun {
+			result.Status = StatusValid
+		}
+		results = append(results, result)
+		tbl.rows = append(tbl.rows, []string{
+			fmt.Sprint(result.UserID),
+			joinSlugs(result.SegmentsToAdd),
+			joinSlugs(result.SegmentsToDelete),
+			result.Status,
+			result.Error,
+		})
+	}
+
+	if err = write(c.out, *cmd.format, results, tbl); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d users", ErrApplyFailed, failed, len(changes))
+	}
+	return nil
+}
+
+func (c *CLI) applyChange(ctx context.Context, change *model.UserSegmentsInput, dryRun bool) error {
+	if err := change.Validate(); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+
+	userID := change.UserID
+	record := &model.AuditRecord{Action: model.AuditUserSegmentsUpdate, UserID: &userID, Reason: change.Reason}
+	record.AddSegments(change.SegmentsToAdd...)
+	record.AddSegments(change.SegmentsToDelete...)
+	ctx = c.auditContext(ctx, record, "user apply")
+	if err := c.userService.UpdateUserSegments(ctx, change); err != nil {
+		return err
+	}
+	c.audit(ctx, record)
+	return nil
+}
+
+// readChanges reads users segments changes from .csv or .json file.
+func readChanges(path string) ([]*model.UserSegmentsInput, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrChangesFile, err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return readCSVChanges(file)
+	case ".json":
+		return readJSONChanges(file)
+	default:
+		return nil, fmt.Errorf("%w: %s: only .csv and .json files are supported", ErrChangesFile, path)
+	}
+}
+
+// jsonChange describes the user segments change in the json changes file.
+type jsonChange struct {
+	UserID           uint64       `json:"user_id"`
+	SegmentsToAdd    []model.Slug `json:"segments_to_add"`
+	SegmentsToDelete []model.Slug `json:"segments_to_delete"`
+	Reason           string       `json:"reason"`
+}
+
+func readJSONChanges(r io.Reader) ([]*model.UserSegmentsInput, error) {
+	var items []jsonChange
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&items); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrChangesFile, err)
+	}
+
+	changes := make([]*model.UserSegmentsInput, 0, len(items))
+	for _, item := range items {
+		changes = append(changes, &model.UserSegmentsInput{
+			UserID:           item.UserID,
+			SegmentsToAdd:    item.SegmentsToAdd,
+			SegmentsToDelete: item.SegmentsToDelete,
+			Reason:           item.Reason,
+		})
+	}
+	return changes, nil
+}
+
+// readCSVChanges reads rows in the history report format, changes are grouped by user in order of appearance.
+func readCSVChanges(r io.Reader) ([]*model.UserSegmentsInput, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrChangesFile, err)
+	}
+	firstLine := 1
+	if len(rows) > 0 && len(rows[0]) > 0 && rows[0][0] == "user_id" {
+		rows = rows[1:]
+		firstLine++
+	}
+
+	var changes []*model.UserSegmentsInput
+	byUser := make(map[uint64]*model.UserSegmentsInput)
+	for idx, row := range rows {
+		line := idx + firstLine
+		if len(row) < 3 {
+			return nil, fmt.Errorf("%w: line %d: user_id, segment_slug and operation are required", ErrChangesFile, line)
+		}
+		userID, err := strconv.ParseUint(row[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrChangesFile, line, model.ErrInvalidUserID)
+		}
+
+		change, ok := byUser[userID]
+		if !ok {
+			change = &model.UserSegmentsInput{UserID: userID}
+			byUser[userID] = change
+			changes = append(changes, change)
+		}
+
+		slug := model.Slug(row[1])
+		switch row[2] {
+		case utils.OperationAdd:
+			change.SegmentsToAdd = append(change.SegmentsToAdd, slug)
+		case utils.OperationDelete:
+			change.SegmentsToDelete = append(change.SegmentsToDelete, slug)
+		default:
+			return nil, fmt.Errorf("%w: line %d: unknown operation %q", ErrChangesFile, line, row[2])
+		}
+	}
+	return changes, nil
+}
+
+func joinSlugs(slugs []model.Slug) string {
+	parts := make([]string, 0, len(slugs))
+	for _, slug := range slugs {
+		parts = append(parts, string(slug))
+	}
+	return strings.Join(parts, ",")
+}
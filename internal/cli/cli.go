@@ -0,0 +1,366 @@
+// Package cli describes segctl, the admin command line tool that works with the storage in-process
+// through the same services as the server.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/services"
+	"github.com/unbeman/av-prac-task/internal/worker"
+)
+
+// AuditMethod is the method of audit records made by the CLI.
+const AuditMethod = "CLI"
+
+var (
+	ErrUsage       = errors.New("usage")
+	ErrApplyFailed = errors.New("some membership changes failed")
+)
+
+const usage = `segctl [config flags] <command> [flags]
+
+Commands:
+  segment create -slug SLUG [-selection 0.2] [-reason TEXT]
+  segment delete -slug SLUG [-reason TEXT]
+  segment restore -slug SLUG [-reason TEXT]
+  segment list [-deleted]
+  user segments -id USER_ID
+  user apply -file changes.csv|changes.json [-dry-run]
+  user history -id USER_ID -from YYYY-MM-DD -to YYYY-MM-DD
+  migrate
+
+Every command accepts -o table|json|csv output format.`
+
+// CLI runs segctl commands.
+type CLI struct {
+	db             database.IDatabase
+	workersPool    *worker.WorkersPool
+	segmentService *services.SegmentService
+	userService    *services.UserService
+	auditService   *services.AuditService
+	out            io.Writer
+	actor          string
+}
+
+// NewCLI returns CLI writing commands output to out, actor is recorded in the audit log of changes.
+func NewCLI(db database.IDatabase, wp *worker.WorkersPool, fileDir string, out io.Writer, actor string) (*CLI, error) {
+	segmentService, err := services.NewSegmentService(db)
+	if err != nil {
+		return nil, err
+	}
+	userService, err := services.NewUserService(db, wp, fileDir)
+	if err != nil {
+		return nil, err
+	}
+	auditService, err := services.NewAuditService(db)
+	if err != nil {
+		return nil, err
+	}
+	return &CLI{
+		db:             db,
+		workersPool:    wp,
+		segmentService: segmentService,
+		userService:    userService,
+		auditService:   auditService,
+		out:            out,
+		actor:          actor,
+	}, nil
+}
+
+// Usage returns commands description.
+func Usage() string {
+	return usage
+}
+
+// Run runs the command given by args.
+func (c *CLI) Run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: command is required", ErrUsage)
+	}
+
+	command := args[0]
+	if command != "migrate" {
+		if len(args) < 2 {
+			return fmt.Errorf("%w: %s subcommand is required", ErrUsage, command)
+		}
+		command += " " + args[1]
+		args = args[1:]
+	}
+
+	switch command {
+	case "segment create":
+		return c.createSegment(ctx, args[1:])
+	case "segment delete":
+		return c.deleteSegment(ctx, args[1:])
+	case "segment restore":
+		return c.restoreSegment(ctx, args[1:])
+	case "segment list":
+		return c.listSegments(ctx, args[1:])
+	case "user segments":
+		return c.userSegments(ctx, args[1:])
+	case "user apply":
+		return c.applyMemberships(ctx, args[1:])
+	case "user history":
+		return c.userHistory(ctx, args[1:])
+	case "migrate":
+		return c.migrate(ctx, args[1:])
+	default:
+		return fmt.Errorf("%w: unknown command %q", ErrUsage, command)
+	}
+}
+
+// command is the flag set of the command with output format flag.
+type command struct {
+	*flag.FlagSet
+	format *string
+}
+
+func newCommand(name string) command {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return command{FlagSet: fs, format: fs.String("o", FormatTable, "output format: table, json or csv")}
+}
+
+func (cmd command) parse(args []string) error {
+	if err := cmd.Parse(args); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUsage, cmd.Name(), err)
+	}
+	if cmd.NArg() > 0 {
+		return fmt.Errorf("%w: %s: unexpected arguments %v", ErrUsage, cmd.Name(), cmd.Args())
+	}
+	if !validFormat(*cmd.format) {
+		return fmt.Errorf("%w: %s: unknown output format %q", ErrUsage, cmd.Name(), *cmd.format)
+	}
+	return nil
+}
+
+// segmentResult describes segment change result.
+type segmentResult struct {
+	Slug   model.Slug `json:"slug"`
+	Status string     `json:"status"`
+}
+
+func (c *CLI) createSegment(ctx context.Context, args []string) error {
+	cmd := newCommand("segment create")
+	input := &model.CreateSegmentInput{}
+	cmd.StringVar((*string)(&input.Slug), "slug", "", "segment slug")
+	selection := cmd.Float64("selection", -1, "part of users [0, 1] the segment is added to")
+	cmd.StringVar(&input.Reason, "reason", "", "reason of the change for the audit log")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if *selection >= 0 {
+		input.Selection = selection
+	}
+	if err := input.Validate(); err != nil {
+		return err
+	}
+
+	record := &model.AuditRecord{Action: model.AuditSegmentCreate, Segments: string(input.Slug), Reason: input.Reason}
+	ctx = c.auditContext(ctx, record, cmd.Name())
+	output, err := c.segmentService.CreateSegment(ctx, input)
+	if err != nil {
+		return err
+	}
+	c.audit(ctx, record)
+
+	return write(c.out, *cmd.format, output, table{
+		header: []string{"slug", "assigned_users"},
+		rows:   [][]string{{string(output.Slug), fmt.Sprint(output.AssignedUsers)}},
+	})
+}
+
+func (c *CLI) deleteSegment(ctx context.Context, args []string) error {
+	return c.changeSegment(ctx, args, "segment delete", model.AuditSegmentDelete, "deleted", c.segmentService.DeleteSegment)
+}
+
+func (c *CLI) restoreSegment(ctx context.Context, args []string) error {
+	return c.changeSegment(ctx, args, "segment restore", model.AuditSegmentRestore, "restored", c.segmentService.RestoreSegment)
+}
+
+func (c *CLI) changeSegment(
+	ctx context.Context,
+	args []string,
+	name, action, status string,
+	change func(ctx context.Context, input *model.SegmentInput) error,
+) error {
+	cmd := newCommand(name)
+	input := &model.SegmentInput{}
+	cmd.StringVar((*string)(&input.Slug), "slug", "", "segment slug")
+	reason := cmd.String("reason", "", "reason of the change for the audit log")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if err := input.Slug.Validate(); err != nil {
+		return err
+	}
+
+	record := &model.AuditRecord{Action: action, Segments: string(input.Slug), Reason: *reason}
+	ctx = c.auditContext(ctx, record, name)
+	if err := change(ctx, input); err != nil {
+		return err
+	}
+	c.audit(ctx, record)
+
+	result := segmentResult{Slug: input.Slug, Status: status}
+	return write(c.out, *cmd.format, result, table{
+		header: []string{"slug", "status"},
+		rows:   [][]string{{string(result.Slug), result.Status}},
+	})
+}
+
+func (c *CLI) listSegments(ctx context.Context, args []string) error {
+	cmd := newCommand("segment list")
+	filter := model.SegmentsFilter{}
+	cmd.BoolVar(&filter.WithDeleted, "deleted", false, "include deleted segments")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+
+	segments, err := c.segmentService.ListSegments(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	tbl := table{header: []string{"slug", "created_at", "deleted_at", "grants"}}
+	for _, segment := range segments {
+		deletedAt := ""
+		if segment.DeletedAt.Valid {
+			deletedAt = segment.DeletedAt.Time.Format(time.RFC3339)
+		}
+		grants := make([]string, 0, len(segment.Grants))
+		for _, grant := range segment.Grants {
+			grants = append(grants, grant.Team+":"+string(grant.Permission))
+		}
+		tbl.rows = append(tbl.rows, []string{
+			string(segment.Slug),
+			segment.CreatedAt.Format(time.RFC3339),
+			deletedAt,
+			strings.Join(grants, ","),
+		})
+	}
+	return write(c.out, *cmd.format, segments, tbl)
+}
+
+// userSegmentsResult describes user active segments.
+type userSegmentsResult struct {
+	UserID    uint64      `json:"user_id"`
+	Segments  model.Slugs `json:"segments"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+func (c *CLI) userSegments(ctx context.Context, args []string) error {
+	cmd := newCommand("user segments")
+	input := &model.UserInput{}
+	cmd.Uint64Var(&input.UserID, "id", 0, "user id")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+
+	slugs, updatedAt, err := c.userService.GetUserActiveSegments(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	result := userSegmentsResult{UserID: input.UserID, Segments: slugs, UpdatedAt: updatedAt}
+	tbl := table{header: []string{"user_id", "segment"}}
+	for _, slug := range slugs {
+		tbl.rows = append(tbl.rows, []string{fmt.Sprint(input.UserID), string(slug)})
+	}
+	return write(c.out, *cmd.format, result, tbl)
+}
+
+// historyResult describes generated history report.
+type historyResult struct {
+	UserID uint64 `json:"user_id"`
+	File   string `json:"file"`
+}
+
+// userHistory generates history report and waits until it is written.
+func (c *CLI) userHistory(ctx context.Context, args []string) error {
+	cmd := newCommand("user history")
+	input := &model.UserSegmentsHistoryInput{}
+	cmd.Uint64Var(&input.UserID, "id", 0, "user id")
+	from := cmd.String("from", "", "interval start date, YYYY-MM-DD")
+	to := cmd.String("to", "", "interval end date, YYYY-MM-DD")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if err := input.ParseDates(*from, *to); err != nil {
+		return err
+	}
+
+	filename, err := c.userService.GenerateUserSegmentsHistoryFile(ctx, input)
+	if err != nil {
+		return err
+	}
+	// the pool is owned by the CLI, its shutdown waits for the report task
+	if err = c.workersPool.Shutdown(ctx); err != nil {
+		return err
+	}
+	filePath, err := c.userService.DownloadUserSegmentsHistory(filename)
+	if err != nil {
+		return err
+	}
+
+	result := historyResult{UserID: input.UserID, File: filePath}
+	return write(c.out, *cmd.format, result, table{
+		header: []string{"user_id", "file"},
+		rows:   [][]string{{fmt.Sprint(result.UserID), result.File}},
+	})
+}
+
+// migrateResult describes storage schema state.
+type migrateResult struct {
+	SchemaVersion int `json:"schema_version"`
+}
+
+// migrate reports schema version, migrations are applied on the database connection.
+func (c *CLI) migrate(ctx context.Context, args []string) error {
+	cmd := newCommand("migrate")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+
+	version, err := c.db.GetSchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	result := migrateResult{SchemaVersion: version}
+	return write(c.out, *cmd.format, result, table{
+		header: []string{"schema_version"},
+		rows:   [][]string{{fmt.Sprint(version)}},
+	})
+}
+
+// auditContext returns context the change of the command is made with, so the change writes
+// its audit record in its transaction, see database.ContextWithAudit.
+func (c *CLI) auditContext(ctx context.Context, record *model.AuditRecord, command string) context.Context {
+	record.Actor = c.actor
+	record.Method = AuditMethod
+	record.Path = command
+	record.StatusCode = 200
+	return database.ContextWithAudit(ctx, record)
+}
+
+// audit records the change made by the CLI if it is not recorded along with the change,
+// failure to record doesn't fail the command.
+func (c *CLI) audit(ctx context.Context, record *model.AuditRecord) {
+	if record.ID > 0 {
+		return
+	}
+	if err := c.auditService.Record(ctx, record); err != nil {
+		log.Errorf("audit: can't save record of %s: %v", record.Action, err)
+	}
+}
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/worker"
+)
+
+func setupCLI(t *testing.T, setupDB func(db *mock_database.MockIDatabase)) (*CLI, *bytes.Buffer, string) {
+	ctrl := gomock.NewController(t)
+	db := mock_database.NewMockIDatabase(ctrl)
+	setupDB(db)
+
+	wp := worker.NewWorkersPool(config.NewWorkerPoolConfig())
+	go wp.Run()
+	t.Cleanup(func() { _ = wp.Shutdown(context.Background()) })
+
+	fileDir := t.TempDir()
+	out := &bytes.Buffer{}
+	c, err := NewCLI(db, wp, fileDir, out, "segctl:test")
+	require.NoError(t, err)
+	return c, out, fileDir
+}
+
+func TestCLI_SegmentList(t *testing.T) {
+	createdAt := time.Date(2023, 8, 1, 10, 0, 0, 0, time.UTC)
+	segments := []*model.Segment{
+		{Slug: "PROMO_5", CreatedAt: createdAt, Grants: []model.SegmentGrant{{Team: "legal", Permission: model.PermissionRead}}},
+		{Slug: "VOICE_MSG", CreatedAt: createdAt, DeletedAt: gorm.DeletedAt{Time: createdAt.Add(time.Hour), Valid: true}},
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{
+			name:   "table",
+			format: FormatTable,
+			want: "SLUG       CREATED_AT            DELETED_AT            GRANTS\n" +
+				"PROMO_5    2023-08-01T10:00:00Z                        legal:read\n" +
+				"VOICE_MSG  2023-08-01T10:00:00Z  2023-08-01T11:00:00Z  \n",
+		},
+		{
+			name:   "csv",
+			format: FormatCSV,
+			want: "slug,created_at,deleted_at,grants\n" +
+				"PROMO_5,2023-08-01T10:00:00Z,,legal:read\n" +
+				"VOICE_MSG,2023-08-01T10:00:00Z,2023-08-01T11:00:00Z,\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, out, _ := setupCLI(t, func(db *mock_database.MockIDatabase) {
+				db.EXPECT().ListSegments(gomock.Any(), model.SegmentsFilter{WithDeleted: true}).Return(segments, nil)
+			})
+
+			require.NoError(t, c.Run(context.Background(), []string{"segment", "list", "-deleted", "-o", tt.format}))
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		c, out, _ := setupCLI(t, func(db *mock_database.MockIDatabase) {
+			db.EXPECT().ListSegments(gomock.Any(), model.SegmentsFilter{}).Return(segments[:1], nil)
+		})
+
+		require.NoError(t, c.Run(context.Background(), []string{"segment", "list", "-o", "json"}))
+		assert.Contains(t, out.String(), `"slug": "PROMO_5"`)
+		assert.Contains(t, out.String(), `"team": "legal"`)
+	})
+}
+
+func TestCLI_SegmentRestore(t *testing.T) {
+	c, out, _ := setupCLI(t, func(db *mock_database.MockIDatabase) {
+		db.EXPECT().RestoreSegment(gomock.Any(), &model.Segment{Slug: "PROMO_5"}).Return(nil)
+		db.EXPECT().CreateAuditRecord(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, record *model.AuditRecord) error {
+				assert.Equal(t, model.AuditSegmentRestore, record.Action)
+				assert.Equal(t, "segctl:test", record.Actor)
+				assert.Equal(t, AuditMethod, record.Method)
+				assert.Equal(t, "segment restore", record.Path)
+				assert.Equal(t, "PROMO_5", record.Segments)
+				assert.Equal(t, "restarted promo", record.Reason)
+				return nil
+			})
+	})
+
+	err := c.Run(context.Background(), []string{"segment", "restore", "-slug", "promo_5", "-reason", "restarted promo", "-o", "csv"})
+	require.NoError(t, err)
+	assert.Equal(t, "slug,status\nPROMO_5,restored\n", out.String())
+}
+
+func TestCLI_SegmentRestoreNotFound(t *testing.T) {
+	c, _, _ := setupCLI(t, func(db *mock_database.MockIDatabase) {
+		db.EXPECT().RestoreSegment(gomock.Any(), gomock.Any()).Return(database.ErrNotFound)
+		db.EXPECT().CreateAuditRecord(gomock.Any(), gomock.Any()).Times(0)
+	})
+
+	err := c.Run(context.Background(), []string{"segment", "restore", "-slug", "PROMO_5"})
+	require.ErrorIs(t, err, database.ErrNotFound)
+}
+
+func TestCLI_UserApply(t *testing.T) {
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "changes.csv")
+	require.NoError(t, os.WriteFile(csvFile, []byte(
+		"user_id,segment_slug,operation\n"+
+			"1,promo_5,add\n"+
+			"2,voice_msg,delete\n"+
+			"1,voice_msg,delete\n",
+	), 0o600))
+	jsonFile := filepath.Join(dir, "changes.json")
+	require.NoError(t, os.WriteFile(jsonFile, []byte(
+		`[{"user_id": 1, "segments_to_add": ["PROMO_5"], "segments_to_delete": ["VOICE_MSG"], "reason": "ticket 1"},
+		  {"user_id": 2, "segments_to_delete": ["VOICE_MSG"]}]`,
+	), 0o600))
+
+	for _, file := range []string{csvFile, jsonFile} {
+		t.Run(filepath.Ext(file), func(t *testing.T) {
+			c, out, _ := setupCLI(t, func(db *mock_database.MockIDatabase) {
+				user1, user2 := &model.User{}, &model.User{}
+				user1.ID, user2.ID = 1, 2
+				db.EXPECT().CreateDeleteUserSegments(gomock.Any(), user1, []model.Slug{"PROMO_5"}, []model.Slug{"VOICE_MSG"}, gomock.Any()).Return(nil)
+				db.EXPECT().CreateDeleteUserSegments(gomock.Any(), user2, nil, []model.Slug{"VOICE_MSG"}, gomock.Any()).Return(database.ErrNotFound)
+				db.EXPECT().CreateAuditRecord(gomock.Any(), gomock.Any()).Times(1)
+			})
+
+			err := c.Run(context.Background(), []string{"user", "apply", "-file", file, "-o", "csv"})
+			require.ErrorIs(t, err, ErrApplyFailed)
+			assert.Equal(t, "user_id,segments_to_add,segments_to_delete,status,error\n"+
+				"1,PROMO_5,VOICE_MSG,applied,\n"+
+				"2,,VOICE_MSG,failed,"+database.ErrNotFound.Error()+"\n", out.String())
+		})
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		c, out, _ := setupCLI(t, func(db *mock_database.MockIDatabase) {
+			db.EXPECT().CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+		})
+
+		require.NoError(t, c.Run(context.Background(), []string{"user", "apply", "-file", csvFile, "-dry-run", "-o", "csv"}))
+		assert.Contains(t, out.String(), "1,PROMO_5,VOICE_MSG,valid,")
+	})
+
+	t.Run("invalid operation", func(t *testing.T) {
+		badFile := filepath.Join(dir, "bad.csv")
+		require.NoError(t, os.WriteFile(badFile, []byte("1,PROMO_5,update\n"), 0o600))
+		c, _, _ := setupCLI(t, func(db *mock_database.MockIDatabase) {})
+
+		err := c.Run(context.Background(), []string{"user", "apply", "-file", badFile})
+		require.ErrorIs(t, err, ErrChangesFile)
+		assert.ErrorContains(t, err, "line 1")
+	})
+}
+
+func TestCLI_UserHistory(t *testing.T) {
+	c, out, fileDir := setupCLI(t, func(db *mock_database.MockIDatabase) {
+		db.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(&model.User{}, nil)
+		db.EXPECT().GetUserSegmentsHistory(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil)
+	})
+
+	err := c.Run(context.Background(), []string{"user", "history", "-id", "1", "-from", "2023-08-01", "-to", "2023-08-31", "-o", "json"})
+	require.NoError(t, err)
+
+	filePath := filepath.Join(fileDir, "user-1_2023-08-01_2023-08-31.csv")
+	assert.FileExists(t, filePath)
+	assert.Contains(t, out.String(), `"file": "`+filePath+`"`)
+}
+
+func TestCLI_Usage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "no command", args: nil},
+		{name: "no subcommand", args: []string{"segment"}},
+		{name: "unknown command", args: []string{"segment", "rename"}},
+		{name: "unknown flag", args: []string{"segment", "list", "-all"}},
+		{name: "unknown format", args: []string{"migrate", "-o", "xml"}},
+		{name: "no file", args: []string{"user", "apply"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _, _ := setupCLI(t, func(db *mock_database.MockIDatabase) {})
+			require.ErrorIs(t, c.Run(context.Background(), tt.args), ErrUsage)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats.
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+func validFormat(format string) bool {
+	switch format {
+	case FormatTable, FormatJSON, FormatCSV:
+		return true
+	}
+	return false
+}
+
+// table describes command result for table and csv formats.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// write writes the result in the given format, value is written as json, tbl as table or csv.
+func write(w io.Writer, format string, value any, tbl table) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case FormatCSV:
+		return writeCSV(w, tbl)
+	default:
+		return writeTable(w, tbl)
+	}
+}
+
+func writeCSV(w io.Writer, tbl table) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(tbl.header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(tbl.rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+func writeTable(w io.Writer, tbl table) error {
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := make([]string, 0, len(tbl.header))
+	for _, name := range tbl.header {
+		header = append(header, strings.ToUpper(name))
+	}
+	if _, err := fmt.Fprintln(writer, strings.Join(header, "\t")); err != nil {
+		return err
+	}
+	for _, row := range tbl.rows {
+		if _, err := fmt.Fprintln(writer, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,9 @@
+package config
+
+import "errors"
+
+var (
+	ErrInvalidConfig = errors.New("invalid config")
+	ErrConfigFile    = errors.New("can't read config file")
+	ErrFlags         = errors.New("invalid command-line flags")
+)
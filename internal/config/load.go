@@ -0,0 +1,191 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env/v8"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file path is set by ConfigFileFlag command-line flag or ConfigFileEnv env var.
+const (
+	ConfigFileFlag = "config"
+	ConfigFileEnv  = "CONFIG_FILE"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// GetAppConfig returns the config layered from defaults, YAML config file, env vars and command-line flags args,
+// each next layer overrides the previous one. Every setting has the flag named by its dotted path in the file,
+// e.g. -workers_pool.workers_count.
+func GetAppConfig(args []string) (AppConfig, error) {
+	cfg, rest, err := LoadAppConfig(args)
+	if err != nil {
+		return cfg, err
+	}
+	if len(rest) > 0 {
+		return cfg, fmt.Errorf("%w: unexpected arguments %v", ErrFlags, rest)
+	}
+	return cfg, nil
+}
+
+// LoadAppConfig is GetAppConfig that stops parsing flags at the first non-flag argument
+// and returns the rest of args, e.g. the command of the CLI.
+func LoadAppConfig(args []string) (AppConfig, []string, error) {
+	cfg := NewAppConfig()
+
+	fs := flag.NewFlagSet("segments", flag.ContinueOnError)
+	configFile := fs.String(ConfigFileFlag, os.Getenv(ConfigFileEnv), "path to YAML config file, env "+ConfigFileEnv)
+	overrides := map[string]string{}
+	for _, f := range settings(&cfg) {
+		f := f
+		fs.Func(f.path, fmt.Sprintf("env %s (default %s)", f.env, formatValue(f.value)), func(s string) error {
+			if err := parseValue(reflect.New(f.value.Type()).Elem(), s); err != nil {
+				return err
+			}
+			overrides[f.path] = s
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, fmt.Errorf("%w: %w", ErrFlags, err)
+	}
+
+	if *configFile != "" {
+		if err := loadFile(&cfg, *configFile); err != nil {
+			return cfg, nil, err
+		}
+		cfg.File = *configFile
+	}
+
+	if err := env.Parse(&cfg); err != nil {
+		return cfg, nil, err
+	}
+
+	for _, f := range settings(&cfg) {
+		if s, ok := overrides[f.path]; ok {
+			// the value is already checked by the flag parsing
+			_ = parseValue(f.value, s)
+		}
+	}
+	return cfg, fs.Args(), nil
+}
+
+// loadFile overrides cfg with settings from YAML file, unknown settings are rejected.
+func loadFile(cfg *AppConfig, path string) error {
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+	default:
+		return fmt.Errorf("%w %s: unsupported format %q, use .yaml or .yml", ErrConfigFile, path, ext)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConfigFile, err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w %s: %v", ErrConfigFile, path, err)
+	}
+	return nil
+}
+
+// setting is the leaf config field.
+type setting struct {
+	path   string // dotted path of YAML keys
+	env    string
+	secret string
+	value  reflect.Value
+}
+
+// settings returns addressable leaf fields of cfg in declaration order.
+func settings(cfg *AppConfig) []setting {
+	var out []setting
+	collectSettings(reflect.ValueOf(cfg).Elem(), "", "", &out)
+	return out
+}
+
+func collectSettings(v reflect.Value, path, envPrefix string, out *[]setting) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Tag.Get("yaml") == "-" {
+			continue
+		}
+		name := path + yamlName(field)
+		if field.Type.Kind() == reflect.Struct {
+			collectSettings(v.Field(i), name+".", envPrefix+field.Tag.Get("envPrefix"), out)
+			continue
+		}
+		*out = append(*out, setting{
+			path:   name,
+			env:    envPrefix + field.Tag.Get("env"),
+			secret: field.Tag.Get("secret"),
+			value:  v.Field(i),
+		})
+	}
+}
+
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// parseValue sets v parsed from s.
+func parseValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"io"
+	"reflect"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+const masked = "*****"
+
+var (
+	// urlPassword matches password of URL DSN user info.
+	urlPassword = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*://[^:/@]*:)[^@]+@`)
+	// dsnPassword matches password of key=value DSN and URL query.
+	dsnPassword = regexp.MustCompile(`(?i)\b(password)=('[^']*'|[^\s&]+)`)
+)
+
+// WriteYAML writes cfg in the config file format with env var names in comments, secrets are masked.
+func (cfg AppConfig) WriteYAML(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{yamlNode(reflect.ValueOf(cfg), "")}}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func yamlNode(v reflect.Value, envPrefix string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Tag.Get("yaml") == "-" {
+			continue
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: yamlName(field)}
+
+		if field.Type.Kind() == reflect.Struct {
+			node.Content = append(node.Content, key, yamlNode(v.Field(i), envPrefix+field.Tag.Get("envPrefix")))
+			continue
+		}
+
+		value := formatValue(v.Field(i))
+		switch field.Tag.Get("secret") {
+		case "true":
+			if value != "" {
+				value = masked
+			}
+		case "dsn":
+			value = maskDSN(value)
+		}
+		node.Content = append(node.Content, key, &yaml.Node{
+			Kind:        yaml.ScalarNode,
+			Value:       value,
+			LineComment: envPrefix + field.Tag.Get("env"),
+		})
+	}
+	return node
+}
+
+// maskDSN hides password of URL or key=value DSN.
+func maskDSN(dsn string) string {
+	dsn = urlPassword.ReplaceAllString(dsn, "${1}"+masked+"@")
+	return dsnPassword.ReplaceAllString(dsn, "${1}="+masked)
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Changed returns paths of settings that differ in cfg and other.
+func (cfg AppConfig) Changed(other AppConfig) []string {
+	var changed []string
+	otherSettings := settings(&other)
+	for i, s := range settings(&cfg) {
+		if !reflect.DeepEqual(s.value.Interface(), otherSettings[i].value.Interface()) {
+			changed = append(changed, s.path)
+		}
+	}
+	return changed
+}
+
+// WatchFile polls the file every interval and notifies when its modification time or size is changed.
+// The returned channel is nil if there is no file or interval is zero, so it is never ready.
+func WatchFile(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	if path == "" || interval <= 0 {
+		return nil
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last, _ := os.Stat(path)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil || (last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size()) {
+				continue
+			}
+			last = info
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Validate checks settings are usable, all the problems are reported at once.
+func (cfg AppConfig) Validate() error {
+	v := validator{settings: map[string]setting{}}
+	for _, s := range settings(&cfg) {
+		v.settings[s.path] = s
+	}
+
+	v.check(cfg.Address != "", "address", "must not be empty")
+	v.check(cfg.GRPCAddress != "", "grpc_address", "must not be empty")
+	v.check(cfg.FileDirectory != "", "file_directory", "must not be empty")
+	v.check(cfg.ShutdownTimeout > 0, "shutdown_timeout", "must be positive")
+	v.check(cfg.ReloadInterval >= 0, "reload_interval", "must not be negative, 0 disables it")
+
+	v.check(cfg.DB.DSN != "", "postgres.dsn", "must not be empty")
+	v.check(cfg.DB.MaxOpenConns >= 0, "postgres.max_open_conns", "must not be negative, 0 is unlimited")
+	v.check(cfg.DB.MaxIdleConns >= 0, "postgres.max_idle_conns", "must not be negative")
+	v.check(cfg.DB.MaxOpenConns == 0 || cfg.DB.MaxIdleConns <= cfg.DB.MaxOpenConns,
+		"postgres.max_idle_conns", "must not exceed postgres.max_open_conns")
+	v.check(cfg.DB.ConnMaxLifetime >= 0, "postgres.conn_max_lifetime", "must not be negative, 0 is unlimited")
+	v.check(cfg.DB.SlowQueryThreshold >= 0, "postgres.slow_query_threshold", "must not be negative, 0 disables it")
+
+	_, err := log.ParseLevel(cfg.Logger.Level)
+	v.check(err == nil, "log.level", "must be one of trace, debug, info, warn, error, fatal, panic")
+	v.check(cfg.Logger.Format == "json" || cfg.Logger.Format == "text", "log.format", "must be json or text")
+
+	v.check(cfg.WorkersPool.WorkersCount > 0, "workers_pool.workers_count", "must be positive")
+	v.check(cfg.WorkersPool.TasksSize >= 0, "workers_pool.tasks_size", "must not be negative")
+
+	v.check(cfg.Cache.Size > 0, "cache.size", "must be positive")
+	v.check(cfg.Cache.TTL >= 0, "cache.ttl", "must not be negative")
+
+	v.check(cfg.Events.PollInterval > 0, "events.poll_interval", "must be positive")
+	v.check(cfg.Events.Heartbeat > 0, "events.heartbeat", "must be positive")
+	v.check(cfg.Events.BatchSize > 0, "events.batch_size", "must be positive")
+
+	v.check(cfg.Webhooks.DispatchInterval > 0, "webhooks.dispatch_interval", "must be positive")
+	v.check(cfg.Webhooks.Timeout > 0, "webhooks.timeout", "must be positive")
+	v.check(cfg.Webhooks.BatchSize > 0, "webhooks.batch_size", "must be positive")
+	v.check(cfg.Webhooks.MaxBackoff > 0, "webhooks.max_backoff", "must be positive")
+	v.check(cfg.Webhooks.Workers > 0, "webhooks.workers", "must be positive")
+	// the claimed subscription waits for a busy worker at most for one delivery
+	v.check(cfg.Webhooks.Lease > 2*cfg.Webhooks.Timeout, "webhooks.lease", "must be greater than twice webhooks.timeout")
+
+	v.check(cfg.Scheduler.Interval > 0, "scheduler.interval", "must be positive")
+
+	for _, group := range []struct {
+		name  string
+		limit RateLimit
+	}{
+		{"read", cfg.RateLimit.Read},
+		{"write", cfg.RateLimit.Write},
+		{"reports", cfg.RateLimit.Reports},
+	} {
+		path := "rate_limit." + group.name
+		v.check(group.limit.Rate >= 0, path+".rate", "must not be negative, 0 disables the limit")
+		v.check(group.limit.Rate == 0 || group.limit.Burst > 0, path+".burst", "must be positive")
+	}
+
+	v.check(cfg.Idempotency.TTL > 0, "idempotency.ttl", "must be positive")
+	v.check(cfg.Idempotency.Lease > 0, "idempotency.lease", "must be positive")
+
+	switch cfg.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		v.check(cfg.Tracing.OTLPEndpoint != "", "tracing.otlp_endpoint", "must not be empty for otlp exporter")
+	default:
+		v.check(false, "tracing.exporter", "must be one of none, otlp, stdout")
+	}
+	v.check(cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "tracing.sample_ratio", "must be between 0 and 1")
+
+	v.check(cfg.Health.CheckTimeout > 0, "health.check_timeout", "must be positive")
+	v.check(cfg.Health.DrainDelay >= 0, "health.drain_delay", "must not be negative")
+
+	return errors.Join(v.errs...)
+}
+
+type validator struct {
+	settings map[string]setting
+	errs     []error
+}
+
+// check reports the setting by its path in the config file and env var name.
+func (v *validator) check(ok bool, path, msg string) {
+	if ok {
+		return
+	}
+	s := v.settings[path]
+	v.errs = append(v.errs, fmt.Errorf("%w: %s (env %s) %s, got %q", ErrInvalidConfig, path, s.env, msg, formatValue(s.value)))
+}
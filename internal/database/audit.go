@@ -0,0 +1,22 @@
+package database
+
+import (
+	"context"
+
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+type auditCtxKey struct{}
+
+// ContextWithAudit returns context carrying audit record of the call. Mutations made with the context
+// write the record and a record per changed user membership in their transaction, the record ID is set
+// once it is committed.
+func ContextWithAudit(ctx context.Context, record *model.AuditRecord) context.Context {
+	return context.WithValue(ctx, auditCtxKey{}, record)
+}
+
+// AuditFromContext returns audit record of the call, ok is false for calls that are not audited.
+func AuditFromContext(ctx context.Context) (*model.AuditRecord, bool) {
+	record, ok := ctx.Value(auditCtxKey{}).(*model.AuditRecord)
+	return record, ok
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"sync"
+
+	"github.com/unbeman/av-prac-task/internal/cache"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+// generationStripes is the number of users invalidation counters, users share counters by id.
+const generationStripes = 256
+
+// cachedDatabase is IDatabase implementation that serves users active segments
+// from cache and invalidates it on memberships changes.
+type cachedDatabase struct {
+	IDatabase
+	cache cache.ICache
+
+	// mu orders storing loaded users with invalidations. Users generations are bumped on every invalidation,
+	// so a user loaded before the invalidation is not stored to cache after it.
+	mu          sync.Mutex
+	generations [generationStripes]uint64
+	purges      uint64
+}
+
+// NewCachedDatabase returns IDatabase that reads users active segments through given cache.
+func NewCachedDatabase(db IDatabase, c cache.ICache) IDatabase {
+	return &cachedDatabase{IDatabase: db, cache: c}
+}
+
+// CreateSegmentForRandomUsers creates segment of random users and purges cache,
+// as any of the cached users may be selected.
+func (c *cachedDatabase) CreateSegmentForRandomUsers(ctx context.Context, segment *model.Segment, selection float64) (*model.Segment, int64, error) {
+	created, assigned, err := c.IDatabase.CreateSegmentForRandomUsers(ctx, segment, selection)
+	if assigned > 0 {
+		c.purge()
+	}
+	return created, assigned, err
+}
+
+// DeleteSegment deletes segment and purges cache.
+func (c *cachedDatabase) DeleteSegment(ctx context.Context, segment *model.Segment, authorize SegmentsAuthorizer) error {
+	defer c.purge()
+	return c.IDatabase.DeleteSegment(ctx, segment, authorize)
+}
+
+// SetSegmentGrants updates segment grants and purges cache,
+// as grants are cached along with users segments.
+func (c *cachedDatabase) SetSegmentGrants(ctx context.Context, segment *model.Segment, grants []model.SegmentGrant) error {
+	defer c.purge()
+	return c.IDatabase.SetSegmentGrants(ctx, segment, grants)
+}
+
+// CreateDeleteUserSegments updates user segments and invalidates cached user.
+func (c *cachedDatabase) CreateDeleteUserSegments(
+	ctx context.Context,
+	user *model.User,
+	toInSegments []model.Slug,
+	toDelSegments []model.Slug,
+	authorize SegmentsAuthorizer,
+) error {
+	defer c.invalidate(user.ID)
+	return c.IDatabase.CreateDeleteUserSegments(ctx, user, toInSegments, toDelSegments, authorize)
+}
+
+// GetUserWithActiveSegments returns cached user with active segments,
+// on cache miss user is loaded from underlying database and stored to cache
+// unless the user is invalidated while loading.
+func (c *cachedDatabase) GetUserWithActiveSegments(ctx context.Context, user *model.User) (*model.User, error) {
+	if cached, ok := c.cache.Get(user.ID); ok {
+		*user = *cached
+		return user, nil
+	}
+
+	c.mu.Lock()
+	generation := c.generation(user.ID)
+	c.mu.Unlock()
+
+	user, err := c.IDatabase.GetUserWithActiveSegments(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation(user.ID) == generation {
+		c.cache.Set(user)
+	}
+	return user, nil
+}
+
+// generation returns the counter of the user invalidations, c.mu must be held.
+func (c *cachedDatabase) generation(userID uint64) uint64 {
+	return c.generations[userID%generationStripes] + c.purges
+}
+
+// invalidate deletes the user from cache.
+func (c *cachedDatabase) invalidate(userID uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generations[userID%generationStripes]++
+	c.cache.Delete(userID)
+}
+
+// purge deletes all users from cache.
+func (c *cachedDatabase) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purges++
+	c.cache.Purge()
+}
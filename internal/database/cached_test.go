@@ -0,0 +1,104 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/cache"
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+func TestCachedDatabase_GetUserWithActiveSegments(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	user := model.User{ID: 1, Segments: []model.Segment{{Slug: "SEGMENT-A"}}}
+
+	mockDB := mock_database.NewMockIDatabase(ctrl)
+	mockDB.EXPECT().
+		GetUserWithActiveSegments(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, u *model.User) (*model.User, error) {
+			*u = user
+			return u, nil
+		}).
+		Times(3)
+	mockDB.EXPECT().
+		CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil)
+	mockDB.EXPECT().
+		DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil)
+
+	userCache := cache.NewLRUCache(config.NewCacheConfig())
+	db := database.NewCachedDatabase(mockDB, userCache)
+	ctx := context.Background()
+
+	get := func() *model.User {
+		got, err := db.GetUserWithActiveSegments(ctx, &model.User{ID: user.ID})
+		require.NoError(t, err)
+		return got
+	}
+
+	// miss, then hit
+	require.Equal(t, user.Segments, get().Segments)
+	require.Equal(t, user.Segments, get().Segments)
+
+	// membership change invalidates the user
+	require.NoError(t, db.CreateDeleteUserSegments(ctx, &model.User{ID: user.ID}, []model.Slug{"SEGMENT-B"}, nil, nil))
+	get()
+
+	// segment deletion invalidates everyone
+	require.NoError(t, db.DeleteSegment(ctx, &model.Segment{Slug: "SEGMENT-A"}, nil))
+	get()
+
+	require.Equal(t, model.CacheStats{Hits: 1, Misses: 3, Size: 1}, userCache.Stats())
+}
+
+func TestCachedDatabase_GetUserWithActiveSegmentsInvalidatedWhileLoading(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stale := model.User{ID: 1, Segments: []model.Segment{{Slug: "SEGMENT-A"}}}
+	fresh := model.User{ID: 1, Segments: []model.Segment{{Slug: "SEGMENT-B"}}}
+
+	userCache := cache.NewLRUCache(config.NewCacheConfig())
+	mockDB := mock_database.NewMockIDatabase(ctrl)
+	var db database.IDatabase
+	ctx := context.Background()
+
+	gomock.InOrder(
+		// the user is changed after it is read, but before it is stored to cache
+		mockDB.EXPECT().
+			GetUserWithActiveSegments(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, u *model.User) (*model.User, error) {
+				*u = stale
+				require.NoError(t, db.CreateDeleteUserSegments(ctx, &model.User{ID: u.ID}, []model.Slug{"SEGMENT-B"}, []model.Slug{"SEGMENT-A"}, nil))
+				return u, nil
+			}),
+		mockDB.EXPECT().
+			GetUserWithActiveSegments(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, u *model.User) (*model.User, error) {
+				*u = fresh
+				return u, nil
+			}),
+	)
+	mockDB.EXPECT().
+		CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil)
+	db = database.NewCachedDatabase(mockDB, userCache)
+
+	got, err := db.GetUserWithActiveSegments(ctx, &model.User{ID: stale.ID})
+	require.NoError(t, err)
+	require.Equal(t, stale.Segments, got.Segments)
+
+	// the stale user is not cached, so the change is seen by the next read
+	got, err = db.GetUserWithActiveSegments(ctx, &model.User{ID: stale.ID})
+	require.NoError(t, err)
+	require.Equal(t, fresh.Segments, got.Segments)
+}
@@ -8,17 +8,63 @@ import (
 	"github.com/unbeman/av-prac-task/internal/model"
 )
 
+// SegmentsAuthorizer checks the caller may change users of segments that are changed along with
+// the requested change, i.e. removed by exclusion group replace policy or by prerequisites cascade,
+// also on the segment deletion.
+// Nil authorizer allows every change.
+type SegmentsAuthorizer func(segments []*model.Segment) error
+
+// SchemaVersion is the version of the storage schema the application works with,
+// it is bumped whenever migrated models change.
+const SchemaVersion = 10
+
 // IDatabase describes the storage usage.
 type IDatabase interface {
 	CreateSegment(ctx context.Context, segment *model.Segment) (*model.Segment, error)
-	AddSegmentToRandomUsers(ctx context.Context, segment *model.Segment, selection float64) error
-	DeleteSegment(ctx context.Context, segment *model.Segment) error
+	CreateSegmentForRandomUsers(ctx context.Context, segment *model.Segment, selection float64) (*model.Segment, int64, error)
+	DeleteSegment(ctx context.Context, segment *model.Segment, authorize SegmentsAuthorizer) error
+	RestoreSegment(ctx context.Context, segment *model.Segment) error
+	ListSegments(ctx context.Context, filter model.SegmentsFilter) ([]*model.Segment, error)
+	GetSegmentsVersion(ctx context.Context) (uint64, error)
 	GetSegment(ctx context.Context, segment *model.Segment) (*model.Segment, error)
 	GetSegments(ctx context.Context, slugs []model.Slug) ([]*model.Segment, error)
-	CreateDeleteUserSegments(ctx context.Context, user *model.User, SegSlugsForCreate []model.Slug, SegSlugsForDelete []model.Slug) error
+	SetSegmentGrants(ctx context.Context, segment *model.Segment, grants []model.SegmentGrant) error
+	GetSegmentPrerequisites(ctx context.Context, segment *model.Segment) (*model.Segment, error)
+	SetSegmentPrerequisites(ctx context.Context, segment *model.Segment, prerequisites []model.PrerequisiteInput) error
+	CreateExperiment(ctx context.Context, experiment *model.Experiment) (*model.Experiment, error)
+	GetExperiment(ctx context.Context, experiment *model.Experiment) (*model.Experiment, error)
+	ListExperiments(ctx context.Context) ([]*model.Experiment, error)
+	UpdateExperimentWeights(ctx context.Context, experiment *model.Experiment, weights map[model.Slug]float64) (*model.Experiment, error)
+	CreateExclusionGroup(ctx context.Context, group *model.ExclusionGroup, slugs []model.Slug) (*model.ExclusionGroup, error)
+	GetExclusionGroup(ctx context.Context, group *model.ExclusionGroup) (*model.ExclusionGroup, error)
+	DeleteExclusionGroup(ctx context.Context, group *model.ExclusionGroup) error
+	CreateDeleteUserSegments(
+		ctx context.Context,
+		user *model.User,
+		SegSlugsForCreate []model.Slug,
+		SegSlugsForDelete []model.Slug,
+		authorize SegmentsAuthorizer,
+	) error
 	GetUserWithActiveSegments(ctx context.Context, input *model.User) (*model.User, error)
 	GetUserSegmentsHistory(ctx context.Context, user *model.User, from time.Time, to time.Time) ([]model.UserSegment, error)
 	GetUser(ctx context.Context, user *model.User) (*model.User, error)
+	GetEvents(ctx context.Context, filter model.EventsFilter) ([]model.Event, error)
+	CreateWebhook(ctx context.Context, webhook *model.WebhookSubscription) (*model.WebhookSubscription, error)
+	GetWebhooks(ctx context.Context) ([]*model.WebhookSubscription, error)
+	DeleteWebhook(ctx context.Context, webhook *model.WebhookSubscription) error
+	ClaimWebhooks(ctx context.Context, lease time.Duration, limit int) ([]*model.WebhookSubscription, error)
+	UpdateWebhookDelivery(ctx context.Context, webhook *model.WebhookSubscription) error
+	CreateAPIKey(ctx context.Context, key *model.APIKey) (*model.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*model.APIKey, error)
+	DeleteAPIKey(ctx context.Context, key *model.APIKey) error
+	CreateAuditRecord(ctx context.Context, record *model.AuditRecord) error
+	GetAuditRecords(ctx context.Context, filter model.AuditFilter) ([]model.AuditRecord, error)
+	CreateIdempotencyRecord(ctx context.Context, record *model.IdempotencyRecord) (*model.IdempotencyRecord, bool, error)
+	CompleteIdempotencyRecord(ctx context.Context, record *model.IdempotencyRecord) error
+	DeleteIdempotencyRecord(ctx context.Context, record *model.IdempotencyRecord) error
+	Ping(ctx context.Context) error
+	GetSchemaVersion(ctx context.Context) (int, error)
+	Close() error
 }
 
 // GetDatabase returns IDatabase implementation.
@@ -6,4 +6,10 @@ var (
 	ErrAlreadyExists = errors.New("already exists")
 	ErrNotFound      = errors.New("not found")
 	ErrDB            = errors.New("database error")
+
+	ErrDynamicSegment = errors.New("membership can't be changed explicitly")
+	ErrGroupConflict  = errors.New("segments of exclusion group can't share users")
+
+	ErrPrerequisite      = errors.New("segment prerequisite is not met")
+	ErrPrerequisiteCycle = errors.New("segment prerequisites make a cycle")
 )
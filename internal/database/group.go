@@ -0,0 +1,52 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+// replaceGroupSegments checks segments to insert to the user against the user's current segments
+// of their exclusion groups and returns segments to delete with the current segments replaced by inserted ones.
+// Inserting two segments of one group, or a segment of the group with reject policy the user is already
+// in another segment of, fails with ErrGroupConflict. Groups are given by id.
+func replaceGroupSegments(
+	current []*model.Segment,
+	groups map[uint64]*model.ExclusionGroup,
+	insertSegments []*model.Segment,
+	deleteSegments []*model.Segment,
+) ([]*model.Segment, error) {
+	inserted := make(map[uint64]model.Slug)
+	changed := make(map[uint64]bool, len(insertSegments)+len(deleteSegments))
+	for _, segment := range insertSegments {
+		changed[segment.ID] = true
+		if segment.GroupID == nil {
+			continue
+		}
+		if other, ok := inserted[*segment.GroupID]; ok {
+			return nil, fmt.Errorf("segments with slugs (%s, %s) are in the same group, %w", other, segment.Slug, ErrGroupConflict)
+		}
+		inserted[*segment.GroupID] = segment.Slug
+	}
+	for _, segment := range deleteSegments {
+		changed[segment.ID] = true
+	}
+
+	for _, segment := range current {
+		if segment.GroupID == nil || changed[segment.ID] {
+			continue
+		}
+		if _, ok := inserted[*segment.GroupID]; !ok {
+			continue
+		}
+		group, ok := groups[*segment.GroupID]
+		if !ok {
+			return nil, fmt.Errorf("%w: exclusion group (%d) is not loaded", ErrDB, *segment.GroupID)
+		}
+		if group.Policy != model.GroupPolicyReplace {
+			return nil, fmt.Errorf("user is in segment with slug (%s) of group (%s), %w", segment.Slug, group.Slug, ErrGroupConflict)
+		}
+		deleteSegments = append(deleteSegments, segment)
+	}
+	return deleteSegments, nil
+}
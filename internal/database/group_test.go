@@ -0,0 +1,78 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+func TestReplaceGroupSegments(t *testing.T) {
+	rejectID, replaceID := uint64(1), uint64(2)
+	groups := map[uint64]*model.ExclusionGroup{
+		rejectID:  {ID: rejectID, Slug: "PROMO_DISCOUNTS", Policy: model.GroupPolicyReject},
+		replaceID: {ID: replaceID, Slug: "CHECKOUT", Policy: model.GroupPolicyReplace},
+	}
+	promo5 := &model.Segment{ID: 1, Slug: "PROMO_5", GroupID: &rejectID}
+	sales20 := &model.Segment{ID: 2, Slug: "AVITO_SALES_20", GroupID: &rejectID}
+	checkoutA := &model.Segment{ID: 3, Slug: "CHECKOUT_A", GroupID: &replaceID}
+	checkoutB := &model.Segment{ID: 4, Slug: "CHECKOUT_B", GroupID: &replaceID}
+	voice := &model.Segment{ID: 5, Slug: "AVITO_VOICE_MESSAGES"}
+
+	tests := []struct {
+		name           string
+		current        []*model.Segment
+		insertSegments []*model.Segment
+		deleteSegments []*model.Segment
+		expected       []*model.Segment
+		expectedErr    error
+	}{
+		{
+			name:           "Ungrouped segments",
+			current:        []*model.Segment{promo5},
+			insertSegments: []*model.Segment{voice},
+		},
+		{
+			name:           "Reject",
+			current:        []*model.Segment{promo5, voice},
+			insertSegments: []*model.Segment{sales20},
+			expectedErr:    ErrGroupConflict,
+		},
+		{
+			name:           "Reject with the other segment deleted",
+			current:        []*model.Segment{promo5},
+			insertSegments: []*model.Segment{sales20},
+			deleteSegments: []*model.Segment{promo5},
+			expected:       []*model.Segment{promo5},
+		},
+		{
+			name:           "Replace",
+			current:        []*model.Segment{checkoutA, promo5},
+			insertSegments: []*model.Segment{checkoutB},
+			expected:       []*model.Segment{checkoutA},
+		},
+		{
+			name:           "Insert current segment",
+			current:        []*model.Segment{promo5},
+			insertSegments: []*model.Segment{promo5},
+		},
+		{
+			name:           "Insert two segments of the group",
+			insertSegments: []*model.Segment{checkoutA, checkoutB},
+			expectedErr:    ErrGroupConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleteSegments, err := replaceGroupSegments(tt.current, groups, tt.insertSegments, tt.deleteSegments)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, deleteSegments)
+		})
+	}
+}
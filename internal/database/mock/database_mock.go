@@ -10,6 +10,7 @@ import (
 	time "time"
 
 	gomock "github.com/golang/mock/gomock"
+	database "github.com/unbeman/av-prac-task/internal/database"
 	model "github.com/unbeman/av-prac-task/internal/model"
 )
 
@@ -36,32 +37,136 @@ func (m *MockIDatabase) EXPECT() *MockIDatabaseMockRecorder {
 	return m.recorder
 }
 
-// AddSegmentToRandomUsers mocks base method.
-func (m *MockIDatabase) AddSegmentToRandomUsers(arg0 context.Context, arg1 *model.Segment, arg2 float64) error {
+// ClaimWebhooks mocks base method.
+func (m *MockIDatabase) ClaimWebhooks(arg0 context.Context, arg1 time.Duration, arg2 int) ([]*model.WebhookSubscription, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddSegmentToRandomUsers", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "ClaimWebhooks", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]*model.WebhookSubscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimWebhooks indicates an expected call of ClaimWebhooks.
+func (mr *MockIDatabaseMockRecorder) ClaimWebhooks(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimWebhooks", reflect.TypeOf((*MockIDatabase)(nil).ClaimWebhooks), arg0, arg1, arg2)
+}
+
+// Close mocks base method.
+func (m *MockIDatabase) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockIDatabaseMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockIDatabase)(nil).Close))
+}
+
+// CompleteIdempotencyRecord mocks base method.
+func (m *MockIDatabase) CompleteIdempotencyRecord(arg0 context.Context, arg1 *model.IdempotencyRecord) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompleteIdempotencyRecord", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompleteIdempotencyRecord indicates an expected call of CompleteIdempotencyRecord.
+func (mr *MockIDatabaseMockRecorder) CompleteIdempotencyRecord(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteIdempotencyRecord", reflect.TypeOf((*MockIDatabase)(nil).CompleteIdempotencyRecord), arg0, arg1)
+}
+
+// CreateAPIKey mocks base method.
+func (m *MockIDatabase) CreateAPIKey(arg0 context.Context, arg1 *model.APIKey) (*model.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAPIKey", arg0, arg1)
+	ret0, _ := ret[0].(*model.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateAPIKey indicates an expected call of CreateAPIKey.
+func (mr *MockIDatabaseMockRecorder) CreateAPIKey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAPIKey", reflect.TypeOf((*MockIDatabase)(nil).CreateAPIKey), arg0, arg1)
+}
+
+// CreateAuditRecord mocks base method.
+func (m *MockIDatabase) CreateAuditRecord(arg0 context.Context, arg1 *model.AuditRecord) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAuditRecord", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// AddSegmentToRandomUsers indicates an expected call of AddSegmentToRandomUsers.
-func (mr *MockIDatabaseMockRecorder) AddSegmentToRandomUsers(arg0, arg1, arg2 interface{}) *gomock.Call {
+// CreateAuditRecord indicates an expected call of CreateAuditRecord.
+func (mr *MockIDatabaseMockRecorder) CreateAuditRecord(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddSegmentToRandomUsers", reflect.TypeOf((*MockIDatabase)(nil).AddSegmentToRandomUsers), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAuditRecord", reflect.TypeOf((*MockIDatabase)(nil).CreateAuditRecord), arg0, arg1)
 }
 
 // CreateDeleteUserSegments mocks base method.
-func (m *MockIDatabase) CreateDeleteUserSegments(arg0 context.Context, arg1 *model.User, arg2, arg3 []model.Slug) error {
+func (m *MockIDatabase) CreateDeleteUserSegments(arg0 context.Context, arg1 *model.User, arg2, arg3 []model.Slug, arg4 database.SegmentsAuthorizer) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateDeleteUserSegments", arg0, arg1, arg2, arg3)
+	ret := m.ctrl.Call(m, "CreateDeleteUserSegments", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // CreateDeleteUserSegments indicates an expected call of CreateDeleteUserSegments.
-func (mr *MockIDatabaseMockRecorder) CreateDeleteUserSegments(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+func (mr *MockIDatabaseMockRecorder) CreateDeleteUserSegments(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateDeleteUserSegments", reflect.TypeOf((*MockIDatabase)(nil).CreateDeleteUserSegments), arg0, arg1, arg2, arg3, arg4)
+}
+
+// CreateExclusionGroup mocks base method.
+func (m *MockIDatabase) CreateExclusionGroup(arg0 context.Context, arg1 *model.ExclusionGroup, arg2 []model.Slug) (*model.ExclusionGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateExclusionGroup", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*model.ExclusionGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateExclusionGroup indicates an expected call of CreateExclusionGroup.
+func (mr *MockIDatabaseMockRecorder) CreateExclusionGroup(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateExclusionGroup", reflect.TypeOf((*MockIDatabase)(nil).CreateExclusionGroup), arg0, arg1, arg2)
+}
+
+// CreateExperiment mocks base method.
+func (m *MockIDatabase) CreateExperiment(arg0 context.Context, arg1 *model.Experiment) (*model.Experiment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateExperiment", arg0, arg1)
+	ret0, _ := ret[0].(*model.Experiment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateExperiment indicates an expected call of CreateExperiment.
+func (mr *MockIDatabaseMockRecorder) CreateExperiment(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateDeleteUserSegments", reflect.TypeOf((*MockIDatabase)(nil).CreateDeleteUserSegments), arg0, arg1, arg2, arg3)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateExperiment", reflect.TypeOf((*MockIDatabase)(nil).CreateExperiment), arg0, arg1)
+}
+
+// CreateIdempotencyRecord mocks base method.
+func (m *MockIDatabase) CreateIdempotencyRecord(arg0 context.Context, arg1 *model.IdempotencyRecord) (*model.IdempotencyRecord, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateIdempotencyRecord", arg0, arg1)
+	ret0, _ := ret[0].(*model.IdempotencyRecord)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CreateIdempotencyRecord indicates an expected call of CreateIdempotencyRecord.
+func (mr *MockIDatabaseMockRecorder) CreateIdempotencyRecord(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateIdempotencyRecord", reflect.TypeOf((*MockIDatabase)(nil).CreateIdempotencyRecord), arg0, arg1)
 }
 
 // CreateSegment mocks base method.
@@ -79,18 +184,195 @@ func (mr *MockIDatabaseMockRecorder) CreateSegment(arg0, arg1 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSegment", reflect.TypeOf((*MockIDatabase)(nil).CreateSegment), arg0, arg1)
 }
 
+// CreateSegmentForRandomUsers mocks base method.
+func (m *MockIDatabase) CreateSegmentForRandomUsers(arg0 context.Context, arg1 *model.Segment, arg2 float64) (*model.Segment, int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSegmentForRandomUsers", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*model.Segment)
+	ret1, _ := ret[1].(int64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CreateSegmentForRandomUsers indicates an expected call of CreateSegmentForRandomUsers.
+func (mr *MockIDatabaseMockRecorder) CreateSegmentForRandomUsers(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSegmentForRandomUsers", reflect.TypeOf((*MockIDatabase)(nil).CreateSegmentForRandomUsers), arg0, arg1, arg2)
+}
+
+// CreateWebhook mocks base method.
+func (m *MockIDatabase) CreateWebhook(arg0 context.Context, arg1 *model.WebhookSubscription) (*model.WebhookSubscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateWebhook", arg0, arg1)
+	ret0, _ := ret[0].(*model.WebhookSubscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateWebhook indicates an expected call of CreateWebhook.
+func (mr *MockIDatabaseMockRecorder) CreateWebhook(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateWebhook", reflect.TypeOf((*MockIDatabase)(nil).CreateWebhook), arg0, arg1)
+}
+
+// DeleteAPIKey mocks base method.
+func (m *MockIDatabase) DeleteAPIKey(arg0 context.Context, arg1 *model.APIKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteAPIKey", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteAPIKey indicates an expected call of DeleteAPIKey.
+func (mr *MockIDatabaseMockRecorder) DeleteAPIKey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAPIKey", reflect.TypeOf((*MockIDatabase)(nil).DeleteAPIKey), arg0, arg1)
+}
+
+// DeleteExclusionGroup mocks base method.
+func (m *MockIDatabase) DeleteExclusionGroup(arg0 context.Context, arg1 *model.ExclusionGroup) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExclusionGroup", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteExclusionGroup indicates an expected call of DeleteExclusionGroup.
+func (mr *MockIDatabaseMockRecorder) DeleteExclusionGroup(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExclusionGroup", reflect.TypeOf((*MockIDatabase)(nil).DeleteExclusionGroup), arg0, arg1)
+}
+
+// DeleteIdempotencyRecord mocks base method.
+func (m *MockIDatabase) DeleteIdempotencyRecord(arg0 context.Context, arg1 *model.IdempotencyRecord) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteIdempotencyRecord", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteIdempotencyRecord indicates an expected call of DeleteIdempotencyRecord.
+func (mr *MockIDatabaseMockRecorder) DeleteIdempotencyRecord(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteIdempotencyRecord", reflect.TypeOf((*MockIDatabase)(nil).DeleteIdempotencyRecord), arg0, arg1)
+}
+
 // DeleteSegment mocks base method.
-func (m *MockIDatabase) DeleteSegment(arg0 context.Context, arg1 *model.Segment) error {
+func (m *MockIDatabase) DeleteSegment(arg0 context.Context, arg1 *model.Segment, arg2 database.SegmentsAuthorizer) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteSegment", arg0, arg1)
+	ret := m.ctrl.Call(m, "DeleteSegment", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteSegment indicates an expected call of DeleteSegment.
-func (mr *MockIDatabaseMockRecorder) DeleteSegment(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockIDatabaseMockRecorder) DeleteSegment(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSegment", reflect.TypeOf((*MockIDatabase)(nil).DeleteSegment), arg0, arg1, arg2)
+}
+
+// DeleteWebhook mocks base method.
+func (m *MockIDatabase) DeleteWebhook(arg0 context.Context, arg1 *model.WebhookSubscription) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteWebhook", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteWebhook indicates an expected call of DeleteWebhook.
+func (mr *MockIDatabaseMockRecorder) DeleteWebhook(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteWebhook", reflect.TypeOf((*MockIDatabase)(nil).DeleteWebhook), arg0, arg1)
+}
+
+// GetAPIKeyByHash mocks base method.
+func (m *MockIDatabase) GetAPIKeyByHash(arg0 context.Context, arg1 string) (*model.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKeyByHash", arg0, arg1)
+	ret0, _ := ret[0].(*model.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKeyByHash indicates an expected call of GetAPIKeyByHash.
+func (mr *MockIDatabaseMockRecorder) GetAPIKeyByHash(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSegment", reflect.TypeOf((*MockIDatabase)(nil).DeleteSegment), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeyByHash", reflect.TypeOf((*MockIDatabase)(nil).GetAPIKeyByHash), arg0, arg1)
+}
+
+// GetAuditRecords mocks base method.
+func (m *MockIDatabase) GetAuditRecords(arg0 context.Context, arg1 model.AuditFilter) ([]model.AuditRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAuditRecords", arg0, arg1)
+	ret0, _ := ret[0].([]model.AuditRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAuditRecords indicates an expected call of GetAuditRecords.
+func (mr *MockIDatabaseMockRecorder) GetAuditRecords(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAuditRecords", reflect.TypeOf((*MockIDatabase)(nil).GetAuditRecords), arg0, arg1)
+}
+
+// GetEvents mocks base method.
+func (m *MockIDatabase) GetEvents(arg0 context.Context, arg1 model.EventsFilter) ([]model.Event, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEvents", arg0, arg1)
+	ret0, _ := ret[0].([]model.Event)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEvents indicates an expected call of GetEvents.
+func (mr *MockIDatabaseMockRecorder) GetEvents(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEvents", reflect.TypeOf((*MockIDatabase)(nil).GetEvents), arg0, arg1)
+}
+
+// GetExclusionGroup mocks base method.
+func (m *MockIDatabase) GetExclusionGroup(arg0 context.Context, arg1 *model.ExclusionGroup) (*model.ExclusionGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetExclusionGroup", arg0, arg1)
+	ret0, _ := ret[0].(*model.ExclusionGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExclusionGroup indicates an expected call of GetExclusionGroup.
+func (mr *MockIDatabaseMockRecorder) GetExclusionGroup(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExclusionGroup", reflect.TypeOf((*MockIDatabase)(nil).GetExclusionGroup), arg0, arg1)
+}
+
+// GetExperiment mocks base method.
+func (m *MockIDatabase) GetExperiment(arg0 context.Context, arg1 *model.Experiment) (*model.Experiment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetExperiment", arg0, arg1)
+	ret0, _ := ret[0].(*model.Experiment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExperiment indicates an expected call of GetExperiment.
+func (mr *MockIDatabaseMockRecorder) GetExperiment(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExperiment", reflect.TypeOf((*MockIDatabase)(nil).GetExperiment), arg0, arg1)
+}
+
+// GetSchemaVersion mocks base method.
+func (m *MockIDatabase) GetSchemaVersion(arg0 context.Context) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSchemaVersion", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSchemaVersion indicates an expected call of GetSchemaVersion.
+func (mr *MockIDatabaseMockRecorder) GetSchemaVersion(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSchemaVersion", reflect.TypeOf((*MockIDatabase)(nil).GetSchemaVersion), arg0)
 }
 
 // GetSegment mocks base method.
@@ -108,6 +390,21 @@ func (mr *MockIDatabaseMockRecorder) GetSegment(arg0, arg1 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSegment", reflect.TypeOf((*MockIDatabase)(nil).GetSegment), arg0, arg1)
 }
 
+// GetSegmentPrerequisites mocks base method.
+func (m *MockIDatabase) GetSegmentPrerequisites(arg0 context.Context, arg1 *model.Segment) (*model.Segment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSegmentPrerequisites", arg0, arg1)
+	ret0, _ := ret[0].(*model.Segment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSegmentPrerequisites indicates an expected call of GetSegmentPrerequisites.
+func (mr *MockIDatabaseMockRecorder) GetSegmentPrerequisites(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSegmentPrerequisites", reflect.TypeOf((*MockIDatabase)(nil).GetSegmentPrerequisites), arg0, arg1)
+}
+
 // GetSegments mocks base method.
 func (m *MockIDatabase) GetSegments(arg0 context.Context, arg1 []model.Slug) ([]*model.Segment, error) {
 	m.ctrl.T.Helper()
@@ -123,6 +420,21 @@ func (mr *MockIDatabaseMockRecorder) GetSegments(arg0, arg1 interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSegments", reflect.TypeOf((*MockIDatabase)(nil).GetSegments), arg0, arg1)
 }
 
+// GetSegmentsVersion mocks base method.
+func (m *MockIDatabase) GetSegmentsVersion(arg0 context.Context) (uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSegmentsVersion", arg0)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSegmentsVersion indicates an expected call of GetSegmentsVersion.
+func (mr *MockIDatabaseMockRecorder) GetSegmentsVersion(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSegmentsVersion", reflect.TypeOf((*MockIDatabase)(nil).GetSegmentsVersion), arg0)
+}
+
 // GetUser mocks base method.
 func (m *MockIDatabase) GetUser(arg0 context.Context, arg1 *model.User) (*model.User, error) {
 	m.ctrl.T.Helper()
@@ -167,3 +479,133 @@ func (mr *MockIDatabaseMockRecorder) GetUserWithActiveSegments(arg0, arg1 interf
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserWithActiveSegments", reflect.TypeOf((*MockIDatabase)(nil).GetUserWithActiveSegments), arg0, arg1)
 }
+
+// GetWebhooks mocks base method.
+func (m *MockIDatabase) GetWebhooks(arg0 context.Context) ([]*model.WebhookSubscription, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebhooks", arg0)
+	ret0, _ := ret[0].([]*model.WebhookSubscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhooks indicates an expected call of GetWebhooks.
+func (mr *MockIDatabaseMockRecorder) GetWebhooks(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhooks", reflect.TypeOf((*MockIDatabase)(nil).GetWebhooks), arg0)
+}
+
+// ListExperiments mocks base method.
+func (m *MockIDatabase) ListExperiments(arg0 context.Context) ([]*model.Experiment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListExperiments", arg0)
+	ret0, _ := ret[0].([]*model.Experiment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListExperiments indicates an expected call of ListExperiments.
+func (mr *MockIDatabaseMockRecorder) ListExperiments(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListExperiments", reflect.TypeOf((*MockIDatabase)(nil).ListExperiments), arg0)
+}
+
+// ListSegments mocks base method.
+func (m *MockIDatabase) ListSegments(arg0 context.Context, arg1 model.SegmentsFilter) ([]*model.Segment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSegments", arg0, arg1)
+	ret0, _ := ret[0].([]*model.Segment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSegments indicates an expected call of ListSegments.
+func (mr *MockIDatabaseMockRecorder) ListSegments(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSegments", reflect.TypeOf((*MockIDatabase)(nil).ListSegments), arg0, arg1)
+}
+
+// Ping mocks base method.
+func (m *MockIDatabase) Ping(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping.
+func (mr *MockIDatabaseMockRecorder) Ping(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockIDatabase)(nil).Ping), arg0)
+}
+
+// RestoreSegment mocks base method.
+func (m *MockIDatabase) RestoreSegment(arg0 context.Context, arg1 *model.Segment) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreSegment", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreSegment indicates an expected call of RestoreSegment.
+func (mr *MockIDatabaseMockRecorder) RestoreSegment(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreSegment", reflect.TypeOf((*MockIDatabase)(nil).RestoreSegment), arg0, arg1)
+}
+
+// SetSegmentGrants mocks base method.
+func (m *MockIDatabase) SetSegmentGrants(arg0 context.Context, arg1 *model.Segment, arg2 []model.SegmentGrant) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetSegmentGrants", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetSegmentGrants indicates an expected call of SetSegmentGrants.
+func (mr *MockIDatabaseMockRecorder) SetSegmentGrants(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSegmentGrants", reflect.TypeOf((*MockIDatabase)(nil).SetSegmentGrants), arg0, arg1, arg2)
+}
+
+// SetSegmentPrerequisites mocks base method.
+func (m *MockIDatabase) SetSegmentPrerequisites(arg0 context.Context, arg1 *model.Segment, arg2 []model.PrerequisiteInput) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetSegmentPrerequisites", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetSegmentPrerequisites indicates an expected call of SetSegmentPrerequisites.
+func (mr *MockIDatabaseMockRecorder) SetSegmentPrerequisites(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSegmentPrerequisites", reflect.TypeOf((*MockIDatabase)(nil).SetSegmentPrerequisites), arg0, arg1, arg2)
+}
+
+// UpdateExperimentWeights mocks base method.
+func (m *MockIDatabase) UpdateExperimentWeights(arg0 context.Context, arg1 *model.Experiment, arg2 map[model.Slug]float64) (*model.Experiment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateExperimentWeights", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*model.Experiment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateExperimentWeights indicates an expected call of UpdateExperimentWeights.
+func (mr *MockIDatabaseMockRecorder) UpdateExperimentWeights(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateExperimentWeights", reflect.TypeOf((*MockIDatabase)(nil).UpdateExperimentWeights), arg0, arg1, arg2)
+}
+
+// UpdateWebhookDelivery mocks base method.
+func (m *MockIDatabase) UpdateWebhookDelivery(arg0 context.Context, arg1 *model.WebhookSubscription) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateWebhookDelivery", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateWebhookDelivery indicates an expected call of UpdateWebhookDelivery.
+func (mr *MockIDatabaseMockRecorder) UpdateWebhookDelivery(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateWebhookDelivery", reflect.TypeOf((*MockIDatabase)(nil).UpdateWebhookDelivery), arg0, arg1)
+}
@@ -2,29 +2,61 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"gorm.io/gorm/clause"
 	"math"
+	"sort"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 
 	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/logging"
+	"github.com/unbeman/av-prac-task/internal/metrics"
 	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/tracing"
+	"github.com/unbeman/av-prac-task/pkg/evaluation"
 )
 
 type pg struct {
 	conn *gorm.DB
 }
 
+// segmentVersionsSequence generates versions of segments changes.
+const segmentVersionsSequence = "segment_versions"
+
+// membershipsLockClass is the advisory lock class of users memberships. Changes of one user's memberships
+// take it shared along with the user's advisory lock, changes of many users' memberships take it exclusive,
+// so memberships checks see every concurrent change committed.
+const membershipsLockClass = 1
+
+// segmentVersionsLockClass is the advisory lock class of segments versions, see bumpSegmentVersion.
+// It is taken after memberships locks.
+const segmentVersionsLockClass = 2
+
+// eventsCommitted selects events of transactions older than every in-flight one. Event ids are allocated
+// at insert, so events are read in the order of their transactions, and an event committed later
+// is never placed before the events already read.
+const eventsCommitted = "tx_id < pg_snapshot_xmin(pg_current_snapshot())"
+
+// membershipsBatchSize limits users changed by one statement, statement parameters are limited.
+const membershipsBatchSize = 1000
+
+// schemaMigration records applied schema version.
+type schemaMigration struct {
+	Version   int `gorm:"primary_key;autoIncrement:false"`
+	AppliedAt time.Time
+}
+
 // NewPGDatabase returns the initialized pg object that implements IDatabase interface.
 func NewPGDatabase(cfg config.PostgresConfig) (*pg, error) {
 	db := &pg{}
-	if err := db.connect(cfg.DSN); err != nil {
+	if err := db.connect(cfg); err != nil {
 		return nil, err
 	}
 	if err := db.migrate(); err != nil {
@@ -34,13 +66,35 @@ func NewPGDatabase(cfg config.PostgresConfig) (*pg, error) {
 	return db, nil
 }
 
-// connect initialize database session connection instance with dsn.
-func (p *pg) connect(dsn string) error {
-	log.Info("PG DSN: ", dsn)
-	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true, Logger: logger.Default.LogMode(logger.Info)}) //todo: use custom logger based on logrus
+// connect initialize database session connection instance with dsn and limits connections pool.
+func (p *pg) connect(cfg config.PostgresConfig) error {
+	log.Info("PG DSN: ", logging.Redact(cfg.DSN))
+	conn, err := gorm.Open(postgres.Open(cfg.DSN), &gorm.Config{
+		TranslateError: true,
+		Logger:         logging.GORMLogger{SlowThreshold: cfg.SlowQueryThreshold},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = conn.Use(metrics.GORMPlugin{}); err != nil {
+		return err
+	}
+	if err = conn.Use(tracing.GORMPlugin{}); err != nil {
+		return err
+	}
+
+	sqlDB, err := conn.DB()
 	if err != nil {
 		return err
 	}
+	if err = metrics.RegisterDBStats(sqlDB, "segments"); err != nil {
+		return err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
 	p.conn = conn
 	return nil
 }
@@ -50,12 +104,38 @@ func (p *pg) migrate() error {
 	err := p.conn.AutoMigrate(
 		&model.User{},
 		&model.Segment{},
+		&model.Experiment{},
+		&model.ExclusionGroup{},
 		&model.UserSegment{},
+		&model.Event{},
+		&model.WebhookSubscription{},
+		&model.APIKey{},
+		&model.SegmentGrant{},
+		&model.SegmentPrerequisite{},
+		&model.AuditRecord{},
+		&model.IdempotencyRecord{},
+		&schemaMigration{},
 	)
 	if err != nil {
 		return err
 	}
 
+	err = p.conn.Exec("CREATE SEQUENCE IF NOT EXISTS " + segmentVersionsSequence).Error
+	if err != nil {
+		return err
+	}
+
+	// the transaction id is never read by the application, so it is not a model field
+	err = p.conn.Exec("ALTER TABLE events ADD COLUMN IF NOT EXISTS tx_id xid8 NOT NULL DEFAULT pg_current_xact_id()").Error
+	if err != nil {
+		return err
+	}
+
+	err = p.conn.Exec("CREATE INDEX IF NOT EXISTS idx_events_position ON events (tx_id, id)").Error
+	if err != nil {
+		return err
+	}
+
 	err = p.conn.SetupJoinTable(&model.User{}, "Segments", &model.UserSegment{})
 	if err != nil {
 		return err
@@ -65,21 +145,46 @@ func (p *pg) migrate() error {
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return p.conn.
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&schemaMigration{Version: SchemaVersion, AppliedAt: time.Now()}).
+		Error
 }
 
 // getUsersCount returns count of user.
-func (p *pg) getUsersCount(ctx context.Context) (int64, error) {
+func (p *pg) getUsersCount(ctx context.Context, tx *gorm.DB) (int64, error) {
 	var count int64
-	result := p.conn.WithContext(ctx).Model(&model.User{}).Count(&count)
+	result := tx.WithContext(ctx).Model(&model.User{}).Count(&count)
 	if result.Error != nil {
-		return count, result.Error
+		return count, fmt.Errorf("%w: %v", ErrDB, result.Error)
 	}
 	return count, nil
 }
 
+// lockUserMemberships serializes memberships changes of the user until the transaction ends,
+// it must be taken before the user's memberships are read.
+func (p *pg) lockUserMemberships(ctx context.Context, tx *gorm.DB, user *model.User) error {
+	result := tx.WithContext(ctx).Exec(
+		"SELECT pg_advisory_xact_lock_shared(?::int, 0), pg_advisory_xact_lock(?::bigint)", membershipsLockClass, user.ID)
+	if result.Error != nil {
+		return fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return nil
+}
+
+// lockAllMemberships waits for memberships changes in progress and blocks new ones until the transaction ends.
+func (p *pg) lockAllMemberships(ctx context.Context, tx *gorm.DB) error {
+	result := tx.WithContext(ctx).Exec("SELECT pg_advisory_xact_lock(?::int, 0)", membershipsLockClass)
+	if result.Error != nil {
+		return fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return nil
+}
+
 // createSegment returns new saved model.Segment.
 func (p *pg) createSegment(ctx context.Context, tx *gorm.DB, segment *model.Segment) (*model.Segment, error) {
+	var err error
 	result := tx.WithContext(ctx).Create(segment)
 	if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
 		return nil, fmt.Errorf("segment with slug (%s) %w", segment.Slug, ErrAlreadyExists)
@@ -87,12 +192,53 @@ func (p *pg) createSegment(ctx context.Context, tx *gorm.DB, segment *model.Segm
 	if result.Error != nil {
 		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
 	}
+
+	segment.Version, err = p.bumpSegmentVersion(ctx, tx, segment.Slug)
+	if err != nil {
+		return nil, err
+	}
 	return segment, nil
 }
 
 // CreateSegment inserts new segment with given unique slug if not exists.
 func (p *pg) CreateSegment(ctx context.Context, segment *model.Segment) (*model.Segment, error) {
-	return p.createSegment(ctx, p.conn, segment)
+	var created *model.Segment
+	err := p.transaction(ctx, func(tx *gorm.DB) error {
+		var txErr error
+		created, txErr = p.createSegment(ctx, tx, segment)
+		return txErr
+	})
+	return created, err
+}
+
+// bumpSegmentVersion sets the next version to the changed segment, so snapshot clients get the change.
+// Versions are allocated under the versions lock held until commit, so they are committed in order
+// and a snapshot client never gets a version before the change with a lower one is committed.
+func (p *pg) bumpSegmentVersion(ctx context.Context, tx *gorm.DB, slug model.Slug) (uint64, error) {
+	result := tx.WithContext(ctx).Exec("SELECT pg_advisory_xact_lock(?::int, 0)", segmentVersionsLockClass)
+	if result.Error != nil {
+		return 0, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+
+	var version uint64
+	result = tx.WithContext(ctx).Raw(
+		"UPDATE segments SET version = nextval(?), updated_at = ? WHERE slug = ? RETURNING version",
+		segmentVersionsSequence, time.Now(), slug,
+	).Scan(&version)
+	if result.Error != nil {
+		return 0, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return version, nil
+}
+
+// GetSegmentsVersion returns the version of the latest segments change.
+func (p *pg) GetSegmentsVersion(ctx context.Context) (uint64, error) {
+	var version uint64
+	result := p.conn.WithContext(ctx).Unscoped().Model(&model.Segment{}).Select("COALESCE(MAX(version), 0)").Scan(&version)
+	if result.Error != nil {
+		return 0, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return version, nil
 }
 
 // deleteSegment soft deletes segment by slug.
@@ -103,7 +249,9 @@ func (p *pg) deleteSegment(ctx context.Context, tx *gorm.DB, segment *model.Segm
 	} else if result.RowsAffected < 1 {
 		return fmt.Errorf("segment with slug (%s) is %w for delete", segment.Slug, ErrNotFound)
 	}
-	return nil
+
+	_, err := p.bumpSegmentVersion(ctx, tx, segment.Slug)
+	return err
 }
 
 // deleteSegment soft deletes user segment relation by segment id.
@@ -116,9 +264,27 @@ func (p *pg) deleteSegmentFromUsers(ctx context.Context, tx *gorm.DB, segment *m
 }
 
 // DeleteSegment soft deletes segment by slug from its table and user_segments.
-func (p *pg) DeleteSegment(ctx context.Context, segment *model.Segment) error {
-	err := p.conn.Transaction(func(tx *gorm.DB) error {
-		err := p.deleteSegment(ctx, tx, segment)
+// Prerequisites of the segment dependents are applied to its users, users removed from dependent segments
+// by cascade policy are authorized.
+func (p *pg) DeleteSegment(ctx context.Context, segment *model.Segment, authorize SegmentsAuthorizer) error {
+	err := p.transaction(ctx, func(tx *gorm.DB) error {
+		// users must not get dependent segments concurrently
+		err := p.lockAllMemberships(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		err = p.deleteSegment(ctx, tx, segment)
+		if err != nil {
+			return err
+		}
+
+		err = p.createSegmentUsersEvents(ctx, tx, segment, model.EventDelete)
+		if err != nil {
+			return err
+		}
+
+		err = p.applyDeletedPrerequisite(ctx, tx, segment, authorize)
 		if err != nil {
 			return err
 		}
@@ -127,175 +293,1211 @@ func (p *pg) DeleteSegment(ctx context.Context, segment *model.Segment) error {
 		if err != nil {
 			return err
 		}
-		return nil
+
+		return p.createEvents(ctx, tx, []model.Event{{Type: model.EventSegmentDeleted, SegmentSlug: segment.Slug}})
 	})
 
 	return err
 }
 
-// GetSegment returns segment with all users in it.
-func (p *pg) GetSegment(ctx context.Context, segment *model.Segment) (*model.Segment, error) {
-	result := p.conn.WithContext(ctx).Preload("Users").First(segment, "slug = ?", segment.Slug)
-	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		return nil, fmt.Errorf("segment with slug (%s) %w", segment.Slug, ErrNotFound)
+// applyDeletedPrerequisite loads dependent segments of the deleted segment, transitively, with memberships
+// of its users and applies prerequisites to them, see cascadeDeletedPrerequisite.
+func (p *pg) applyDeletedPrerequisite(ctx context.Context, tx *gorm.DB, segment *model.Segment, authorize SegmentsAuthorizer) error {
+	var relations []model.SegmentPrerequisite
+	dependents := make(map[uint64]*model.Segment)
+	for frontier := []uint64{segment.ID}; len(frontier) > 0; {
+		var found []model.SegmentPrerequisite
+		result := tx.WithContext(ctx).Preload("Segment.Grants").Find(&found, "prerequisite_id IN ?", frontier)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+
+		frontier = nil
+		for _, relation := range found {
+			// deleted dependent segments have no users
+			if relation.Segment == nil {
+				continue
+			}
+			relations = append(relations, relation)
+			if _, ok := dependents[relation.SegmentID]; !ok {
+				dependents[relation.SegmentID] = relation.Segment
+				frontier = append(frontier, relation.SegmentID)
+			}
+		}
 	}
-	if result.Error != nil {
-		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	if len(dependents) == 0 {
+		return nil
 	}
-	return segment, nil
-}
 
-// GetSegments returns segments by given slugs.
-func (p *pg) GetSegments(ctx context.Context, slugs []model.Slug) ([]*model.Segment, error) {
-	return p.getSegments(ctx, p.conn, slugs)
-}
+	ids := make([]uint64, 0, len(dependents))
+	for id := range dependents {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
 
-// getSegments returns segments by given slugs.
-func (p *pg) getSegments(ctx context.Context, tx *gorm.DB, slugs []model.Slug) ([]*model.Segment, error) {
-	var segments []*model.Segment
-	result := tx.WithContext(ctx).Find(&segments, "slug IN ?", slugs)
+	var userSegments []model.UserSegment
+	result := tx.WithContext(ctx).
+		Where("segment_id IN ? AND user_id IN (SELECT deleted_users.user_id FROM user_segments deleted_users "+
+			"WHERE deleted_users.segment_id = ? AND deleted_users.deleted_at IS NULL)", ids, segment.ID).
+		Find(&userSegments)
 	if result.Error != nil {
-		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
-	} else if len(segments) != len(slugs) {
-		return nil, fmt.Errorf("some segments %w", ErrNotFound)
+		return fmt.Errorf("%w: %v", ErrDB, result.Error)
 	}
-	return segments, nil
+	memberships := make(map[uint64][]*model.Segment)
+	for _, userSegment := range userSegments {
+		memberships[uint64(userSegment.UserID)] = append(memberships[uint64(userSegment.UserID)], dependents[uint64(userSegment.SegmentID)])
+	}
+
+	removals, err := cascadeDeletedPrerequisite(segment, relations, memberships)
+	if err != nil {
+		return err
+	}
+
+	if authorize != nil && len(removals) > 0 {
+		removed := make([]*model.Segment, 0, len(removals))
+		for _, id := range ids {
+			if _, ok := removals[id]; ok {
+				removed = append(removed, dependents[id])
+			}
+		}
+		if err = authorize(removed); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range ids {
+		if err = p.deleteUsersFromSegment(ctx, tx, dependents[id], removals[id]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// CreateDeleteUserSegments insert and delete relation by specified segments (represented by slugs) for given user.
-func (p *pg) CreateDeleteUserSegments(ctx context.Context, user *model.User, toInSegments []model.Slug, toDelSegments []model.Slug) error {
-	var insertSegments []*model.Segment
-	var deleteSegments []*model.Segment
-	err := p.conn.Transaction(func(tx *gorm.DB) error { //todo: check gorm's tx errors
-		var txErr error
+// deleteUsersFromSegment soft deletes relations of given users to the segment with delete events,
+// users are deleted by batches of membershipsBatchSize.
+func (p *pg) deleteUsersFromSegment(ctx context.Context, tx *gorm.DB, segment *model.Segment, userIDs []uint64) error {
+	now := time.Now()
+	for start := 0; start < len(userIDs); start += membershipsBatchSize {
+		end := start + membershipsBatchSize
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		result := tx.WithContext(ctx).Exec(
+			"WITH deleted AS ("+
+				"UPDATE user_segments SET deleted_at = ? "+
+				"WHERE segment_id = ? AND user_id IN ? AND deleted_at IS NULL "+
+				"RETURNING user_id) "+
+				"INSERT INTO events (type, user_id, segment_slug, created_at) "+
+				"SELECT ?, deleted.user_id, ?, ? FROM deleted",
+			now, segment.ID, userIDs[start:end], model.EventDelete, segment.Slug, now)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+	}
+	return nil
+}
 
-		insertSegments, txErr = p.getSegments(ctx, tx, toInSegments)
-		if txErr != nil {
-			return txErr
+// RestoreSegment restores soft deleted segment by slug, its former users are not restored.
+// The passed end of the segment active window is cleared.
+func (p *pg) RestoreSegment(ctx context.Context, segment *model.Segment) error {
+	return p.transaction(ctx, func(tx *gorm.DB) error {
+		result := tx.WithContext(ctx).Unscoped().Model(&model.Segment{}).
+			Where("slug = ? AND deleted_at IS NOT NULL", segment.Slug).
+			Update("deleted_at", nil)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		} else if result.RowsAffected < 1 {
+			return fmt.Errorf("deleted segment with slug (%s) is %w for restore", segment.Slug, ErrNotFound)
 		}
 
-		deleteSegments, txErr = p.getSegments(ctx, tx, toDelSegments)
-		if txErr != nil {
-			return txErr
+		if err := p.resetVariant(ctx, tx, segment); err != nil {
+			return err
 		}
 
-		if len(insertSegments) > 0 {
-			txErr = p.insertUserSegments(ctx, tx, user, insertSegments)
-			if txErr != nil {
-				return txErr
-			}
+		// the passed window end would delete the segment again
+		result = tx.WithContext(ctx).Model(&model.Segment{}).
+			Where("slug = ? AND active_until <= ?", segment.Slug, time.Now()).
+			Update("active_until", nil)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
 		}
 
-		if len(deleteSegments) > 0 {
-			txErr = p.deleteUserSegments(ctx, tx, user, deleteSegments)
-			if txErr != nil {
-				return txErr
-			}
+		if _, err := p.bumpSegmentVersion(ctx, tx, segment.Slug); err != nil {
+			return err
 		}
 
-		return nil
+		return p.createEvents(ctx, tx, []model.Event{{Type: model.EventSegmentRestored, SegmentSlug: segment.Slug}})
 	})
-
-	return err
 }
 
-// insertUserSegments connects user with given segments.
-func (p *pg) insertUserSegments(ctx context.Context, tx *gorm.DB, user *model.User, segments []*model.Segment) error {
-	log.Info(user.ID)
-	err := tx.WithContext(ctx).Model(user).Omit("Segments.*").Association("Segments").Append(segments)
-	if err != nil {
-		return fmt.Errorf("%w: %v", ErrDB, err)
+// resetVariant sets zero weight to the restored experiment variant, its buckets could be given
+// to other variants of the experiment while it was deleted.
+func (p *pg) resetVariant(ctx context.Context, tx *gorm.DB, segment *model.Segment) error {
+	restored := &model.Segment{}
+	result := tx.WithContext(ctx).First(restored, "slug = ?", segment.Slug)
+	if result.Error != nil {
+		return fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	if restored.Variant == nil {
+		return nil
+	}
+
+	restored.Variant = &evaluation.Variant{Experiment: restored.Variant.Experiment}
+	result = tx.WithContext(ctx).Model(restored).Select("variant").Updates(restored)
+	if result.Error != nil {
+		return fmt.Errorf("%w: %v", ErrDB, result.Error)
 	}
 	return nil
 }
 
-// deleteUserSegments soft deletes user relation to given segments,
-// just updates deleted_at column.
-func (p *pg) deleteUserSegments(ctx context.Context, tx *gorm.DB, user *model.User, segments []*model.Segment) error {
-	err := tx.WithContext(ctx).Model(user).Association("Segments").Delete(segments)
-	if err != nil {
-		return fmt.Errorf("%w: %v", ErrDB, err)
+// ListSegments returns segments with their grants ordered by slug.
+func (p *pg) ListSegments(ctx context.Context, filter model.SegmentsFilter) ([]*model.Segment, error) {
+	query := p.conn.WithContext(ctx).Preload("Grants").Order("slug")
+	if filter.WithDeleted {
+		query = query.Unscoped()
+		if filter.DeletedWithin > 0 {
+			query = query.Where("deleted_at IS NULL OR deleted_at > ?", time.Now().Add(-filter.DeletedWithin))
+		}
 	}
-	return nil
+	if len(filter.Slugs) > 0 {
+		query = query.Where("slug IN ?", filter.Slugs)
+	}
+	if filter.Dynamic {
+		query = query.Where("rollout IS NOT NULL OR rules NOT IN ('', 'null', '[]') OR variant IS NOT NULL")
+	}
+	if filter.SinceVersion > 0 {
+		query = query.Where("version > ?", filter.SinceVersion)
+	}
+	if !filter.ExpiredAt.IsZero() {
+		query = query.Where("active_until <= ?", filter.ExpiredAt)
+	}
+
+	var segments []*model.Segment
+	if err := query.Find(&segments).Error; err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, err)
+	}
+	return segments, nil
 }
 
-// GetUserWithActiveSegments returns user with related segments.
-func (p *pg) GetUserWithActiveSegments(ctx context.Context, user *model.User) (*model.User, error) {
-	result := p.conn.WithContext(ctx).Preload("Segments").First(user)
+// GetSegment returns segment with all users in it.
+func (p *pg) GetSegment(ctx context.Context, segment *model.Segment) (*model.Segment, error) {
+	result := p.conn.WithContext(ctx).Preload("Users").First(segment, "slug = ?", segment.Slug)
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		return nil, fmt.Errorf("user with ID (%d) %w", user.ID, ErrNotFound)
+		return nil, fmt.Errorf("segment with slug (%s) %w", segment.Slug, ErrNotFound)
 	}
 	if result.Error != nil {
 		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
 	}
-	return user, nil
+	return segment, nil
 }
 
-// GetUserSegmentsHistory returns user relation to segments for the specified time interval.
-func (p *pg) GetUserSegmentsHistory(ctx context.Context, user *model.User, from time.Time, to time.Time) ([]model.UserSegment, error) {
-	var userSegments []model.UserSegment
+// GetSegments returns segments with their grants by given slugs.
+func (p *pg) GetSegments(ctx context.Context, slugs []model.Slug) ([]*model.Segment, error) {
+	return p.getSegments(ctx, p.conn.Preload("Grants"), slugs)
+}
 
-	result := p.conn.WithContext(ctx).Unscoped().Preload("Segment").Model(&userSegments).
-		Find(&userSegments, "user_segments.user_id = ? AND "+
-			"((user_segments.created_at >= ? AND user_segments.created_at < ?)"+
-			" OR (user_segments.deleted_at = null "+
-			" OR (user_segments.deleted_at >= ? AND user_segments.deleted_at < ?)))"+
-			" ORDER BY user_segments.created_at ASC", user.ID, from, to, from, to)
-	if result.Error != nil {
-		log.Error(result)
-		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
-	}
+// SetSegmentGrants replaces segment grants with given ones and bumps segment version.
+func (p *pg) SetSegmentGrants(ctx context.Context, segment *model.Segment, grants []model.SegmentGrant) error {
+	return p.transaction(ctx, func(tx *gorm.DB) error {
+		result := tx.WithContext(ctx).Delete(&model.SegmentGrant{}, "segment_id = ?", segment.ID)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
 
-	return userSegments, nil
+		// snapshot clients of teams that lost the grant must drop the segment
+		if _, err := p.bumpSegmentVersion(ctx, tx, segment.Slug); err != nil {
+			return err
+		}
+
+		if len(grants) == 0 {
+			return nil
+		}
+
+		for idx := range grants {
+			grants[idx].ID = 0
+			grants[idx].SegmentID = segment.ID
+		}
+		result = tx.WithContext(ctx).Create(&grants)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		return nil
+	})
 }
 
-// getRandomUsers returns random users rows.
-func (p *pg) getRandomUsers(ctx context.Context, count int) ([]*model.User, error) {
-	var users []*model.User
+// GetSegmentPrerequisites returns segment with its grants and prerequisites, deleted prerequisites included.
+func (p *pg) GetSegmentPrerequisites(ctx context.Context, segment *model.Segment) (*model.Segment, error) {
 	result := p.conn.WithContext(ctx).
-		Order("random()").
-		Limit(count).
-		Find(&users)
+		Preload("Grants").
+		Preload("Prerequisites", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Prerequisites.Prerequisite", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		First(segment, "slug = ?", segment.Slug)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("segment with slug (%s) %w", segment.Slug, ErrNotFound)
+	}
 	if result.Error != nil {
-		return nil, result.Error
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
 	}
-	return users, nil
+	return segment, nil
 }
 
-// addSegmentToUsers adds relation for given segment and users.
-func (p *pg) addSegmentToUsers(ctx context.Context, segment model.Segment, users []*model.User) error {
-	for _, user := range users {
-		if err := p.insertUserSegments(ctx, p.conn, user, []*model.Segment{&segment}); err != nil {
-			return err
-		}
+// SetSegmentPrerequisites replaces prerequisites of the explicit segment with given active explicit segments,
+// the change is rejected if prerequisites make a cycle.
+func (p *pg) SetSegmentPrerequisites(ctx context.Context, segment *model.Segment, prerequisites []model.PrerequisiteInput) error {
+	if segment.IsDynamic() {
+		return fmt.Errorf("segment with slug (%s) users are evaluated from its definition, %w", segment.Slug, ErrDynamicSegment)
 	}
-	return nil
-}
 
-// AddSegmentToRandomUsers adds relation for given segment to random users depending on selection percent.
-func (p *pg) AddSegmentToRandomUsers(ctx context.Context, segment *model.Segment, selection float64) error {
-	count, err := p.getUsersCount(ctx)
-	if err != nil {
-		return err
-	}
+	return p.transaction(ctx, func(tx *gorm.DB) error {
+		// concurrent changes of different segments could make a cycle together
+		result := tx.WithContext(ctx).Exec("LOCK TABLE segment_prerequisites IN SHARE ROW EXCLUSIVE MODE")
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
 
-	if count == 0 {
-		log.Info("AddSegmentToRandomUsers: no users in database to add segment")
-		return nil
-	}
+		relations := make([]model.SegmentPrerequisite, 0, len(prerequisites))
+		if len(prerequisites) > 0 {
+			slugs := make([]model.Slug, 0, len(prerequisites))
+			for _, prerequisite := range prerequisites {
+				slugs = append(slugs, prerequisite.Slug)
+			}
+			segments, err := p.getSegments(ctx, tx, slugs)
+			if err != nil {
+				return err
+			}
 
-	selectionCount := int(math.Ceil(float64(count) * selection)) //todo: wrap
+			edges, err := p.getPrerequisitesEdges(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if cycle := prerequisitesCycle(edges, segment.Slug, slugs); cycle != nil {
+				parts := make([]string, 0, len(cycle))
+				for _, slug := range cycle {
+					parts = append(parts, string(slug))
+				}
+				return fmt.Errorf("%w: %s", ErrPrerequisiteCycle, strings.Join(parts, " -> "))
+			}
 
-	users, err := p.getRandomUsers(ctx, selectionCount)
-	if err != nil {
-		return err
+			policies := make(map[model.Slug]model.PrerequisitePolicy, len(prerequisites))
+			for _, prerequisite := range prerequisites {
+				policies[prerequisite.Slug] = prerequisite.OnRemove
+			}
+			for _, prerequisite := range segments {
+				if prerequisite.IsDynamic() {
+					return fmt.Errorf("segment with slug (%s) users are evaluated from its definition, %w", prerequisite.Slug, ErrDynamicSegment)
+				}
+				relations = append(relations, model.SegmentPrerequisite{
+					SegmentID:      segment.ID,
+					PrerequisiteID: prerequisite.ID,
+					OnRemove:       policies[prerequisite.Slug],
+				})
+			}
+		}
+
+		result = tx.WithContext(ctx).Delete(&model.SegmentPrerequisite{}, "segment_id = ?", segment.ID)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		if len(relations) == 0 {
+			return nil
+		}
+		result = tx.WithContext(ctx).Create(&relations)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		return nil
+	})
+}
+
+// getPrerequisitesEdges returns slugs of prerequisites by slugs of segments, deleted segments included.
+func (p *pg) getPrerequisitesEdges(ctx context.Context, tx *gorm.DB) (map[model.Slug][]model.Slug, error) {
+	var rows []struct {
+		Segment      model.Slug
+		Prerequisite model.Slug
+	}
+	result := tx.WithContext(ctx).Raw(
+		"SELECT segments.slug AS segment, prerequisites.slug AS prerequisite FROM segment_prerequisites " +
+			"JOIN segments ON segments.id = segment_prerequisites.segment_id " +
+			"JOIN segments prerequisites ON prerequisites.id = segment_prerequisites.prerequisite_id").
+		Scan(&rows)
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
 	}
 
-	err = p.addSegmentToUsers(ctx, *segment, users)
-	if err != nil {
-		return err
+	edges := make(map[model.Slug][]model.Slug)
+	for _, row := range rows {
+		edges[row.Segment] = append(edges[row.Segment], row.Prerequisite)
 	}
-	return nil
+	return edges, nil
+}
+
+// getSegments returns segments by given slugs.
+func (p *pg) getSegments(ctx context.Context, tx *gorm.DB, slugs []model.Slug) ([]*model.Segment, error) {
+	var segments []*model.Segment
+	result := tx.WithContext(ctx).Find(&segments, "slug IN ?", slugs)
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	} else if len(segments) != len(slugs) {
+		return nil, fmt.Errorf("some segments %w", ErrNotFound)
+	}
+	return segments, nil
+}
+
+// CreateExperiment inserts new experiment with its variants segments.
+func (p *pg) CreateExperiment(ctx context.Context, experiment *model.Experiment) (*model.Experiment, error) {
+	err := p.transaction(ctx, func(tx *gorm.DB) error {
+		result := tx.WithContext(ctx).Omit("Variants").Create(experiment)
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return fmt.Errorf("experiment with slug (%s) %w", experiment.Slug, ErrAlreadyExists)
+		}
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+
+		for idx := range experiment.Variants {
+			experiment.Variants[idx].ExperimentID = &experiment.ID
+			if _, err := p.createSegment(ctx, tx, &experiment.Variants[idx]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return experiment, nil
+}
+
+// getExperiment returns experiment by slug with its active variants ordered by slug.
+func (p *pg) getExperiment(ctx context.Context, tx *gorm.DB, experiment *model.Experiment) (*model.Experiment, error) {
+	result := tx.WithContext(ctx).
+		Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("slug") }).
+		Preload("Variants.Grants").
+		First(experiment, "slug = ?", experiment.Slug)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("experiment with slug (%s) %w", experiment.Slug, ErrNotFound)
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return experiment, nil
+}
+
+// GetExperiment returns experiment with its active variants.
+func (p *pg) GetExperiment(ctx context.Context, experiment *model.Experiment) (*model.Experiment, error) {
+	return p.getExperiment(ctx, p.conn, experiment)
+}
+
+// ListExperiments returns experiments with their active variants ordered by slug.
+func (p *pg) ListExperiments(ctx context.Context) ([]*model.Experiment, error) {
+	var experiments []*model.Experiment
+	result := p.conn.WithContext(ctx).
+		Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("slug") }).
+		Preload("Variants.Grants").
+		Order("slug").
+		Find(&experiments)
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return experiments, nil
+}
+
+// UpdateExperimentWeights sets weights to the experiment variants and reallocates their buckets,
+// so users stay in their variants if its weight doesn't decrease. Weights of variants not in the map are kept.
+// The experiment is locked for update, so concurrent updates reallocate buckets one by one.
+func (p *pg) UpdateExperimentWeights(ctx context.Context, experiment *model.Experiment, weights map[model.Slug]float64) (*model.Experiment, error) {
+	err := p.transaction(ctx, func(tx *gorm.DB) error {
+		locked := tx.Clauses(clause.Locking{Strength: "UPDATE"})
+		if _, err := p.getExperiment(ctx, locked, experiment); err != nil {
+			return err
+		}
+
+		known := make(map[model.Slug]bool, len(experiment.Variants))
+		current := make([][]evaluation.Range, len(experiment.Variants))
+		newWeights := make([]float64, len(experiment.Variants))
+		for idx, variant := range experiment.Variants {
+			known[variant.Slug] = true
+			if variant.Variant != nil {
+				current[idx] = variant.Variant.Ranges
+				newWeights[idx] = variant.Variant.Weight
+			}
+			if weight, ok := weights[variant.Slug]; ok {
+				newWeights[idx] = weight
+			}
+		}
+		for slug := range weights {
+			if !known[slug] {
+				return fmt.Errorf("variant with slug (%s) of experiment (%s) %w", slug, experiment.Slug, ErrNotFound)
+			}
+		}
+
+		allocation, err := evaluation.Allocate(current, newWeights)
+		if err != nil {
+			return err
+		}
+
+		for idx := range experiment.Variants {
+			variant := &experiment.Variants[idx]
+			variant.Variant = &evaluation.Variant{
+				Experiment: string(experiment.Slug),
+				Weight:     newWeights[idx],
+				Ranges:     allocation[idx],
+			}
+			result := tx.WithContext(ctx).Model(variant).Select("variant").Updates(variant)
+			if result.Error != nil {
+				return fmt.Errorf("%w: %v", ErrDB, result.Error)
+			}
+			if variant.Version, err = p.bumpSegmentVersion(ctx, tx, variant.Slug); err != nil {
+				return err
+			}
+		}
+
+		result := tx.WithContext(ctx).Model(experiment).Update("updated_at", time.Now())
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return experiment, nil
+}
+
+// CreateExclusionGroup inserts new exclusion group of given explicit segments,
+// the segments must not be in other groups and must not share users already.
+func (p *pg) CreateExclusionGroup(ctx context.Context, group *model.ExclusionGroup, slugs []model.Slug) (*model.ExclusionGroup, error) {
+	err := p.transaction(ctx, func(tx *gorm.DB) error {
+		// shared users are counted with no memberships changes in progress
+		if err := p.lockAllMemberships(ctx, tx); err != nil {
+			return err
+		}
+
+		segments, err := p.getSegments(ctx, tx.Clauses(clause.Locking{Strength: "UPDATE"}), slugs)
+		if err != nil {
+			return err
+		}
+
+		ids := make([]uint64, 0, len(segments))
+		for _, segment := range segments {
+			if segment.IsDynamic() {
+				return fmt.Errorf("segment with slug (%s) users are evaluated from its definition, %w", segment.Slug, ErrDynamicSegment)
+			}
+			if segment.GroupID != nil {
+				return fmt.Errorf("segment with slug (%s) is in another group, %w", segment.Slug, ErrGroupConflict)
+			}
+			ids = append(ids, segment.ID)
+		}
+
+		var shared int64
+		result := tx.WithContext(ctx).Raw(
+			"SELECT COUNT(*) FROM (SELECT user_id FROM user_segments "+
+				"WHERE deleted_at IS NULL AND segment_id IN ? GROUP BY user_id HAVING COUNT(*) > 1) shared", ids).
+			Scan(&shared)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		if shared > 0 {
+			return fmt.Errorf("%d users are in several segments, %w", shared, ErrGroupConflict)
+		}
+
+		result = tx.WithContext(ctx).Omit("Segments").Create(group)
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return fmt.Errorf("exclusion group with slug (%s) %w", group.Slug, ErrAlreadyExists)
+		}
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+
+		result = tx.WithContext(ctx).Model(&model.Segment{}).Where("id IN ?", ids).Update("group_id", group.ID)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+
+		group.Segments = make([]model.Segment, 0, len(segments))
+		for _, segment := range segments {
+			segment.GroupID = &group.ID
+			group.Segments = append(group.Segments, *segment)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// GetExclusionGroup returns exclusion group with its active segments ordered by slug.
+func (p *pg) GetExclusionGroup(ctx context.Context, group *model.ExclusionGroup) (*model.ExclusionGroup, error) {
+	result := p.conn.WithContext(ctx).
+		Preload("Segments", func(db *gorm.DB) *gorm.DB { return db.Order("slug") }).
+		First(group, "slug = ?", group.Slug)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("exclusion group with slug (%s) %w", group.Slug, ErrNotFound)
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return group, nil
+}
+
+// DeleteExclusionGroup deletes exclusion group, its segments stay without group.
+func (p *pg) DeleteExclusionGroup(ctx context.Context, group *model.ExclusionGroup) error {
+	return p.transaction(ctx, func(tx *gorm.DB) error {
+		result := tx.WithContext(ctx).Clauses(clause.Returning{}).Delete(group, "slug = ?", group.Slug)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		} else if result.RowsAffected < 1 {
+			return fmt.Errorf("exclusion group with slug (%s) is %w for delete", group.Slug, ErrNotFound)
+		}
+
+		result = tx.WithContext(ctx).Unscoped().Model(&model.Segment{}).
+			Where("group_id = ?", group.ID).
+			Update("group_id", nil)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		return nil
+	})
+}
+
+// getUserSegments returns the user's current segments with their grants.
+func (p *pg) getUserSegments(ctx context.Context, tx *gorm.DB, user *model.User) ([]*model.Segment, error) {
+	var segments []*model.Segment
+	result := tx.WithContext(ctx).Preload("Grants").
+		Joins("JOIN user_segments ON user_segments.segment_id = segments.id AND user_segments.deleted_at IS NULL").
+		Where("user_segments.user_id = ?", user.ID).
+		Find(&segments)
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return segments, nil
+}
+
+// excludeGroupSegments loads exclusion groups of segments to insert and applies them
+// to the user's current segments, see replaceGroupSegments.
+func (p *pg) excludeGroupSegments(
+	ctx context.Context,
+	tx *gorm.DB,
+	current []*model.Segment,
+	insertSegments []*model.Segment,
+	deleteSegments []*model.Segment,
+) ([]*model.Segment, error) {
+	groupIDs := make([]uint64, 0)
+	for _, segment := range insertSegments {
+		if segment.GroupID != nil {
+			groupIDs = append(groupIDs, *segment.GroupID)
+		}
+	}
+	if len(groupIDs) == 0 {
+		return deleteSegments, nil
+	}
+
+	var groups []*model.ExclusionGroup
+	if err := tx.WithContext(ctx).Find(&groups, "id IN ?", groupIDs).Error; err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, err)
+	}
+	groupsByID := make(map[uint64]*model.ExclusionGroup, len(groups))
+	for _, group := range groups {
+		groupsByID[group.ID] = group
+	}
+	return replaceGroupSegments(current, groupsByID, insertSegments, deleteSegments)
+}
+
+// applyPrerequisites loads prerequisites of the user's current and inserted segments and applies them
+// to the change, see cascadePrerequisites.
+func (p *pg) applyPrerequisites(
+	ctx context.Context,
+	tx *gorm.DB,
+	current []*model.Segment,
+	insertSegments []*model.Segment,
+	deleteSegments []*model.Segment,
+) ([]*model.Segment, error) {
+	ids := make([]uint64, 0, len(current)+len(insertSegments))
+	for _, segment := range current {
+		ids = append(ids, segment.ID)
+	}
+	for _, segment := range insertSegments {
+		ids = append(ids, segment.ID)
+	}
+	if len(ids) == 0 {
+		return deleteSegments, nil
+	}
+
+	var relations []model.SegmentPrerequisite
+	result := tx.WithContext(ctx).
+		Preload("Prerequisite", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Find(&relations, "segment_id IN ?", ids)
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return cascadePrerequisites(current, relations, insertSegments, deleteSegments)
+}
+
+// CreateDeleteUserSegments insert and delete relation by specified segments (represented by slugs) for given user.
+// Memberships changes of the user are serialized, so exclusion groups and prerequisites are checked
+// against the committed memberships. Segments the user is removed from along with the change are authorized.
+func (p *pg) CreateDeleteUserSegments(
+	ctx context.Context,
+	user *model.User,
+	toInSegments []model.Slug,
+	toDelSegments []model.Slug,
+	authorize SegmentsAuthorizer,
+) error {
+	var insertSegments []*model.Segment
+	var deleteSegments []*model.Segment
+	err := p.transaction(ctx, func(tx *gorm.DB) error { //todo: check gorm's tx errors
+		txErr := p.lockUserMemberships(ctx, tx, user)
+		if txErr != nil {
+			return txErr
+		}
+
+		insertSegments, txErr = p.getSegments(ctx, tx, toInSegments)
+		if txErr != nil {
+			return txErr
+		}
+
+		deleteSegments, txErr = p.getSegments(ctx, tx, toDelSegments)
+		if txErr != nil {
+			return txErr
+		}
+
+		for _, segment := range append(insertSegments, deleteSegments...) {
+			if segment.IsDynamic() {
+				return fmt.Errorf("segment with slug (%s) users are evaluated from its definition, %w", segment.Slug, ErrDynamicSegment)
+			}
+		}
+
+		current, txErr := p.getUserSegments(ctx, tx, user)
+		if txErr != nil {
+			return txErr
+		}
+
+		requested := len(deleteSegments)
+		deleteSegments, txErr = p.excludeGroupSegments(ctx, tx, current, insertSegments, deleteSegments)
+		if txErr != nil {
+			return txErr
+		}
+
+		deleteSegments, txErr = p.applyPrerequisites(ctx, tx, current, insertSegments, deleteSegments)
+		if txErr != nil {
+			return txErr
+		}
+
+		// segments replaced in exclusion groups and removed by prerequisites cascade
+		if authorize != nil && len(deleteSegments) > requested {
+			if txErr = authorize(deleteSegments[requested:]); txErr != nil {
+				return txErr
+			}
+		}
+
+		if len(insertSegments) > 0 {
+			txErr = p.insertUserSegments(ctx, tx, user, insertSegments)
+			if txErr != nil {
+				return txErr
+			}
+		}
+
+		if len(deleteSegments) > 0 {
+			txErr = p.deleteUserSegments(ctx, tx, user, deleteSegments)
+			if txErr != nil {
+				return txErr
+			}
+		}
+
+		events := make([]model.Event, 0, len(insertSegments)+len(deleteSegments))
+		for _, segment := range insertSegments {
+			events = append(events, model.Event{Type: model.EventAdd, UserID: &user.ID, SegmentSlug: segment.Slug})
+		}
+		for _, segment := range deleteSegments {
+			events = append(events, model.Event{Type: model.EventDelete, UserID: &user.ID, SegmentSlug: segment.Slug})
+		}
+
+		return p.createEvents(ctx, tx, events)
+	})
+
+	return err
+}
+
+// insertUserSegments connects user with given segments.
+func (p *pg) insertUserSegments(ctx context.Context, tx *gorm.DB, user *model.User, segments []*model.Segment) error {
+	err := tx.WithContext(ctx).Model(user).Omit("Segments.*").Association("Segments").Append(segments)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDB, err)
+	}
+	return nil
+}
+
+// deleteUserSegments soft deletes user relation to given segments,
+// just updates deleted_at column.
+func (p *pg) deleteUserSegments(ctx context.Context, tx *gorm.DB, user *model.User, segments []*model.Segment) error {
+	err := tx.WithContext(ctx).Model(user).Association("Segments").Delete(segments)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDB, err)
+	}
+	return nil
+}
+
+// GetUserWithActiveSegments returns user with related segments
+// and the time of the last change of user's segments.
+func (p *pg) GetUserWithActiveSegments(ctx context.Context, user *model.User) (*model.User, error) {
+	result := p.conn.WithContext(ctx).Preload("Segments.Grants").First(user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("user with ID (%d) %w", user.ID, ErrNotFound)
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+
+	updatedAt, err := p.getUserSegmentsUpdatedAt(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	user.SegmentsUpdatedAt = updatedAt
+
+	return user, nil
+}
+
+// getUserSegmentsUpdatedAt returns the latest creation or deletion time over user's segments relations,
+// user creation time is returned if user has never been in any segment.
+func (p *pg) getUserSegmentsUpdatedAt(ctx context.Context, user *model.User) (time.Time, error) {
+	var updatedAt sql.NullTime
+	result := p.conn.WithContext(ctx).Raw(
+		"SELECT MAX(GREATEST(created_at, COALESCE(deleted_at, created_at))) "+
+			"FROM user_segments WHERE user_id = ?", user.ID).
+		Scan(&updatedAt)
+	if result.Error != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	if !updatedAt.Valid {
+		return user.CreatedAt, nil
+	}
+	return updatedAt.Time, nil
+}
+
+// GetUserSegmentsHistory returns user relation to segments for the specified time interval.
+func (p *pg) GetUserSegmentsHistory(ctx context.Context, user *model.User, from time.Time, to time.Time) ([]model.UserSegment, error) {
+	var userSegments []model.UserSegment
+
+	result := p.conn.WithContext(ctx).Unscoped().Preload("Segment").Model(&userSegments).
+		Find(&userSegments, "user_segments.user_id = ? AND "+
+			"((user_segments.created_at >= ? AND user_segments.created_at < ?)"+
+			" OR (user_segments.deleted_at = null "+
+			" OR (user_segments.deleted_at >= ? AND user_segments.deleted_at < ?)))"+
+			" ORDER BY user_segments.created_at ASC", user.ID, from, to, from, to)
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+
+	return userSegments, nil
+}
+
+// sampleRandomUsers returns ids of random users for the new segment depending on selection percent.
+// Users of other segments of the segment's group and users that are not in all prerequisites of the segment
+// are skipped.
+func (p *pg) sampleRandomUsers(ctx context.Context, tx *gorm.DB, segment *model.Segment, selection float64) ([]uint64, error) {
+	count, err := p.getUsersCount(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		log.Info("CreateSegmentForRandomUsers: no users in database to add segment")
+		return nil, nil
+	}
+
+	limit := int(math.Ceil(float64(count) * selection))
+	if limit == 0 {
+		return nil, nil
+	}
+
+	var groupID uint64
+	if segment.GroupID != nil {
+		groupID = *segment.GroupID
+	}
+	query := tx.WithContext(ctx).Model(&model.User{}).
+		Where("NOT EXISTS (SELECT 1 FROM user_segments JOIN segments ON segments.id = user_segments.segment_id "+
+			"WHERE user_segments.user_id = users.id AND user_segments.deleted_at IS NULL AND segments.group_id = ?)", groupID)
+	if len(segment.Prerequisites) > 0 {
+		prerequisiteIDs := make([]uint64, 0, len(segment.Prerequisites))
+		for _, prerequisite := range segment.Prerequisites {
+			prerequisiteIDs = append(prerequisiteIDs, prerequisite.PrerequisiteID)
+		}
+		query = query.Where("(SELECT COUNT(DISTINCT prerequisite_users.segment_id) FROM user_segments prerequisite_users "+
+			"WHERE prerequisite_users.user_id = users.id AND prerequisite_users.deleted_at IS NULL "+
+			"AND prerequisite_users.segment_id IN ?) = ?", prerequisiteIDs, len(prerequisiteIDs))
+	}
+
+	var ids []uint64
+	result := query.Order("random()").Limit(limit).Pluck("users.id", &ids)
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return ids, nil
+}
+
+// addSegmentToUsers adds relation for given segment to given users, writes add event for each of them
+// and returns the number of inserted rows. Users are checked again, users of other segments
+// of the segment's group and users that are not in all prerequisites of the segment are skipped.
+// Users are added by batches of membershipsBatchSize.
+func (p *pg) addSegmentToUsers(ctx context.Context, tx *gorm.DB, segment *model.Segment, userIDs []uint64) (int64, error) {
+	now := time.Now()
+	var groupID uint64
+	if segment.GroupID != nil {
+		groupID = *segment.GroupID
+	}
+	var added int64
+	for start := 0; start < len(userIDs); start += membershipsBatchSize {
+		end := start + membershipsBatchSize
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		result := tx.WithContext(ctx).Exec(
+			"WITH inserted AS ("+
+				"INSERT INTO user_segments (user_id, segment_id, created_at) "+
+				"SELECT users.id, ?, ? FROM users WHERE users.id IN ? AND users.deleted_at IS NULL "+
+				"AND NOT EXISTS (SELECT 1 FROM user_segments JOIN segments ON segments.id = user_segments.segment_id "+
+				"WHERE user_segments.user_id = users.id AND user_segments.deleted_at IS NULL AND segments.group_id = ?) "+
+				"AND NOT EXISTS (SELECT 1 FROM segment_prerequisites WHERE segment_prerequisites.segment_id = ? "+
+				"AND NOT EXISTS (SELECT 1 FROM user_segments prerequisite_users "+
+				"WHERE prerequisite_users.user_id = users.id AND prerequisite_users.deleted_at IS NULL "+
+				"AND prerequisite_users.segment_id = segment_prerequisites.prerequisite_id)) "+
+				"RETURNING user_id) "+
+				"INSERT INTO events (type, user_id, segment_slug, created_at) "+
+				"SELECT ?, inserted.user_id, ?, ? FROM inserted",
+			segment.ID, now, userIDs[start:end], groupID, segment.ID, model.EventAdd, segment.Slug, now)
+		if result.Error != nil {
+			return 0, fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		added += result.RowsAffected
+	}
+	return added, nil
+}
+
+// CreateSegmentForRandomUsers inserts new segment and adds it to random users depending on selection percent
+// in one transaction, so the segment is either created with all selected users or not created.
+// Users are sampled before the memberships lock, as random ordering of all users is slow,
+// and are checked again under the lock, so users that joined the segment's group or lost its prerequisites
+// meanwhile are skipped. Returns the created segment and the number of users it was assigned to.
+func (p *pg) CreateSegmentForRandomUsers(ctx context.Context, segment *model.Segment, selection float64) (*model.Segment, int64, error) {
+	var created *model.Segment
+	var assigned int64
+	err := p.transaction(ctx, func(tx *gorm.DB) error {
+		userIDs, txErr := p.sampleRandomUsers(ctx, tx, segment, selection)
+		if txErr != nil {
+			return txErr
+		}
+
+		// the selected users must not get the segment concurrently with other segments of its group
+		// or lose its prerequisites
+		if txErr = p.lockAllMemberships(ctx, tx); txErr != nil {
+			return txErr
+		}
+
+		created, txErr = p.createSegment(ctx, tx, segment)
+		if txErr != nil {
+			return txErr
+		}
+
+		assigned, txErr = p.addSegmentToUsers(ctx, tx, created, userIDs)
+		return txErr
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return created, assigned, nil
+}
+
+// createEvents appends given events to the event log.
+func (p *pg) createEvents(ctx context.Context, tx *gorm.DB, events []model.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	result := tx.WithContext(ctx).Create(&events)
+	if result.Error != nil {
+		return fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return nil
+}
+
+// createSegmentUsersEvents appends event of given type for each active user of the segment.
+func (p *pg) createSegmentUsersEvents(ctx context.Context, tx *gorm.DB, segment *model.Segment, eventType model.EventType) error {
+	result := tx.WithContext(ctx).Exec(
+		"INSERT INTO events (type, user_id, segment_slug, created_at) "+
+			"SELECT ?, user_segments.user_id, ?, ? FROM user_segments "+
+			"WHERE user_segments.segment_id = ? AND user_segments.deleted_at IS NULL",
+		eventType, segment.Slug, time.Now(), segment.ID)
+	if result.Error != nil {
+		return fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return nil
+}
+
+// GetEvents returns committed events matching the filter after the event with AfterID,
+// events are ordered by their transactions and then by id, see eventsCommitted.
+func (p *pg) GetEvents(ctx context.Context, filter model.EventsFilter) ([]model.Event, error) {
+	var events []model.Event
+
+	query := p.conn.WithContext(ctx).Where(eventsCommitted)
+	if filter.AfterID > 0 {
+		var txID string
+		result := p.conn.WithContext(ctx).Model(&model.Event{}).Select("tx_id::text").Where("id = ?", filter.AfterID).Scan(&txID)
+		if result.Error != nil {
+			return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		if result.RowsAffected > 0 {
+			query = query.Where("(tx_id, id) > (?::xid8, ?)", txID, filter.AfterID)
+		} else {
+			// unknown event, e.g. given by the client
+			query = query.Where("id > ?", filter.AfterID)
+		}
+	}
+	if filter.UserID != nil {
+		query = query.Where("user_id = ?", *filter.UserID)
+	}
+	if filter.SegmentSlug != nil {
+		query = query.Where("segment_slug = ?", *filter.SegmentSlug)
+	}
+	if len(filter.Types) > 0 {
+		query = query.Where("type IN ?", filter.Types)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	result := query.Order("tx_id ASC, id ASC").Find(&events)
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return events, nil
+}
+
+// CreateWebhook inserts new webhook subscription, the subscription receives only events
+// that happen after its creation.
+func (p *pg) CreateWebhook(ctx context.Context, webhook *model.WebhookSubscription) (*model.WebhookSubscription, error) {
+	err := p.transaction(ctx, func(tx *gorm.DB) error {
+		// the subscriber gets events read after the last committed one, see GetEvents
+		var lastEventID sql.NullInt64
+		result := tx.WithContext(ctx).Model(&model.Event{}).Select("id").Where(eventsCommitted).
+			Order("tx_id DESC, id DESC").Limit(1).Scan(&lastEventID)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		webhook.LastEventID = uint64(lastEventID.Int64)
+
+		result = tx.WithContext(ctx).Create(webhook)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// GetWebhooks returns all active webhook subscriptions.
+func (p *pg) GetWebhooks(ctx context.Context) ([]*model.WebhookSubscription, error) {
+	var webhooks []*model.WebhookSubscription
+	result := p.conn.WithContext(ctx).Order("id ASC").Find(&webhooks)
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook soft deletes webhook subscription by id.
+func (p *pg) DeleteWebhook(ctx context.Context, webhook *model.WebhookSubscription) error {
+	return p.transaction(ctx, func(tx *gorm.DB) error {
+		result := tx.WithContext(ctx).Delete(webhook)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		} else if result.RowsAffected < 1 {
+			return fmt.Errorf("webhook with id (%d) is %w for delete", webhook.ID, ErrNotFound)
+		}
+		return nil
+	})
+}
+
+// ClaimWebhooks claims up to limit active webhook subscriptions that are not waiting for retry
+// and are not claimed by others for lease, the least recently claimed first.
+// Subscriptions locked by concurrent claims are skipped, so each one is delivered by one instance at a time.
+func (p *pg) ClaimWebhooks(ctx context.Context, lease time.Duration, limit int) ([]*model.WebhookSubscription, error) {
+	now := time.Now()
+	var webhooks []*model.WebhookSubscription
+	result := p.conn.WithContext(ctx).Raw(
+		"UPDATE webhook_subscriptions SET locked_until = ? WHERE id IN ("+
+			"SELECT id FROM webhook_subscriptions "+
+			"WHERE deleted_at IS NULL AND next_attempt_at <= ? AND locked_until <= ? "+
+			"ORDER BY locked_until, id LIMIT ? FOR UPDATE SKIP LOCKED) "+
+			"RETURNING *",
+		now.Add(lease).Truncate(time.Microsecond), now, now, limit).
+		Scan(&webhooks)
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhookDelivery saves webhook subscription delivery state and releases its claim.
+// The state is not saved if the claim has expired and the subscription is claimed again.
+func (p *pg) UpdateWebhookDelivery(ctx context.Context, webhook *model.WebhookSubscription) error {
+	result := p.conn.WithContext(ctx).Model(&model.WebhookSubscription{}).
+		Where("id = ? AND locked_until = ?", webhook.ID, webhook.LockedUntil).
+		Updates(map[string]interface{}{
+			"last_event_id":   webhook.LastEventID,
+			"failed_attempts": webhook.FailedAttempts,
+			"next_attempt_at": webhook.NextAttemptAt,
+			"last_error":      webhook.LastError,
+			"locked_until":    time.Now(),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("%w: %v", ErrDB, result.Error)
+	} else if result.RowsAffected < 1 {
+		return fmt.Errorf("claim of webhook with id (%d) is %w for update", webhook.ID, ErrNotFound)
+	}
+	return nil
+}
+
+// CreateAPIKey inserts new API key.
+func (p *pg) CreateAPIKey(ctx context.Context, key *model.APIKey) (*model.APIKey, error) {
+	err := p.transaction(ctx, func(tx *gorm.DB) error {
+		result := tx.WithContext(ctx).Create(key)
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return fmt.Errorf("api key %w", ErrAlreadyExists)
+		}
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// GetAPIKeyByHash returns active API key with given hash.
+func (p *pg) GetAPIKeyByHash(ctx context.Context, keyHash string) (*model.APIKey, error) {
+	key := &model.APIKey{}
+	result := p.conn.WithContext(ctx).First(key, "key_hash = ?", keyHash)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("api key %w", ErrNotFound)
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return key, nil
+}
+
+// DeleteAPIKey soft deletes API key by id.
+func (p *pg) DeleteAPIKey(ctx context.Context, key *model.APIKey) error {
+	return p.transaction(ctx, func(tx *gorm.DB) error {
+		result := tx.WithContext(ctx).Delete(key)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		} else if result.RowsAffected < 1 {
+			return fmt.Errorf("api key with id (%d) is %w for delete", key.ID, ErrNotFound)
+		}
+		return nil
+	})
+}
+
+// transaction runs fn in a transaction along with writing audit records of the call, see writeAudit.
+// Mutating calls are run by it, so their audit records are committed with their changes or not at all.
+func (p *pg) transaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	var recordID uint64
+	err := p.conn.Transaction(func(tx *gorm.DB) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		var err error
+		recordID, err = p.writeAudit(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if record, ok := AuditFromContext(ctx); ok && recordID > 0 {
+		record.ID = recordID
+	}
+	return nil
+}
+
+// writeAudit inserts audit record of the call from the context, unless it is already written
+// by an earlier transaction of the call, and a record per user membership changed by the transaction,
+// including memberships removed by cascades and replaces. Changed memberships are taken from events
+// of the transaction. Returns id of the inserted call record.
+func (p *pg) writeAudit(ctx context.Context, tx *gorm.DB) (uint64, error) {
+	record, ok := AuditFromContext(ctx)
+	if !ok {
+		return 0, nil
+	}
+	now := time.Now()
+
+	var recordID uint64
+	if record.ID == 0 {
+		call := *record
+		call.CreatedAt = now
+		result := tx.WithContext(ctx).Create(&call)
+		if result.Error != nil {
+			return 0, fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		recordID = call.ID
+	}
+
+	result := tx.WithContext(ctx).Exec(
+		"INSERT INTO audit_records (action, actor, request_id, source_ip, forwarded_for, method, path, "+
+			"user_id, segments, reason, status_code, change, created_at) "+
+			"SELECT ?, ?, ?, ?, ?, ?, ?, events.user_id, events.segment_slug, ?, ?, events.type, ? FROM events "+
+			"WHERE events.tx_id = pg_current_xact_id() AND events.user_id IS NOT NULL ORDER BY events.id",
+		record.Action, record.Actor, record.RequestID, record.SourceIP, record.ForwardedFor, record.Method, record.Path,
+		record.Reason, record.StatusCode, now)
+	if result.Error != nil {
+		return 0, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return recordID, nil
+}
+
+// CreateAuditRecord inserts audit record.
+func (p *pg) CreateAuditRecord(ctx context.Context, record *model.AuditRecord) error {
+	result := p.conn.WithContext(ctx).Create(record)
+	if result.Error != nil {
+		return fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return nil
+}
+
+// GetAuditRecords returns audit records matching the filter, newest first.
+func (p *pg) GetAuditRecords(ctx context.Context, filter model.AuditFilter) ([]model.AuditRecord, error) {
+	var records []model.AuditRecord
+
+	query := p.conn.WithContext(ctx)
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.SegmentSlug != nil {
+		query = query.Where("? = ANY(string_to_array(segments, ','))", *filter.SegmentSlug)
+	}
+	if filter.UserID != nil {
+		query = query.Where("user_id = ?", *filter.UserID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	if filter.BeforeID > 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+
+	result := query.Order("id DESC").Limit(filter.Limit).Find(&records)
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return records, nil
 }
 
 // GetUser returns user with given user.ID.
@@ -306,3 +1508,109 @@ func (p *pg) GetUser(ctx context.Context, user *model.User) (*model.User, error)
 	}
 	return user, nil
 }
+
+// CreateIdempotencyRecord inserts idempotency record if its key is not used yet or previous record is expired.
+// The record of the same request which is not completed in its lock time is taken over:
+// it is locked until the new record's LockedUntil and returned as created.
+// Otherwise, the existing record is returned and created is false.
+func (p *pg) CreateIdempotencyRecord(ctx context.Context, record *model.IdempotencyRecord) (*model.IdempotencyRecord, bool, error) {
+	created := false
+	err := p.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("expires_at < ?", record.CreatedAt).Delete(&model.IdempotencyRecord{})
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+
+		result = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+		if result.RowsAffected > 0 {
+			created = true
+			return nil
+		}
+
+		existing := &model.IdempotencyRecord{}
+		result = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("idempotency_key = ?", record.Key).
+			First(existing)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrDB, result.Error)
+		}
+
+		if !existing.Completed && existing.Fingerprint == record.Fingerprint && existing.LockedUntil.Before(record.CreatedAt) {
+			existing.LockedUntil = record.LockedUntil
+			result = tx.Model(existing).Update("locked_until", existing.LockedUntil)
+			if result.Error != nil {
+				return fmt.Errorf("%w: %v", ErrDB, result.Error)
+			}
+			created = true
+		}
+		record = existing
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return record, created, nil
+}
+
+// CompleteIdempotencyRecord saves response of the request made with idempotency key.
+// If the key was taken over by a retry, ErrNotFound is returned and the response is not saved.
+func (p *pg) CompleteIdempotencyRecord(ctx context.Context, record *model.IdempotencyRecord) error {
+	record.Completed = true
+	result := p.conn.WithContext(ctx).
+		Model(record).
+		Where("locked_until = ?", record.LockedUntil).
+		Select("completed", "status_code", "content_type", "body").
+		Updates(record)
+	if result.Error != nil {
+		return fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("idempotency key %s is taken over, %w", record.Key, ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteIdempotencyRecord releases idempotency key unless it was taken over by a retry.
+func (p *pg) DeleteIdempotencyRecord(ctx context.Context, record *model.IdempotencyRecord) error {
+	result := p.conn.WithContext(ctx).
+		Where("locked_until = ?", record.LockedUntil).
+		Delete(record)
+	if result.Error != nil {
+		return fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return nil
+}
+
+// Ping checks the database is reachable.
+func (p *pg) Ping(ctx context.Context) error {
+	sqlDB, err := p.conn.DB()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDB, err)
+	}
+	if err = sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("%w: %v", ErrDB, err)
+	}
+	return nil
+}
+
+// GetSchemaVersion returns the latest applied schema version.
+func (p *pg) GetSchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	result := p.conn.WithContext(ctx).Model(&schemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version)
+	if result.Error != nil {
+		return 0, fmt.Errorf("%w: %v", ErrDB, result.Error)
+	}
+	return version, nil
+}
+
+// Close closes database connections.
+func (p *pg) Close() error {
+	sqlDB, err := p.conn.DB()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDB, err)
+	}
+	return sqlDB.Close()
+}
@@ -0,0 +1,444 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+// testDSNEnv enables tests against a real PostgreSQL, tables are created in a new schema of the database.
+const testDSNEnv = "TEST_POSTGRES_DSN"
+
+// errTestForbidden is returned by test authorizers.
+var errTestForbidden = errors.New("forbidden")
+
+var (
+	testPGOnce   sync.Once
+	testPG       *pg
+	testPGErr    error
+	testPGSchema string
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if testPG != nil {
+		testPG.conn.Exec("DROP SCHEMA " + testPGSchema + " CASCADE")
+		testPG.Close()
+	}
+	os.Exit(code)
+}
+
+// setupPG returns the database with empty tables, the test is skipped if the database is not configured.
+func setupPG(t *testing.T) *pg {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+
+	testPGOnce.Do(func() {
+		testPGSchema = fmt.Sprintf("segments_test_%d", time.Now().UnixNano())
+		conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			testPGErr = err
+			return
+		}
+		testPGErr = conn.Exec("CREATE SCHEMA " + testPGSchema).Error
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+		if testPGErr != nil {
+			return
+		}
+
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		cfg := config.NewPostgresConfig()
+		cfg.DSN = dsn + separator + "search_path=" + testPGSchema
+		testPG, testPGErr = NewPGDatabase(cfg)
+	})
+	require.NoError(t, testPGErr)
+
+	err := testPG.conn.Exec("TRUNCATE users, segments, user_segments, segment_prerequisites, exclusion_groups, events, webhook_subscriptions, audit_records " +
+		"RESTART IDENTITY CASCADE").Error
+	require.NoError(t, err)
+	return testPG
+}
+
+// createTestUsers creates users with ids from 1 to count.
+func createTestUsers(t *testing.T, p *pg, count int) {
+	users := make([]model.User, 0, count)
+	for id := 1; id <= count; id++ {
+		users = append(users, model.User{ID: uint64(id)})
+	}
+	require.NoError(t, p.conn.Create(&users).Error)
+}
+
+// createTestSegment creates explicit segment with given slug.
+func createTestSegment(t *testing.T, p *pg, segment *model.Segment) *model.Segment {
+	segment, err := p.CreateSegment(context.Background(), segment)
+	require.NoError(t, err)
+	return segment
+}
+
+// userSegmentsIDs returns ids of users in the segment.
+func userSegmentsIDs(t *testing.T, p *pg, segment *model.Segment) []uint64 {
+	var ids []uint64
+	err := p.conn.Model(&model.UserSegment{}).Where("segment_id = ?", segment.ID).Order("user_id").Pluck("user_id", &ids).Error
+	require.NoError(t, err)
+	return ids
+}
+
+func TestPG_CreateSegmentForRandomUsersGroup(t *testing.T) {
+	p := setupPG(t)
+	ctx := context.Background()
+	createTestUsers(t, p, 3)
+
+	promo5 := createTestSegment(t, p, &model.Segment{Slug: "PROMO_5"})
+	group, err := p.CreateExclusionGroup(ctx, &model.ExclusionGroup{Slug: "PROMO_DISCOUNTS", Policy: model.GroupPolicyReject},
+		[]model.Slug{promo5.Slug})
+	require.NoError(t, err)
+	require.NoError(t, p.CreateDeleteUserSegments(ctx, &model.User{ID: 1}, []model.Slug{promo5.Slug}, nil, nil))
+
+	sales20, assigned, err := p.CreateSegmentForRandomUsers(ctx, &model.Segment{Slug: "AVITO_SALES_20", GroupID: &group.ID}, 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), assigned)
+	require.Equal(t, []uint64{2, 3}, userSegmentsIDs(t, p, sales20))
+}
+
+func TestPG_CreateSegmentForRandomUsersExisting(t *testing.T) {
+	p := setupPG(t)
+	ctx := context.Background()
+	createTestUsers(t, p, 3)
+
+	promo5 := createTestSegment(t, p, &model.Segment{Slug: "PROMO_5"})
+	_, _, err := p.CreateSegmentForRandomUsers(ctx, &model.Segment{Slug: promo5.Slug}, 1)
+	require.ErrorIs(t, err, ErrAlreadyExists)
+	require.Empty(t, userSegmentsIDs(t, p, promo5))
+}
+
+func TestPG_CreateDeleteUserSegmentsConcurrentGroup(t *testing.T) {
+	p := setupPG(t)
+	ctx := context.Background()
+	createTestUsers(t, p, 1)
+
+	promo5 := createTestSegment(t, p, &model.Segment{Slug: "PROMO_5"})
+	sales20 := createTestSegment(t, p, &model.Segment{Slug: "AVITO_SALES_20"})
+	_, err := p.CreateExclusionGroup(ctx, &model.ExclusionGroup{Slug: "PROMO_DISCOUNTS", Policy: model.GroupPolicyReject},
+		[]model.Slug{promo5.Slug, sales20.Slug})
+	require.NoError(t, err)
+
+	errs := make(chan error, 2)
+	for _, slug := range []model.Slug{promo5.Slug, sales20.Slug} {
+		go func(slug model.Slug) {
+			errs <- p.CreateDeleteUserSegments(ctx, &model.User{ID: 1}, []model.Slug{slug}, nil, nil)
+		}(slug)
+	}
+
+	var conflicts int
+	for i := 0; i < 2; i++ {
+		if err = <-errs; err != nil {
+			require.ErrorIs(t, err, ErrGroupConflict)
+			conflicts++
+		}
+	}
+	require.Equal(t, 1, conflicts)
+}
+
+func TestPG_CreateSegmentForRandomUsersPrerequisites(t *testing.T) {
+	p := setupPG(t)
+	ctx := context.Background()
+	createTestUsers(t, p, 3)
+
+	verified := createTestSegment(t, p, &model.Segment{Slug: "VERIFIED_PHONE"})
+	for _, id := range []uint64{1, 3} {
+		require.NoError(t, p.CreateDeleteUserSegments(ctx, &model.User{ID: id}, []model.Slug{verified.Slug}, nil, nil))
+	}
+
+	promo5, assigned, err := p.CreateSegmentForRandomUsers(ctx, &model.Segment{
+		Slug:          "PROMO_5",
+		Prerequisites: []model.SegmentPrerequisite{{PrerequisiteID: verified.ID, OnRemove: model.PrerequisiteReject}},
+	}, 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), assigned)
+	require.Equal(t, []uint64{1, 3}, userSegmentsIDs(t, p, promo5))
+}
+
+func TestPG_CreateDeleteUserSegmentsConcurrentPrerequisite(t *testing.T) {
+	p := setupPG(t)
+	ctx := context.Background()
+	createTestUsers(t, p, 1)
+
+	verified := createTestSegment(t, p, &model.Segment{Slug: "VERIFIED_PHONE"})
+	promo5 := createTestSegment(t, p, &model.Segment{
+		Slug:          "PROMO_5",
+		Prerequisites: []model.SegmentPrerequisite{{PrerequisiteID: verified.ID, OnRemove: model.PrerequisiteReject}},
+	})
+	require.NoError(t, p.CreateDeleteUserSegments(ctx, &model.User{ID: 1}, []model.Slug{verified.Slug}, nil, nil))
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- p.CreateDeleteUserSegments(ctx, &model.User{ID: 1}, []model.Slug{promo5.Slug}, nil, nil)
+	}()
+	go func() {
+		errs <- p.CreateDeleteUserSegments(ctx, &model.User{ID: 1}, nil, []model.Slug{verified.Slug}, nil)
+	}()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			require.ErrorIs(t, err, ErrPrerequisite)
+		}
+	}
+
+	// the user is in PROMO_5 only along with VERIFIED_PHONE
+	promoUsers, verifiedUsers := userSegmentsIDs(t, p, promo5), userSegmentsIDs(t, p, verified)
+	require.True(t, len(promoUsers) == 0 || len(verifiedUsers) == 1)
+}
+
+func TestPG_CreateDeleteUserSegmentsAuthorizeCascade(t *testing.T) {
+	p := setupPG(t)
+	ctx := context.Background()
+	createTestUsers(t, p, 1)
+
+	verified := createTestSegment(t, p, &model.Segment{Slug: "VERIFIED_PHONE"})
+	promo5 := createTestSegment(t, p, &model.Segment{
+		Slug:          "PROMO_5",
+		Prerequisites: []model.SegmentPrerequisite{{PrerequisiteID: verified.ID, OnRemove: model.PrerequisiteCascade}},
+	})
+	user := &model.User{ID: 1}
+	require.NoError(t, p.CreateDeleteUserSegments(ctx, user, []model.Slug{verified.Slug, promo5.Slug}, nil, nil))
+
+	var authorized []model.Slug
+	err := p.CreateDeleteUserSegments(ctx, user, nil, []model.Slug{verified.Slug}, func(segments []*model.Segment) error {
+		for _, segment := range segments {
+			authorized = append(authorized, segment.Slug)
+		}
+		return errTestForbidden
+	})
+	require.ErrorIs(t, err, errTestForbidden)
+	require.Equal(t, []model.Slug{promo5.Slug}, authorized)
+	require.Equal(t, []uint64{1}, userSegmentsIDs(t, p, verified))
+	require.Equal(t, []uint64{1}, userSegmentsIDs(t, p, promo5))
+}
+
+func TestPG_AuditRecordsInTransaction(t *testing.T) {
+	p := setupPG(t)
+	createTestUsers(t, p, 1)
+
+	verified := createTestSegment(t, p, &model.Segment{Slug: "VERIFIED_PHONE"})
+	promo5 := createTestSegment(t, p, &model.Segment{
+		Slug:          "PROMO_5",
+		Prerequisites: []model.SegmentPrerequisite{{PrerequisiteID: verified.ID, OnRemove: model.PrerequisiteCascade}},
+	})
+	user := &model.User{ID: 1}
+	require.NoError(t, p.CreateDeleteUserSegments(context.Background(), user, []model.Slug{verified.Slug, promo5.Slug}, nil, nil))
+
+	// failed change writes no records
+	failed := &model.AuditRecord{Action: model.AuditSegmentCreate, Actor: "admin", Segments: string(verified.Slug)}
+	_, err := p.CreateSegment(ContextWithAudit(context.Background(), failed), &model.Segment{Slug: verified.Slug})
+	require.ErrorIs(t, err, ErrAlreadyExists)
+	require.Zero(t, failed.ID)
+
+	// the cascaded removal is recorded along with the requested one
+	record := &model.AuditRecord{
+		Action:     model.AuditUserSegmentsUpdate,
+		Actor:      "admin",
+		UserID:     &user.ID,
+		Segments:   string(verified.Slug),
+		Reason:     "phone changed",
+		StatusCode: 200,
+	}
+	ctx := ContextWithAudit(context.Background(), record)
+	require.NoError(t, p.CreateDeleteUserSegments(ctx, user, nil, []model.Slug{verified.Slug}, nil))
+	require.NotZero(t, record.ID)
+
+	records, err := p.GetAuditRecords(context.Background(), model.AuditFilter{Limit: model.AuditLimitDefault})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	changes := map[model.Slug]model.EventType{}
+	for _, r := range records {
+		require.Equal(t, "admin", r.Actor)
+		require.Equal(t, "phone changed", r.Reason)
+		require.Equal(t, user.ID, *r.UserID)
+		if r.ID == record.ID {
+			require.Empty(t, r.Change)
+			continue
+		}
+		changes[model.Slug(r.Segments)] = r.Change
+	}
+	require.Equal(t, map[model.Slug]model.EventType{verified.Slug: model.EventDelete, promo5.Slug: model.EventDelete}, changes)
+}
+
+func TestPG_DeleteSegmentPrerequisite(t *testing.T) {
+	p := setupPG(t)
+	ctx := context.Background()
+	createTestUsers(t, p, 2)
+
+	verified := createTestSegment(t, p, &model.Segment{Slug: "VERIFIED_PHONE"})
+	promo5 := createTestSegment(t, p, &model.Segment{
+		Slug:          "PROMO_5",
+		Prerequisites: []model.SegmentPrerequisite{{PrerequisiteID: verified.ID, OnRemove: model.PrerequisiteCascade}},
+	})
+	sales20 := createTestSegment(t, p, &model.Segment{
+		Slug:          "AVITO_SALES_20",
+		Prerequisites: []model.SegmentPrerequisite{{PrerequisiteID: promo5.ID, OnRemove: model.PrerequisiteReject}},
+	})
+	require.NoError(t, p.CreateDeleteUserSegments(ctx, &model.User{ID: 1}, []model.Slug{verified.Slug, promo5.Slug}, nil, nil))
+	require.NoError(t, p.CreateDeleteUserSegments(ctx, &model.User{ID: 2}, []model.Slug{verified.Slug, promo5.Slug, sales20.Slug}, nil, nil))
+
+	// user 2 is in AVITO_SALES_20 that rejects removal of PROMO_5
+	err := p.DeleteSegment(ctx, &model.Segment{Slug: verified.Slug}, nil)
+	require.ErrorIs(t, err, ErrPrerequisite)
+	require.Equal(t, []uint64{1, 2}, userSegmentsIDs(t, p, promo5))
+
+	require.NoError(t, p.CreateDeleteUserSegments(ctx, &model.User{ID: 2}, nil, []model.Slug{sales20.Slug}, nil))
+	require.NoError(t, p.DeleteSegment(ctx, &model.Segment{Slug: verified.Slug}, nil))
+	require.Empty(t, userSegmentsIDs(t, p, promo5))
+
+	events, err := p.GetEvents(ctx, model.EventsFilter{SegmentSlug: &promo5.Slug, Types: []model.EventType{model.EventDelete}})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+}
+
+func TestPG_GetEventsLateCommit(t *testing.T) {
+	p := setupPG(t)
+	ctx := context.Background()
+	createTestUsers(t, p, 1)
+	promo5 := createTestSegment(t, p, &model.Segment{Slug: "PROMO_5"})
+
+	// the event id is allocated before the concurrent change commits
+	late := p.conn.Begin()
+	require.NoError(t, late.Create(&model.Event{Type: model.EventSegmentDeleted, SegmentSlug: "BLACK_FRIDAY"}).Error)
+	require.NoError(t, p.CreateDeleteUserSegments(ctx, &model.User{ID: 1}, []model.Slug{promo5.Slug}, nil, nil))
+
+	events, err := p.GetEvents(ctx, model.EventsFilter{})
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	require.NoError(t, late.Commit().Error)
+	events, err = p.GetEvents(ctx, model.EventsFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, model.Slug("BLACK_FRIDAY"), events[0].SegmentSlug)
+
+	events, err = p.GetEvents(ctx, model.EventsFilter{AfterID: events[0].ID})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, promo5.Slug, events[0].SegmentSlug)
+}
+
+func TestPG_GetSegmentsVersionLateCommit(t *testing.T) {
+	p := setupPG(t)
+	ctx := context.Background()
+	before, err := p.GetSegmentsVersion(ctx)
+	require.NoError(t, err)
+
+	late := p.conn.Begin()
+	promo5, err := p.createSegment(ctx, late, &model.Segment{Slug: "PROMO_5"})
+	require.NoError(t, err)
+
+	created := make(chan error, 1)
+	go func() {
+		_, err := p.CreateSegment(ctx, &model.Segment{Slug: "AVITO_SALES_20"})
+		created <- err
+	}()
+
+	// the concurrent change waits for the previous version to be committed
+	select {
+	case err = <-created:
+		t.Fatalf("segment is created before the previous version is committed: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	version, err := p.GetSegmentsVersion(ctx)
+	require.NoError(t, err)
+	require.Equal(t, before, version)
+
+	require.NoError(t, late.Commit().Error)
+	require.NoError(t, <-created)
+
+	segments, err := p.ListSegments(ctx, model.SegmentsFilter{SinceVersion: promo5.Version})
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	require.Equal(t, model.Slug("AVITO_SALES_20"), segments[0].Slug)
+}
+
+func TestPG_IdempotencyRecordTakeOver(t *testing.T) {
+	p := setupPG(t)
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Microsecond)
+	newRecord := func(createdAt time.Time, fingerprint string) *model.IdempotencyRecord {
+		return &model.IdempotencyRecord{
+			Key:         "key-1",
+			Fingerprint: fingerprint,
+			CreatedAt:   createdAt,
+			LockedUntil: createdAt.Add(time.Minute),
+			ExpiresAt:   createdAt.Add(time.Hour),
+		}
+	}
+
+	first, created, err := p.CreateIdempotencyRecord(ctx, newRecord(now, "request"))
+	require.NoError(t, err)
+	require.True(t, created)
+
+	_, created, err = p.CreateIdempotencyRecord(ctx, newRecord(now.Add(time.Second), "request"))
+	require.NoError(t, err)
+	require.False(t, created, "locked key is taken over")
+
+	_, created, err = p.CreateIdempotencyRecord(ctx, newRecord(now.Add(2*time.Minute), "other request"))
+	require.NoError(t, err)
+	require.False(t, created, "key is taken over by other request")
+
+	retry, created, err := p.CreateIdempotencyRecord(ctx, newRecord(now.Add(2*time.Minute), "request"))
+	require.NoError(t, err)
+	require.True(t, created)
+	require.Equal(t, first.ID, retry.ID)
+
+	// the request which lost the key neither saves its response nor releases the key
+	require.ErrorIs(t, p.CompleteIdempotencyRecord(ctx, first), ErrNotFound)
+	require.NoError(t, p.DeleteIdempotencyRecord(ctx, first))
+	require.NoError(t, p.CompleteIdempotencyRecord(ctx, retry))
+
+	saved, created, err := p.CreateIdempotencyRecord(ctx, newRecord(now.Add(3*time.Minute), "request"))
+	require.NoError(t, err)
+	require.False(t, created)
+	require.True(t, saved.Completed)
+}
+
+func TestPG_ClaimWebhooks(t *testing.T) {
+	p := setupPG(t)
+	ctx := context.Background()
+
+	webhook, err := p.CreateWebhook(ctx, &model.WebhookSubscription{URL: "https://crm.example.com/hooks", Secret: "secret"})
+	require.NoError(t, err)
+
+	claimed, err := p.ClaimWebhooks(ctx, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	require.Equal(t, webhook.ID, claimed[0].ID)
+
+	again, err := p.ClaimWebhooks(ctx, time.Minute, 10)
+	require.NoError(t, err)
+	require.Empty(t, again, "claimed webhook is not claimed twice")
+
+	claimed[0].LastEventID = 42
+	require.NoError(t, p.UpdateWebhookDelivery(ctx, claimed[0]))
+	require.ErrorIs(t, p.UpdateWebhookDelivery(ctx, claimed[0]), ErrNotFound, "released claim is not updated")
+
+	again, err = p.ClaimWebhooks(ctx, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, again, 1, "released webhook is claimed again")
+	require.Equal(t, uint64(42), again[0].LastEventID)
+}
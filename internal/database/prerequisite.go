@@ -0,0 +1,136 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+// prerequisitesCycle returns the cycle the prerequisites of the segment would make with existing edges
+// from segments to their prerequisites, e.g. [A B C A], nil is returned if there is no cycle.
+// Existing edges of the segment are ignored as they are replaced.
+func prerequisitesCycle(edges map[model.Slug][]model.Slug, segment model.Slug, prerequisites []model.Slug) []model.Slug {
+	visited := make(map[model.Slug]bool)
+	var path []model.Slug
+
+	var reaches func(slug model.Slug) bool
+	reaches = func(slug model.Slug) bool {
+		if slug == segment {
+			return true
+		}
+		if visited[slug] {
+			return false
+		}
+		visited[slug] = true
+		for _, next := range edges[slug] {
+			if reaches(next) {
+				path = append(path, next)
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, prerequisite := range prerequisites {
+		if !reaches(prerequisite) {
+			continue
+		}
+		path = append(path, prerequisite, segment)
+		// path is collected from the end
+		for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+			path[i], path[j] = path[j], path[i]
+		}
+		return path
+	}
+	return nil
+}
+
+// cascadePrerequisites checks the user is in prerequisites of segments to insert after the change and returns
+// segments to delete with the user's dependent segments of deleted ones removed by cascade policy, transitively.
+// Removal of the prerequisite of the user's segment with reject policy fails with ErrPrerequisite.
+// Relations must contain prerequisites of the current and inserted segments.
+func cascadePrerequisites(
+	current []*model.Segment,
+	relations []model.SegmentPrerequisite,
+	insertSegments []*model.Segment,
+	deleteSegments []*model.Segment,
+) ([]*model.Segment, error) {
+	member := make(map[uint64]*model.Segment, len(current)+len(insertSegments))
+	for _, segment := range current {
+		member[segment.ID] = segment
+	}
+	inserting := make(map[uint64]bool, len(insertSegments))
+	for _, segment := range insertSegments {
+		member[segment.ID] = segment
+		inserting[segment.ID] = true
+	}
+	for _, segment := range deleteSegments {
+		delete(member, segment.ID)
+	}
+
+	dependents := make(map[uint64][]model.SegmentPrerequisite)
+	for _, relation := range relations {
+		dependents[relation.PrerequisiteID] = append(dependents[relation.PrerequisiteID], relation)
+	}
+
+	removed := append([]*model.Segment(nil), deleteSegments...)
+	for len(removed) > 0 {
+		prerequisite := removed[0]
+		removed = removed[1:]
+		for _, relation := range dependents[prerequisite.ID] {
+			dependent, ok := member[relation.SegmentID]
+			// inserted dependent segments are checked below
+			if !ok || inserting[relation.SegmentID] {
+				continue
+			}
+			if relation.OnRemove != model.PrerequisiteCascade {
+				return nil, fmt.Errorf("user is in segment with slug (%s) that requires (%s), %w",
+					dependent.Slug, prerequisite.Slug, ErrPrerequisite)
+			}
+			delete(member, dependent.ID)
+			removed = append(removed, dependent)
+			deleteSegments = append(deleteSegments, dependent)
+		}
+	}
+
+	for _, relation := range relations {
+		if !inserting[relation.SegmentID] || member[relation.PrerequisiteID] != nil {
+			continue
+		}
+		prerequisite := model.Slug("")
+		if relation.Prerequisite != nil {
+			prerequisite = relation.Prerequisite.Slug
+		}
+		return nil, fmt.Errorf("segment with slug (%s) requires (%s), %w",
+			member[relation.SegmentID].Slug, prerequisite, ErrPrerequisite)
+	}
+	return deleteSegments, nil
+}
+
+// cascadeDeletedPrerequisite applies prerequisites to users of the deleted segment given by their memberships
+// in its dependent segments and returns users to remove by dependent segment id, see cascadePrerequisites.
+func cascadeDeletedPrerequisite(
+	segment *model.Segment,
+	relations []model.SegmentPrerequisite,
+	memberships map[uint64][]*model.Segment,
+) (map[uint64][]uint64, error) {
+	users := make([]uint64, 0, len(memberships))
+	for userID := range memberships {
+		users = append(users, userID)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+
+	removals := make(map[uint64][]uint64)
+	for _, userID := range users {
+		current := append([]*model.Segment{segment}, memberships[userID]...)
+		deleteSegments, err := cascadePrerequisites(current, relations, nil, []*model.Segment{segment})
+		if err != nil {
+			return nil, fmt.Errorf("user with ID (%d): %w", userID, err)
+		}
+		for _, dependent := range deleteSegments[1:] {
+			removals[dependent.ID] = append(removals[dependent.ID], userID)
+		}
+	}
+	return removals, nil
+}
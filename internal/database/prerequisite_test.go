@@ -0,0 +1,192 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+func TestPrerequisitesCycle(t *testing.T) {
+	edges := map[model.Slug][]model.Slug{
+		"PROMO_5":        {"VERIFIED_PHONE"},
+		"VERIFIED_PHONE": {"ACTIVE"},
+		"ACTIVE":         {},
+		// replaced edges of the segment
+		"NEW": {"PROMO_5"},
+	}
+
+	tests := []struct {
+		name          string
+		segment       model.Slug
+		prerequisites []model.Slug
+		expected      []model.Slug
+	}{
+		{
+			name:          "No cycle",
+			segment:       "NEW",
+			prerequisites: []model.Slug{"PROMO_5", "ACTIVE"},
+		},
+		{
+			name:          "Replaced edges are ignored",
+			segment:       "PROMO_5",
+			prerequisites: []model.Slug{"ACTIVE"},
+		},
+		{
+			name:          "Direct cycle",
+			segment:       "ACTIVE",
+			prerequisites: []model.Slug{"VERIFIED_PHONE"},
+			expected:      []model.Slug{"ACTIVE", "VERIFIED_PHONE", "ACTIVE"},
+		},
+		{
+			name:          "Transitive cycle",
+			segment:       "ACTIVE",
+			prerequisites: []model.Slug{"NEW"},
+			expected:      []model.Slug{"ACTIVE", "NEW", "PROMO_5", "VERIFIED_PHONE", "ACTIVE"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, prerequisitesCycle(edges, tt.segment, tt.prerequisites))
+		})
+	}
+}
+
+func TestCascadePrerequisites(t *testing.T) {
+	verified := &model.Segment{ID: 1, Slug: "VERIFIED_PHONE"}
+	active := &model.Segment{ID: 2, Slug: "ACTIVE"}
+	promo5 := &model.Segment{ID: 3, Slug: "PROMO_5"}
+	sales20 := &model.Segment{ID: 4, Slug: "AVITO_SALES_20"}
+	// PROMO_5 requires VERIFIED_PHONE with cascade, VERIFIED_PHONE requires ACTIVE with cascade,
+	// AVITO_SALES_20 requires ACTIVE with reject
+	relations := []model.SegmentPrerequisite{
+		{SegmentID: promo5.ID, PrerequisiteID: verified.ID, Prerequisite: verified, OnRemove: model.PrerequisiteCascade},
+		{SegmentID: verified.ID, PrerequisiteID: active.ID, Prerequisite: active, OnRemove: model.PrerequisiteCascade},
+		{SegmentID: sales20.ID, PrerequisiteID: active.ID, Prerequisite: active, OnRemove: model.PrerequisiteReject},
+	}
+
+	tests := []struct {
+		name           string
+		current        []*model.Segment
+		insertSegments []*model.Segment
+		deleteSegments []*model.Segment
+		expected       []*model.Segment
+		expectedErr    error
+	}{
+		{
+			name:           "Insert with prerequisite",
+			current:        []*model.Segment{active, verified},
+			insertSegments: []*model.Segment{promo5},
+		},
+		{
+			name:           "Insert with prerequisite inserted",
+			current:        []*model.Segment{active},
+			insertSegments: []*model.Segment{verified, promo5},
+		},
+		{
+			name:           "Insert with missing prerequisite",
+			current:        []*model.Segment{active},
+			insertSegments: []*model.Segment{promo5},
+			expectedErr:    ErrPrerequisite,
+		},
+		{
+			name:           "Insert with prerequisite deleted",
+			current:        []*model.Segment{active, verified},
+			insertSegments: []*model.Segment{promo5},
+			deleteSegments: []*model.Segment{verified},
+			expectedErr:    ErrPrerequisite,
+		},
+		{
+			name:           "Cascade",
+			current:        []*model.Segment{active, verified, promo5},
+			deleteSegments: []*model.Segment{verified},
+			expected:       []*model.Segment{verified, promo5},
+		},
+		{
+			name:           "Cascade chain",
+			current:        []*model.Segment{active, verified, promo5},
+			deleteSegments: []*model.Segment{active},
+			expected:       []*model.Segment{active, verified, promo5},
+		},
+		{
+			name:           "Reject",
+			current:        []*model.Segment{active, verified, sales20},
+			deleteSegments: []*model.Segment{active},
+			expectedErr:    ErrPrerequisite,
+		},
+		{
+			name:           "Reject with the dependent deleted",
+			current:        []*model.Segment{active, sales20},
+			deleteSegments: []*model.Segment{active, sales20},
+			expected:       []*model.Segment{active, sales20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleteSegments, err := cascadePrerequisites(tt.current, relations, tt.insertSegments, tt.deleteSegments)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, deleteSegments)
+		})
+	}
+}
+
+func TestCascadeDeletedPrerequisite(t *testing.T) {
+	active := &model.Segment{ID: 1, Slug: "ACTIVE"}
+	verified := &model.Segment{ID: 2, Slug: "VERIFIED_PHONE"}
+	promo5 := &model.Segment{ID: 3, Slug: "PROMO_5"}
+	sales20 := &model.Segment{ID: 4, Slug: "AVITO_SALES_20"}
+	// VERIFIED_PHONE requires ACTIVE with cascade, PROMO_5 requires VERIFIED_PHONE with cascade,
+	// AVITO_SALES_20 requires ACTIVE with reject
+	relations := []model.SegmentPrerequisite{
+		{SegmentID: verified.ID, PrerequisiteID: active.ID, OnRemove: model.PrerequisiteCascade},
+		{SegmentID: promo5.ID, PrerequisiteID: verified.ID, OnRemove: model.PrerequisiteCascade},
+		{SegmentID: sales20.ID, PrerequisiteID: active.ID, OnRemove: model.PrerequisiteReject},
+	}
+
+	tests := []struct {
+		name        string
+		memberships map[uint64][]*model.Segment
+		expected    map[uint64][]uint64
+		expectedErr error
+	}{
+		{
+			name:        "No dependent users",
+			memberships: map[uint64][]*model.Segment{},
+			expected:    map[uint64][]uint64{},
+		},
+		{
+			name: "Cascade chain",
+			memberships: map[uint64][]*model.Segment{
+				1: {verified, promo5},
+				2: {verified},
+				3: {promo5},
+			},
+			expected: map[uint64][]uint64{verified.ID: {1, 2}, promo5.ID: {1}},
+		},
+		{
+			name: "Reject",
+			memberships: map[uint64][]*model.Segment{
+				1: {verified},
+				2: {sales20},
+			},
+			expectedErr: ErrPrerequisite,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			removals, err := cascadeDeletedPrerequisite(active, relations, tt.memberships)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, removals)
+		})
+	}
+}
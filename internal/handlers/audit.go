@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/logging"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/services"
+)
+
+// Audit headers. ActorHeader identifies the caller when authentication is disabled,
+// ReasonHeader passes the reason of the change for calls without json body.
+const (
+	ActorHeader  = "X-Actor"
+	ReasonHeader = "X-Audit-Reason"
+)
+
+// audited returns middleware that records the call with given action to the audit log.
+// Handlers add call targets and reason with auditTarget and auditReason.
+// Changes of the call write its record in their transaction with the success status, see
+// database.ContextWithAudit, calls that changed nothing, e.g. failed ones, are recorded after the response.
+func (h HTTPHandler) audited(action string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			record := &model.AuditRecord{
+				Action:       action,
+				Actor:        auditActor(request),
+				RequestID:    middleware.GetReqID(request.Context()),
+				SourceIP:     remoteIP(request),
+				ForwardedFor: request.Header.Get("X-Forwarded-For"),
+				Method:       request.Method,
+				Path:         request.URL.Path,
+				Reason:       request.Header.Get(ReasonHeader),
+				StatusCode:   http.StatusOK,
+			}
+
+			ww := middleware.NewWrapResponseWriter(writer, request.ProtoMajor)
+			next.ServeHTTP(ww, request.WithContext(database.ContextWithAudit(request.Context(), record)))
+
+			if record.ID > 0 {
+				return
+			}
+			record.StatusCode = ww.Status()
+			if record.StatusCode == 0 {
+				record.StatusCode = http.StatusOK
+			}
+
+			// the record is saved even if the client has gone
+			if err := h.auditService.Record(context.Background(), record); err != nil {
+				logging.FromContext(request.Context()).Errorf("audit: can't save record of %s: %v", action, err)
+			}
+		})
+	}
+}
+
+// auditTarget adds user and segments affected by the call to its audit record.
+func auditTarget(ctx context.Context, userID *uint64, slugs ...model.Slug) {
+	record, ok := database.AuditFromContext(ctx)
+	if !ok {
+		return
+	}
+	if userID != nil {
+		record.UserID = userID
+	}
+	record.AddSegments(slugs...)
+}
+
+// auditReason sets reason of the call passed in json body.
+func auditReason(ctx context.Context, reason string) {
+	record, ok := database.AuditFromContext(ctx)
+	if !ok || reason == "" {
+		return
+	}
+	record.Reason = reason
+}
+
+// auditStatus sets status code the call responds with on success, if it differs from 200 OK.
+func auditStatus(ctx context.Context, statusCode int) {
+	if record, ok := database.AuditFromContext(ctx); ok {
+		record.StatusCode = statusCode
+	}
+}
+
+// auditActor returns authenticated principal subject or X-Actor header value.
+func auditActor(request *http.Request) string {
+	if principal, ok := services.PrincipalFromContext(request.Context()); ok {
+		return principal.Subject
+	}
+	if actor := request.Header.Get(ActorHeader); actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+func remoteIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// requestIDHeader returns request id to the client, so it can be matched with audit and logs.
+func requestIDHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(request.Context()))
+		next.ServeHTTP(writer, request)
+	})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/database"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+func TestHTTPHandlers_AuditRecord(t *testing.T) {
+	tests := []struct {
+		name          string
+		dbErr         error
+		inTransaction bool
+		wantStatus    int
+	}{
+		{
+			name:       "OK",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:          "Recorded in the change transaction",
+			inTransaction: true,
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:       "Failed call is recorded too",
+			dbErr:      database.ErrDB,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var record *model.AuditRecord
+			saved := false
+			handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, _ *model.User, _, _ []model.Slug, _ database.SegmentsAuthorizer) error {
+						if tt.inTransaction {
+							record, _ = database.AuditFromContext(ctx)
+							record.ID = 1
+						}
+						return tt.dbErr
+					})
+				db.EXPECT().
+					CreateAuditRecord(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, r *model.AuditRecord) error {
+						record = r
+						saved = true
+						return nil
+					}).AnyTimes()
+			})
+			recorder := httptest.NewRecorder()
+
+			data, err := json.Marshal(model.UserSegmentsInput{
+				UserID:           1,
+				SegmentsToAdd:    []model.Slug{"SEGMENT-3"},
+				SegmentsToDelete: []model.Slug{"SEGMENT-1"},
+				Reason:           "moved to new tariff",
+			})
+			require.NoError(t, err)
+
+			request, err := http.NewRequest(http.MethodPost, "/api/v1/segments/user/1", bytes.NewBuffer(data))
+			require.NoError(t, err)
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set(ActorHeader, "crm-service")
+			request.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+			handler.ServeHTTP(recorder, request)
+			require.Equal(t, tt.wantStatus, recorder.Code)
+
+			require.NotNil(t, record)
+			require.Equal(t, !tt.inTransaction, saved)
+			require.Equal(t, model.AuditUserSegmentsUpdate, record.Action)
+			require.Equal(t, "crm-service", record.Actor)
+			require.Equal(t, "203.0.113.7", record.ForwardedFor)
+			require.Equal(t, http.MethodPost, record.Method)
+			require.Equal(t, "/api/v1/segments/user/1", record.Path)
+			require.Equal(t, uint64(1), *record.UserID)
+			require.Equal(t, "SEGMENT-3,SEGMENT-1", record.Segments)
+			require.Equal(t, "moved to new tariff", record.Reason)
+			require.Equal(t, tt.wantStatus, record.StatusCode)
+			require.NotEmpty(t, record.RequestID)
+			require.Equal(t, record.RequestID, recorder.Header().Get(middleware.RequestIDHeader))
+		})
+	}
+}
+
+func TestHTTPHandlers_GetAuditRecords(t *testing.T) {
+	userID := uint64(1)
+	records := []model.AuditRecord{
+		{ID: 2, Action: model.AuditSegmentDelete, Actor: "admin", Segments: "SEGMENT-1"},
+		{ID: 1, Action: model.AuditUserSegmentsUpdate, Actor: "admin", UserID: &userID},
+	}
+
+	tests := []struct {
+		name          string
+		query         string
+		buildStubs    func(db *mock_database.MockIDatabase)
+		checkResponse func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:  "OK",
+			query: "?actor=admin&from=2023-08-01&before_id=3&limit=2",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetAuditRecords(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, filter model.AuditFilter) ([]model.AuditRecord, error) {
+						require.Equal(t, "admin", filter.Actor)
+						require.NotNil(t, filter.From)
+						require.Equal(t, uint64(3), filter.BeforeID)
+						require.Equal(t, 2, filter.Limit)
+						return records, nil
+					})
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+
+				var got []model.AuditRecord
+				require.NoError(t, json.NewDecoder(recorder.Body).Decode(&got))
+				require.Equal(t, records, got)
+			},
+		},
+		{
+			name:  "Invalid limit",
+			query: "?limit=5000",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetAuditRecords(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:  "Invalid date interval",
+			query: "?from=2023-08-31&to=2023-08-01",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetAuditRecords(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			handler := setupHandler(t, ctrl, tt.buildStubs)
+			recorder := httptest.NewRecorder()
+
+			request, err := http.NewRequest(http.MethodGet, "/api/v1/audit"+tt.query, nil)
+			require.NoError(t, err)
+
+			handler.ServeHTTP(recorder, request)
+			tt.checkResponse(t, recorder)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/services"
+)
+
+// APIKeyHeader is the header API clients pass their API key in.
+const APIKeyHeader = "X-API-Key"
+
+// authenticate checks request credentials and puts authenticated principal to the request context.
+func (h HTTPHandler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if !h.authService.Enabled() {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		principal, err := h.authService.Authenticate(
+			request.Context(),
+			request.Header.Get("Authorization"),
+			request.Header.Get(APIKeyHeader),
+		)
+		if err != nil {
+			h.processError(writer, request, err)
+			return
+		}
+
+		next.ServeHTTP(writer, request.WithContext(services.ContextWithPrincipal(request.Context(), principal)))
+	})
+}
+
+// requireScope returns middleware that allows only principals granted the scope.
+func (h HTTPHandler) requireScope(scope model.Scope) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if !h.authService.Enabled() {
+				next.ServeHTTP(writer, request)
+				return
+			}
+
+			principal, ok := services.PrincipalFromContext(request.Context())
+			if !ok {
+				h.processError(writer, request, services.ErrUnauthenticated)
+				return
+			}
+
+			if err := h.authService.Authorize(principal, scope); err != nil {
+				h.processError(writer, request, err)
+				return
+			}
+
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
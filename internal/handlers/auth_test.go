@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+const testJWTSecret = "test-secret"
+
+func signTestJWT(t *testing.T, secret, scope string, expiresAt time.Time, teams ...string) string {
+	return signTestJWTClaims(t, secret, jwt.MapClaims{
+		"sub":   "test-client",
+		"scope": scope,
+		"teams": teams,
+		"exp":   expiresAt.Unix(),
+	})
+}
+
+func signTestJWTClaims(t *testing.T, secret string, claims jwt.MapClaims) string {
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return signed
+}
+
+func hashTestAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestHTTPHandlers_Authentication(t *testing.T) {
+	apiKey := "valid-api-key"
+	apiKeyHash := sha256.Sum256([]byte(apiKey))
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		buildStubs   func(db *mock_database.MockIDatabase)
+		expectedCode int
+	}{
+		{
+			name:         "No credentials",
+			buildStubs:   func(db *mock_database.MockIDatabase) {},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name: "JWT with required scope",
+			headers: map[string]string{
+				"Authorization": "Bearer " + signTestJWT(t, testJWTSecret, "segments:write", time.Now().Add(time.Hour)),
+			},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegments(gomock.Any(), gomock.Any()).Return([]*model.Segment{{Slug: "SEGMENT-SLUG"}}, nil)
+				db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name: "JWT with admin scope",
+			headers: map[string]string{
+				"Authorization": "Bearer " + signTestJWT(t, testJWTSecret, "admin", time.Now().Add(time.Hour)),
+			},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name: "JWT without required scope",
+			headers: map[string]string{
+				"Authorization": "Bearer " + signTestJWT(t, testJWTSecret, "memberships:read history:read", time.Now().Add(time.Hour)),
+			},
+			buildStubs:   func(db *mock_database.MockIDatabase) {},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name: "Expired JWT",
+			headers: map[string]string{
+				"Authorization": "Bearer " + signTestJWT(t, testJWTSecret, "segments:write", time.Now().Add(-time.Hour)),
+			},
+			buildStubs:   func(db *mock_database.MockIDatabase) {},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name: "JWT without expiration",
+			headers: map[string]string{
+				"Authorization": "Bearer " + signTestJWTClaims(t, testJWTSecret, jwt.MapClaims{
+					"sub":   "test-client",
+					"scope": "segments:write",
+				}),
+			},
+			buildStubs:   func(db *mock_database.MockIDatabase) {},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name: "JWT with wrong signature",
+			headers: map[string]string{
+				"Authorization": "Bearer " + signTestJWT(t, "other-secret", "segments:write", time.Now().Add(time.Hour)),
+			},
+			buildStubs:   func(db *mock_database.MockIDatabase) {},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:    "Valid API key",
+			headers: map[string]string{APIKeyHeader: apiKey},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetAPIKeyByHash(gomock.Any(), hex.EncodeToString(apiKeyHash[:])).
+					Return(&model.APIKey{Name: "crm", Scopes: "memberships:write,segments:write"}, nil)
+				db.EXPECT().GetSegments(gomock.Any(), gomock.Any()).Return([]*model.Segment{{Slug: "SEGMENT-SLUG"}}, nil)
+				db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:    "Unknown API key",
+			headers: map[string]string{APIKeyHeader: "unknown"},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetAPIKeyByHash(gomock.Any(), gomock.Any()).Return(nil, database.ErrNotFound)
+			},
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			authCfg := config.NewAuthConfig()
+			authCfg.JWTSecret = testJWTSecret
+
+			handler := setupHandlerWithAuth(t, ctrl, tt.buildStubs, authCfg)
+			recorder := httptest.NewRecorder()
+
+			request, err := http.NewRequest(http.MethodDelete, "/api/v1/segment/SEGMENT-SLUG", nil)
+			require.NoError(t, err)
+			for key, value := range tt.headers {
+				request.Header.Set(key, value)
+			}
+
+			handler.ServeHTTP(recorder, request)
+			require.Equal(t, tt.expectedCode, recorder.Code)
+		})
+	}
+}
+
+func TestHTTPHandlers_SegmentACL(t *testing.T) {
+	legalSegment := model.Segment{
+		ID:   1,
+		Slug: "LEGAL_HOLD",
+		Grants: []model.SegmentGrant{
+			{Team: "legal", Permission: model.PermissionWrite},
+			{Team: model.AnyTeam, Permission: model.PermissionRead},
+		},
+	}
+	privateSegment := model.Segment{
+		ID:     2,
+		Slug:   "LEGAL_PRIVATE",
+		Grants: []model.SegmentGrant{{Team: "legal", Permission: model.PermissionWrite}},
+	}
+	openSegment := model.Segment{ID: 3, Slug: "OPEN"}
+
+	var user model.User
+	user.ID = 1
+	user.Segments = []model.Segment{legalSegment, privateSegment, openSegment}
+
+	scopes := "segments:write memberships:read memberships:write"
+
+	tests := []struct {
+		name          string
+		team          string
+		method        string
+		url           string
+		body          string
+		buildStubs    func(db *mock_database.MockIDatabase)
+		checkResponse func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:   "Owner team deletes segment",
+			team:   "legal",
+			method: http.MethodDelete,
+			url:    "/api/v1/segment/LEGAL_HOLD",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegments(gomock.Any(), gomock.Any()).Return([]*model.Segment{&legalSegment}, nil)
+				db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name:   "Read-only team can't delete segment",
+			team:   "growth",
+			method: http.MethodDelete,
+			url:    "/api/v1/segment/LEGAL_HOLD",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegments(gomock.Any(), gomock.Any()).Return([]*model.Segment{&legalSegment}, nil)
+				db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+		{
+			name:   "Read-only team can't add users to segment",
+			team:   "growth",
+			method: http.MethodPost,
+			url:    "/api/v1/segments/user/1",
+			body:   `{"segments_to_add": ["OPEN", "LEGAL_HOLD"]}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetSegments(gomock.Any(), []model.Slug{"OPEN", "LEGAL_HOLD"}).
+					Return([]*model.Segment{&openSegment, &legalSegment}, nil)
+				db.EXPECT().CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+		{
+			name:   "Segments without read grant are hidden",
+			team:   "growth",
+			method: http.MethodGet,
+			url:    "/api/v1/segments/user/1",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetUserWithActiveSegments(gomock.Any(), gomock.Any()).Return(&user, nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `["LEGAL_HOLD", "OPEN"]`, recorder.Body.String())
+			},
+		},
+		{
+			name:   "Snapshot hides segments without read grant",
+			team:   "growth",
+			method: http.MethodGet,
+			url:    "/api/v1/segments/snapshot",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(3), nil)
+				db.EXPECT().
+					ListSegments(gomock.Any(), model.SegmentsFilter{}).
+					Return([]*model.Segment{&legalSegment, &privateSegment, &openSegment}, nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `{"version": 3, "full": true, "segments": [
+					{"slug": "LEGAL_HOLD", "version": 0, "explicit": true},
+					{"slug": "OPEN", "version": 0, "explicit": true}
+				]}`, recorder.Body.String())
+			},
+		},
+		{
+			name:   "Snapshot delta deletes segments without read grant",
+			team:   "growth",
+			method: http.MethodGet,
+			url:    "/api/v1/segments/snapshot?since=1",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(3), nil)
+				db.EXPECT().
+					ListSegments(gomock.Any(), model.SegmentsFilter{SinceVersion: 1, WithDeleted: true}).
+					Return([]*model.Segment{&privateSegment}, nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `{"version": 3, "full": false, "segments": [
+					{"slug": "LEGAL_PRIVATE", "version": 0, "explicit": false, "deleted": true}
+				]}`, recorder.Body.String())
+			},
+		},
+		{
+			name:   "Read-only team can't add segment to group",
+			team:   "growth",
+			method: http.MethodPost,
+			url:    "/api/v1/segment",
+			body:   `{"slug": "PROMO", "group": "LEGAL"}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetExclusionGroup(gomock.Any(), &model.ExclusionGroup{Slug: "LEGAL"}).
+					Return(&model.ExclusionGroup{ID: 1, Slug: "LEGAL", Segments: []model.Segment{{ID: 1, Slug: "LEGAL_HOLD"}}}, nil)
+				db.EXPECT().GetSegments(gomock.Any(), []model.Slug{"LEGAL_HOLD"}).Return([]*model.Segment{&legalSegment}, nil)
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+		{
+			name:   "Read-only team can't add prerequisite to new segment",
+			team:   "growth",
+			method: http.MethodPost,
+			url:    "/api/v1/segment",
+			body:   `{"slug": "PROMO", "prerequisites": [{"slug": "LEGAL_HOLD"}]}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegments(gomock.Any(), []model.Slug{"LEGAL_HOLD"}).Return([]*model.Segment{&legalSegment}, nil)
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+		{
+			name:   "Read-only team can't set prerequisite",
+			team:   "growth",
+			method: http.MethodPut,
+			url:    "/api/v1/segment/OPEN/prerequisites",
+			body:   `{"prerequisites": [{"slug": "LEGAL_HOLD"}]}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegments(gomock.Any(), []model.Slug{"OPEN"}).Return([]*model.Segment{&openSegment}, nil)
+				db.EXPECT().GetSegments(gomock.Any(), []model.Slug{"LEGAL_HOLD"}).Return([]*model.Segment{&legalSegment}, nil)
+				db.EXPECT().SetSegmentPrerequisites(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+		{
+			name:   "Owner team sets prerequisite",
+			team:   "legal",
+			method: http.MethodPut,
+			url:    "/api/v1/segment/OPEN/prerequisites",
+			body:   `{"prerequisites": [{"slug": "LEGAL_HOLD"}]}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegments(gomock.Any(), []model.Slug{"OPEN"}).Return([]*model.Segment{&openSegment}, nil)
+				db.EXPECT().GetSegments(gomock.Any(), []model.Slug{"LEGAL_HOLD"}).Return([]*model.Segment{&legalSegment}, nil)
+				db.EXPECT().SetSegmentPrerequisites(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name:   "Read-only team can't change experiment weights",
+			team:   "growth",
+			method: http.MethodPut,
+			url:    "/api/v1/experiments/LEGAL/weights",
+			body:   `{"variants": [{"slug": "LEGAL_HOLD", "weight": 0.5}]}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetExperiment(gomock.Any(), &model.Experiment{Slug: "LEGAL"}).
+					Return(&model.Experiment{Slug: "LEGAL", Variants: []model.Segment{legalSegment, openSegment}}, nil)
+				db.EXPECT().UpdateExperimentWeights(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusForbidden, recorder.Code)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			authCfg := config.NewAuthConfig()
+			authCfg.JWTSecret = testJWTSecret
+
+			handler := setupHandlerWithAuth(t, ctrl, tt.buildStubs, authCfg)
+			recorder := httptest.NewRecorder()
+
+			request, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("Authorization", "Bearer "+signTestJWT(t, testJWTSecret, scopes, time.Now().Add(time.Hour), tt.team))
+
+			handler.ServeHTTP(recorder, request)
+			tt.checkResponse(t, recorder)
+		})
+	}
+}
+
+func TestHTTPHandlers_StreamEventsACL(t *testing.T) {
+	userID := uint64(1)
+	events := []model.Event{
+		{ID: 6, Type: model.EventAdd, UserID: &userID, SegmentSlug: "OPEN"},
+		{ID: 7, Type: model.EventAdd, UserID: &userID, SegmentSlug: "LEGAL_PRIVATE"},
+		{ID: 8, Type: model.EventDelete, UserID: &userID, SegmentSlug: "OPEN"},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authCfg := config.NewAuthConfig()
+	authCfg.JWTSecret = testJWTSecret
+
+	handler := setupHandlerWithAuth(t, ctrl, func(db *mock_database.MockIDatabase) {
+		first := db.EXPECT().GetEvents(gomock.Any(), gomock.Any()).Return(events, nil)
+		db.EXPECT().
+			GetEvents(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, filter model.EventsFilter) ([]model.Event, error) {
+				require.Equal(t, uint64(8), filter.AfterID)
+				return nil, nil
+			}).
+			After(first).
+			AnyTimes()
+		db.EXPECT().
+			ListSegments(gomock.Any(), model.SegmentsFilter{Slugs: []model.Slug{"OPEN", "LEGAL_PRIVATE"}, WithDeleted: true}).
+			Return([]*model.Segment{
+				{Slug: "LEGAL_PRIVATE", Grants: []model.SegmentGrant{{Team: "legal", Permission: model.PermissionWrite}}},
+				{Slug: "OPEN"},
+			}, nil)
+	}, authCfg)
+	recorder := httptest.NewRecorder()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/v1/events/stream", nil)
+	require.NoError(t, err)
+	request.Header.Set("Authorization", "Bearer "+signTestJWT(t, testJWTSecret, "events:read", time.Now().Add(time.Hour), "growth"))
+
+	handler.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	body := recorder.Body.String()
+	require.Contains(t, body, "id: 6\n")
+	require.NotContains(t, body, "id: 7\n")
+	require.Contains(t, body, "id: 8\n")
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+// userSegmentsETag formats strong ETag from user id, version of user's segments and hash of the returned slugs,
+// as the slugs visible to the caller depend on its grants.
+func userSegmentsETag(userID uint64, updatedAt time.Time, slugs model.Slugs) string {
+	hash := fnv.New64a()
+	for _, slug := range slugs {
+		hash.Write([]byte(slug))
+		hash.Write([]byte{0})
+	}
+	return fmt.Sprintf(`"%d-%x-%x"`, userID, updatedAt.UnixNano(), hash.Sum64())
+}
+
+// setValidators writes ETag and Last-Modified response headers. Shared caches may store the response
+// and revalidate it on every request, the response depends on the caller, so it varies by credentials.
+func setValidators(w http.ResponseWriter, etag string, lastModified time.Time) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "public, no-cache")
+	w.Header().Add("Vary", "Authorization, "+APIKeyHeader)
+}
+
+// isNotModified checks request's If-None-Match and If-Modified-Since headers against given validators.
+// If-Modified-Since is ignored when If-None-Match is present, see RFC 9110 13.1.3.
+func isNotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !lastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
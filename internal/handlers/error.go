@@ -3,5 +3,7 @@ package handlers
 import "errors"
 
 var (
-	ErrInvalidRequest = errors.New("invalid request")
+	ErrInvalidRequest       = errors.New("invalid request")
+	ErrStreamingUnsupported = errors.New("streaming unsupported")
+	ErrTooManyRequests      = errors.New("too many requests")
 )
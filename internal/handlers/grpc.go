@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/logging"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/pb"
+	"github.com/unbeman/av-prac-task/internal/ratelimit"
+	"github.com/unbeman/av-prac-task/internal/services"
+	"github.com/unbeman/av-prac-task/internal/utils"
+	"github.com/unbeman/av-prac-task/internal/worker"
+)
+
+// historyChunkSize is the size of csv report chunk sent by DownloadHistory.
+const historyChunkSize = 64 * 1024
+
+// grpcMethodScopes maps gRPC full method names to required scopes.
+var grpcMethodScopes = map[string]model.Scope{
+	pb.SegmentService_CreateSegment_FullMethodName:   model.ScopeSegmentsWrite,
+	pb.SegmentService_DeleteSegment_FullMethodName:   model.ScopeSegmentsWrite,
+	pb.UserService_UpdateUserSegments_FullMethodName: model.ScopeMembershipsWrite,
+	pb.UserService_GetUserSegments_FullMethodName:    model.ScopeMembershipsRead,
+	pb.UserService_RequestHistory_FullMethodName:     model.ScopeHistoryRead,
+	pb.UserService_DownloadHistory_FullMethodName:    model.ScopeHistoryRead,
+}
+
+// GRPCHandler implements gRPC segment and user services on top of the same services as HTTPHandler.
+type GRPCHandler struct {
+	pb.UnimplementedSegmentServiceServer
+	pb.UnimplementedUserServiceServer
+	userService        *services.UserService
+	segmentService     *services.SegmentService
+	authService        *services.AuthService
+	auditService       *services.AuditService
+	idempotencyService *services.IdempotencyService
+	limiter            ratelimit.ILimiter
+}
+
+// GetGRPCServer setups and returns gRPC server with registered segment and user services.
+// Calls are authenticated, rate limited, made idempotent and audited the same way as /api/v1 requests.
+func GetGRPCServer(
+	userService *services.UserService,
+	segmentService *services.SegmentService,
+	authService *services.AuthService,
+	auditService *services.AuditService,
+	idempotencyService *services.IdempotencyService,
+	limiter ratelimit.ILimiter,
+) (*grpc.Server, error) {
+	h := &GRPCHandler{
+		userService:        userService,
+		segmentService:     segmentService,
+		authService:        authService,
+		auditService:       auditService,
+		idempotencyService: idempotencyService,
+		limiter:            limiter,
+	}
+
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(h.authenticateUnary, h.rateLimitUnary, h.idempotentUnary, h.auditUnary),
+		grpc.ChainStreamInterceptor(h.authenticateStream, h.rateLimitStream),
+	)
+	pb.RegisterSegmentServiceServer(server, h)
+	pb.RegisterUserServiceServer(server, h)
+
+	return server, nil
+}
+
+// CreateSegment creates new segment.
+func (h *GRPCHandler) CreateSegment(ctx context.Context, request *pb.CreateSegmentRequest) (*pb.CreateSegmentResponse, error) {
+	input := &model.CreateSegmentInput{Slug: model.Slug(request.GetSlug()), Selection: request.Selection}
+	if err := input.Validate(); err != nil {
+		return nil, h.processError(ctx, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+	}
+	auditTarget(ctx, nil, input.Slug)
+
+	output, err := h.segmentService.CreateSegment(ctx, input)
+	if err != nil {
+		return nil, h.processError(ctx, err)
+	}
+
+	return &pb.CreateSegmentResponse{Slug: string(output.Slug), AssignedUsers: output.AssignedUsers}, nil
+}
+
+// DeleteSegment soft deletes segment.
+func (h *GRPCHandler) DeleteSegment(ctx context.Context, request *pb.DeleteSegmentRequest) (*pb.DeleteSegmentResponse, error) {
+	input := &model.SegmentInput{Slug: model.Slug(request.GetSlug())}
+	if err := input.Slug.Validate(); err != nil {
+		return nil, h.processError(ctx, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+	}
+	auditTarget(ctx, nil, input.Slug)
+
+	if err := h.segmentService.DeleteSegment(ctx, input); err != nil {
+		return nil, h.processError(ctx, err)
+	}
+
+	return &pb.DeleteSegmentResponse{}, nil
+}
+
+// UpdateUserSegments adds and deletes user's segments.
+func (h *GRPCHandler) UpdateUserSegments(ctx context.Context, request *pb.UpdateUserSegmentsRequest) (*pb.UpdateUserSegmentsResponse, error) {
+	input := &model.UserSegmentsInput{
+		UserID:           request.GetUserId(),
+		SegmentsToAdd:    toSlugs(request.GetSegmentsToAdd()),
+		SegmentsToDelete: toSlugs(request.GetSegmentsToDelete()),
+	}
+	if err := input.Validate(); err != nil {
+		return nil, h.processError(ctx, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+	}
+	auditTarget(ctx, &input.UserID, input.SegmentsToAdd...)
+	auditTarget(ctx, nil, input.SegmentsToDelete...)
+
+	if err := h.userService.UpdateUserSegments(ctx, input); err != nil {
+		return nil, h.processError(ctx, err)
+	}
+
+	return &pb.UpdateUserSegmentsResponse{}, nil
+}
+
+// GetUserSegments returns user's active segments, segments with rules on user attributes are left to the client.
+func (h *GRPCHandler) GetUserSegments(ctx context.Context, request *pb.GetUserSegmentsRequest) (*pb.GetUserSegmentsResponse, error) {
+	input := &model.UserInput{UserID: request.GetUserId()}
+
+	slugs, _, err := h.userService.GetUserActiveSegments(ctx, input)
+	if err != nil {
+		return nil, h.processError(ctx, err)
+	}
+
+	response := &pb.GetUserSegmentsResponse{Slugs: make([]string, 0, len(slugs))}
+	for _, slug := range slugs {
+		response.Slugs = append(response.Slugs, string(slug))
+	}
+	return response, nil
+}
+
+// RequestHistory starts user's segments history report generation.
+func (h *GRPCHandler) RequestHistory(ctx context.Context, request *pb.RequestHistoryRequest) (*pb.RequestHistoryResponse, error) {
+	input := &model.UserSegmentsHistoryInput{UserID: request.GetUserId()}
+	if err := input.ParseDates(request.GetFrom(), request.GetTo()); err != nil {
+		return nil, h.processError(ctx, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+	}
+
+	filename, err := h.userService.GenerateUserSegmentsHistoryFile(ctx, input)
+	if err != nil {
+		return nil, h.processError(ctx, err)
+	}
+
+	return &pb.RequestHistoryResponse{Filename: filename}, nil
+}
+
+// DownloadHistory streams generated csv report.
+func (h *GRPCHandler) DownloadHistory(request *pb.DownloadHistoryRequest, stream pb.UserService_DownloadHistoryServer) error {
+	ctx := stream.Context()
+	filePath, err := h.userService.DownloadUserSegmentsHistory(request.GetFilename())
+	if err != nil {
+		return h.processError(ctx, err)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return h.processError(ctx, err)
+	}
+	defer file.Close()
+
+	buf := make([]byte, historyChunkSize)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if sendErr := stream.Send(&pb.DownloadHistoryChunk{Data: buf[:n]}); sendErr != nil {
+				return sendErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return h.processError(ctx, err)
+		}
+	}
+}
+
+// authenticateUnary checks unary call credentials and method scope.
+func (h *GRPCHandler) authenticateUnary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	ctx, err := h.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authenticateStream checks stream call credentials and method scope.
+func (h *GRPCHandler) authenticateStream(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	ctx, err := h.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticate checks credentials from "authorization" or "x-api-key" metadata,
+// returns context carrying authenticated principal.
+func (h *GRPCHandler) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	if !h.authService.Enabled() {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	principal, err := h.authService.Authenticate(ctx, firstValue(md, "authorization"), firstValue(md, "x-api-key"))
+	if err != nil {
+		return nil, h.processError(ctx, err)
+	}
+
+	scope, ok := grpcMethodScopes[fullMethod]
+	if !ok {
+		scope = model.ScopeAdmin
+	}
+	if err = h.authService.Authorize(principal, scope); err != nil {
+		return nil, h.processError(ctx, err)
+	}
+
+	return services.ContextWithPrincipal(ctx, principal), nil
+}
+
+// processError converts app errors to gRPC status errors, mirrors HTTPHandler.processError.
+// Internal errors are logged and their text is not returned to the client.
+func (h *GRPCHandler) processError(ctx context.Context, err error) error {
+	var code codes.Code
+	switch {
+	case errors.Is(err, ErrInvalidRequest):
+		code = codes.InvalidArgument
+	case errors.Is(err, database.ErrAlreadyExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, database.ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, database.ErrDynamicSegment), errors.Is(err, database.ErrGroupConflict):
+		code = codes.FailedPrecondition
+	case errors.Is(err, database.ErrPrerequisite), errors.Is(err, database.ErrPrerequisiteCycle):
+		code = codes.FailedPrecondition
+	case errors.Is(err, utils.ErrFileNotFound):
+		code = codes.NotFound
+	case errors.Is(err, services.ErrUnauthenticated):
+		code = codes.Unauthenticated
+	case errors.Is(err, services.ErrForbidden):
+		code = codes.PermissionDenied
+	case errors.Is(err, worker.ErrPoolClosed):
+		code = codes.Unavailable
+	case errors.Is(err, services.ErrIdempotencyKeyInProgress):
+		code = codes.Aborted
+	case errors.Is(err, services.ErrIdempotencyKeyReused):
+		code = codes.FailedPrecondition
+	case errors.Is(err, ErrTooManyRequests):
+		code = codes.ResourceExhausted
+	default:
+		logging.FromContext(ctx).WithError(err).Error("grpc call failed")
+		return status.Error(codes.Internal, "internal error")
+	}
+	return status.Error(code, err.Error())
+}
+
+func toSlugs(values []string) []model.Slug {
+	slugs := make([]model.Slug, 0, len(values))
+	for _, value := range values {
+		slugs = append(slugs, model.Slug(value))
+	}
+	return slugs
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/logging"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/pb"
+	"github.com/unbeman/av-prac-task/internal/ratelimit"
+	"github.com/unbeman/av-prac-task/internal/services"
+)
+
+// gRPC metadata keys, same as HTTP headers of /api/v1 in lower case.
+const (
+	grpcIdempotencyKeyMD = "idempotency-key"
+	grpcActorMD          = "x-actor"
+	grpcReasonMD         = "x-audit-reason"
+	grpcRequestIDMD      = "x-request-id"
+	grpcForwardedForMD   = "x-forwarded-for"
+)
+
+// grpcAuditMethod is AuditRecord.Method of gRPC calls, their Path is the full method name
+// and StatusCode is the gRPC status code.
+const grpcAuditMethod = "GRPC"
+
+// grpcProtoContentType marks saved idempotent responses of gRPC calls.
+const grpcProtoContentType = "application/grpc+proto"
+
+// grpcRateLimitGroups maps gRPC full method names to rate limit groups.
+var grpcRateLimitGroups = map[string]string{
+	pb.SegmentService_CreateSegment_FullMethodName:   ratelimit.GroupWrite,
+	pb.SegmentService_DeleteSegment_FullMethodName:   ratelimit.GroupWrite,
+	pb.UserService_UpdateUserSegments_FullMethodName: ratelimit.GroupWrite,
+	pb.UserService_GetUserSegments_FullMethodName:    ratelimit.GroupRead,
+	pb.UserService_RequestHistory_FullMethodName:     ratelimit.GroupReports,
+	pb.UserService_DownloadHistory_FullMethodName:    ratelimit.GroupRead,
+}
+
+// grpcAuditActions maps mutating gRPC full method names to audit actions.
+var grpcAuditActions = map[string]string{
+	pb.SegmentService_CreateSegment_FullMethodName:   model.AuditSegmentCreate,
+	pb.SegmentService_DeleteSegment_FullMethodName:   model.AuditSegmentDelete,
+	pb.UserService_UpdateUserSegments_FullMethodName: model.AuditUserSegmentsUpdate,
+}
+
+// grpcIdempotentResponses returns empty responses of mutating gRPC methods to replay saved ones into.
+var grpcIdempotentResponses = map[string]func() proto.Message{
+	pb.SegmentService_CreateSegment_FullMethodName:   func() proto.Message { return &pb.CreateSegmentResponse{} },
+	pb.SegmentService_DeleteSegment_FullMethodName:   func() proto.Message { return &pb.DeleteSegmentResponse{} },
+	pb.UserService_UpdateUserSegments_FullMethodName: func() proto.Message { return &pb.UpdateUserSegmentsResponse{} },
+}
+
+// rateLimitUnary limits unary calls of the method group per API client, like HTTPHandler.rateLimited.
+func (h *GRPCHandler) rateLimitUnary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if err := h.allow(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// rateLimitStream limits stream calls of the method group per API client.
+func (h *GRPCHandler) rateLimitStream(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if err := h.allow(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+func (h *GRPCHandler) allow(ctx context.Context, fullMethod string) error {
+	group, ok := grpcRateLimitGroups[fullMethod]
+	if !ok {
+		group = ratelimit.GroupWrite
+	}
+
+	result := h.limiter.Allow(group, grpcRateLimitKey(ctx))
+	if result.Limit == 0 || result.Allowed {
+		return nil
+	}
+
+	grpc.SetHeader(ctx, metadata.Pairs(
+		RetryAfterHeader, ceilSeconds(result.RetryAfter),
+	))
+	return h.processError(ctx, ErrTooManyRequests)
+}
+
+func grpcRateLimitKey(ctx context.Context) string {
+	if principal, ok := services.PrincipalFromContext(ctx); ok {
+		return "client:" + principal.ClientID
+	}
+	return "ip:" + peerIP(ctx)
+}
+
+// idempotentUnary executes mutating call with "idempotency-key" metadata only once
+// and replays its result to retries, like HTTPHandler.idempotent.
+// Calls failed with server error are not saved, their key is released to retry.
+func (h *GRPCHandler) idempotentUnary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	key := firstValue(md, grpcIdempotencyKeyMD)
+	newResponse, ok := grpcIdempotentResponses[info.FullMethod]
+	if key == "" || !ok {
+		return handler(ctx, req)
+	}
+
+	if err := model.ValidateIdempotencyKey(key); err != nil {
+		return nil, h.processError(ctx, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+	}
+
+	fingerprint, err := grpcFingerprint(info.FullMethod, req)
+	if err != nil {
+		return nil, h.processError(ctx, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+	}
+
+	record, replay, err := h.idempotencyService.Begin(ctx, "grpc:"+grpcIdempotencyScope(ctx)+key, fingerprint)
+	if err != nil {
+		return nil, h.processError(ctx, err)
+	}
+
+	if replay {
+		grpc.SetHeader(ctx, metadata.Pairs(IdempotentReplayedHeader, "true"))
+		if record.ContentType != grpcProtoContentType {
+			return nil, status.Error(codes.Code(record.StatusCode), string(record.Body))
+		}
+		response := newResponse()
+		if err = proto.Unmarshal(record.Body, response); err != nil {
+			return nil, h.processError(ctx, err)
+		}
+		return response, nil
+	}
+
+	defer func() {
+		if rvr := recover(); rvr != nil {
+			if err := h.idempotencyService.Release(context.Background(), record); err != nil {
+				logging.FromContext(ctx).Errorf("idempotency: can't release key %s: %v", key, err)
+			}
+			panic(rvr)
+		}
+	}()
+
+	response, callErr := handler(ctx, req)
+
+	// the record is saved even if the client has gone, it is going to retry
+	saveCtx := context.Background()
+	code := status.Code(callErr)
+	switch {
+	case isServerCode(code):
+		err = h.idempotencyService.Release(saveCtx, record)
+	case callErr != nil:
+		err = h.idempotencyService.Complete(saveCtx, record, int(code), "", []byte(status.Convert(callErr).Message()))
+	default:
+		var body []byte
+		if body, err = proto.Marshal(response.(proto.Message)); err == nil {
+			err = h.idempotencyService.Complete(saveCtx, record, int(code), grpcProtoContentType, body)
+		}
+	}
+	if err != nil {
+		logging.FromContext(ctx).Errorf("idempotency: can't save response for key %s: %v", key, err)
+	}
+
+	return response, callErr
+}
+
+// grpcIdempotencyScope separates keys of authenticated clients.
+func grpcIdempotencyScope(ctx context.Context) string {
+	if principal, ok := services.PrincipalFromContext(ctx); ok {
+		return principal.ClientID + ":"
+	}
+	return ""
+}
+
+// grpcFingerprint returns hash of the full method name and request message.
+func grpcFingerprint(fullMethod string, req interface{}) (string, error) {
+	message, ok := req.(proto.Message)
+	if !ok {
+		return "", fmt.Errorf("unexpected request type %T", req)
+	}
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(fullMethod + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// isServerCode reports whether the call failed on server side and can be retried.
+func isServerCode(code codes.Code) bool {
+	switch code {
+	case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss,
+		codes.DeadlineExceeded, codes.Canceled, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// auditUnary records mutating call to the audit log, like HTTPHandler.audited.
+// Handlers add call targets with auditTarget, changes of the call write its record in their transaction.
+func (h *GRPCHandler) auditUnary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	action, ok := grpcAuditActions[info.FullMethod]
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	record := &model.AuditRecord{
+		Action:       action,
+		Actor:        grpcAuditActor(ctx, md),
+		RequestID:    firstValue(md, grpcRequestIDMD),
+		SourceIP:     peerIP(ctx),
+		ForwardedFor: firstValue(md, grpcForwardedForMD),
+		Method:       grpcAuditMethod,
+		Path:         info.FullMethod,
+		Reason:       firstValue(md, grpcReasonMD),
+		StatusCode:   int(codes.OK),
+	}
+
+	response, err := handler(database.ContextWithAudit(ctx, record), req)
+
+	if record.ID > 0 {
+		return response, err
+	}
+	record.StatusCode = int(status.Code(err))
+
+	// the record is saved even if the client has gone
+	if err := h.auditService.Record(context.Background(), record); err != nil {
+		logging.FromContext(ctx).Errorf("audit: can't save record of %s: %v", action, err)
+	}
+
+	return response, err
+}
+
+// grpcAuditActor returns authenticated principal subject or "x-actor" metadata value.
+func grpcAuditActor(ctx context.Context, md metadata.MD) string {
+	if principal, ok := services.PrincipalFromContext(ctx); ok {
+		return principal.Subject
+	}
+	if actor := firstValue(md, grpcActorMD); actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// authenticatedStream is a server stream carrying context with authenticated principal.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the stream context with authenticated principal.
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
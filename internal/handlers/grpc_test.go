@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/pb"
+	"github.com/unbeman/av-prac-task/internal/ratelimit"
+	"github.com/unbeman/av-prac-task/internal/services"
+	"github.com/unbeman/av-prac-task/internal/worker"
+)
+
+func setupGRPCClient(t *testing.T, ctrl *gomock.Controller, setupDB func(db *mock_database.MockIDatabase)) *grpc.ClientConn {
+	rateLimitCfg := config.NewRateLimitConfig()
+	rateLimitCfg.Enabled = false
+	return setupGRPCClientWithLimits(t, ctrl, setupDB, rateLimitCfg)
+}
+
+func setupGRPCClientWithLimits(
+	t *testing.T,
+	ctrl *gomock.Controller,
+	setupDB func(db *mock_database.MockIDatabase),
+	rateLimitCfg config.RateLimitConfig,
+) *grpc.ClientConn {
+	database := mock_database.NewMockIDatabase(ctrl)
+	setupDB(database)
+	database.EXPECT().CreateAuditRecord(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	database.EXPECT().ListSegments(gomock.Any(), dynamicSegmentsFilter).Return(nil, nil).AnyTimes()
+	database.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(0), nil).AnyTimes()
+
+	wp := worker.NewWorkersPool(config.NewWorkerPoolConfig())
+
+	segmentServ, err := services.NewSegmentService(database)
+	require.NoError(t, err)
+
+	userServ, err := services.NewUserService(database, wp, t.TempDir())
+	require.NoError(t, err)
+
+	authCfg := config.NewAuthConfig()
+	authCfg.Enabled = false
+	authServ, err := services.NewAuthService(database, authCfg)
+	require.NoError(t, err)
+
+	auditServ, err := services.NewAuditService(database)
+	require.NoError(t, err)
+
+	idempotencyServ, err := services.NewIdempotencyService(database, config.NewIdempotencyConfig())
+	require.NoError(t, err)
+
+	server, err := GetGRPCServer(userServ, segmentServ, authServ, auditServ, idempotencyServ, ratelimit.NewMemoryLimiter(rateLimitCfg))
+	require.NoError(t, err)
+
+	listener := bufconn.Listen(1024 * 1024)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestGRPCHandler_CreateSegment(t *testing.T) {
+	segment := model.Segment{Slug: "SEGMENT-SLUG"}
+	selection := 0.5
+
+	tests := []struct {
+		name         string
+		request      *pb.CreateSegmentRequest
+		buildStubs   func(db *mock_database.MockIDatabase)
+		expectedCode codes.Code
+	}{
+		{
+			name:    "OK",
+			request: &pb.CreateSegmentRequest{Slug: "segment-slug", Selection: &selection},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().CreateSegmentForRandomUsers(gomock.Any(), gomock.Any(), selection).Return(&segment, int64(5), nil)
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name:    "Segment already exist",
+			request: &pb.CreateSegmentRequest{Slug: "segment-slug"},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(nil, database.ErrAlreadyExists)
+			},
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			name:    "Internal Error",
+			request: &pb.CreateSegmentRequest{Slug: "segment-slug"},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(nil, database.ErrDB)
+			},
+			expectedCode: codes.Internal,
+		},
+		{
+			name:    "Empty slug",
+			request: &pb.CreateSegmentRequest{Slug: ""},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Times(0)
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			client := pb.NewSegmentServiceClient(setupGRPCClient(t, ctrl, tt.buildStubs))
+
+			response, err := client.CreateSegment(context.Background(), tt.request)
+			require.Equal(t, tt.expectedCode, status.Code(err))
+			if tt.expectedCode == codes.Internal {
+				require.Equal(t, "internal error", status.Convert(err).Message())
+			}
+			if tt.expectedCode == codes.OK {
+				require.Equal(t, string(segment.Slug), response.GetSlug())
+				require.Equal(t, int64(5), response.GetAssignedUsers())
+			}
+		})
+	}
+}
+
+func TestGRPCHandler_GetUserSegments(t *testing.T) {
+	var user model.User
+	user.ID = 1
+	user.Segments = []model.Segment{{Slug: "SEGMENT-A"}, {Slug: "SEGMENT-B"}}
+
+	tests := []struct {
+		name         string
+		buildStubs   func(db *mock_database.MockIDatabase)
+		expectedCode codes.Code
+	}{
+		{
+			name: "OK",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetUserWithActiveSegments(gomock.Any(), gomock.Any()).Return(&user, nil)
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name: "User not found",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetUserWithActiveSegments(gomock.Any(), gomock.Any()).Return(nil, database.ErrNotFound)
+			},
+			expectedCode: codes.NotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			client := pb.NewUserServiceClient(setupGRPCClient(t, ctrl, tt.buildStubs))
+
+			response, err := client.GetUserSegments(context.Background(), &pb.GetUserSegmentsRequest{UserId: user.ID})
+			require.Equal(t, tt.expectedCode, status.Code(err))
+			if tt.expectedCode == codes.OK {
+				require.Equal(t, []string{"SEGMENT-A", "SEGMENT-B"}, response.GetSlugs())
+			}
+		})
+	}
+}
+
+func TestGRPCHandler_RateLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	rateLimitCfg := config.NewRateLimitConfig()
+	rateLimitCfg.Enabled = true
+	rateLimitCfg.Write = config.RateLimit{Rate: 0.1, Burst: 1}
+
+	conn := setupGRPCClientWithLimits(t, ctrl, func(db *mock_database.MockIDatabase) {
+		db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	}, rateLimitCfg)
+	client := pb.NewSegmentServiceClient(conn)
+
+	_, err := client.DeleteSegment(context.Background(), &pb.DeleteSegmentRequest{Slug: "SEGMENT-SLUG"})
+	require.NoError(t, err)
+
+	var header metadata.MD
+	_, err = client.DeleteSegment(context.Background(), &pb.DeleteSegmentRequest{Slug: "SEGMENT-SLUG"}, grpc.Header(&header))
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Equal(t, []string{"10"}, header.Get(RetryAfterHeader))
+}
+
+func TestGRPCHandler_Idempotency(t *testing.T) {
+	segment := model.Segment{Slug: "SEGMENT-SLUG"}
+
+	createSegment := func(client pb.SegmentServiceClient, key, slug string) (*pb.CreateSegmentResponse, metadata.MD, error) {
+		var header metadata.MD
+		ctx := metadata.AppendToOutgoingContext(context.Background(), grpcIdempotencyKeyMD, key)
+		response, err := client.CreateSegment(ctx, &pb.CreateSegmentRequest{Slug: slug}, grpc.Header(&header))
+		return response, header, err
+	}
+
+	t.Run("Retry is replayed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		client := pb.NewSegmentServiceClient(setupGRPCClient(t, ctrl, func(db *mock_database.MockIDatabase) {
+			stubIdempotencyRecords(db, map[string]*model.IdempotencyRecord{})
+			db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(&segment, nil).Times(1)
+		}))
+
+		first, header, err := createSegment(client, "key-1", "segment-slug")
+		require.NoError(t, err)
+		require.Empty(t, header.Get(IdempotentReplayedHeader))
+
+		retry, header, err := createSegment(client, "key-1", "segment-slug")
+		require.NoError(t, err)
+		require.Equal(t, []string{"true"}, header.Get(IdempotentReplayedHeader))
+		require.Equal(t, first.GetSlug(), retry.GetSlug())
+	})
+
+	t.Run("Client error is replayed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		client := pb.NewSegmentServiceClient(setupGRPCClient(t, ctrl, func(db *mock_database.MockIDatabase) {
+			stubIdempotencyRecords(db, map[string]*model.IdempotencyRecord{})
+			db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(nil, database.ErrAlreadyExists).Times(1)
+		}))
+
+		_, _, err := createSegment(client, "key-1", "segment-slug")
+		require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+		_, header, retryErr := createSegment(client, "key-1", "segment-slug")
+		require.Equal(t, codes.AlreadyExists, status.Code(retryErr))
+		require.Equal(t, status.Convert(err).Message(), status.Convert(retryErr).Message())
+		require.Equal(t, []string{"true"}, header.Get(IdempotentReplayedHeader))
+	})
+
+	t.Run("Internal error is not saved", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		client := pb.NewSegmentServiceClient(setupGRPCClient(t, ctrl, func(db *mock_database.MockIDatabase) {
+			stubIdempotencyRecords(db, map[string]*model.IdempotencyRecord{})
+			gomock.InOrder(
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(nil, database.ErrDB),
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(&segment, nil),
+			)
+		}))
+
+		_, _, err := createSegment(client, "key-1", "segment-slug")
+		require.Equal(t, codes.Internal, status.Code(err))
+
+		_, _, err = createSegment(client, "key-1", "segment-slug")
+		require.NoError(t, err)
+	})
+
+	t.Run("Reused key with other request", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		client := pb.NewSegmentServiceClient(setupGRPCClient(t, ctrl, func(db *mock_database.MockIDatabase) {
+			stubIdempotencyRecords(db, map[string]*model.IdempotencyRecord{})
+			db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(&segment, nil).Times(1)
+		}))
+
+		_, _, err := createSegment(client, "key-1", "segment-slug")
+		require.NoError(t, err)
+
+		_, _, err = createSegment(client, "key-1", "other")
+		require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+}
+
+func TestGRPCHandler_Audit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var record *model.AuditRecord
+	client := pb.NewUserServiceClient(setupGRPCClient(t, ctrl, func(db *mock_database.MockIDatabase) {
+		db.EXPECT().CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+		db.EXPECT().CreateAuditRecord(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, r *model.AuditRecord) error {
+				record = r
+				return nil
+			})
+	}))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), grpcActorMD, "crm", grpcReasonMD, "support ticket 123")
+	_, err := client.UpdateUserSegments(ctx, &pb.UpdateUserSegmentsRequest{
+		UserId:           1,
+		SegmentsToAdd:    []string{"SEGMENT-A"},
+		SegmentsToDelete: []string{"SEGMENT-B"},
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, record)
+	require.Equal(t, model.AuditUserSegmentsUpdate, record.Action)
+	require.Equal(t, "crm", record.Actor)
+	require.Equal(t, grpcAuditMethod, record.Method)
+	require.Equal(t, pb.UserService_UpdateUserSegments_FullMethodName, record.Path)
+	require.Equal(t, uint64(1), *record.UserID)
+	require.Equal(t, "SEGMENT-A,SEGMENT-B", record.Segments)
+	require.Equal(t, "support ticket 123", record.Reason)
+	require.Equal(t, int(codes.OK), record.StatusCode)
+}
+
+// testServerStream is a server stream with given context.
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestGRPCHandler_AuthenticateStream(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	apiKey := "valid-api-key"
+	db := mock_database.NewMockIDatabase(ctrl)
+	db.EXPECT().
+		GetAPIKeyByHash(gomock.Any(), hashTestAPIKey(apiKey)).
+		Return(&model.APIKey{ID: 7, Name: "reports", Scopes: string(model.ScopeHistoryRead)}, nil)
+
+	authServ, err := services.NewAuthService(db, config.NewAuthConfig())
+	require.NoError(t, err)
+	h := &GRPCHandler{authService: authServ}
+
+	stream := testServerStream{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", apiKey))}
+	info := &grpc.StreamServerInfo{FullMethod: pb.UserService_DownloadHistory_FullMethodName}
+
+	var principal *model.Principal
+	err = h.authenticateStream(nil, stream, info, func(_ interface{}, stream grpc.ServerStream) error {
+		principal, _ = services.PrincipalFromContext(stream.Context())
+		return nil
+	})
+	require.NoError(t, err)
+	require.NotNil(t, principal)
+	require.Equal(t, "key:7", principal.ClientID)
+}
@@ -2,50 +2,180 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 
-	logger "github.com/chi-middleware/logrus-logger"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
-	log "github.com/sirupsen/logrus"
 	httpSwagger "github.com/swaggo/http-swagger/v2"
 
 	_ "github.com/unbeman/av-prac-task/docs"
+	"github.com/unbeman/av-prac-task/internal/cache"
 	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/logging"
+	"github.com/unbeman/av-prac-task/internal/metrics"
 	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/ratelimit"
 	"github.com/unbeman/av-prac-task/internal/services"
+	"github.com/unbeman/av-prac-task/internal/tracing"
 	"github.com/unbeman/av-prac-task/internal/utils"
+	"github.com/unbeman/av-prac-task/internal/worker"
+	"github.com/unbeman/av-prac-task/pkg/evaluation"
 )
 
 type HTTPHandler struct {
 	*chi.Mux
-	userService    *services.UserService
-	segmentService *services.SegmentService
+	userService        *services.UserService
+	segmentService     *services.SegmentService
+	eventService       *services.EventService
+	webhookService     *services.WebhookService
+	authService        *services.AuthService
+	auditService       *services.AuditService
+	idempotencyService *services.IdempotencyService
+	healthService      *services.HealthService
+	cache              cache.ICache
+	limiter            ratelimit.ILimiter
 }
 
 // GetHandler setups and returns HTTPHandler.
-func GetHandler(userService *services.UserService, segmentService *services.SegmentService) (*HTTPHandler, error) {
+func GetHandler(
+	userService *services.UserService,
+	segmentService *services.SegmentService,
+	eventService *services.EventService,
+	webhookService *services.WebhookService,
+	authService *services.AuthService,
+	auditService *services.AuditService,
+	idempotencyService *services.IdempotencyService,
+	healthService *services.HealthService,
+	cache cache.ICache,
+	limiter ratelimit.ILimiter,
+) (*HTTPHandler, error) {
 	h := &HTTPHandler{
-		Mux:            chi.NewMux(),
-		userService:    userService,
-		segmentService: segmentService,
+		Mux:                chi.NewMux(),
+		userService:        userService,
+		segmentService:     segmentService,
+		eventService:       eventService,
+		webhookService:     webhookService,
+		authService:        authService,
+		auditService:       auditService,
+		idempotencyService: idempotencyService,
+		healthService:      healthService,
+		cache:              cache,
+		limiter:            limiter,
 	}
 
-	h.Use(logger.Logger("router", log.New()))
+	h.Use(middleware.RequestID)
+	h.Use(requestIDHeader)
+	h.Use(tracing.HTTPMiddleware)
+	h.Use(logging.HTTPMiddleware)
+	h.Use(metrics.HTTPMiddleware)
 	h.Get("/swagger/*", httpSwagger.Handler())
+	h.Handle("/metrics", metrics.Handler())
+	h.Get("/healthz", h.Liveness)
+	h.Get("/readyz", h.Readiness)
 	h.Route("/api/v1/", func(router chi.Router) {
+		router.Use(h.authenticate)
+
 		router.Route("/segments/user", func(r chi.Router) {
-			r.Get("/{user_id}", h.GetActiveUserSegments)
-			r.Get("/{user_id}/csv", h.GenerateUserSegmentsHistory)
-			r.Post("/{user_id}", h.UpdateUserSegments)
-			r.Get("/history/{filename}", h.GetUserSegmentsHistoryFile)
+			r.With(
+				h.requireScope(model.ScopeMembershipsRead),
+				h.rateLimited(ratelimit.GroupRead),
+			).Get("/{user_id}", h.GetActiveUserSegments)
+			r.With(
+				h.requireScope(model.ScopeHistoryRead),
+				h.rateLimited(ratelimit.GroupReports),
+			).Get("/{user_id}/csv", h.GenerateUserSegmentsHistory)
+			r.With(
+				h.requireScope(model.ScopeMembershipsWrite),
+				h.rateLimited(ratelimit.GroupWrite),
+				h.idempotent,
+				h.audited(model.AuditUserSegmentsUpdate),
+			).Post("/{user_id}", h.UpdateUserSegments)
+			r.With(
+				h.requireScope(model.ScopeHistoryRead),
+				h.rateLimited(ratelimit.GroupRead),
+			).Get("/history/{filename}", h.GetUserSegmentsHistoryFile)
 		})
 
+		router.With(
+			h.requireScope(model.ScopeMembershipsRead),
+			h.rateLimited(ratelimit.GroupRead),
+		).Get("/segments/snapshot", h.GetSegmentsSnapshot)
+
 		router.Route("/segment", func(r chi.Router) {
-			r.Post("/", h.CreateSegment)
-			r.Delete("/{slug}", h.DeleteSegment)
+			r.With(h.rateLimited(ratelimit.GroupRead)).Get("/{slug}/acl", h.GetSegmentGrants)
+			r.With(h.rateLimited(ratelimit.GroupRead)).Get("/{slug}/prerequisites", h.GetSegmentPrerequisites)
+
+			r.Group(func(r chi.Router) {
+				r.Use(h.requireScope(model.ScopeSegmentsWrite))
+				r.Use(h.rateLimited(ratelimit.GroupWrite))
+				r.Use(h.idempotent)
+				r.With(h.audited(model.AuditSegmentCreate)).Post("/", h.CreateSegment)
+				r.With(h.audited(model.AuditSegmentDelete)).Delete("/{slug}", h.DeleteSegment)
+				r.With(h.audited(model.AuditSegmentACLUpdate)).Put("/{slug}/acl", h.SetSegmentGrants)
+				r.With(h.audited(model.AuditSegmentPrerequisitesUpdate)).Put("/{slug}/prerequisites", h.SetSegmentPrerequisites)
+			})
+		})
+
+		router.Route("/experiments", func(r chi.Router) {
+			r.With(h.rateLimited(ratelimit.GroupRead)).Get("/{slug}", h.GetExperiment)
+			r.With(
+				h.requireScope(model.ScopeMembershipsRead),
+				h.rateLimited(ratelimit.GroupRead),
+			).Get("/user/{user_id}", h.GetUserVariants)
+
+			r.Group(func(r chi.Router) {
+				r.Use(h.requireScope(model.ScopeSegmentsWrite))
+				r.Use(h.rateLimited(ratelimit.GroupWrite))
+				r.Use(h.idempotent)
+				r.With(h.audited(model.AuditExperimentCreate)).Post("/", h.CreateExperiment)
+				r.With(h.audited(model.AuditExperimentUpdate)).Put("/{slug}/weights", h.UpdateExperimentWeights)
+			})
+		})
+
+		router.Route("/groups", func(r chi.Router) {
+			r.With(h.rateLimited(ratelimit.GroupRead)).Get("/{slug}", h.GetExclusionGroup)
+
+			r.Group(func(r chi.Router) {
+				r.Use(h.requireScope(model.ScopeSegmentsWrite))
+				r.Use(h.rateLimited(ratelimit.GroupWrite))
+				r.Use(h.idempotent)
+				r.With(h.audited(model.AuditGroupCreate)).Post("/", h.CreateExclusionGroup)
+				r.With(h.audited(model.AuditGroupDelete)).Delete("/{slug}", h.DeleteExclusionGroup)
+			})
+		})
+
+		router.Route("/webhooks", func(r chi.Router) {
+			r.Use(h.requireScope(model.ScopeWebhooksWrite))
+			r.With(
+				h.rateLimited(ratelimit.GroupWrite),
+				h.idempotent,
+				h.audited(model.AuditWebhookCreate),
+			).Post("/", h.CreateWebhook)
+			r.With(h.rateLimited(ratelimit.GroupRead)).Get("/", h.GetWebhooks)
+			r.With(
+				h.rateLimited(ratelimit.GroupWrite),
+				h.idempotent,
+				h.audited(model.AuditWebhookDelete),
+			).Delete("/{id}", h.DeleteWebhook)
+		})
+
+		router.Route("/keys", func(r chi.Router) {
+			r.Use(h.requireScope(model.ScopeAdmin))
+			r.Use(h.rateLimited(ratelimit.GroupWrite))
+			r.Use(h.idempotent)
+			r.With(h.audited(model.AuditAPIKeyCreate)).Post("/", h.CreateAPIKey)
+			r.With(h.audited(model.AuditAPIKeyDelete)).Delete("/{id}", h.DeleteAPIKey)
+		})
+
+		router.Group(func(r chi.Router) {
+			r.Use(h.rateLimited(ratelimit.GroupRead))
+			r.With(h.requireScope(model.ScopeAuditRead)).Get("/audit", h.GetAuditRecords)
+			r.With(h.requireScope(model.ScopeEventsRead)).Get("/events/stream", h.StreamEvents)
+			r.With(h.requireScope(model.ScopeAdmin)).Get("/cache/stats", h.GetCacheStats)
 		})
 	})
 
@@ -56,42 +186,75 @@ func GetHandler(userService *services.UserService, segmentService *services.Segm
 // @Summary Creates new segment with given slug
 // @Description Создает новый сегмент с заданным значением Slug и (опционально) Selection - процентом для выборки
 // @Description пользователей [0, 1). При непустом значении Selection, новый сегмент добавляется рандомно выбранным
-// @Description пользователям в количестве (AllUsersCount * Selection).
+// @Description пользователям в количестве (AllUsersCount * Selection). В ответе возвращается количество
+// @Description пользователей, которым был добавлен сегмент.
+// @Description Grants задают права команд на сегмент (read/write, команда "*" - все). Если они не заданы, команды
+// @Description создателя получают право write, остальные - read. Сегмент без grants доступен всем.
+// @Description Rollout (доля пользователей [0, 1] по хэшу id) и Rules (условия на атрибуты пользователя, операторы
+// @Description in и not_in) задают динамический сегмент: его пользователи не хранятся, а вычисляются, явно добавить
+// @Description или удалить пользователя из такого сегмента нельзя (409). Selection с ними не используется.
+// @Description Group добавляет сегмент в группу взаимоисключающих сегментов, Selection при этом выбирает только
+// @Description пользователей, которых нет в других сегментах группы. На сегменты группы нужно право write (403).
+// @Description Prerequisites - сегменты, в которых пользователь должен состоять, чтобы попасть в новый сегмент,
+// @Description Selection выбирает только таких пользователей. На prerequisites нужно право write (403).
+// @Description ActiveFrom и ActiveUntil задают окно активности: вне его сегмент не возвращается в сегментах
+// @Description пользователя, а в ActiveUntil сегмент удаляется планировщиком (как DELETE /segment/{slug}).
 // @Accept json
 // @Produce json
 // @Param segment body model.CreateSegmentInput true "Segment input"
-// @Success 200
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
+// @Success 200 {object} model.CreateSegmentOutput
 // @Failure 400 {object} model.OutputError
 // @Failure 409 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
 // @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /segment [post]
 func (h HTTPHandler) CreateSegment(writer http.ResponseWriter, request *http.Request) {
 	input := &model.CreateSegmentInput{}
 	err := render.Bind(request, input)
 	if err != nil {
-		log.Info(err)
 		h.processError(writer, request, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
 		return
 	}
 
-	_, err = h.segmentService.CreateSegment(request.Context(), input)
+	auditTarget(request.Context(), nil, input.Slug)
+	auditReason(request.Context(), input.Reason)
+
+	output, err := h.segmentService.CreateSegment(request.Context(), input)
 	if err != nil {
 		h.processError(writer, request, err)
 		return
 	}
 
 	render.Status(request, http.StatusOK) // todo: status accepted for auto user adding
+	render.Render(writer, request, output)
 }
 
 // DeleteSegment godoc
 // @Summary Deletes segment with given slug
 // @Description Совершает "soft delete" - помечает сегмент и его связь с пользователями как удаленный.
+// @Description Пользователи удаляются из зависимых сегментов с политикой cascade, зависимые сегменты
+// @Description с политикой reject, в которых есть пользователи сегмента, отклоняют удаление (409).
+// @Description Требует право write на сегмент и на зависимые сегменты, из которых удаляются пользователи.
 // @Produce json
 // @Param slug path string true "slug"
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
 // @Success 200
 // @Failure 400 {object} model.OutputError
 // @Failure 404 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 409 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
 // @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /segment/{slug} [delete]
 func (h HTTPHandler) DeleteSegment(writer http.ResponseWriter, request *http.Request) {
 	segment := &model.SegmentInput{}
@@ -102,6 +265,8 @@ func (h HTTPHandler) DeleteSegment(writer http.ResponseWriter, request *http.Req
 		return
 	}
 
+	auditTarget(request.Context(), nil, segment.Slug)
+
 	err = h.segmentService.DeleteSegment(request.Context(), segment)
 	if err != nil {
 		h.processError(writer, request, err)
@@ -111,18 +276,70 @@ func (h HTTPHandler) DeleteSegment(writer http.ResponseWriter, request *http.Req
 	render.Status(request, http.StatusOK)
 }
 
+// GetSegmentsSnapshot godoc
+// @Summary Get segments definitions snapshot
+// @Description Возвращает определения сегментов для вычисления принадлежности пользователей на стороне клиента.
+// @Description Пользователи сегментов с rollout и rules вычисляются по хэшу id пользователя и его атрибутам,
+// @Description для сегментов с explicit=true пользователи хранятся явно и запрашиваются через API.
+// @Description Без since возвращаются все активные сегменты (full=true), с since - только измененные после этой
+// @Description версии, включая удаленные (deleted=true). Version ответа передается в since следующего запроса.
+// @Produce json
+// @Param since query uint false "Version of the previous snapshot"
+// @Success 200 {object} model.SegmentsSnapshot
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /segments/snapshot [get]
+func (h HTTPHandler) GetSegmentsSnapshot(writer http.ResponseWriter, request *http.Request) {
+	input := &model.SnapshotInput{}
+
+	err := input.FromURI(request)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %s", ErrInvalidRequest, err))
+		return
+	}
+
+	snapshot, err := h.segmentService.GetSnapshot(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+	render.Render(writer, request, snapshot)
+}
+
 // UpdateUserSegments godoc
 // @Summary Updates user's segments
 // @Description Обновляет сегменты пользователя: добавляет и удаляет существующие по соответствующим спискам.
-// @Description Отдает ошибку в том числе, если списки пересекаются, если сегмента не существует, если сегмент уже удален.
+// @Description Отдает ошибку в том числе, если списки пересекаются, если сегмента не существует, если сегмент уже удален,
+// @Description если у клиента нет права write на какой-либо из сегментов, если сегмент динамический (409),
+// @Description если добавляемые сегменты из одной группы взаимоисключающих сегментов или пользователь уже в другом
+// @Description сегменте группы с политикой reject (409). При политике replace пользователь удаляется из другого
+// @Description сегмента группы в той же транзакции. Добавление в сегмент отклоняется (409), если пользователь
+// @Description не состоит в его prerequisites после изменения. Удаление из prerequisite отклоняется (409) для
+// @Description зависимых сегментов пользователя с on_remove=reject и удаляет его из зависимых сегментов с cascade.
+// @Description На сегменты, из которых пользователь удаляется по replace или cascade, тоже нужно право write (403).
 // @Accept json
 // @Produce json
 // @Param user_id path uint true "User id"
 // @Param input body model.UserSegmentsInput true "User segments input"
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
 // @Success 200
 // @Failure 400 {object} model.OutputError
 // @Failure 404 {object} model.OutputError
+// @Failure 409 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
 // @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /segments/user/{user_id} [post]
 func (h HTTPHandler) UpdateUserSegments(writer http.ResponseWriter, request *http.Request) {
 	input := &model.UserSegmentsInput{}
@@ -133,6 +350,10 @@ func (h HTTPHandler) UpdateUserSegments(writer http.ResponseWriter, request *htt
 		return
 	}
 
+	auditTarget(request.Context(), &input.UserID, input.SegmentsToAdd...)
+	auditTarget(request.Context(), nil, input.SegmentsToDelete...)
+	auditReason(request.Context(), input.Reason)
+
 	err = h.userService.UpdateUserSegments(request.Context(), input)
 	if err != nil {
 		h.processError(writer, request, err)
@@ -144,13 +365,27 @@ func (h HTTPHandler) UpdateUserSegments(writer http.ResponseWriter, request *htt
 
 // GetActiveUserSegments godoc
 // @Summary Get user's active segments
-// @Description Возвращает список активных сегментов пользователя
+// @Description Возвращает список активных сегментов пользователя, на которые у клиента есть право read.
+// @Description Динамические сегменты вычисляются только по id пользователя, сегменты с rules на другие атрибуты
+// @Description в ответ не входят: их вычисляет клиент по снапшоту определений (client.Evaluator).
+// @Description В ответе передаются заголовки ETag и Last-Modified, при совпадении If-None-Match (If-Modified-Since)
+// @Description с текущей версией сегментов пользователя возвращается 304 без тела. ETag зависит от видимых клиенту
+// @Description сегментов, ответ помечается Cache-Control: public, no-cache и Vary: Authorization, X-API-Key, поэтому
+// @Description общий кеш перепроверяет его по ETag и не отдает одному клиенту ответ другого.
 // @Produce json
 // @Param user_id path uint true "User ID"
+// @Param If-None-Match header string false "ETag from previous response"
+// @Param If-Modified-Since header string false "Last-Modified from previous response"
 // @Success 200 {object} model.Slugs
+// @Success 304
 // @Failure 400 {object} model.OutputError
 // @Failure 404 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
 // @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /segments/user/{user_id} [get]
 func (h HTTPHandler) GetActiveUserSegments(writer http.ResponseWriter, request *http.Request) {
 	input := &model.UserInput{}
@@ -161,12 +396,19 @@ func (h HTTPHandler) GetActiveUserSegments(writer http.ResponseWriter, request *
 		return
 	}
 
-	segments, err := h.userService.GetUserActiveSegments(request.Context(), input)
+	segments, updatedAt, err := h.userService.GetUserActiveSegments(request.Context(), input)
 	if err != nil {
 		h.processError(writer, request, err)
 		return
 	}
 
+	etag := userSegmentsETag(input.UserID, updatedAt, segments)
+	setValidators(writer, etag, updatedAt)
+	if isNotModified(request, etag, updatedAt) {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	render.Status(request, http.StatusOK)
 	render.Render(writer, request, segments)
 }
@@ -182,7 +424,12 @@ func (h HTTPHandler) GetActiveUserSegments(writer http.ResponseWriter, request *
 // @Success 202
 // @Failure 400 {object} model.OutputError
 // @Failure 404 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
 // @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /segments/user/{user_id}/csv [get]
 func (h HTTPHandler) GenerateUserSegmentsHistory(writer http.ResponseWriter, request *http.Request) {
 	input := &model.UserSegmentsHistoryInput{}
@@ -215,37 +462,799 @@ func (h HTTPHandler) GenerateUserSegmentsHistory(writer http.ResponseWriter, req
 // @Success 200
 // @Failure 400 {object} model.OutputError
 // @Failure 404 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
 // @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /segments/user/history/{filename} [get]
 func (h HTTPHandler) GetUserSegmentsHistoryFile(writer http.ResponseWriter, request *http.Request) {
 	filename := chi.URLParam(request, "filename")
 
-	log.Infof("requesting file: %s", filename)
+	logging.FromContext(request.Context()).Debugf("requesting file: %s", filename)
 
 	filePath, err := h.userService.DownloadUserSegmentsHistory(filename)
 	if err != nil {
 		h.processError(writer, request, err)
+		return
 	}
 
 	http.ServeFile(writer, request, filePath)
 }
 
-// processError handle app errors.
+// StreamEvents godoc
+// @Summary Stream membership change events
+// @Description Отдает поток событий (Server-Sent Events) о добавлении пользователя в сегмент (add),
+// @Description удалении из сегмента (delete), удалении сегмента (segment_deleted) и его восстановлении (segment_restored).
+// @Description Поток можно отфильтровать по пользователю и сегменту. Для продолжения чтения после переподключения
+// @Description передается заголовок Last-Event-ID (или параметр last_event_id) с id последнего полученного события.
+// @Description События отдаются в порядке фиксации их транзакций, id событий в потоке не обязательно возрастают.
+// @Description События сегментов, на которые у клиента нет права read, не отдаются.
+// @Produce text/event-stream
+// @Param user_id query uint false "User ID"
+// @Param segment query string false "Segment slug"
+// @Param last_event_id query uint false "Last received event ID"
+// @Param Last-Event-ID header uint false "Last received event ID"
+// @Success 200 {object} model.Event
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /events/stream [get]
+func (h HTTPHandler) StreamEvents(writer http.ResponseWriter, request *http.Request) {
+	filter := model.EventsFilter{}
+
+	err := filter.FromURI(request)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %s", ErrInvalidRequest, err))
+		return
+	}
+
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		h.processError(writer, request, ErrStreamingUnsupported)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	err = h.eventService.StreamEvents(request.Context(), filter, func(events []model.Event) error {
+		if len(events) == 0 {
+			if _, err := fmt.Fprint(writer, ": ping\n\n"); err != nil {
+				return err
+			}
+		}
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			if err != nil {
+				return err
+			}
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		logging.FromContext(request.Context()).Errorf("StreamEvents: %v", err)
+	}
+}
+
+// GetSegmentGrants godoc
+// @Summary Get segment ACL
+// @Description Возвращает права команд на сегмент. Требует право read на сегмент.
+// @Produce json
+// @Param slug path string true "slug"
+// @Success 200 {object} model.SegmentGrantsOutput
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 404 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /segment/{slug}/acl [get]
+func (h HTTPHandler) GetSegmentGrants(writer http.ResponseWriter, request *http.Request) {
+	segment := &model.SegmentInput{}
+
+	err := segment.FromURI(request)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %s", ErrInvalidRequest, err))
+		return
+	}
+
+	output, err := h.segmentService.GetSegmentGrants(request.Context(), segment)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+	render.Render(writer, request, output)
+}
+
+// SetSegmentGrants godoc
+// @Summary Replaces segment ACL
+// @Description Заменяет права команд на сегмент. Требует право write на сегмент.
+// @Description Пустой список делает сегмент доступным всем.
+// @Accept json
+// @Produce json
+// @Param slug path string true "slug"
+// @Param input body model.SegmentGrantsInput true "Segment grants"
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
+// @Success 200
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 404 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /segment/{slug}/acl [put]
+func (h HTTPHandler) SetSegmentGrants(writer http.ResponseWriter, request *http.Request) {
+	input := &model.SegmentGrantsInput{}
+
+	err := render.Bind(request, input)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+		return
+	}
+
+	auditTarget(request.Context(), nil, input.Slug)
+	auditReason(request.Context(), input.Reason)
+
+	err = h.segmentService.SetSegmentGrants(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+}
+
+// CreateExperiment godoc
+// @Summary Creates A/B experiment
+// @Description Создает эксперимент и сегменты его вариантов. Weight варианта - доля пользователей [0, 1], сумма
+// @Description весов не больше 1. Пользователи распределяются по вариантам детерминированно по хэшу id и slug'а
+// @Description эксперимента, пользователь попадает не больше чем в один вариант. Сегменты вариантов динамические:
+// @Description явно добавить или удалить пользователя нельзя (409), они возвращаются в сегментах пользователя и в
+// @Description снапшоте. Grants задаются каждому варианту как при создании сегмента.
+// @Accept json
+// @Produce json
+// @Param experiment body model.CreateExperimentInput true "Experiment input"
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
+// @Success 200 {object} model.ExperimentOutput
+// @Failure 400 {object} model.OutputError
+// @Failure 409 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /experiments [post]
+func (h HTTPHandler) CreateExperiment(writer http.ResponseWriter, request *http.Request) {
+	input := &model.CreateExperimentInput{}
+	err := render.Bind(request, input)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+		return
+	}
+
+	auditTarget(request.Context(), nil, input.Slug)
+	for _, variant := range input.Variants {
+		auditTarget(request.Context(), nil, variant.Slug)
+	}
+	auditReason(request.Context(), input.Reason)
+
+	output, err := h.segmentService.CreateExperiment(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+	render.Render(writer, request, output)
+}
+
+// GetExperiment godoc
+// @Summary Get experiment variants
+// @Description Возвращает варианты эксперимента и их веса. Варианты без права read у клиента не возвращаются.
+// @Produce json
+// @Param slug path string true "slug"
+// @Success 200 {object} model.ExperimentOutput
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 404 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /experiments/{slug} [get]
+func (h HTTPHandler) GetExperiment(writer http.ResponseWriter, request *http.Request) {
+	input := &model.ExperimentInput{}
+
+	err := input.FromURI(request)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %s", ErrInvalidRequest, err))
+		return
+	}
+
+	output, err := h.segmentService.GetExperiment(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+	render.Render(writer, request, output)
+}
+
+// UpdateExperimentWeights godoc
+// @Summary Updates experiment variants weights
+// @Description Изменяет веса вариантов эксперимента, веса неуказанных вариантов сохраняются. Пользователи остаются
+// @Description в своих вариантах, если вес варианта не уменьшился, освободившаяся доля отдается растущим вариантам.
+// @Description Требует право write на все варианты.
+// @Accept json
+// @Produce json
+// @Param slug path string true "slug"
+// @Param input body model.ExperimentWeightsInput true "Variants weights"
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
+// @Success 200 {object} model.ExperimentOutput
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 404 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /experiments/{slug}/weights [put]
+func (h HTTPHandler) UpdateExperimentWeights(writer http.ResponseWriter, request *http.Request) {
+	input := &model.ExperimentWeightsInput{}
+	err := render.Bind(request, input)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+		return
+	}
+
+	auditTarget(request.Context(), nil, input.Slug)
+	auditReason(request.Context(), input.Reason)
+
+	output, err := h.segmentService.UpdateExperimentWeights(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+	render.Render(writer, request, output)
+}
+
+// GetUserVariants godoc
+// @Summary Get user's experiments variants
+// @Description Возвращает вариант пользователя в каждом эксперименте, variant равен null, если пользователь
+// @Description не попал ни в один вариант эксперимента.
+// @Produce json
+// @Param user_id path uint true "User id"
+// @Success 200 {array} model.UserVariant
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /experiments/user/{user_id} [get]
+func (h HTTPHandler) GetUserVariants(writer http.ResponseWriter, request *http.Request) {
+	input := &model.UserInput{}
+
+	err := input.FromURI(request)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %s", ErrInvalidRequest, err))
+		return
+	}
+
+	variants, err := h.segmentService.GetUserVariants(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+	render.Render(writer, request, variants)
+}
+
+// CreateExclusionGroup godoc
+// @Summary Creates exclusion group of segments
+// @Description Создает группу взаимоисключающих сегментов: пользователь может быть только в одном сегменте группы.
+// @Description При добавлении пользователя в сегмент группы, когда он уже в другом ее сегменте, политика reject
+// @Description отклоняет изменение (409), а replace удаляет пользователя из другого сегмента. Сегмент может быть
+// @Description только в одной группе, динамические сегменты в группы не входят, сегменты не должны уже иметь общих
+// @Description пользователей (409). Требует право write на все сегменты.
+// @Accept json
+// @Produce json
+// @Param group body model.CreateExclusionGroupInput true "Exclusion group input"
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
+// @Success 200 {object} model.ExclusionGroupOutput
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 404 {object} model.OutputError
+// @Failure 409 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /groups [post]
+func (h HTTPHandler) CreateExclusionGroup(writer http.ResponseWriter, request *http.Request) {
+	input := &model.CreateExclusionGroupInput{}
+	err := render.Bind(request, input)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+		return
+	}
+
+	auditTarget(request.Context(), nil, input.Segments...)
+	auditReason(request.Context(), input.Reason)
+
+	output, err := h.segmentService.CreateExclusionGroup(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+	render.Render(writer, request, output)
+}
+
+// GetExclusionGroup godoc
+// @Summary Get exclusion group
+// @Description Возвращает политику и сегменты группы взаимоисключающих сегментов.
+// @Produce json
+// @Param slug path string true "slug"
+// @Success 200 {object} model.ExclusionGroupOutput
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 404 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /groups/{slug} [get]
+func (h HTTPHandler) GetExclusionGroup(writer http.ResponseWriter, request *http.Request) {
+	input := &model.ExclusionGroupInput{}
+
+	err := input.FromURI(request)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %s", ErrInvalidRequest, err))
+		return
+	}
+
+	output, err := h.segmentService.GetExclusionGroup(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+	render.Render(writer, request, output)
+}
+
+// DeleteExclusionGroup godoc
+// @Summary Deletes exclusion group
+// @Description Удаляет группу, ее сегменты и их пользователи сохраняются. Требует право write на все сегменты группы.
+// @Produce json
+// @Param slug path string true "slug"
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
+// @Success 200
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 404 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /groups/{slug} [delete]
+func (h HTTPHandler) DeleteExclusionGroup(writer http.ResponseWriter, request *http.Request) {
+	input := &model.ExclusionGroupInput{}
+
+	err := input.FromURI(request)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %s", ErrInvalidRequest, err))
+		return
+	}
+
+	err = h.segmentService.DeleteExclusionGroup(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+}
+
+// GetSegmentPrerequisites godoc
+// @Summary Get segment prerequisites
+// @Description Возвращает сегменты, в которых пользователь должен состоять, чтобы его можно было добавить в сегмент,
+// @Description и политику on_remove при удалении пользователя из них. Требует право read на сегмент.
+// @Produce json
+// @Param slug path string true "slug"
+// @Success 200 {object} model.SegmentPrerequisitesOutput
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 404 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /segment/{slug}/prerequisites [get]
+func (h HTTPHandler) GetSegmentPrerequisites(writer http.ResponseWriter, request *http.Request) {
+	segment := &model.SegmentInput{}
+
+	err := segment.FromURI(request)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %s", ErrInvalidRequest, err))
+		return
+	}
+
+	output, err := h.segmentService.GetSegmentPrerequisites(request.Context(), segment)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+	render.Render(writer, request, output)
+}
+
+// SetSegmentPrerequisites godoc
+// @Summary Replaces segment prerequisites
+// @Description Заменяет prerequisites сегмента. При удалении пользователя из prerequisite политика on_remove=reject
+// @Description (по умолчанию) отклоняет удаление, если пользователь в зависимом сегменте, cascade - удаляет его и
+// @Description из зависимого сегмента. Циклические зависимости отклоняются (409), динамические сегменты
+// @Description не используются (409). Текущие пользователи сегмента не проверяются. Требует право write на сегмент
+// @Description и на его prerequisites (403).
+// @Accept json
+// @Produce json
+// @Param slug path string true "slug"
+// @Param input body model.SegmentPrerequisitesInput true "Segment prerequisites"
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
+// @Success 200
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 404 {object} model.OutputError
+// @Failure 409 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /segment/{slug}/prerequisites [put]
+func (h HTTPHandler) SetSegmentPrerequisites(writer http.ResponseWriter, request *http.Request) {
+	input := &model.SegmentPrerequisitesInput{}
+
+	err := render.Bind(request, input)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+		return
+	}
+
+	auditTarget(request.Context(), nil, input.Slug)
+	auditReason(request.Context(), input.Reason)
+
+	err = h.segmentService.SetSegmentPrerequisites(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+}
+
+// CreateWebhook godoc
+// @Summary Creates webhook subscription
+// @Description Создает подписку на события изменения сегментов. События доставляются POST запросом
+// @Description пачками на указанный URL. Запрос подписывается HMAC-SHA256: заголовок X-Webhook-Signature содержит
+// @Description "sha256=" + hex(HMAC(secret, X-Webhook-Timestamp + "." + body)). Если secret не задан, он генерируется
+// @Description и возвращается только в ответе на создание. При ошибке доставка повторяется с экспоненциальной задержкой.
+// @Accept json
+// @Produce json
+// @Param webhook body model.CreateWebhookInput true "Webhook input"
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
+// @Success 201 {object} model.WebhookOutput
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks [post]
+func (h HTTPHandler) CreateWebhook(writer http.ResponseWriter, request *http.Request) {
+	input := &model.CreateWebhookInput{}
+	err := render.Bind(request, input)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+		return
+	}
+
+	auditStatus(request.Context(), http.StatusCreated)
+
+	output, err := h.webhookService.CreateWebhook(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusCreated)
+	render.Render(writer, request, output)
+}
+
+// GetWebhooks godoc
+// @Summary Get webhook subscriptions
+// @Description Возвращает список подписок и состояние их доставки.
+// @Produce json
+// @Success 200 {object} model.WebhooksOutput
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks [get]
+func (h HTTPHandler) GetWebhooks(writer http.ResponseWriter, request *http.Request) {
+	output, err := h.webhookService.GetWebhooks(request.Context())
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+	render.Render(writer, request, output)
+}
+
+// DeleteWebhook godoc
+// @Summary Deletes webhook subscription
+// @Description Удаляет подписку, доставка событий по ней прекращается.
+// @Produce json
+// @Param id path uint true "Webhook ID"
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
+// @Success 200
+// @Failure 400 {object} model.OutputError
+// @Failure 404 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{id} [delete]
+func (h HTTPHandler) DeleteWebhook(writer http.ResponseWriter, request *http.Request) {
+	input := &model.WebhookInput{}
+
+	err := input.FromURI(request)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %s", ErrInvalidRequest, err))
+		return
+	}
+
+	err = h.webhookService.DeleteWebhook(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+}
+
+// CreateAPIKey godoc
+// @Summary Creates API key
+// @Description Создает API ключ для клиента с заданными правами (scopes). Ключ возвращается только в этом ответе,
+// @Description в базе хранится его хэш. Ключ передается в заголовке X-API-Key.
+// @Description Требует права admin, первый ключ можно создать с помощью JWT с правом admin.
+// @Accept json
+// @Produce json
+// @Param key body model.CreateAPIKeyInput true "API key input"
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
+// @Success 201 {object} model.APIKeyOutput
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /keys [post]
+func (h HTTPHandler) CreateAPIKey(writer http.ResponseWriter, request *http.Request) {
+	input := &model.CreateAPIKeyInput{}
+	err := render.Bind(request, input)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+		return
+	}
+
+	auditStatus(request.Context(), http.StatusCreated)
+
+	output, err := h.authService.CreateAPIKey(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusCreated)
+	render.Render(writer, request, output)
+}
+
+// DeleteAPIKey godoc
+// @Summary Deletes API key
+// @Description Отзывает API ключ.
+// @Produce json
+// @Param id path uint true "API key ID"
+// @Param Idempotency-Key header string false "Unique key to safely retry the request"
+// @Success 200
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 404 {object} model.OutputError
+// @Failure 422 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /keys/{id} [delete]
+func (h HTTPHandler) DeleteAPIKey(writer http.ResponseWriter, request *http.Request) {
+	input := &model.APIKeyInput{}
+
+	err := input.FromURI(request)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %s", ErrInvalidRequest, err))
+		return
+	}
+
+	err = h.authService.DeleteAPIKey(request.Context(), input)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+}
+
+// GetAuditRecords godoc
+// @Summary Get audit log
+// @Description Возвращает записи аудита изменяющих запросов: кто (actor), когда, с какого адреса, с каким request id
+// @Description и по какой причине (reason) выполнил запрос. Записи отдаются от новых к старым, для следующей страницы
+// @Description передается before_id - id последней полученной записи.
+// @Description Actor берется из аутентификации или из заголовка X-Actor, reason - из поля reason тела запроса
+// @Description или заголовка X-Audit-Reason.
+// @Produce json
+// @Param actor query string false "Actor"
+// @Param segment query string false "Segment slug"
+// @Param user_id query uint false "User ID"
+// @Param from query string false "From time, RFC 3339 or date" Example("2023-08-01")
+// @Param to query string false "To time, RFC 3339 or date" Example("2023-08-31T12:00:00Z")
+// @Param before_id query uint false "Return records with id less than before_id"
+// @Param limit query int false "Max records count, 100 by default, 1000 max"
+// @Success 200 {object} model.AuditRecords
+// @Failure 400 {object} model.OutputError
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Failure 500 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /audit [get]
+func (h HTTPHandler) GetAuditRecords(writer http.ResponseWriter, request *http.Request) {
+	filter := model.AuditFilter{}
+
+	err := filter.FromURI(request)
+	if err != nil {
+		h.processError(writer, request, fmt.Errorf("%w: %s", ErrInvalidRequest, err))
+		return
+	}
+
+	records, err := h.auditService.GetAuditRecords(request.Context(), filter)
+	if err != nil {
+		h.processError(writer, request, err)
+		return
+	}
+
+	render.Status(request, http.StatusOK)
+	render.Render(writer, request, records)
+}
+
+// Liveness responds ok while the process is able to serve requests, it is a liveness probe for orchestrators.
+func (h HTTPHandler) Liveness(writer http.ResponseWriter, request *http.Request) {
+	render.Render(writer, request, h.healthService.Live())
+}
+
+// Readiness checks database, its schema version, reports directory and workers pool,
+// it is a readiness probe for orchestrators and load balancers. Responds 503 if any check fails
+// or the application is shutting down.
+func (h HTTPHandler) Readiness(writer http.ResponseWriter, request *http.Request) {
+	render.Render(writer, request, h.healthService.Ready(request.Context()))
+}
+
+// GetCacheStats godoc
+// @Summary Get user segments cache statistics
+// @Description Возвращает количество попаданий и промахов кэша активных сегментов пользователей.
+// @Produce json
+// @Success 200 {object} model.CacheStats
+// @Failure 401 {object} model.OutputError
+// @Failure 403 {object} model.OutputError
+// @Failure 429 {object} model.OutputError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /cache/stats [get]
+func (h HTTPHandler) GetCacheStats(writer http.ResponseWriter, request *http.Request) {
+	render.Status(request, http.StatusOK)
+	render.Render(writer, request, h.cache.Stats())
+}
+
+// processError handle app errors. Text of internal errors is only logged, the client gets the status text.
 func (h HTTPHandler) processError(w http.ResponseWriter, r *http.Request, err error) {
 	var httpCode int
 	switch {
-	case errors.Is(err, ErrInvalidRequest):
+	case errors.Is(err, ErrInvalidRequest), errors.Is(err, evaluation.ErrInvalidWeights):
 		httpCode = http.StatusBadRequest
 	case errors.Is(err, database.ErrAlreadyExists):
 		httpCode = http.StatusConflict
 	case errors.Is(err, database.ErrNotFound):
 		httpCode = http.StatusNotFound
+	case errors.Is(err, database.ErrDynamicSegment), errors.Is(err, database.ErrGroupConflict):
+		httpCode = http.StatusConflict
+	case errors.Is(err, database.ErrPrerequisite), errors.Is(err, database.ErrPrerequisiteCycle):
+		httpCode = http.StatusConflict
 	case errors.Is(err, utils.ErrFileNotFound):
 		httpCode = http.StatusNotFound
+	case errors.Is(err, services.ErrUnauthenticated):
+		httpCode = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	case errors.Is(err, services.ErrForbidden):
+		httpCode = http.StatusForbidden
+	case errors.Is(err, services.ErrIdempotencyKeyInProgress):
+		httpCode = http.StatusConflict
+	case errors.Is(err, services.ErrIdempotencyKeyReused):
+		httpCode = http.StatusUnprocessableEntity
+	case errors.Is(err, worker.ErrPoolClosed):
+		httpCode = http.StatusServiceUnavailable
+	case errors.Is(err, ErrTooManyRequests):
+		httpCode = http.StatusTooManyRequests
 	default:
 		httpCode = http.StatusInternalServerError
 	}
-	oErr := model.OutputError{HttpCode: httpCode, Message: err.Error()}
+	message := err.Error()
+	if httpCode >= http.StatusInternalServerError {
+		logging.FromContext(r.Context()).WithError(err).Error("request failed")
+	}
+	if httpCode == http.StatusInternalServerError {
+		message = http.StatusText(httpCode)
+	}
+	oErr := model.OutputError{HttpCode: httpCode, Message: message}
 
 	render.Render(w, r, oErr)
 }
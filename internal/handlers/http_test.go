@@ -2,26 +2,65 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 
+	"github.com/unbeman/av-prac-task/internal/cache"
 	"github.com/unbeman/av-prac-task/internal/config"
 	"github.com/unbeman/av-prac-task/internal/database"
 	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
 	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/ratelimit"
 	"github.com/unbeman/av-prac-task/internal/services"
 	"github.com/unbeman/av-prac-task/internal/worker"
+	"github.com/unbeman/av-prac-task/pkg/evaluation"
 )
 
+// dynamicSegmentsFilter lists dynamic segments evaluated for users segments.
+var dynamicSegmentsFilter = model.SegmentsFilter{
+	Dynamic:       true,
+	WithDeleted:   true,
+	DeletedWithin: services.DynamicSegmentsHorizon,
+}
+
 func setupHandler(t *testing.T, ctrl *gomock.Controller, setupDB func(db *mock_database.MockIDatabase)) *HTTPHandler {
+	authCfg := config.NewAuthConfig()
+	authCfg.Enabled = false
+	return setupHandlerWithAuth(t, ctrl, setupDB, authCfg)
+}
+
+func setupHandlerWithAuth(
+	t *testing.T,
+	ctrl *gomock.Controller,
+	setupDB func(db *mock_database.MockIDatabase),
+	authCfg config.AuthConfig,
+) *HTTPHandler {
+	rateLimitCfg := config.NewRateLimitConfig()
+	rateLimitCfg.Enabled = false
+	return setupHandlerWithLimits(t, ctrl, setupDB, authCfg, rateLimitCfg)
+}
+
+func setupHandlerWithLimits(
+	t *testing.T,
+	ctrl *gomock.Controller,
+	setupDB func(db *mock_database.MockIDatabase),
+	authCfg config.AuthConfig,
+	rateLimitCfg config.RateLimitConfig,
+) *HTTPHandler {
 	database := mock_database.NewMockIDatabase(ctrl)
 	setupDB(database)
+	database.EXPECT().CreateAuditRecord(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	database.EXPECT().ListSegments(gomock.Any(), dynamicSegmentsFilter).Return(nil, nil).AnyTimes()
+	database.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(0), nil).AnyTimes()
 
 	wp := worker.NewWorkersPool(config.NewWorkerPoolConfig())
 
@@ -31,16 +70,55 @@ func setupHandler(t *testing.T, ctrl *gomock.Controller, setupDB func(db *mock_d
 	userServ, err := services.NewUserService(database, wp, t.TempDir())
 	require.NoError(t, err)
 
-	h, err := GetHandler(userServ, segmentServ)
+	eventsCfg := config.NewEventsConfig()
+	eventsCfg.PollInterval = 10 * time.Millisecond
+	eventServ, err := services.NewEventService(database, eventsCfg)
+	require.NoError(t, err)
+
+	webhookServ, err := services.NewWebhookService(database, config.NewWebhooksConfig())
+	require.NoError(t, err)
+
+	authServ, err := services.NewAuthService(database, authCfg)
+	require.NoError(t, err)
+
+	auditServ, err := services.NewAuditService(database)
+	require.NoError(t, err)
+
+	idempotencyServ, err := services.NewIdempotencyService(database, config.NewIdempotencyConfig())
+	require.NoError(t, err)
+
+	healthServ, err := services.NewHealthService(database, wp, t.TempDir(), config.NewHealthConfig())
+	require.NoError(t, err)
+
+	h, err := GetHandler(
+		userServ,
+		segmentServ,
+		eventServ,
+		webhookServ,
+		authServ,
+		auditServ,
+		idempotencyServ,
+		healthServ,
+		cache.NewLRUCache(config.NewCacheConfig()),
+		ratelimit.NewMemoryLimiter(rateLimitCfg),
+	)
 	require.NoError(t, err)
 
 	return h
 }
 
+func slug(s model.Slug) *model.Slug {
+	return &s
+}
+
 func getSelection(n float64) *float64 {
 	return &n
 }
 
+func timeRef(t time.Time) *time.Time {
+	return &t
+}
+
 func TestHTTPHandlers_CreateSegment(t *testing.T) {
 	segment := model.Segment{Slug: "SEGMENT-SLUG"}
 	tests := []struct {
@@ -54,11 +132,8 @@ func TestHTTPHandlers_CreateSegment(t *testing.T) {
 			input: model.CreateSegmentInput{Slug: segment.Slug, Selection: getSelection(0.5)},
 			buildStubs: func(db *mock_database.MockIDatabase) {
 				db.EXPECT().
-					CreateSegment(gomock.Any(), gomock.Any()).
-					Return(&segment, nil)
-				db.EXPECT().
-					AddSegmentToRandomUsers(gomock.Any(), gomock.Any(), gomock.Any()).
-					Return(nil)
+					CreateSegmentForRandomUsers(gomock.Any(), gomock.Any(), 0.5).
+					Return(&segment, int64(5), nil)
 			},
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
 				require.Equal(t, http.StatusOK, recorder.Code)
@@ -100,6 +175,173 @@ func TestHTTPHandlers_CreateSegment(t *testing.T) {
 				require.Equal(t, http.StatusBadRequest, recorder.Code)
 			},
 		},
+		{
+			name:  "Grouped segment",
+			input: model.CreateSegmentInput{Slug: segment.Slug, Selection: getSelection(0.5), Group: slug("PROMO_DISCOUNTS")},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetExclusionGroup(gomock.Any(), &model.ExclusionGroup{Slug: "PROMO_DISCOUNTS"}).
+					Return(&model.ExclusionGroup{ID: 7, Slug: "PROMO_DISCOUNTS"}, nil)
+				db.EXPECT().
+					CreateSegmentForRandomUsers(gomock.Any(), gomock.Any(), 0.5).
+					DoAndReturn(func(ctx context.Context, s *model.Segment, selection float64) (*model.Segment, int64, error) {
+						require.Equal(t, uint64(7), *s.GroupID)
+						return s, 5, nil
+					})
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name:  "Unknown group",
+			input: model.CreateSegmentInput{Slug: segment.Slug, Group: slug("UNKNOWN")},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetExclusionGroup(gomock.Any(), gomock.Any()).Return(nil, database.ErrNotFound)
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNotFound, recorder.Code)
+			},
+		},
+		{
+			name: "Segment with prerequisites",
+			input: model.CreateSegmentInput{
+				Slug:          segment.Slug,
+				Selection:     getSelection(0.5),
+				Prerequisites: []model.PrerequisiteInput{{Slug: "VERIFIED", OnRemove: model.PrerequisiteCascade}},
+			},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetSegments(gomock.Any(), []model.Slug{"VERIFIED"}).
+					Return([]*model.Segment{{ID: 3, Slug: "VERIFIED"}}, nil)
+				db.EXPECT().
+					CreateSegmentForRandomUsers(gomock.Any(), gomock.Any(), 0.5).
+					DoAndReturn(func(ctx context.Context, s *model.Segment, selection float64) (*model.Segment, int64, error) {
+						require.Equal(t, []model.SegmentPrerequisite{
+							{PrerequisiteID: 3, OnRemove: model.PrerequisiteCascade},
+						}, s.Prerequisites)
+						return s, 5, nil
+					})
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name: "Dynamic prerequisite",
+			input: model.CreateSegmentInput{
+				Slug:          segment.Slug,
+				Prerequisites: []model.PrerequisiteInput{{Slug: "VERIFIED"}},
+			},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetSegments(gomock.Any(), []model.Slug{"VERIFIED"}).
+					Return([]*model.Segment{{ID: 3, Slug: "VERIFIED", Rollout: getSelection(0.1)}}, nil)
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name: "Active window",
+			input: model.CreateSegmentInput{
+				Slug:        segment.Slug,
+				ActiveFrom:  timeRef(time.Now().Add(time.Hour)),
+				ActiveUntil: timeRef(time.Now().Add(24 * time.Hour)),
+			},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					CreateSegment(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, s *model.Segment) (*model.Segment, error) {
+						require.NotNil(t, s.ActiveFrom)
+						require.True(t, s.ActiveUntil.After(*s.ActiveFrom))
+						return s, nil
+					})
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name: "Active window ends before start",
+			input: model.CreateSegmentInput{
+				Slug:        segment.Slug,
+				ActiveFrom:  timeRef(time.Now().Add(24 * time.Hour)),
+				ActiveUntil: timeRef(time.Now().Add(time.Hour)),
+			},
+			buildStubs: func(db *mock_database.MockIDatabase) {},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:       "Active window ended",
+			input:      model.CreateSegmentInput{Slug: segment.Slug, ActiveUntil: timeRef(time.Now().Add(-time.Hour))},
+			buildStubs: func(db *mock_database.MockIDatabase) {},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:       "Grouped dynamic segment",
+			input:      model.CreateSegmentInput{Slug: segment.Slug, Rollout: getSelection(0.1), Group: slug("PROMO_DISCOUNTS")},
+			buildStubs: func(db *mock_database.MockIDatabase) {},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name: "Dynamic segment",
+			input: model.CreateSegmentInput{
+				Slug:    segment.Slug,
+				Rollout: getSelection(0.1),
+				Rules:   []model.SegmentRule{{Attribute: "country", Operator: "in", Values: []string{"RU"}}},
+			},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					CreateSegment(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, s *model.Segment) (*model.Segment, error) {
+						require.Equal(t, 0.1, *s.Rollout)
+						require.Len(t, s.Rules, 1)
+						return s, nil
+					})
+				db.EXPECT().
+					CreateSegmentForRandomUsers(gomock.Any(), gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name:  "Selection with rollout",
+			input: model.CreateSegmentInput{Slug: segment.Slug, Selection: getSelection(0.5), Rollout: getSelection(0.5)},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					CreateSegment(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name: "Invalid rule",
+			input: model.CreateSegmentInput{
+				Slug:  segment.Slug,
+				Rules: []model.SegmentRule{{Attribute: "country", Operator: "eq", Values: []string{"RU"}}},
+			},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					CreateSegment(gomock.Any(), gomock.Any()).
+					Times(0)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
 		{
 			name:  "Empty slug",
 			input: model.CreateSegmentInput{Slug: ""},
@@ -150,7 +392,7 @@ func TestHTTPHandlers_DeleteSegment(t *testing.T) {
 			input: model.SegmentInput{Slug: segment.Slug},
 			buildStubs: func(db *mock_database.MockIDatabase) {
 				db.EXPECT().
-					DeleteSegment(gomock.Any(), gomock.Any()).
+					DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).
 					Return(nil)
 			},
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
@@ -162,7 +404,7 @@ func TestHTTPHandlers_DeleteSegment(t *testing.T) {
 			input: model.SegmentInput{Slug: segment.Slug},
 			buildStubs: func(db *mock_database.MockIDatabase) {
 				db.EXPECT().
-					DeleteSegment(gomock.Any(), gomock.Any()).
+					DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).
 					Return(database.ErrDB)
 			},
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
@@ -174,7 +416,7 @@ func TestHTTPHandlers_DeleteSegment(t *testing.T) {
 			input: model.SegmentInput{Slug: ""},
 			buildStubs: func(db *mock_database.MockIDatabase) {
 				db.EXPECT().
-					DeleteSegment(gomock.Any(), gomock.Any()).
+					DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).
 					Times(0)
 			},
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
@@ -219,7 +461,7 @@ func TestHTTPHandlers_UpdateUserSegments(t *testing.T) {
 			},
 			buildStubs: func(db *mock_database.MockIDatabase) {
 				db.EXPECT().
-					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()). //todo: fill
+					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()). //todo: fill
 					Return(nil)
 			},
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
@@ -235,7 +477,7 @@ func TestHTTPHandlers_UpdateUserSegments(t *testing.T) {
 			},
 			buildStubs: func(db *mock_database.MockIDatabase) {
 				db.EXPECT().
-					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()). //todo: fill
+					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()). //todo: fill
 					Return(database.ErrDB)
 			},
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
@@ -251,13 +493,58 @@ func TestHTTPHandlers_UpdateUserSegments(t *testing.T) {
 			},
 			buildStubs: func(db *mock_database.MockIDatabase) {
 				db.EXPECT().
-					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
 					Return(database.ErrAlreadyExists)
 			},
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
 				require.Equal(t, http.StatusConflict, recorder.Code)
 			},
 		},
+		{
+			name: "Dynamic segment",
+			input: model.UserSegmentsInput{
+				UserID:        1,
+				SegmentsToAdd: []model.Slug{"SEGMENT-3"},
+			},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(database.ErrDynamicSegment)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name: "Exclusion group conflict",
+			input: model.UserSegmentsInput{
+				UserID:        1,
+				SegmentsToAdd: []model.Slug{"PROMO_5"},
+			},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(database.ErrGroupConflict)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name: "Prerequisite not satisfied",
+			input: model.UserSegmentsInput{
+				UserID:           1,
+				SegmentsToDelete: []model.Slug{"VERIFIED"},
+			},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(database.ErrPrerequisite)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
 		{
 			name: "User not found",
 			input: model.UserSegmentsInput{
@@ -267,7 +554,7 @@ func TestHTTPHandlers_UpdateUserSegments(t *testing.T) {
 			},
 			buildStubs: func(db *mock_database.MockIDatabase) {
 				db.EXPECT().
-					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
 					Return(database.ErrNotFound)
 			},
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
@@ -282,7 +569,7 @@ func TestHTTPHandlers_UpdateUserSegments(t *testing.T) {
 			},
 			buildStubs: func(db *mock_database.MockIDatabase) {
 				db.EXPECT().
-					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
 					Times(0)
 			},
 			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
@@ -353,6 +640,63 @@ func TestHTTPHandlers_GetActiveUserSegments(t *testing.T) {
 				require.Equal(t, http.StatusInternalServerError, recorder.Code)
 			},
 		},
+		{
+			name:  "Dynamic segments",
+			input: user,
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetUserWithActiveSegments(gomock.Any(), gomock.Any()).
+					Return(&user, nil)
+				db.EXPECT().
+					ListSegments(gomock.Any(), dynamicSegmentsFilter).
+					Return([]*model.Segment{
+						{Slug: "EVERYONE", Rollout: getSelection(1)},
+						{Slug: "NOBODY", Rollout: getSelection(0)},
+						{Slug: "STAFF", Rules: []model.SegmentRule{{Attribute: "user_id", Operator: "in", Values: []string{"1"}}}},
+						{Slug: "RU", Rules: []model.SegmentRule{{Attribute: "country", Operator: "in", Values: []string{"RU"}}}},
+						{Slug: "NOT_RU", Rules: []model.SegmentRule{{Attribute: "country", Operator: "not_in", Values: []string{"RU"}}}},
+						{Slug: "DELETED", Rollout: getSelection(1), DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}},
+					}, nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `["SEGMENT-A", "SEGMENT-B", "EVERYONE", "STAFF"]`, recorder.Body.String())
+			},
+		},
+		{
+			name:  "Active windows",
+			input: user,
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				started := time.Now().Add(-time.Hour).Truncate(time.Second)
+				tomorrow, yesterday := time.Now().Add(24*time.Hour), time.Now().Add(-24*time.Hour)
+				windowed := model.User{
+					Segments: []model.Segment{
+						{Slug: "STARTED", ActiveFrom: &started, ActiveUntil: &tomorrow},
+						{Slug: "UPCOMING", ActiveFrom: &tomorrow},
+						{Slug: "ENDED", ActiveUntil: &yesterday},
+					},
+					SegmentsUpdatedAt: yesterday.Add(-time.Hour),
+				}
+				windowed.ID = user.ID
+				db.EXPECT().
+					GetUserWithActiveSegments(gomock.Any(), gomock.Any()).
+					Return(&windowed, nil)
+				db.EXPECT().
+					ListSegments(gomock.Any(), dynamicSegmentsFilter).
+					Return([]*model.Segment{
+						{Slug: "EVERYONE", Rollout: getSelection(1), ActiveUntil: &tomorrow},
+						{Slug: "EVERYONE_LATER", Rollout: getSelection(1), ActiveFrom: &tomorrow},
+					}, nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `["STARTED", "EVERYONE"]`, recorder.Body.String())
+				// the window start is the latest change of the user's segments
+				lastModified, err := http.ParseTime(recorder.Header().Get("Last-Modified"))
+				require.NoError(t, err)
+				require.WithinDuration(t, time.Now().Add(-time.Hour), lastModified, time.Minute)
+			},
+		},
 		{
 			name:  "User not found",
 			input: user,
@@ -388,13 +732,686 @@ func TestHTTPHandlers_GetActiveUserSegments(t *testing.T) {
 	}
 }
 
-//func requireUserSegmentsEqual(t *testing.T, body *bytes.Buffer, segments model.Segments) {
-//	data, err := io.ReadAll(body)
-//	require.NoError(t, err)
-//
-//	var gotSegments model.Segments
-//	err = json.Unmarshal(data, &gotSegments)
-//	require.NoError(t, err)
-//
-//	require.Equal(t, segments, gotSegments) //todo: check slugs sets
-//}
+func TestHTTPHandlers_GetActiveUserSegmentsNotModified(t *testing.T) {
+	var user model.User
+	user.ID = 1
+	user.Segments = []model.Segment{{Slug: "SEGMENT-A"}}
+	user.SegmentsUpdatedAt = time.Date(2023, 8, 31, 12, 0, 0, 0, time.UTC)
+
+	etag := userSegmentsETag(user.ID, user.SegmentsUpdatedAt, model.Slugs{"SEGMENT-A"})
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		expectedCode int
+	}{
+		{
+			name:         "No validators",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "ETag matches",
+			headers:      map[string]string{"If-None-Match": etag},
+			expectedCode: http.StatusNotModified,
+		},
+		{
+			name:         "ETag doesn't match",
+			headers:      map[string]string{"If-None-Match": `"1-0"`},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Not modified since",
+			headers:      map[string]string{"If-Modified-Since": user.SegmentsUpdatedAt.Format(http.TimeFormat)},
+			expectedCode: http.StatusNotModified,
+		},
+		{
+			name:         "Modified since",
+			headers:      map[string]string{"If-Modified-Since": user.SegmentsUpdatedAt.Add(-time.Hour).Format(http.TimeFormat)},
+			expectedCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetUserWithActiveSegments(gomock.Any(), gomock.Any()).
+					Return(&user, nil)
+			})
+			recorder := httptest.NewRecorder()
+
+			request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/segments/user/%d", user.ID), nil)
+			require.NoError(t, err)
+			for key, value := range tt.headers {
+				request.Header.Set(key, value)
+			}
+
+			handler.ServeHTTP(recorder, request)
+			require.Equal(t, tt.expectedCode, recorder.Code)
+			require.Equal(t, etag, recorder.Header().Get("ETag"))
+			require.Equal(t, "public, no-cache", recorder.Header().Get("Cache-Control"))
+			require.Equal(t, "Authorization, X-API-Key", recorder.Header().Get("Vary"))
+			if tt.expectedCode == http.StatusNotModified {
+				require.Empty(t, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestUserSegmentsETag(t *testing.T) {
+	updatedAt := time.Date(2023, 8, 31, 12, 0, 0, 0, time.UTC)
+
+	// callers with different grants see different segments of the same user
+	all := userSegmentsETag(1, updatedAt, model.Slugs{"SEGMENT-A", "SEGMENT-B"})
+	require.NotEqual(t, all, userSegmentsETag(1, updatedAt, model.Slugs{"SEGMENT-A"}))
+	require.NotEqual(t, all, userSegmentsETag(1, updatedAt, model.Slugs{"SEGMENT-AS", "EGMENT-B"}))
+	require.Equal(t, all, userSegmentsETag(1, updatedAt, model.Slugs{"SEGMENT-A", "SEGMENT-B"}))
+}
+
+func TestHTTPHandlers_GetSegmentsSnapshot(t *testing.T) {
+	explicit := &model.Segment{Slug: "PROMO_5", Version: 3}
+	dynamic := &model.Segment{Slug: "VOICE_MSG", Version: 5, Rollout: getSelection(0.2)}
+	deleted := &model.Segment{Slug: "OLD", Version: 6, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}
+
+	tests := []struct {
+		name          string
+		query         string
+		buildStubs    func(db *mock_database.MockIDatabase)
+		checkResponse func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name: "Full",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(6), nil)
+				db.EXPECT().ListSegments(gomock.Any(), model.SegmentsFilter{}).Return([]*model.Segment{explicit, dynamic}, nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `{"version": 6, "full": true, "segments": [
+					{"slug": "PROMO_5", "version": 3, "explicit": true},
+					{"slug": "VOICE_MSG", "version": 5, "explicit": false, "rollout": 0.2}
+				]}`, recorder.Body.String())
+			},
+		},
+		{
+			name:  "Delta",
+			query: "?since=4",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(6), nil)
+				db.EXPECT().
+					ListSegments(gomock.Any(), model.SegmentsFilter{SinceVersion: 4, WithDeleted: true}).
+					Return([]*model.Segment{dynamic, deleted}, nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `{"version": 6, "full": false, "segments": [
+					{"slug": "VOICE_MSG", "version": 5, "explicit": false, "rollout": 0.2},
+					{"slug": "OLD", "version": 6, "explicit": true, "deleted": true}
+				]}`, recorder.Body.String())
+			},
+		},
+		{
+			name:  "Segment changed after version is read",
+			query: "?since=6",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(6), nil)
+				db.EXPECT().
+					ListSegments(gomock.Any(), model.SegmentsFilter{SinceVersion: 6, WithDeleted: true}).
+					Return([]*model.Segment{{Slug: "NEW", Version: 7}}, nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `{"version": 7, "full": false, "segments": [
+					{"slug": "NEW", "version": 7, "explicit": true}
+				]}`, recorder.Body.String())
+			},
+		},
+		{
+			name:  "Client ahead of storage gets full snapshot",
+			query: "?since=100",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(6), nil)
+				db.EXPECT().ListSegments(gomock.Any(), model.SegmentsFilter{}).Return([]*model.Segment{}, nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `{"version": 6, "full": true, "segments": []}`, recorder.Body.String())
+			},
+		},
+		{
+			name:       "Invalid version",
+			query:      "?since=-1",
+			buildStubs: func(db *mock_database.MockIDatabase) {},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			handler := setupHandler(t, ctrl, tt.buildStubs)
+			recorder := httptest.NewRecorder()
+
+			request, err := http.NewRequest(http.MethodGet, "/api/v1/segments/snapshot"+tt.query, nil)
+			require.NoError(t, err)
+
+			handler.ServeHTTP(recorder, request)
+			tt.checkResponse(t, recorder)
+		})
+	}
+}
+
+func TestHTTPHandlers_CreateExperiment(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         model.CreateExperimentInput
+		buildStubs    func(db *mock_database.MockIDatabase)
+		checkResponse func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name: "OK",
+			input: model.CreateExperimentInput{Slug: "checkout", Variants: []model.ExperimentVariantInput{
+				{Slug: "checkout_a", Weight: 0.5}, {Slug: "checkout_b", Weight: 0.25},
+			}},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().CreateExperiment(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(ctx context.Context, experiment *model.Experiment) (*model.Experiment, error) {
+						require.Equal(t, model.Slug("CHECKOUT"), experiment.Slug)
+						require.Len(t, experiment.Variants, 2)
+						require.Equal(t, &evaluation.Variant{
+							Experiment: "CHECKOUT", Weight: 0.5, Ranges: []evaluation.Range{{From: 0, To: 5000}},
+						}, experiment.Variants[0].Variant)
+						require.Equal(t, &evaluation.Variant{
+							Experiment: "CHECKOUT", Weight: 0.25, Ranges: []evaluation.Range{{From: 5000, To: 7500}},
+						}, experiment.Variants[1].Variant)
+						return experiment, nil
+					})
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `{"slug": "CHECKOUT", "variants": [
+					{"slug": "CHECKOUT_A", "weight": 0.5},
+					{"slug": "CHECKOUT_B", "weight": 0.25}
+				]}`, recorder.Body.String())
+			},
+		},
+		{
+			name:  "Already exists",
+			input: model.CreateExperimentInput{Slug: "CHECKOUT", Variants: []model.ExperimentVariantInput{{Slug: "CHECKOUT_A", Weight: 1}}},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().CreateExperiment(gomock.Any(), gomock.Any()).Return(nil, database.ErrAlreadyExists)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name: "Weights sum is greater than 1",
+			input: model.CreateExperimentInput{Slug: "CHECKOUT", Variants: []model.ExperimentVariantInput{
+				{Slug: "CHECKOUT_A", Weight: 0.6}, {Slug: "CHECKOUT_B", Weight: 0.6},
+			}},
+			buildStubs: func(db *mock_database.MockIDatabase) {},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name: "Duplicated variant",
+			input: model.CreateExperimentInput{Slug: "CHECKOUT", Variants: []model.ExperimentVariantInput{
+				{Slug: "CHECKOUT_A", Weight: 0.5}, {Slug: "checkout_a", Weight: 0.5},
+			}},
+			buildStubs: func(db *mock_database.MockIDatabase) {},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:       "No variants",
+			input:      model.CreateExperimentInput{Slug: "CHECKOUT"},
+			buildStubs: func(db *mock_database.MockIDatabase) {},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			handler := setupHandler(t, ctrl, tt.buildStubs)
+			recorder := httptest.NewRecorder()
+
+			data, err := json.Marshal(tt.input)
+			require.NoError(t, err)
+
+			request, err := http.NewRequest(http.MethodPost, "/api/v1/experiments", bytes.NewBuffer(data))
+			require.NoError(t, err)
+			request.Header.Set("Content-Type", "application/json")
+
+			handler.ServeHTTP(recorder, request)
+			tt.checkResponse(t, recorder)
+		})
+	}
+}
+
+func TestHTTPHandlers_UpdateExperimentWeights(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         model.ExperimentWeightsInput
+		buildStubs    func(db *mock_database.MockIDatabase)
+		checkResponse func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:  "OK",
+			input: model.ExperimentWeightsInput{Variants: []model.ExperimentVariantInput{{Slug: "checkout_b", Weight: 0.5}}},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					UpdateExperimentWeights(gomock.Any(), &model.Experiment{Slug: "CHECKOUT"}, map[model.Slug]float64{"CHECKOUT_B": 0.5}).
+					Return(&model.Experiment{Slug: "CHECKOUT", Variants: []model.Segment{
+						{Slug: "CHECKOUT_A", Variant: &evaluation.Variant{Experiment: "CHECKOUT", Weight: 0.5}},
+						{Slug: "CHECKOUT_B", Variant: &evaluation.Variant{Experiment: "CHECKOUT", Weight: 0.5}},
+					}}, nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `{"slug": "CHECKOUT", "variants": [
+					{"slug": "CHECKOUT_A", "weight": 0.5},
+					{"slug": "CHECKOUT_B", "weight": 0.5}
+				]}`, recorder.Body.String())
+			},
+		},
+		{
+			name:  "Unknown variant",
+			input: model.ExperimentWeightsInput{Variants: []model.ExperimentVariantInput{{Slug: "PROMO_5", Weight: 0.5}}},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().UpdateExperimentWeights(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, database.ErrNotFound)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNotFound, recorder.Code)
+			},
+		},
+		{
+			name:  "Weights sum with kept weights is greater than 1",
+			input: model.ExperimentWeightsInput{Variants: []model.ExperimentVariantInput{{Slug: "CHECKOUT_B", Weight: 0.8}}},
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					UpdateExperimentWeights(gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(nil, fmt.Errorf("%w: sum 1.3 is greater than 1", evaluation.ErrInvalidWeights))
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			handler := setupHandler(t, ctrl, tt.buildStubs)
+			recorder := httptest.NewRecorder()
+
+			data, err := json.Marshal(tt.input)
+			require.NoError(t, err)
+
+			request, err := http.NewRequest(http.MethodPut, "/api/v1/experiments/checkout/weights", bytes.NewBuffer(data))
+			require.NoError(t, err)
+			request.Header.Set("Content-Type", "application/json")
+
+			handler.ServeHTTP(recorder, request)
+			tt.checkResponse(t, recorder)
+		})
+	}
+}
+
+func TestHTTPHandlers_GetUserVariants(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+		db.EXPECT().ListExperiments(gomock.Any()).Return([]*model.Experiment{
+			{Slug: "CHECKOUT", Variants: []model.Segment{
+				{Slug: "CHECKOUT_A", Variant: &evaluation.Variant{Experiment: "CHECKOUT", Weight: 0}},
+				{Slug: "CHECKOUT_B", Variant: &evaluation.Variant{
+					Experiment: "CHECKOUT", Weight: 1, Ranges: []evaluation.Range{{From: 0, To: evaluation.Buckets}},
+				}},
+			}},
+			{Slug: "SEARCH", Variants: []model.Segment{
+				{Slug: "SEARCH_A", Variant: &evaluation.Variant{Experiment: "SEARCH", Weight: 0}},
+			}},
+		}, nil)
+	})
+	recorder := httptest.NewRecorder()
+
+	request, err := http.NewRequest(http.MethodGet, "/api/v1/experiments/user/1000", nil)
+	require.NoError(t, err)
+
+	handler.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.JSONEq(t, `[
+		{"experiment": "CHECKOUT", "variant": "CHECKOUT_B"},
+		{"experiment": "SEARCH", "variant": null}
+	]`, recorder.Body.String())
+}
+
+func TestHTTPHandlers_ExclusionGroups(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		body          string
+		buildStubs    func(db *mock_database.MockIDatabase)
+		checkResponse func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:   "Create",
+			method: http.MethodPost,
+			url:    "/api/v1/groups",
+			body:   `{"slug": "promo_discounts", "segments": ["PROMO_5", "avito_sales_20"]}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					CreateExclusionGroup(
+						gomock.Any(),
+						&model.ExclusionGroup{Slug: "PROMO_DISCOUNTS", Policy: model.GroupPolicyReject},
+						[]model.Slug{"PROMO_5", "AVITO_SALES_20"},
+					).
+					DoAndReturn(func(ctx context.Context, group *model.ExclusionGroup, slugs []model.Slug) (*model.ExclusionGroup, error) {
+						group.Segments = []model.Segment{{Slug: "PROMO_5"}, {Slug: "AVITO_SALES_20"}}
+						return group, nil
+					})
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `{"slug": "PROMO_DISCOUNTS", "policy": "reject", "segments": ["PROMO_5", "AVITO_SALES_20"]}`,
+					recorder.Body.String())
+			},
+		},
+		{
+			name:   "Create with shared users",
+			method: http.MethodPost,
+			url:    "/api/v1/groups",
+			body:   `{"slug": "PROMO_DISCOUNTS", "policy": "replace", "segments": ["PROMO_5", "AVITO_SALES_20"]}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().CreateExclusionGroup(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, database.ErrGroupConflict)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:       "Create with unknown policy",
+			method:     http.MethodPost,
+			url:        "/api/v1/groups",
+			body:       `{"slug": "PROMO_DISCOUNTS", "policy": "merge", "segments": ["PROMO_5"]}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:   "Get",
+			method: http.MethodGet,
+			url:    "/api/v1/groups/promo_discounts",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetExclusionGroup(gomock.Any(), &model.ExclusionGroup{Slug: "PROMO_DISCOUNTS"}).
+					Return(&model.ExclusionGroup{
+						Slug:     "PROMO_DISCOUNTS",
+						Policy:   model.GroupPolicyReplace,
+						Segments: []model.Segment{{Slug: "AVITO_SALES_20"}, {Slug: "PROMO_5"}},
+					}, nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `{"slug": "PROMO_DISCOUNTS", "policy": "replace", "segments": ["AVITO_SALES_20", "PROMO_5"]}`,
+					recorder.Body.String())
+			},
+		},
+		{
+			name:   "Delete unknown",
+			method: http.MethodDelete,
+			url:    "/api/v1/groups/PROMO_DISCOUNTS",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().DeleteExclusionGroup(gomock.Any(), &model.ExclusionGroup{Slug: "PROMO_DISCOUNTS"}).Return(database.ErrNotFound)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusNotFound, recorder.Code)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			handler := setupHandler(t, ctrl, tt.buildStubs)
+			recorder := httptest.NewRecorder()
+
+			request, err := http.NewRequest(tt.method, tt.url, bytes.NewBufferString(tt.body))
+			require.NoError(t, err)
+			request.Header.Set("Content-Type", "application/json")
+
+			handler.ServeHTTP(recorder, request)
+			tt.checkResponse(t, recorder)
+		})
+	}
+}
+
+func TestHTTPHandlers_SegmentPrerequisites(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		body          string
+		buildStubs    func(db *mock_database.MockIDatabase)
+		checkResponse func(t *testing.T, recorder *httptest.ResponseRecorder)
+	}{
+		{
+			name:   "Get",
+			method: http.MethodGet,
+			url:    "/api/v1/segment/promo_5/prerequisites",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().
+					GetSegmentPrerequisites(gomock.Any(), &model.Segment{Slug: "PROMO_5"}).
+					Return(&model.Segment{
+						Slug: "PROMO_5",
+						Prerequisites: []model.SegmentPrerequisite{
+							{Prerequisite: &model.Segment{Slug: "VERIFIED"}, OnRemove: model.PrerequisiteCascade},
+						},
+					}, nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+				require.JSONEq(t, `{"slug": "PROMO_5", "prerequisites": [{"slug": "VERIFIED", "on_remove": "cascade"}]}`,
+					recorder.Body.String())
+			},
+		},
+		{
+			name:   "Set",
+			method: http.MethodPut,
+			url:    "/api/v1/segment/promo_5/prerequisites",
+			body:   `{"prerequisites": [{"slug": "verified"}]}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				segment := &model.Segment{ID: 5, Slug: "PROMO_5"}
+				db.EXPECT().GetSegments(gomock.Any(), []model.Slug{"PROMO_5"}).Return([]*model.Segment{segment}, nil)
+				db.EXPECT().
+					SetSegmentPrerequisites(gomock.Any(), segment, []model.PrerequisiteInput{
+						{Slug: "VERIFIED", OnRemove: model.PrerequisiteReject},
+					}).
+					Return(nil)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusOK, recorder.Code)
+			},
+		},
+		{
+			name:   "Set cycle",
+			method: http.MethodPut,
+			url:    "/api/v1/segment/PROMO_5/prerequisites",
+			body:   `{"prerequisites": [{"slug": "VERIFIED", "on_remove": "cascade"}]}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetSegments(gomock.Any(), gomock.Any()).Return([]*model.Segment{{ID: 5, Slug: "PROMO_5"}}, nil)
+				db.EXPECT().SetSegmentPrerequisites(gomock.Any(), gomock.Any(), gomock.Any()).Return(database.ErrPrerequisiteCycle)
+			},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusConflict, recorder.Code)
+			},
+		},
+		{
+			name:       "Set self",
+			method:     http.MethodPut,
+			url:        "/api/v1/segment/PROMO_5/prerequisites",
+			body:       `{"prerequisites": [{"slug": "promo_5"}]}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+		{
+			name:       "Set unknown policy",
+			method:     http.MethodPut,
+			url:        "/api/v1/segment/PROMO_5/prerequisites",
+			body:       `{"prerequisites": [{"slug": "VERIFIED", "on_remove": "ignore"}]}`,
+			buildStubs: func(db *mock_database.MockIDatabase) {},
+			checkResponse: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				require.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			handler := setupHandler(t, ctrl, tt.buildStubs)
+			recorder := httptest.NewRecorder()
+
+			request, err := http.NewRequest(tt.method, tt.url, bytes.NewBufferString(tt.body))
+			require.NoError(t, err)
+			request.Header.Set("Content-Type", "application/json")
+
+			handler.ServeHTTP(recorder, request)
+			tt.checkResponse(t, recorder)
+		})
+	}
+}
+
+func TestHTTPHandlers_StreamEvents(t *testing.T) {
+	userID := uint64(1)
+	events := []model.Event{
+		{ID: 6, Type: model.EventAdd, UserID: &userID, SegmentSlug: "SEGMENT-A"},
+		{ID: 7, Type: model.EventDelete, UserID: &userID, SegmentSlug: "SEGMENT-B"},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+		first := db.EXPECT().
+			GetEvents(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, filter model.EventsFilter) ([]model.Event, error) {
+				require.Equal(t, uint64(5), filter.AfterID)
+				require.Equal(t, userID, *filter.UserID)
+				return events, nil
+			})
+		db.EXPECT().
+			GetEvents(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, filter model.EventsFilter) ([]model.Event, error) {
+				require.Equal(t, uint64(7), filter.AfterID)
+				return nil, nil
+			}).
+			After(first).
+			AnyTimes()
+	})
+	recorder := httptest.NewRecorder()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/v1/events/stream?user_id=1", nil)
+	require.NoError(t, err)
+	request.Header.Set("Last-Event-ID", "5")
+
+	handler.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+	body := recorder.Body.String()
+	require.Contains(t, body, "id: 6\nevent: add\ndata: ")
+	require.Contains(t, body, "id: 7\nevent: delete\ndata: ")
+}
+
+//func requireUserSegmentsEqual(t *testing.T, body *bytes.Buffer, segments model.Segments) {
+//	data, err := io.ReadAll(body)
+//	require.NoError(t, err)
+//
+//	var gotSegments model.Segments
+//	err = json.Unmarshal(data, &gotSegments)
+//	require.NoError(t, err)
+//
+//	require.Equal(t, segments, gotSegments) //todo: check slugs sets
+//}
+
+func TestHTTPHandlers_Metrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+		db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	})
+
+	request, err := http.NewRequest(http.MethodDelete, "/api/v1/segment/SEGMENT-SLUG", nil)
+	require.NoError(t, err)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	recorder := httptest.NewRecorder()
+	request, err = http.NewRequest(http.MethodGet, "/metrics", nil)
+	require.NoError(t, err)
+	handler.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Contains(
+		t,
+		recorder.Body.String(),
+		`segapp_http_requests_total{method="DELETE",route="/api/v1/segment/{slug}",status="200"}`,
+	)
+}
+
+func TestHTTPHandlers_Health(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+		db.EXPECT().Ping(gomock.Any()).Return(nil)
+		db.EXPECT().GetSchemaVersion(gomock.Any()).Return(database.SchemaVersion, nil)
+	})
+
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest(http.MethodGet, "/healthz", nil)
+	require.NoError(t, err)
+	handler.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	// workers pool of the test handler is not running
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest(http.MethodGet, "/readyz", nil)
+	require.NoError(t, err)
+	handler.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	var output model.HealthOutput
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&output))
+	require.Equal(t, model.HealthNotReady, output.Status)
+	require.Equal(t, map[string]string{
+		services.CheckDatabase: model.HealthOK,
+		services.CheckSchema:   model.HealthOK,
+		services.CheckFiles:    model.HealthOK,
+		services.CheckWorkers:  model.HealthFailed,
+	}, output.Checks)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/unbeman/av-prac-task/internal/logging"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/services"
+)
+
+// Idempotency headers. Clients pass unique IdempotencyKeyHeader with mutating request to retry it safely,
+// replayed responses are marked with IdempotentReplayedHeader.
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// idempotent returns middleware that executes request with Idempotency-Key only once
+// and replays its response to retries. Failed with server error or panicked requests are not saved,
+// their key is released to retry.
+func (h HTTPHandler) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		key := request.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		if err := model.ValidateIdempotencyKey(key); err != nil {
+			h.processError(writer, request, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+			return
+		}
+
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			h.processError(writer, request, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+			return
+		}
+		request.Body = io.NopCloser(bytes.NewReader(body))
+
+		record, replay, err := h.idempotencyService.Begin(
+			request.Context(),
+			idempotencyScope(request)+key,
+			requestFingerprint(request, body),
+		)
+		if err != nil {
+			h.processError(writer, request, err)
+			return
+		}
+
+		if replay {
+			if record.ContentType != "" {
+				writer.Header().Set("Content-Type", record.ContentType)
+			}
+			writer.Header().Set(IdempotentReplayedHeader, "true")
+			writer.WriteHeader(record.StatusCode)
+			writer.Write(record.Body)
+			return
+		}
+
+		defer func() {
+			if rvr := recover(); rvr != nil {
+				if err := h.idempotencyService.Release(context.Background(), record); err != nil {
+					logging.FromContext(request.Context()).Errorf("idempotency: can't release key %s: %v", key, err)
+				}
+				panic(rvr)
+			}
+		}()
+
+		response := &bytes.Buffer{}
+		ww := middleware.NewWrapResponseWriter(writer, request.ProtoMajor)
+		ww.Tee(response)
+
+		next.ServeHTTP(ww, request)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		// the record is saved even if the client has gone, it is going to retry
+		ctx := context.Background()
+		if status >= http.StatusInternalServerError {
+			err = h.idempotencyService.Release(ctx, record)
+		} else {
+			err = h.idempotencyService.Complete(ctx, record, status, ww.Header().Get("Content-Type"), response.Bytes())
+		}
+		if err != nil {
+			logging.FromContext(request.Context()).Errorf("idempotency: can't save response for key %s: %v", key, err)
+		}
+	})
+}
+
+// idempotencyScope separates keys of authenticated clients.
+func idempotencyScope(request *http.Request) string {
+	if principal, ok := services.PrincipalFromContext(request.Context()); ok {
+		return principal.ClientID + ":"
+	}
+	return ""
+}
+
+// requestFingerprint returns hash of the request method, path and body.
+func requestFingerprint(request *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(request.Method + " " + request.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
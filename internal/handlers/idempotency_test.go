@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/database"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+// stubIdempotencyRecords makes mock database keep idempotency records in the map.
+func stubIdempotencyRecords(db *mock_database.MockIDatabase, records map[string]*model.IdempotencyRecord) {
+	db.EXPECT().
+		CreateIdempotencyRecord(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, record *model.IdempotencyRecord) (*model.IdempotencyRecord, bool, error) {
+			if existing, ok := records[record.Key]; ok {
+				return existing, false, nil
+			}
+			records[record.Key] = record
+			return record, true, nil
+		}).
+		AnyTimes()
+	db.EXPECT().
+		CompleteIdempotencyRecord(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, record *model.IdempotencyRecord) error {
+			record.Completed = true
+			return nil
+		}).
+		AnyTimes()
+	db.EXPECT().
+		DeleteIdempotencyRecord(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, record *model.IdempotencyRecord) error {
+			delete(records, record.Key)
+			return nil
+		}).
+		AnyTimes()
+}
+
+func TestHTTPHandlers_Idempotency(t *testing.T) {
+	segment := model.Segment{Slug: "SEGMENT-SLUG"}
+
+	createSegment := func(handler *HTTPHandler, key string, input model.CreateSegmentInput) *httptest.ResponseRecorder {
+		data, err := json.Marshal(input)
+		require.NoError(t, err)
+
+		request, err := http.NewRequest(http.MethodPost, "/api/v1/segment", bytes.NewBuffer(data))
+		require.NoError(t, err)
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(IdempotencyKeyHeader, key)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	t.Run("Retry is replayed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+			stubIdempotencyRecords(db, map[string]*model.IdempotencyRecord{})
+			db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(&segment, nil).Times(1)
+		})
+
+		input := model.CreateSegmentInput{Slug: segment.Slug}
+		first := createSegment(handler, "key-1", input)
+		require.Equal(t, http.StatusOK, first.Code)
+		require.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+
+		retry := createSegment(handler, "key-1", input)
+		require.Equal(t, http.StatusOK, retry.Code)
+		require.Equal(t, "true", retry.Header().Get(IdempotentReplayedHeader))
+		require.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+		require.Equal(t, first.Body.String(), retry.Body.String())
+	})
+
+	t.Run("Reused key with other body", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+			stubIdempotencyRecords(db, map[string]*model.IdempotencyRecord{})
+			db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(&segment, nil).Times(1)
+		})
+
+		require.Equal(t, http.StatusOK, createSegment(handler, "key-1", model.CreateSegmentInput{Slug: segment.Slug}).Code)
+		recorder := createSegment(handler, "key-1", model.CreateSegmentInput{Slug: "OTHER"})
+		require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	})
+
+	t.Run("Request in progress", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		input := model.CreateSegmentInput{Slug: segment.Slug}
+		data, err := json.Marshal(input)
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/segment", nil)
+
+		handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+			stubIdempotencyRecords(db, map[string]*model.IdempotencyRecord{
+				"key-1": {Key: "key-1", Fingerprint: requestFingerprint(request, data)},
+			})
+			db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Times(0)
+		})
+
+		require.Equal(t, http.StatusConflict, createSegment(handler, "key-1", input).Code)
+	})
+
+	t.Run("Failed request can be retried", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+			stubIdempotencyRecords(db, map[string]*model.IdempotencyRecord{})
+			gomock.InOrder(
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(nil, database.ErrDB),
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(&segment, nil),
+			)
+		})
+
+		input := model.CreateSegmentInput{Slug: segment.Slug}
+		require.Equal(t, http.StatusInternalServerError, createSegment(handler, "key-1", input).Code)
+		require.Equal(t, http.StatusOK, createSegment(handler, "key-1", input).Code)
+	})
+
+	t.Run("Panicked request can be retried", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+			stubIdempotencyRecords(db, map[string]*model.IdempotencyRecord{})
+			gomock.InOrder(
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(context.Context, *model.Segment) (*model.Segment, error) {
+						panic("unexpected")
+					}),
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(&segment, nil),
+			)
+		})
+
+		input := model.CreateSegmentInput{Slug: segment.Slug}
+		require.Panics(t, func() { createSegment(handler, "key-1", input) })
+		require.Equal(t, http.StatusOK, createSegment(handler, "key-1", input).Code)
+	})
+
+	t.Run("Conflict is saved too", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+			stubIdempotencyRecords(db, map[string]*model.IdempotencyRecord{})
+			db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Return(nil, database.ErrAlreadyExists).Times(1)
+		})
+
+		input := model.CreateSegmentInput{Slug: segment.Slug}
+		require.Equal(t, http.StatusConflict, createSegment(handler, "key-1", input).Code)
+
+		retry := createSegment(handler, "key-1", input)
+		require.Equal(t, http.StatusConflict, retry.Code)
+		require.Equal(t, "true", retry.Header().Get(IdempotentReplayedHeader))
+	})
+
+	t.Run("Invalid key", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		handler := setupHandler(t, ctrl, func(db *mock_database.MockIDatabase) {
+			db.EXPECT().CreateIdempotencyRecord(gomock.Any(), gomock.Any()).Times(0)
+			db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).Times(0)
+		})
+
+		recorder := createSegment(handler, strings.Repeat("k", model.IdempotencyKeyMaxLength+1), model.CreateSegmentInput{Slug: segment.Slug})
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+}
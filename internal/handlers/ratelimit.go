@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/unbeman/av-prac-task/internal/services"
+)
+
+// Rate limit headers, see draft-ietf-httpapi-ratelimit-headers.
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+	RetryAfterHeader         = "Retry-After"
+)
+
+// rateLimited returns middleware that limits requests of the route group per API client.
+// Clients are identified by API key or JWT subject of authenticated principal or by IP address as a fallback.
+func (h HTTPHandler) rateLimited(group string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			result := h.limiter.Allow(group, rateLimitKey(request))
+			if result.Limit == 0 {
+				next.ServeHTTP(writer, request)
+				return
+			}
+
+			writer.Header().Set(RateLimitLimitHeader, strconv.Itoa(result.Limit))
+			writer.Header().Set(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+			writer.Header().Set(RateLimitResetHeader, ceilSeconds(result.Reset))
+
+			if !result.Allowed {
+				writer.Header().Set(RetryAfterHeader, ceilSeconds(result.RetryAfter))
+				h.processError(writer, request, ErrTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
+
+func rateLimitKey(request *http.Request) string {
+	if principal, ok := services.PrincipalFromContext(request.Context()); ok {
+		return "client:" + principal.ClientID
+	}
+	return "ip:" + remoteIP(request)
+}
+
+// ceilSeconds formats duration as whole seconds rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
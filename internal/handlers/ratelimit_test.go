@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+func TestHTTPHandlers_RateLimit(t *testing.T) {
+	token := signTestJWT(t, testJWTSecret, "admin", time.Now().Add(time.Hour))
+
+	deleteSegment := func(handler *HTTPHandler, remoteAddr, bearer string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest(http.MethodDelete, "/api/v1/segment/SEGMENT-SLUG", nil)
+		require.NoError(t, err)
+		request.RemoteAddr = remoteAddr
+		if bearer != "" {
+			request.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	rateLimitCfg := config.NewRateLimitConfig()
+	rateLimitCfg.Enabled = true
+	rateLimitCfg.Write = config.RateLimit{Rate: 0.1, Burst: 2}
+
+	t.Run("Limited by IP", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		authCfg := config.NewAuthConfig()
+		authCfg.Enabled = false
+
+		handler := setupHandlerWithLimits(t, ctrl, func(db *mock_database.MockIDatabase) {
+			db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(3)
+		}, authCfg, rateLimitCfg)
+
+		recorder := deleteSegment(handler, "10.0.0.1:1234", "")
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "2", recorder.Header().Get(RateLimitLimitHeader))
+		require.Equal(t, "1", recorder.Header().Get(RateLimitRemainingHeader))
+		require.Equal(t, "10", recorder.Header().Get(RateLimitResetHeader))
+
+		recorder = deleteSegment(handler, "10.0.0.1:1235", "")
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "0", recorder.Header().Get(RateLimitRemainingHeader))
+
+		recorder = deleteSegment(handler, "10.0.0.1:1236", "")
+		require.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		require.Equal(t, "10", recorder.Header().Get(RetryAfterHeader))
+
+		recorder = deleteSegment(handler, "10.0.0.2:1234", "")
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("Limited by client", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		authCfg := config.NewAuthConfig()
+		authCfg.JWTSecret = testJWTSecret
+
+		handler := setupHandlerWithLimits(t, ctrl, func(db *mock_database.MockIDatabase) {
+			db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+		}, authCfg, rateLimitCfg)
+
+		require.Equal(t, http.StatusOK, deleteSegment(handler, "10.0.0.1:1234", token).Code)
+		require.Equal(t, http.StatusOK, deleteSegment(handler, "10.0.0.2:1234", token).Code)
+
+		recorder := deleteSegment(handler, "10.0.0.3:1234", token)
+		require.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		require.NotEmpty(t, recorder.Header().Get(RetryAfterHeader))
+	})
+
+	t.Run("API keys with the same name are limited separately", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		authCfg := config.NewAuthConfig()
+		authCfg.JWTSecret = testJWTSecret
+
+		handler := setupHandlerWithLimits(t, ctrl, func(db *mock_database.MockIDatabase) {
+			db.EXPECT().
+				GetAPIKeyByHash(gomock.Any(), hashTestAPIKey("first-key")).
+				Return(&model.APIKey{ID: 1, Name: "promo-service", Scopes: "admin"}, nil).
+				AnyTimes()
+			db.EXPECT().
+				GetAPIKeyByHash(gomock.Any(), hashTestAPIKey("second-key")).
+				Return(&model.APIKey{ID: 2, Name: "promo-service", Scopes: "admin"}, nil).
+				AnyTimes()
+			db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(3)
+		}, authCfg, rateLimitCfg)
+
+		deleteSegmentWithKey := func(apiKey string) int {
+			recorder := httptest.NewRecorder()
+			request, err := http.NewRequest(http.MethodDelete, "/api/v1/segment/SEGMENT-SLUG", nil)
+			require.NoError(t, err)
+			request.Header.Set("X-API-Key", apiKey)
+			handler.ServeHTTP(recorder, request)
+			return recorder.Code
+		}
+
+		require.Equal(t, http.StatusOK, deleteSegmentWithKey("first-key"))
+		require.Equal(t, http.StatusOK, deleteSegmentWithKey("first-key"))
+		require.Equal(t, http.StatusTooManyRequests, deleteSegmentWithKey("first-key"))
+		require.Equal(t, http.StatusOK, deleteSegmentWithKey("second-key"))
+	})
+
+	t.Run("Groups are limited separately", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		authCfg := config.NewAuthConfig()
+		authCfg.Enabled = false
+
+		handler := setupHandlerWithLimits(t, ctrl, func(db *mock_database.MockIDatabase) {
+			db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(2)
+			db.EXPECT().GetWebhooks(gomock.Any()).Return(nil, nil)
+		}, authCfg, rateLimitCfg)
+
+		deleteSegment(handler, "10.0.0.1:1234", "")
+		deleteSegment(handler, "10.0.0.1:1234", "")
+
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest(http.MethodGet, "/api/v1/webhooks/", nil)
+		require.NoError(t, err)
+		request.RemoteAddr = "10.0.0.1:1234"
+		handler.ServeHTTP(recorder, request)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "100", recorder.Header().Get(RateLimitLimitHeader))
+	})
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+// GORMLogger writes GORM logs to logrus with request and trace ids of the query context.
+// Queries are logged at debug level, slow queries at warn level and failed ones at error level.
+// Queries are logged with placeholders instead of bound values, which may hold user data and secrets.
+type GORMLogger struct {
+	// SlowThreshold is the duration after which query is logged as slow, 0 disables it.
+	SlowThreshold time.Duration
+}
+
+// LogMode is no-op, logs are filtered by the logrus level.
+func (l GORMLogger) LogMode(logger.LogLevel) logger.Interface {
+	return l
+}
+
+func (l GORMLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	FromContext(ctx).Infof(msg, data...)
+}
+
+func (l GORMLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	FromContext(ctx).Warnf(msg, data...)
+}
+
+func (l GORMLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	FromContext(ctx).Errorf(msg, data...)
+}
+
+// ParamsFilter drops bound values of the query, so Trace gets SQL with placeholders.
+func (l GORMLogger) ParamsFilter(_ context.Context, sql string, _ ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
+
+// Trace logs the executed query.
+func (l GORMLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	elapsed := time.Since(begin)
+
+	var level log.Level
+	var msg string
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		level, msg = log.ErrorLevel, "database query failed"
+	case l.SlowThreshold > 0 && elapsed > l.SlowThreshold:
+		level, msg = log.WarnLevel, "slow database query"
+	default:
+		level, msg = log.DebugLevel, "database query"
+	}
+	if !log.IsLevelEnabled(level) {
+		return
+	}
+
+	sql, rows := fc()
+	entry := FromContext(ctx).WithFields(log.Fields{
+		"sql":         sql,
+		"rows":        rows,
+		"duration_ms": float64(elapsed.Microseconds()) / 1000,
+		"source":      utils.FileWithLineNum(),
+	})
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	entry.Log(level, msg)
+}
@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestGORMLogger_Trace(t *testing.T) {
+	sql := func() (string, int64) {
+		return `SELECT * FROM "users" WHERE "users"."id" = 1`, 1
+	}
+
+	tests := []struct {
+		name          string
+		level         log.Level
+		elapsed       time.Duration
+		err           error
+		expectedLevel log.Level
+		expectedMsg   string
+		notLogged     bool
+	}{
+		{
+			name:          "Query at debug level",
+			level:         log.DebugLevel,
+			elapsed:       time.Millisecond,
+			expectedLevel: log.DebugLevel,
+			expectedMsg:   "database query",
+		},
+		{
+			name:      "Query is not logged at info level",
+			level:     log.InfoLevel,
+			elapsed:   time.Millisecond,
+			notLogged: true,
+		},
+		{
+			name:          "Slow query",
+			level:         log.InfoLevel,
+			elapsed:       time.Second,
+			expectedLevel: log.WarnLevel,
+			expectedMsg:   "slow database query",
+		},
+		{
+			name:          "Failed query",
+			level:         log.InfoLevel,
+			elapsed:       time.Millisecond,
+			err:           errors.New("connection refused"),
+			expectedLevel: log.ErrorLevel,
+			expectedMsg:   "database query failed",
+		},
+		{
+			name:      "Record not found is not an error",
+			level:     log.InfoLevel,
+			elapsed:   time.Millisecond,
+			err:       gorm.ErrRecordNotFound,
+			notLogged: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := setupHook(t, tt.level)
+			logger := GORMLogger{SlowThreshold: 100 * time.Millisecond}
+
+			ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "req-1")
+			logger.Trace(ctx, time.Now().Add(-tt.elapsed), sql, tt.err)
+
+			if tt.notLogged {
+				require.Empty(t, hook.AllEntries())
+				return
+			}
+			entry := hook.LastEntry()
+			require.NotNil(t, entry)
+			require.Equal(t, tt.expectedLevel, entry.Level)
+			require.Equal(t, tt.expectedMsg, entry.Message)
+			require.Equal(t, "req-1", entry.Data[FieldRequestID])
+			require.Equal(t, `SELECT * FROM "users" WHERE "users"."id" = 1`, entry.Data["sql"])
+			require.Equal(t, int64(1), entry.Data["rows"])
+			if tt.err != nil {
+				require.Equal(t, tt.err, entry.Data[log.ErrorKey])
+			}
+		})
+	}
+}
+
+func TestGORMLogger_ParamsFilter(t *testing.T) {
+	hook := setupHook(t, log.DebugLevel)
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		Logger:               GORMLogger{},
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	require.NoError(t, err)
+
+	type token struct {
+		ID     uint64
+		Secret string
+	}
+	db.Where("secret = ?", "s3cr3t").First(&token{})
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	require.Contains(t, entry.Data["sql"], "secret = $1")
+	require.NotContains(t, entry.Data["sql"], "s3cr3t")
+}
@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	log "github.com/sirupsen/logrus"
+)
+
+// HTTPMiddleware logs completed requests with request and trace ids,
+// server errors are logged at error level.
+func HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(writer, request.ProtoMajor)
+
+		next.ServeHTTP(ww, request)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		fields := log.Fields{
+			"method":      request.Method,
+			"path":        request.URL.Path,
+			"status":      status,
+			"bytes":       ww.BytesWritten(),
+			"duration_ms": time.Since(start).Milliseconds(),
+			"remote_addr": request.RemoteAddr,
+			"user_agent":  request.UserAgent(),
+		}
+		if routeCtx := chi.RouteContext(request.Context()); routeCtx != nil && routeCtx.RoutePattern() != "" {
+			fields["route"] = routeCtx.RoutePattern()
+		}
+
+		entry := FromContext(request.Context()).WithFields(fields)
+		if status >= http.StatusInternalServerError {
+			entry.Error("request completed")
+			return
+		}
+		entry.Info("request completed")
+	})
+}
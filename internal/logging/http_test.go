@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	hook := setupHook(t, log.InfoLevel)
+
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID)
+	router.Use(HTTPMiddleware)
+	router.Get("/segments/user/{user_id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	})
+	router.Post("/segment", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/segments/user/1", nil)
+	request.Header.Set(middleware.RequestIDHeader, "client-request")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/segment", nil))
+
+	entries := hook.AllEntries()
+	require.Len(t, entries, 2)
+
+	get := entries[0]
+	require.Equal(t, log.InfoLevel, get.Level)
+	require.Equal(t, "client-request", get.Data[FieldRequestID])
+	require.Equal(t, http.MethodGet, get.Data["method"])
+	require.Equal(t, "/segments/user/1", get.Data["path"])
+	require.Equal(t, "/segments/user/{user_id}", get.Data["route"])
+	require.Equal(t, http.StatusOK, get.Data["status"])
+	require.Equal(t, 2, get.Data["bytes"])
+
+	post := entries[1]
+	require.Equal(t, log.ErrorLevel, post.Level)
+	require.Equal(t, http.StatusInternalServerError, post.Data["status"])
+	require.NotEmpty(t, post.Data[FieldRequestID])
+}
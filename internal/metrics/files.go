@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	reportFilesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "reports", "files"),
+		"Count of report files in the files directory.",
+		nil, nil,
+	)
+	reportBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "reports", "bytes"),
+		"Total size of report files in the files directory.",
+		nil, nil,
+	)
+)
+
+// ReportFilesCollector collects count and size of report files on scrape.
+type ReportFilesCollector struct {
+	dir string
+}
+
+// NewReportFilesCollector returns collector of report files in the directory.
+func NewReportFilesCollector(dir string) *ReportFilesCollector {
+	return &ReportFilesCollector{dir: dir}
+}
+
+// Describe implements prometheus.Collector interface method.
+func (c ReportFilesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- reportFilesDesc
+	ch <- reportBytesDesc
+}
+
+// Collect implements prometheus.Collector interface method.
+func (c ReportFilesCollector) Collect(ch chan<- prometheus.Metric) {
+	var count, size int64
+
+	err := filepath.WalkDir(c.dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		count++
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		log.Errorf("metrics: can't walk report files directory %s: %v", c.dir, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(reportFilesDesc, prometheus.GaugeValue, float64(count))
+	ch <- prometheus.MustNewConstMetric(reportBytesDesc, prometheus.GaugeValue, float64(size))
+}
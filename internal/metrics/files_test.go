@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportFilesCollector(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1_2023-08.csv"), []byte("12345"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "2_2023-08.csv"), []byte("123"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested"), 0o755))
+
+	expected := `
+# HELP segapp_reports_bytes Total size of report files in the files directory.
+# TYPE segapp_reports_bytes gauge
+segapp_reports_bytes 8
+# HELP segapp_reports_files Count of report files in the files directory.
+# TYPE segapp_reports_files gauge
+segapp_reports_files 2
+`
+	require.NoError(t, testutil.CollectAndCompare(NewReportFilesCollector(dir), strings.NewReader(expected)))
+}
+
+func TestRegister_Replaces(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1_2023-08.csv"), []byte("12345"), 0o644))
+
+	first := NewReportFilesCollector(t.TempDir())
+	require.NoError(t, Register(first))
+	second := NewReportFilesCollector(dir)
+	require.NoError(t, Register(second))
+	defer Registry.Unregister(second)
+
+	expected := `
+# HELP segapp_reports_files Count of report files in the files directory.
+# TYPE segapp_reports_files gauge
+segapp_reports_files 1
+`
+	require.NoError(t, testutil.GatherAndCompare(Registry, strings.NewReader(expected), "segapp_reports_files"))
+}
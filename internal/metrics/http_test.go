@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(HTTPMiddleware)
+	router.Route("/api/v1/", func(r chi.Router) {
+		r.Get("/segments/user/{user_id}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+		r.Post("/segment", func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	requests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/api/v1/segments/user/1"},
+		{http.MethodGet, "/api/v1/segments/user/2"},
+		{http.MethodPost, "/api/v1/segment"},
+		{http.MethodGet, "/unknown/path"},
+	}
+	for _, r := range requests {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(r.method, r.path, nil))
+	}
+
+	userRoute := "/api/v1/segments/user/{user_id}"
+	require.Equal(t, 2.0, testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, userRoute, "404")))
+	require.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodPost, "/api/v1/segment", "200")))
+	require.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, unmatchedRoute, "404")))
+	require.Equal(t, 3, testutil.CollectAndCount(httpRequestDuration))
+}
@@ -0,0 +1,50 @@
+// Package metrics describes Prometheus metrics of the application.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace is the prefix of all application metrics.
+const Namespace = "segapp"
+
+// Registry holds application metrics together with Go runtime and process ones.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpRequestDuration,
+		dbQueryDuration,
+		dbQueryErrors,
+		workerQueueDepth,
+		workerBusy,
+		workerCount,
+		workerTasks,
+		workerTaskDuration,
+	)
+}
+
+// Register adds collector to Registry. Collector registered before with the same metrics,
+// e.g. by the previous connection to the database, is replaced, so repeated setup doesn't fail.
+func Register(collector prometheus.Collector) error {
+	err := Registry.Register(collector)
+	var registered prometheus.AlreadyRegisteredError
+	if !errors.As(err, &registered) {
+		return err
+	}
+	Registry.Unregister(registered.ExistingCollector)
+	return Registry.Register(collector)
+}
+
+// Handler returns http handler that exposes metrics from Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
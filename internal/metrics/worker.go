@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Task outcomes.
+const (
+	OutcomeSucceeded = "success"
+	OutcomeFailed    = "failure"
+	OutcomeDropped   = "dropped"
+)
+
+var (
+	workerQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "queue_depth",
+		Help:      "Count of tasks waiting in the workers pool queue.",
+	})
+
+	workerBusy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "busy",
+		Help:      "Count of workers processing a task.",
+	})
+
+	workerCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "count",
+		Help:      "Count of running workers.",
+	})
+
+	workerTasks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "tasks_total",
+		Help:      "Count of processed tasks by task name and outcome.",
+	}, []string{"task", "outcome"})
+
+	workerTaskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "worker",
+		Name:      "task_duration_seconds",
+		Help:      "Duration of tasks processing by task name.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"task"})
+)
+
+// WorkersCount records count of running workers.
+func WorkersCount(n int) {
+	workerCount.Set(float64(n))
+}
+
+// TaskQueued records the task added to the queue.
+func TaskQueued() {
+	workerQueueDepth.Inc()
+}
+
+// TaskDropped records the task removed from the queue without processing.
+func TaskDropped(task string) {
+	workerQueueDepth.Dec()
+	workerTasks.WithLabelValues(task, OutcomeDropped).Inc()
+}
+
+// TaskStarted records the task taken from the queue by a worker.
+func TaskStarted() {
+	workerQueueDepth.Dec()
+	workerBusy.Inc()
+}
+
+// TaskFinished records the task processing outcome and duration.
+func TaskFinished(task string, duration time.Duration, err error) {
+	workerBusy.Dec()
+
+	outcome := OutcomeSucceeded
+	if err != nil {
+		outcome = OutcomeFailed
+	}
+	workerTasks.WithLabelValues(task, outcome).Inc()
+	workerTaskDuration.WithLabelValues(task).Observe(duration.Seconds())
+}
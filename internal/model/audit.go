@@ -0,0 +1,149 @@
+package model
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit actions of mutating API calls.
+const (
+	AuditSegmentCreate              = "segment.create"
+	AuditSegmentDelete              = "segment.delete"
+	AuditSegmentRestore             = "segment.restore"
+	AuditSegmentACLUpdate           = "segment.acl.update"
+	AuditSegmentPrerequisitesUpdate = "segment.prerequisites.update"
+	AuditUserSegmentsUpdate         = "user.segments.update"
+	AuditExperimentCreate           = "experiment.create"
+	AuditExperimentUpdate           = "experiment.update"
+	AuditGroupCreate                = "group.create"
+	AuditGroupDelete                = "group.delete"
+	AuditWebhookCreate              = "webhook.create"
+	AuditWebhookDelete              = "webhook.delete"
+	AuditAPIKeyCreate               = "apikey.create"
+	AuditAPIKeyDelete               = "apikey.delete"
+)
+
+// AuditRecord describes who performed mutating API call, on what and why.
+// Segments are stored comma separated.
+// The call is recorded along with a record per changed user membership, Change is the type
+// of the membership change and is empty for the call record.
+type AuditRecord struct {
+	ID           uint64    `json:"id" gorm:"primary_key"`
+	Action       string    `json:"action" example:"segment.delete"`
+	Actor        string    `json:"actor" gorm:"index" example:"crm-service"`
+	RequestID    string    `json:"request_id" example:"host/abcdef-000001"`
+	SourceIP     string    `json:"source_ip" example:"10.0.0.1"`
+	ForwardedFor string    `json:"forwarded_for,omitempty"`
+	Method       string    `json:"method" example:"DELETE"`
+	Path         string    `json:"path" example:"/api/v1/segment/PROMO_5"`
+	UserID       *uint64   `json:"user_id,omitempty" gorm:"index" example:"1"`
+	Segments     string    `json:"segments,omitempty" example:"PROMO_5"`
+	Reason       string    `json:"reason,omitempty" example:"promo is over"`
+	StatusCode   int       `json:"status_code" example:"200"`
+	Change       EventType `json:"change,omitempty" example:"delete"`
+	CreatedAt    time.Time `json:"created_at" gorm:"index"`
+}
+
+// AddSegments appends segments slugs to the record.
+func (a *AuditRecord) AddSegments(slugs ...Slug) {
+	parts := make([]string, 0, len(slugs)+1)
+	if a.Segments != "" {
+		parts = append(parts, a.Segments)
+	}
+	for _, slug := range slugs {
+		parts = append(parts, string(slug))
+	}
+	a.Segments = strings.Join(parts, ",")
+}
+
+type AuditRecords []AuditRecord
+
+// Render implements render.Render interface method.
+func (a AuditRecords) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Audit records query limits.
+const (
+	AuditLimitDefault = 100
+	AuditLimitMax     = 1000
+)
+
+// AuditFilter describes query params for audit records search.
+// Records are returned in descending id order, BeforeID is used for pagination.
+type AuditFilter struct {
+	Actor       string
+	SegmentSlug *Slug
+	UserID      *uint64
+	From        *time.Time
+	To          *time.Time
+	BeforeID    uint64
+	Limit       int
+}
+
+// FromURI gets and checks audit filter from query params.
+// Time range bounds are accepted in RFC 3339 or YYYY-MM-DD format.
+func (f *AuditFilter) FromURI(r *http.Request) error {
+	query := r.URL.Query()
+
+	f.Actor = query.Get("actor")
+
+	if slugParam := query.Get("segment"); slugParam != "" {
+		slug := Slug(slugParam)
+		if err := slug.Validate(); err != nil {
+			return err
+		}
+		f.SegmentSlug = &slug
+	}
+
+	if idParam := query.Get("user_id"); idParam != "" {
+		userID, err := strconv.ParseUint(idParam, 10, 64)
+		if err != nil {
+			return ErrInvalidUserID
+		}
+		f.UserID = &userID
+	}
+
+	var err error
+	if f.From, err = parseTimeParam(query.Get("from")); err != nil {
+		return err
+	}
+	if f.To, err = parseTimeParam(query.Get("to")); err != nil {
+		return err
+	}
+	if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+		return ErrInvalidDateInterval
+	}
+
+	if beforeParam := query.Get("before_id"); beforeParam != "" {
+		f.BeforeID, err = strconv.ParseUint(beforeParam, 10, 64)
+		if err != nil {
+			return ErrInvalidAuditID
+		}
+	}
+
+	f.Limit = AuditLimitDefault
+	if limitParam := query.Get("limit"); limitParam != "" {
+		f.Limit, err = strconv.Atoi(limitParam)
+		if err != nil || f.Limit <= 0 || f.Limit > AuditLimitMax {
+			return ErrInvalidLimit
+		}
+	}
+
+	return nil
+}
+
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return &t, nil
+	}
+	return nil, ErrInvalidDateFormat
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// Scope describes permission granted to API client.
+type Scope string
+
+// Scopes that are checked by handlers.
+const (
+	ScopeSegmentsWrite    Scope = "segments:write"
+	ScopeMembershipsRead  Scope = "memberships:read"
+	ScopeMembershipsWrite Scope = "memberships:write"
+	ScopeHistoryRead      Scope = "history:read"
+	ScopeEventsRead       Scope = "events:read"
+	ScopeWebhooksWrite    Scope = "webhooks:write"
+	ScopeAuditRead        Scope = "audit:read"
+	ScopeAdmin            Scope = "admin"
+)
+
+// AllScopes lists all known scopes.
+var AllScopes = []Scope{
+	ScopeSegmentsWrite,
+	ScopeMembershipsRead,
+	ScopeMembershipsWrite,
+	ScopeHistoryRead,
+	ScopeEventsRead,
+	ScopeWebhooksWrite,
+	ScopeAuditRead,
+	ScopeAdmin,
+}
+
+// Principal describes authenticated API client and teams it acts for.
+// Subject is the client name, ClientID identifies the client: API key id or JWT subject.
+type Principal struct {
+	Subject  string
+	ClientID string
+	Scopes   []Scope
+	Teams    []string
+}
+
+// HasScope checks if principal was granted the scope, admin is granted every scope.
+func (p *Principal) HasScope(scope Scope) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey describes API key model, only sha256 hash of the key is stored.
+type APIKey struct {
+	ID        uint64 `gorm:"primary_key"`
+	Name      string
+	KeyHash   string `gorm:"uniqueIndex"`
+	Scopes    string
+	Teams     string
+	CreatedAt time.Time
+	DeletedAt gorm.DeletedAt `sql:"index"`
+}
+
+// ScopesList returns scopes granted to the key.
+func (k *APIKey) ScopesList() []Scope {
+	if k.Scopes == "" {
+		return nil
+	}
+	parts := strings.Split(k.Scopes, ",")
+	scopes := make([]Scope, 0, len(parts))
+	for _, part := range parts {
+		scopes = append(scopes, Scope(part))
+	}
+	return scopes
+}
+
+// TeamsList returns teams the key acts for.
+func (k *APIKey) TeamsList() []string {
+	if k.Teams == "" {
+		return nil
+	}
+	return strings.Split(k.Teams, ",")
+}
+
+// CreateAPIKeyInput describes json input for API key creation.
+type CreateAPIKeyInput struct {
+	Name   string   `json:"name" example:"crm-service"`
+	Scopes []Scope  `json:"scopes" example:"memberships:read,memberships:write"`
+	Teams  []string `json:"teams,omitempty" example:"crm"`
+}
+
+// Bind implements render.Binder interface method.
+func (k *CreateAPIKeyInput) Bind(r *http.Request) error {
+	if k.Name == "" {
+		return ErrInvalidAPIKeyName
+	}
+	if len(k.Scopes) == 0 {
+		return ErrInvalidScope
+	}
+	for _, scope := range k.Scopes {
+		if !isKnownScope(scope) {
+			return ErrInvalidScope
+		}
+	}
+	for _, team := range k.Teams {
+		if team == "" || team == AnyTeam || strings.Contains(team, ",") {
+			return ErrInvalidTeam
+		}
+	}
+	return nil
+}
+
+// APIKeyInput describes path input to delete API key.
+type APIKeyInput struct {
+	ID uint64
+}
+
+// FromURI gets and checks API key id from url.
+func (k *APIKeyInput) FromURI(r *http.Request) error {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return ErrInvalidAPIKeyID
+	}
+	k.ID = id
+	return nil
+}
+
+// APIKeyOutput describes json response of API key creation,
+// the key itself is returned only once.
+type APIKeyOutput struct {
+	ID     uint64   `json:"id" example:"1"`
+	Name   string   `json:"name" example:"crm-service"`
+	Key    string   `json:"key" example:"3f1c...9a"`
+	Scopes []Scope  `json:"scopes" example:"memberships:read,memberships:write"`
+	Teams  []string `json:"teams,omitempty" example:"crm"`
+}
+
+// Render implements render.Render interface method.
+func (k APIKeyOutput) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func isKnownScope(scope Scope) bool {
+	for _, known := range AllScopes {
+		if known == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+package model
+
+import "net/http"
+
+// CacheStats describes json response of cache usage statistics.
+type CacheStats struct {
+	Hits   uint64 `json:"hits" example:"100"`
+	Misses uint64 `json:"misses" example:"10"`
+	Size   int    `json:"size" example:"90"`
+}
+
+// Render implements render.Render interface method.
+func (c CacheStats) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -15,6 +15,24 @@ var (
 	ErrInvalidUserID       = errors.New("invalid userID")
 	ErrInvalidDateFormat   = errors.New("invalid date format")
 	ErrInvalidDateInterval = errors.New("invalid date interval")
+	ErrInvalidEventID      = errors.New("invalid event id")
+	ErrInvalidEventType    = errors.New("invalid event type")
+	ErrInvalidWebhookURL   = errors.New("invalid webhook url")
+	ErrInvalidWebhookID    = errors.New("invalid webhook id")
+	ErrInvalidScope        = errors.New("invalid scope")
+	ErrInvalidAPIKeyName   = errors.New("invalid api key name")
+	ErrInvalidAPIKeyID     = errors.New("invalid api key id")
+	ErrInvalidGrant        = errors.New("invalid segment grant")
+	ErrInvalidTeam         = errors.New("invalid team")
+	ErrInvalidAuditID      = errors.New("invalid audit record id")
+	ErrInvalidLimit        = errors.New("invalid limit")
+	ErrInvalidVersion      = errors.New("invalid version")
+	ErrInvalidVariants     = errors.New("invalid experiment variants")
+	ErrInvalidGroup        = errors.New("invalid exclusion group")
+	ErrInvalidPrerequisite = errors.New("invalid segment prerequisite")
+	ErrInvalidActiveWindow = errors.New("invalid segment active window")
+
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
 )
 
 // OutputError describes json response for error.
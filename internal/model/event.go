@@ -0,0 +1,73 @@
+package model
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// EventType describes kind of membership change event.
+type EventType string
+
+// Event types.
+const (
+	EventAdd             EventType = "add"
+	EventDelete          EventType = "delete"
+	EventSegmentDeleted  EventType = "segment_deleted"
+	EventSegmentRestored EventType = "segment_restored"
+)
+
+// Event describes persisted membership change event.
+// UserID is empty for segment events.
+type Event struct {
+	ID          uint64    `json:"id" gorm:"primary_key"`
+	Type        EventType `json:"type"`
+	UserID      *uint64   `json:"user_id,omitempty" gorm:"index"`
+	SegmentSlug Slug      `json:"segment" gorm:"index"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// EventsFilter describes query params for events stream.
+type EventsFilter struct {
+	AfterID     uint64
+	UserID      *uint64
+	SegmentSlug *Slug
+	Types       []EventType
+	Limit       int
+}
+
+// FromURI gets and checks events filter from request.
+// Last event id is taken from Last-Event-ID header or last_event_id query param.
+func (f *EventsFilter) FromURI(r *http.Request) error {
+	query := r.URL.Query()
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("last_event_id")
+	}
+	if lastEventID != "" {
+		afterID, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			return ErrInvalidEventID
+		}
+		f.AfterID = afterID
+	}
+
+	if idParam := query.Get("user_id"); idParam != "" {
+		userID, err := strconv.ParseUint(idParam, 10, 64)
+		if err != nil {
+			return ErrInvalidUserID
+		}
+		f.UserID = &userID
+	}
+
+	if slugParam := query.Get("segment"); slugParam != "" {
+		slug := Slug(slugParam)
+		if err := slug.Validate(); err != nil {
+			return err
+		}
+		f.SegmentSlug = &slug
+	}
+
+	return nil
+}
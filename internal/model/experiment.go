@@ -0,0 +1,144 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/unbeman/av-prac-task/pkg/evaluation"
+)
+
+// Experiment describes A/B experiment. Its variants are dynamic segments with disjoint parts of users,
+// so the user is in one variant of the experiment at most, see evaluation.Variant.
+type Experiment struct {
+	ID        uint64    `json:"id" gorm:"primary_key"`
+	Slug      Slug      `json:"slug" gorm:"uniqueIndex"`
+	Variants  []Segment `json:"variants" gorm:"foreignKey:ExperimentID"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ExperimentVariantInput describes the variant segment and its part of users.
+type ExperimentVariantInput struct {
+	Slug   Slug    `json:"slug" example:"CHECKOUT_V2_A"`
+	Weight float64 `json:"weight" example:"0.5"`
+}
+
+// validateVariants checks variants slugs are unique and weights sum is at most 1.
+func validateVariants(variants []ExperimentVariantInput) error {
+	if len(variants) == 0 {
+		return fmt.Errorf("%w: at least one variant is required", ErrInvalidVariants)
+	}
+	slugs := make(map[Slug]bool, len(variants))
+	weights := make([]float64, 0, len(variants))
+	for idx := range variants {
+		if err := variants[idx].Slug.Validate(); err != nil {
+			return err
+		}
+		if slugs[variants[idx].Slug] {
+			return fmt.Errorf("%w: duplicated variant %s", ErrInvalidVariants, variants[idx].Slug)
+		}
+		slugs[variants[idx].Slug] = true
+		weights = append(weights, variants[idx].Weight)
+	}
+	return evaluation.ValidateWeights(weights)
+}
+
+// CreateExperimentInput describes json input for experiment creation, every variant is the new segment.
+// Grants are set to every variant segment like in CreateSegmentInput.
+type CreateExperimentInput struct {
+	Slug     Slug                     `json:"slug" example:"CHECKOUT_V2"`
+	Variants []ExperimentVariantInput `json:"variants"`
+	Grants   []SegmentGrant           `json:"grants,omitempty"`
+	Reason   string                   `json:"reason,omitempty" example:"new checkout flow test"`
+}
+
+// Bind implements render.Binder interface method.
+func (e *CreateExperimentInput) Bind(r *http.Request) error {
+	if err := e.Slug.Validate(); err != nil {
+		return err
+	}
+	if err := validateVariants(e.Variants); err != nil {
+		return err
+	}
+	return ValidateGrants(e.Grants)
+}
+
+// ExperimentInput describes path input to get experiment.
+type ExperimentInput struct {
+	Slug Slug `example:"CHECKOUT_V2"`
+}
+
+// FromURI gets and checks experiment input from url.
+func (e *ExperimentInput) FromURI(r *http.Request) error {
+	segment := SegmentInput{}
+	if err := segment.FromURI(r); err != nil {
+		return err
+	}
+	e.Slug = segment.Slug
+	return nil
+}
+
+// ExperimentWeightsInput describes json input for experiment weights update,
+// weights of variants that are not listed are kept.
+type ExperimentWeightsInput struct {
+	Slug     Slug                     `json:"-" swaggerignore:"true"`
+	Variants []ExperimentVariantInput `json:"variants"`
+	Reason   string                   `json:"reason,omitempty" example:"ramp up variant B"`
+}
+
+// Bind implements render.Binder interface method.
+func (e *ExperimentWeightsInput) Bind(r *http.Request) error {
+	experiment := ExperimentInput{}
+	if err := experiment.FromURI(r); err != nil {
+		return err
+	}
+	e.Slug = experiment.Slug
+	return validateVariants(e.Variants)
+}
+
+// Weights returns weights by variants slugs.
+func (e *ExperimentWeightsInput) Weights() map[Slug]float64 {
+	weights := make(map[Slug]float64, len(e.Variants))
+	for _, variant := range e.Variants {
+		weights[variant.Slug] = variant.Weight
+	}
+	return weights
+}
+
+// ExperimentOutput describes json response of experiment.
+type ExperimentOutput struct {
+	Slug     Slug                     `json:"slug" example:"CHECKOUT_V2"`
+	Variants []ExperimentVariantInput `json:"variants"`
+}
+
+// NewExperimentOutput returns the experiment output.
+func NewExperimentOutput(experiment *Experiment) *ExperimentOutput {
+	output := &ExperimentOutput{Slug: experiment.Slug, Variants: make([]ExperimentVariantInput, 0, len(experiment.Variants))}
+	for _, variant := range experiment.Variants {
+		if variant.Variant == nil {
+			continue
+		}
+		output.Variants = append(output.Variants, ExperimentVariantInput{Slug: variant.Slug, Weight: variant.Variant.Weight})
+	}
+	return output
+}
+
+// Render implements render.Render interface method.
+func (e ExperimentOutput) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// UserVariant describes the variant of the experiment the user is in, Variant is null
+// if the user is not in the experiment.
+type UserVariant struct {
+	Experiment Slug  `json:"experiment" example:"CHECKOUT_V2"`
+	Variant    *Slug `json:"variant" example:"CHECKOUT_V2_A"`
+}
+
+type UserVariants []UserVariant
+
+// Render implements render.Render interface method.
+func (u UserVariants) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,100 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// GroupPolicy describes what happens when the user is added to the segment of the exclusion group
+// while being in another segment of the group.
+type GroupPolicy string
+
+const (
+	// GroupPolicyReject fails the change.
+	GroupPolicyReject GroupPolicy = "reject"
+	// GroupPolicyReplace removes the user from the other segment in the same transaction.
+	GroupPolicyReplace GroupPolicy = "replace"
+)
+
+// ExclusionGroup describes segments that never share users, the segment belongs to one group at most.
+// Users of dynamic segments are evaluated, so only explicit segments can be grouped.
+type ExclusionGroup struct {
+	ID        uint64      `json:"id" gorm:"primary_key"`
+	Slug      Slug        `json:"slug" gorm:"uniqueIndex"`
+	Policy    GroupPolicy `json:"policy"`
+	Segments  []Segment   `json:"segments,omitempty" gorm:"foreignKey:GroupID"`
+	CreatedAt time.Time
+}
+
+// CreateExclusionGroupInput describes json input for exclusion group creation, empty Policy means reject.
+type CreateExclusionGroupInput struct {
+	Slug     Slug        `json:"slug" example:"PROMO_DISCOUNTS"`
+	Policy   GroupPolicy `json:"policy,omitempty" example:"reject" enums:"reject,replace"`
+	Segments []Slug      `json:"segments" example:"PROMO_5,AVITO_SALES_20"`
+	Reason   string      `json:"reason,omitempty" example:"discounts don't stack"`
+}
+
+// Bind implements render.Binder interface method.
+func (g *CreateExclusionGroupInput) Bind(r *http.Request) error {
+	if err := g.Slug.Validate(); err != nil {
+		return err
+	}
+	switch g.Policy {
+	case "":
+		g.Policy = GroupPolicyReject
+	case GroupPolicyReject, GroupPolicyReplace:
+	default:
+		return fmt.Errorf("%w: unknown policy %q, use %s or %s", ErrInvalidGroup, g.Policy, GroupPolicyReject, GroupPolicyReplace)
+	}
+	if len(g.Segments) == 0 {
+		return fmt.Errorf("%w: at least one segment is required", ErrInvalidGroup)
+	}
+	unique := make(map[Slug]bool, len(g.Segments))
+	for idx := range g.Segments {
+		if err := g.Segments[idx].Validate(); err != nil {
+			return err
+		}
+		if unique[g.Segments[idx]] {
+			return fmt.Errorf("%w: duplicated segment %s", ErrInvalidGroup, g.Segments[idx])
+		}
+		unique[g.Segments[idx]] = true
+	}
+	return nil
+}
+
+// ExclusionGroupInput describes path input to get/delete exclusion group.
+type ExclusionGroupInput struct {
+	Slug Slug `example:"PROMO_DISCOUNTS"`
+}
+
+// FromURI gets and checks exclusion group input from url.
+func (g *ExclusionGroupInput) FromURI(r *http.Request) error {
+	segment := SegmentInput{}
+	if err := segment.FromURI(r); err != nil {
+		return err
+	}
+	g.Slug = segment.Slug
+	return nil
+}
+
+// ExclusionGroupOutput describes json response of exclusion group.
+type ExclusionGroupOutput struct {
+	Slug     Slug        `json:"slug" example:"PROMO_DISCOUNTS"`
+	Policy   GroupPolicy `json:"policy" example:"reject"`
+	Segments []Slug      `json:"segments" example:"AVITO_SALES_20,PROMO_5"`
+}
+
+// NewExclusionGroupOutput returns the exclusion group output.
+func NewExclusionGroupOutput(group *ExclusionGroup) *ExclusionGroupOutput {
+	output := &ExclusionGroupOutput{Slug: group.Slug, Policy: group.Policy, Segments: make([]Slug, 0, len(group.Segments))}
+	for _, segment := range group.Segments {
+		output.Segments = append(output.Segments, segment.Slug)
+	}
+	return output
+}
+
+// Render implements render.Render interface method.
+func (g ExclusionGroupOutput) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
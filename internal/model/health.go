@@ -0,0 +1,30 @@
+package model
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+)
+
+// Health statuses.
+const (
+	HealthOK       = "ok"
+	HealthReady    = "ready"
+	HealthNotReady = "not ready"
+	HealthDraining = "draining"
+	HealthFailed   = "failed"
+)
+
+// HealthOutput describes json response for liveness and readiness probes.
+// Checks contains HealthOK or HealthFailed per checked dependency, errors are only logged.
+type HealthOutput struct {
+	HttpCode int               `json:"-"`
+	Status   string            `json:"status" example:"ready"`
+	Checks   map[string]string `json:"checks,omitempty"`
+}
+
+// Render implements render.Render interface method.
+func (h HealthOutput) Render(w http.ResponseWriter, r *http.Request) error {
+	render.Status(r, h.HttpCode)
+	return nil
+}
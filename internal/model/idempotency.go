@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+	"unicode"
+)
+
+// IdempotencyKeyMaxLength is max length of Idempotency-Key header value.
+const IdempotencyKeyMaxLength = 255
+
+// IdempotencyRecord describes mutating request made with idempotency key and its response.
+// Fingerprint is a hash of the request method, path and body, the key can't be reused for other request.
+// Record is not Completed while the request is being processed, the key is locked for it until LockedUntil.
+type IdempotencyRecord struct {
+	ID          uint64    `gorm:"primary_key"`
+	Key         string    `gorm:"column:idempotency_key;uniqueIndex"`
+	Fingerprint string    `gorm:"not null"`
+	Completed   bool      `gorm:"not null;default:false"`
+	StatusCode  int       `gorm:"not null;default:0"`
+	ContentType string    `gorm:"not null;default:''"`
+	Body        []byte    `gorm:"type:bytea"`
+	CreatedAt   time.Time `gorm:"not null"`
+	LockedUntil time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	ExpiresAt   time.Time `gorm:"not null;index"`
+}
+
+// ValidateIdempotencyKey checks idempotency key is not empty, not too long and printable.
+func ValidateIdempotencyKey(key string) error {
+	if key == "" || len(key) > IdempotencyKeyMaxLength {
+		return ErrInvalidIdempotencyKey
+	}
+	for _, r := range key {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return ErrInvalidIdempotencyKey
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// PrerequisitePolicy describes what happens with the user's membership in the dependent segment
+// when the user is removed from its prerequisite.
+type PrerequisitePolicy string
+
+const (
+	// PrerequisiteReject fails the removal of the prerequisite.
+	PrerequisiteReject PrerequisitePolicy = "reject"
+	// PrerequisiteCascade removes the user from the dependent segment too.
+	PrerequisiteCascade PrerequisitePolicy = "cascade"
+)
+
+// SegmentPrerequisite describes that the user can be added to the segment only if the user is in the prerequisite.
+// Users of dynamic segments are evaluated, so both segments are explicit.
+type SegmentPrerequisite struct {
+	ID             uint64             `json:"id" gorm:"primary_key"`
+	SegmentID      uint64             `json:"segment_id" gorm:"uniqueIndex:idx_segment_prerequisite"`
+	Segment        *Segment           `json:"-" gorm:"foreignKey:SegmentID"`
+	PrerequisiteID uint64             `json:"prerequisite_id" gorm:"uniqueIndex:idx_segment_prerequisite;index"`
+	Prerequisite   *Segment           `json:"-" gorm:"foreignKey:PrerequisiteID"`
+	OnRemove       PrerequisitePolicy `json:"on_remove"`
+	CreatedAt      time.Time
+}
+
+// PrerequisiteInput describes the prerequisite segment, empty OnRemove means reject.
+type PrerequisiteInput struct {
+	Slug     Slug               `json:"slug" example:"VERIFIED_PHONE"`
+	OnRemove PrerequisitePolicy `json:"on_remove,omitempty" example:"cascade" enums:"reject,cascade"`
+}
+
+// ValidatePrerequisites checks prerequisites of the segment are unique and sets the default policy.
+func ValidatePrerequisites(segment Slug, prerequisites []PrerequisiteInput) error {
+	unique := make(map[Slug]bool, len(prerequisites))
+	for idx := range prerequisites {
+		prerequisite := &prerequisites[idx]
+		if err := prerequisite.Slug.Validate(); err != nil {
+			return err
+		}
+		switch prerequisite.OnRemove {
+		case "":
+			prerequisite.OnRemove = PrerequisiteReject
+		case PrerequisiteReject, PrerequisiteCascade:
+		default:
+			return fmt.Errorf("%w: unknown policy %q, use %s or %s",
+				ErrInvalidPrerequisite, prerequisite.OnRemove, PrerequisiteReject, PrerequisiteCascade)
+		}
+		if prerequisite.Slug == segment {
+			return fmt.Errorf("%w: segment %s can't require itself", ErrInvalidPrerequisite, segment)
+		}
+		if unique[prerequisite.Slug] {
+			return fmt.Errorf("%w: duplicated prerequisite %s", ErrInvalidPrerequisite, prerequisite.Slug)
+		}
+		unique[prerequisite.Slug] = true
+	}
+	return nil
+}
+
+// SegmentPrerequisitesInput describes json input to replace segment prerequisites.
+type SegmentPrerequisitesInput struct {
+	Slug          Slug                `json:"-" swaggerignore:"true"`
+	Prerequisites []PrerequisiteInput `json:"prerequisites"`
+	Reason        string              `json:"reason,omitempty" example:"promo only for verified users"`
+}
+
+// Bind implements render.Binder interface method.
+func (s *SegmentPrerequisitesInput) Bind(r *http.Request) error {
+	segment := SegmentInput{}
+	if err := segment.FromURI(r); err != nil {
+		return err
+	}
+	s.Slug = segment.Slug
+	return ValidatePrerequisites(s.Slug, s.Prerequisites)
+}
+
+// SegmentPrerequisitesOutput describes json response of segment prerequisites.
+type SegmentPrerequisitesOutput struct {
+	Slug          Slug                `json:"slug" example:"PROMO_5"`
+	Prerequisites []PrerequisiteInput `json:"prerequisites"`
+}
+
+// NewSegmentPrerequisitesOutput returns prerequisites output of the segment, deleted prerequisites included.
+func NewSegmentPrerequisitesOutput(segment *Segment) *SegmentPrerequisitesOutput {
+	output := &SegmentPrerequisitesOutput{Slug: segment.Slug, Prerequisites: make([]PrerequisiteInput, 0, len(segment.Prerequisites))}
+	for _, prerequisite := range segment.Prerequisites {
+		if prerequisite.Prerequisite == nil {
+			continue
+		}
+		output.Prerequisites = append(output.Prerequisites, PrerequisiteInput{
+			Slug:     prerequisite.Prerequisite.Slug,
+			OnRemove: prerequisite.OnRemove,
+		})
+	}
+	return output
+}
+
+// Render implements render.Render interface method.
+func (s SegmentPrerequisitesOutput) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -1,21 +1,83 @@
 package model
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"gorm.io/gorm"
+
+	"github.com/unbeman/av-prac-task/pkg/evaluation"
 )
 
-// Segment describes segment model.
+// Segment describes segment model. Users of the segment with Rollout, Rules or experiment Variant
+// are not stored, they are evaluated from the definition, see Segment.Matches.
+// Version grows on every change of the segment, see SegmentsSnapshot.
+// ActiveFrom and ActiveUntil limit the time users are in the segment, the segment is deleted at ActiveUntil.
 type Segment struct {
-	ID        uint64 `json:"id" gorm:"primary_key"`
-	Slug      Slug   `json:"slug" gorm:"uniqueIndex"`
-	Users     []User `json:"users,omitempty" gorm:"many2many:user_segments;"`
-	CreatedAt time.Time
-	DeletedAt gorm.DeletedAt `sql:"index"`
+	ID            uint64                `json:"id" gorm:"primary_key"`
+	Slug          Slug                  `json:"slug" gorm:"uniqueIndex"`
+	Users         []User                `json:"users,omitempty" gorm:"many2many:user_segments;"`
+	Grants        []SegmentGrant        `json:"grants,omitempty" gorm:"foreignKey:SegmentID"`
+	Prerequisites []SegmentPrerequisite `json:"prerequisites,omitempty" gorm:"foreignKey:SegmentID"`
+	Rollout       *float64              `json:"rollout,omitempty"`
+	Rules         []SegmentRule         `json:"rules,omitempty" gorm:"serializer:json"`
+	ExperimentID  *uint64               `json:"experiment_id,omitempty" gorm:"index"`
+	Variant       *evaluation.Variant   `json:"variant,omitempty" gorm:"serializer:json"`
+	GroupID       *uint64               `json:"group_id,omitempty" gorm:"index"`
+	ActiveFrom    *time.Time            `json:"active_from,omitempty"`
+	ActiveUntil   *time.Time            `json:"active_until,omitempty" gorm:"index"`
+	Version       uint64                `json:"version" gorm:"index"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt `sql:"index"`
+}
+
+// IsDynamic checks if the segment users are evaluated from its definition.
+func (s *Segment) IsDynamic() bool {
+	return s.Rollout != nil || len(s.Rules) > 0 || s.Variant != nil
+}
+
+// IsActive checks if the time is in the segment active window.
+func (s *Segment) IsActive(now time.Time) bool {
+	if s.ActiveFrom != nil && now.Before(*s.ActiveFrom) {
+		return false
+	}
+	return s.ActiveUntil == nil || now.Before(*s.ActiveUntil)
+}
+
+// NeedsAttributes checks if the segment rules depend on user attributes other than user id,
+// such segments can be evaluated only by the client that knows the attributes.
+func (s *Segment) NeedsAttributes() bool {
+	for _, rule := range s.Rules {
+		if rule.Attribute != evaluation.UserIDAttribute {
+			return true
+		}
+	}
+	return false
+}
+
+// Definition returns the segment definition for evaluation.
+func (s *Segment) Definition() evaluation.Definition {
+	rules := make([]evaluation.Rule, 0, len(s.Rules))
+	for _, rule := range s.Rules {
+		rules = append(rules, evaluation.Rule(rule))
+	}
+	return evaluation.Definition{Slug: string(s.Slug), Rollout: s.Rollout, Rules: rules, Variant: s.Variant}
+}
+
+// Matches checks if the user with attributes is in the dynamic segment.
+func (s *Segment) Matches(userID uint64, attrs evaluation.Attributes) bool {
+	return s.IsDynamic() && s.Definition().Matches(userID, attrs)
+}
+
+// SegmentRule describes condition on user attribute of the dynamic segment, see evaluation.Rule.
+type SegmentRule struct {
+	Attribute string   `json:"attribute" example:"country"`
+	Operator  string   `json:"operator" example:"in" enums:"in,not_in"`
+	Values    []string `json:"values" example:"RU,KZ"`
 }
 
 // Render implements render.Render interface method.
@@ -28,6 +90,11 @@ type Slug string
 
 // Bind implements render.Binder interface method.
 func (s *Slug) Bind(r *http.Request) error {
+	return s.Validate()
+}
+
+// Validate checks slug and converts it to upper case.
+func (s *Slug) Validate() error {
 	if *s == "" {
 		return ErrInvalidSlug
 	}
@@ -44,22 +111,100 @@ func (s Slugs) Render(w http.ResponseWriter, r *http.Request) error {
 }
 
 // CreateSegmentInput describes json input for segment creation.
+// Grants describe segment ACL, if they are empty and caller belongs to teams,
+// caller's teams get write grant and everyone else gets read grant.
+// Rollout and Rules define dynamic segment, they can't be used with Selection.
+// Group is the exclusion group of the explicit segment, Selection skips users of other segments of the group.
+// Prerequisites are segments the users of the explicit segment must be in, Selection selects only such users.
+// ActiveFrom and ActiveUntil are optional bounds of the segment active window.
 type CreateSegmentInput struct {
-	Slug      Slug     `json:"slug" example:"AVITO_VOICE_MESSAGES"`
-	Selection *float64 `json:"selection,omitempty" example:"0.2"`
+	Slug          Slug                `json:"slug" example:"AVITO_VOICE_MESSAGES"`
+	Selection     *float64            `json:"selection,omitempty" example:"0.2"`
+	Group         *Slug               `json:"group,omitempty" example:"PROMO_DISCOUNTS"`
+	Prerequisites []PrerequisiteInput `json:"prerequisites,omitempty"`
+	Rollout       *float64            `json:"rollout,omitempty" example:"0.1"`
+	Rules         []SegmentRule       `json:"rules,omitempty"`
+	Grants        []SegmentGrant      `json:"grants,omitempty"`
+	ActiveFrom    *time.Time          `json:"active_from,omitempty" example:"2023-11-24T00:00:00+03:00"`
+	ActiveUntil   *time.Time          `json:"active_until,omitempty" example:"2023-11-27T00:00:00+03:00"`
+	Reason        string              `json:"reason,omitempty" example:"new voice messages rollout"`
 }
 
 // Bind implements render.Binder interface method.
 func (s *CreateSegmentInput) Bind(r *http.Request) error {
-	if err := s.Slug.Bind(r); err != nil { //no idea why it doesn't work without explicit call
+	return s.Validate()
+}
+
+// Validate checks segment creation input.
+func (s *CreateSegmentInput) Validate() error {
+	if err := s.Slug.Validate(); err != nil {
 		return err
 	}
 	if s.Selection != nil && (*s.Selection > 1.0 || *s.Selection < 0.0) {
 		return ErrInvalidSelection
 	}
+	if s.Rollout != nil {
+		if err := evaluation.ValidateRollout(*s.Rollout); err != nil {
+			return err
+		}
+	}
+	for _, rule := range s.Rules {
+		if err := evaluation.Rule(rule).Validate(); err != nil {
+			return err
+		}
+	}
+	if s.Selection != nil && (s.Rollout != nil || len(s.Rules) > 0) {
+		return fmt.Errorf("%w: selection can't be used with rollout or rules", ErrInvalidSelection)
+	}
+	if s.Group != nil {
+		if err := s.Group.Validate(); err != nil {
+			return err
+		}
+		if s.Rollout != nil || len(s.Rules) > 0 {
+			return fmt.Errorf("%w: dynamic segment can't be grouped", ErrInvalidGroup)
+		}
+	}
+	if len(s.Prerequisites) > 0 && (s.Rollout != nil || len(s.Rules) > 0) {
+		return fmt.Errorf("%w: dynamic segment can't have prerequisites", ErrInvalidPrerequisite)
+	}
+	if err := ValidatePrerequisites(s.Slug, s.Prerequisites); err != nil {
+		return err
+	}
+	if s.ActiveUntil != nil {
+		if s.ActiveFrom != nil && !s.ActiveUntil.After(*s.ActiveFrom) {
+			return fmt.Errorf("%w: active_until must be after active_from", ErrInvalidActiveWindow)
+		}
+		if !s.ActiveUntil.After(time.Now()) {
+			return fmt.Errorf("%w: active_until must be in the future", ErrInvalidActiveWindow)
+		}
+	}
+	return ValidateGrants(s.Grants)
+}
+
+// CreateSegmentOutput describes json response of segment creation.
+type CreateSegmentOutput struct {
+	Slug          Slug  `json:"slug" example:"AVITO_VOICE_MESSAGES"`
+	AssignedUsers int64 `json:"assigned_users" example:"1000"`
+}
+
+// Render implements render.Render interface method.
+func (s CreateSegmentOutput) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// SegmentsFilter describes segments listing, empty Slugs match all segments.
+// Dynamic selects only segments with definition, SinceVersion selects segments changed after the version.
+// ExpiredAt selects segments with ActiveUntil not after the time.
+// DeletedWithin limits WithDeleted to segments deleted within the duration, zero means no limit.
+type SegmentsFilter struct {
+	Slugs         []Slug
+	WithDeleted   bool
+	DeletedWithin time.Duration
+	Dynamic       bool
+	SinceVersion  uint64
+	ExpiredAt     time.Time
+}
+
 // SegmentInput describes path input to get/delete segment.
 type SegmentInput struct {
 	Slug Slug `example:"AVITO_VOICE_MESSAGES"`
@@ -68,5 +213,5 @@ type SegmentInput struct {
 // FromURI gets and checks segment input from url.
 func (s *SegmentInput) FromURI(r *http.Request) error {
 	s.Slug = Slug(chi.URLParam(r, "slug"))
-	return s.Slug.Bind(r)
+	return s.Slug.Validate()
 }
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/unbeman/av-prac-task/pkg/evaluation"
+)
+
+// SegmentDefinition describes segment in the snapshot. Explicit segment users are stored per user
+// and must be requested from the API, users of other segments are evaluated from Rollout, Rules and Variant
+// within the ActiveFrom and ActiveUntil window.
+type SegmentDefinition struct {
+	Slug        Slug                `json:"slug" example:"PROMO_5"`
+	Version     uint64              `json:"version" example:"42"`
+	Explicit    bool                `json:"explicit" example:"false"`
+	Rollout     *float64            `json:"rollout,omitempty" example:"0.1"`
+	Rules       []SegmentRule       `json:"rules,omitempty"`
+	Variant     *evaluation.Variant `json:"variant,omitempty"`
+	ActiveFrom  *time.Time          `json:"active_from,omitempty"`
+	ActiveUntil *time.Time          `json:"active_until,omitempty"`
+	Deleted     bool                `json:"deleted,omitempty" example:"false"`
+}
+
+// NewSegmentDefinition returns the definition of the segment.
+func NewSegmentDefinition(segment *Segment) SegmentDefinition {
+	return SegmentDefinition{
+		Slug:        segment.Slug,
+		Version:     segment.Version,
+		Explicit:    !segment.IsDynamic(),
+		Rollout:     segment.Rollout,
+		Rules:       segment.Rules,
+		Variant:     segment.Variant,
+		ActiveFrom:  segment.ActiveFrom,
+		ActiveUntil: segment.ActiveUntil,
+		Deleted:     segment.DeletedAt.Valid,
+	}
+}
+
+// SegmentsSnapshot describes segments definitions at the Version. Full snapshot contains all active segments,
+// delta contains segments changed since the requested version, deleted ones included.
+type SegmentsSnapshot struct {
+	Version  uint64              `json:"version" example:"42"`
+	Full     bool                `json:"full" example:"true"`
+	Segments []SegmentDefinition `json:"segments"`
+}
+
+// Render implements render.Render interface method.
+func (s SegmentsSnapshot) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// SnapshotInput describes query input of the snapshot, zero Since requests full snapshot.
+type SnapshotInput struct {
+	Since uint64
+}
+
+// FromURI gets and checks snapshot input from url query.
+func (s *SnapshotInput) FromURI(r *http.Request) error {
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		return nil
+	}
+	version, err := strconv.ParseUint(since, 10, 64)
+	if err != nil {
+		return ErrInvalidVersion
+	}
+	s.Since = version
+	return nil
+}
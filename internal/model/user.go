@@ -15,6 +15,9 @@ type User struct {
 	Segments  []Segment `json:"segments,omitempty" gorm:"many2many:user_segments;"`
 	CreatedAt time.Time
 	DeletedAt gorm.DeletedAt `sql:"index"`
+	// SegmentsUpdatedAt is the time of the last change of user's segments,
+	// used as version of user's memberships.
+	SegmentsUpdatedAt time.Time `json:"-" gorm:"-"`
 }
 
 // UserInput describes input for getting user segments.
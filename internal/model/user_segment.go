@@ -25,6 +25,7 @@ type UserSegmentsInput struct {
 	UserID           uint64 `json:"-" swaggerignore:"true"`
 	SegmentsToAdd    []Slug `json:"segments_to_add" example:"PROTECTED_PHONE_NUMBER,VOICE_MSG"`
 	SegmentsToDelete []Slug `json:"segments_to_delete" example:"PROMO_5"`
+	Reason           string `json:"reason,omitempty" example:"support ticket 123"`
 }
 
 // Bind implements render.Binder interface method.
@@ -37,13 +38,19 @@ func (u *UserSegmentsInput) Bind(r *http.Request) error {
 
 	u.UserID = userID
 
-	for idx, _ := range u.SegmentsToAdd {
-		if err := u.SegmentsToAdd[idx].Bind(r); err != nil {
+	return u.Validate()
+}
+
+// Validate checks segments slugs to add and delete,
+// slugs must not be duplicated and lists must not intersect.
+func (u *UserSegmentsInput) Validate() error {
+	for idx := range u.SegmentsToAdd {
+		if err := u.SegmentsToAdd[idx].Validate(); err != nil {
 			return err
 		}
 	}
-	for idx, _ := range u.SegmentsToDelete {
-		if err := u.SegmentsToDelete[idx].Bind(r); err != nil {
+	for idx := range u.SegmentsToDelete {
+		if err := u.SegmentsToDelete[idx].Validate(); err != nil {
 			return err
 		}
 	}
@@ -89,10 +96,14 @@ func (u *UserSegmentsHistoryInput) FromURI(r *http.Request) error {
 		return ErrInvalidUserID
 	}
 
-	fromDate := r.URL.Query().Get("from")
-	toDate := r.URL.Query().Get("to")
-
 	u.UserID = userID
+
+	return u.ParseDates(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+}
+
+// ParseDates parses and checks history interval dates in YYYY-MM-DD format.
+func (u *UserSegmentsHistoryInput) ParseDates(fromDate, toDate string) error {
+	var err error
 	u.FromDate, err = time.Parse(time.DateOnly, fromDate)
 	if err != nil {
 		return ErrInvalidDateFormat
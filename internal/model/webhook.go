@@ -0,0 +1,134 @@
+package model
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// WebhookSubscription describes webhook subscription model.
+// LastEventID is the id of the last event delivered to the subscriber,
+// FailedAttempts and NextAttemptAt describe delivery retries state.
+// LockedUntil is the end of the delivery claim, the subscription is claimed by one instance at a time.
+type WebhookSubscription struct {
+	ID             uint64 `gorm:"primary_key"`
+	URL            string
+	EventTypes     string
+	Secret         string
+	LastEventID    uint64
+	FailedAttempts int
+	NextAttemptAt  time.Time
+	LastError      string
+	LockedUntil    time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
+	CreatedAt      time.Time
+	DeletedAt      gorm.DeletedAt `sql:"index"`
+}
+
+// Types returns event types the subscription is interested in.
+func (w *WebhookSubscription) Types() []EventType {
+	if w.EventTypes == "" {
+		return nil
+	}
+	parts := strings.Split(w.EventTypes, ",")
+	types := make([]EventType, 0, len(parts))
+	for _, part := range parts {
+		types = append(types, EventType(part))
+	}
+	return types
+}
+
+// CreateWebhookInput describes json input for webhook subscription creation.
+// Secret is generated if it is empty.
+type CreateWebhookInput struct {
+	URL        string      `json:"url" example:"https://crm.example.com/hooks/segments"`
+	EventTypes []EventType `json:"event_types" example:"add,delete"`
+	Secret     string      `json:"secret,omitempty" example:"s3cr3t"`
+}
+
+// Bind implements render.Binder interface method.
+func (w *CreateWebhookInput) Bind(r *http.Request) error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	if len(w.EventTypes) == 0 {
+		return ErrInvalidEventType
+	}
+	unique := make(map[EventType]bool)
+	for _, eventType := range w.EventTypes {
+		switch eventType {
+		case EventAdd, EventDelete, EventSegmentDeleted, EventSegmentRestored:
+		default:
+			return ErrInvalidEventType
+		}
+		if unique[eventType] {
+			return ErrInvalidEventType
+		}
+		unique[eventType] = true
+	}
+	return nil
+}
+
+// WebhookInput describes path input to delete webhook subscription.
+type WebhookInput struct {
+	ID uint64
+}
+
+// FromURI gets and checks webhook id from url.
+func (w *WebhookInput) FromURI(r *http.Request) error {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return ErrInvalidWebhookID
+	}
+	w.ID = id
+	return nil
+}
+
+// WebhookOutput describes json response of webhook subscription.
+// Secret is returned only on creation.
+type WebhookOutput struct {
+	ID             uint64      `json:"id" example:"1"`
+	URL            string      `json:"url" example:"https://crm.example.com/hooks/segments"`
+	EventTypes     []EventType `json:"event_types" example:"add,delete"`
+	Secret         string      `json:"secret,omitempty" example:"s3cr3t"`
+	LastEventID    uint64      `json:"last_event_id" example:"42"`
+	FailedAttempts int         `json:"failed_attempts" example:"0"`
+	LastError      string      `json:"last_error,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+}
+
+// NewWebhookOutput returns output for given subscription without its secret.
+func NewWebhookOutput(w *WebhookSubscription) WebhookOutput {
+	return WebhookOutput{
+		ID:             w.ID,
+		URL:            w.URL,
+		EventTypes:     w.Types(),
+		LastEventID:    w.LastEventID,
+		FailedAttempts: w.FailedAttempts,
+		LastError:      w.LastError,
+		CreatedAt:      w.CreatedAt,
+	}
+}
+
+// Render implements render.Render interface method.
+func (w WebhookOutput) Render(rw http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type WebhooksOutput []WebhookOutput
+
+// Render implements render.Render interface method.
+func (w WebhooksOutput) Render(rw http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// WebhookPayload describes json body of webhook delivery.
+type WebhookPayload struct {
+	SubscriptionID uint64  `json:"subscription_id"`
+	Events         []Event `json:"events"`
+}
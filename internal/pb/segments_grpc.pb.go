@@ -0,0 +1,394 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: segments.proto
+
+// Package segments describes gRPC API of dynamic user segments server.
+// Services mirror HTTP API routes, see docs/swagger.yaml.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	SegmentService_CreateSegment_FullMethodName = "/segments.v1.SegmentService/CreateSegment"
+	SegmentService_DeleteSegment_FullMethodName = "/segments.v1.SegmentService/DeleteSegment"
+)
+
+// SegmentServiceClient is the client API for SegmentService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SegmentServiceClient interface {
+	// CreateSegment creates new segment with given slug and optionally
+	// adds it to selection part of random users.
+	CreateSegment(ctx context.Context, in *CreateSegmentRequest, opts ...grpc.CallOption) (*CreateSegmentResponse, error)
+	// DeleteSegment soft deletes segment and its relations to users.
+	DeleteSegment(ctx context.Context, in *DeleteSegmentRequest, opts ...grpc.CallOption) (*DeleteSegmentResponse, error)
+}
+
+type segmentServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSegmentServiceClient(cc grpc.ClientConnInterface) SegmentServiceClient {
+	return &segmentServiceClient{cc}
+}
+
+func (c *segmentServiceClient) CreateSegment(ctx context.Context, in *CreateSegmentRequest, opts ...grpc.CallOption) (*CreateSegmentResponse, error) {
+	out := new(CreateSegmentResponse)
+	err := c.cc.Invoke(ctx, SegmentService_CreateSegment_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmentServiceClient) DeleteSegment(ctx context.Context, in *DeleteSegmentRequest, opts ...grpc.CallOption) (*DeleteSegmentResponse, error) {
+	out := new(DeleteSegmentResponse)
+	err := c.cc.Invoke(ctx, SegmentService_DeleteSegment_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SegmentServiceServer is the server API for SegmentService service.
+// All implementations must embed UnimplementedSegmentServiceServer
+// for forward compatibility
+type SegmentServiceServer interface {
+	// CreateSegment creates new segment with given slug and optionally
+	// adds it to selection part of random users.
+	CreateSegment(context.Context, *CreateSegmentRequest) (*CreateSegmentResponse, error)
+	// DeleteSegment soft deletes segment and its relations to users.
+	DeleteSegment(context.Context, *DeleteSegmentRequest) (*DeleteSegmentResponse, error)
+	mustEmbedUnimplementedSegmentServiceServer()
+}
+
+// UnimplementedSegmentServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedSegmentServiceServer struct {
+}
+
+func (UnimplementedSegmentServiceServer) CreateSegment(context.Context, *CreateSegmentRequest) (*CreateSegmentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSegment not implemented")
+}
+func (UnimplementedSegmentServiceServer) DeleteSegment(context.Context, *DeleteSegmentRequest) (*DeleteSegmentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteSegment not implemented")
+}
+func (UnimplementedSegmentServiceServer) mustEmbedUnimplementedSegmentServiceServer() {}
+
+// UnsafeSegmentServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SegmentServiceServer will
+// result in compilation errors.
+type UnsafeSegmentServiceServer interface {
+	mustEmbedUnimplementedSegmentServiceServer()
+}
+
+func RegisterSegmentServiceServer(s grpc.ServiceRegistrar, srv SegmentServiceServer) {
+	s.RegisterService(&SegmentService_ServiceDesc, srv)
+}
+
+func _SegmentService_CreateSegment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSegmentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmentServiceServer).CreateSegment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SegmentService_CreateSegment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmentServiceServer).CreateSegment(ctx, req.(*CreateSegmentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SegmentService_DeleteSegment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteSegmentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmentServiceServer).DeleteSegment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SegmentService_DeleteSegment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmentServiceServer).DeleteSegment(ctx, req.(*DeleteSegmentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// SegmentService_ServiceDesc is the grpc.ServiceDesc for SegmentService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SegmentService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "segments.v1.SegmentService",
+	HandlerType: (*SegmentServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateSegment",
+			Handler:    _SegmentService_CreateSegment_Handler,
+		},
+		{
+			MethodName: "DeleteSegment",
+			Handler:    _SegmentService_DeleteSegment_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "segments.proto",
+}
+
+const (
+	UserService_UpdateUserSegments_FullMethodName = "/segments.v1.UserService/UpdateUserSegments"
+	UserService_GetUserSegments_FullMethodName    = "/segments.v1.UserService/GetUserSegments"
+	UserService_RequestHistory_FullMethodName     = "/segments.v1.UserService/RequestHistory"
+	UserService_DownloadHistory_FullMethodName    = "/segments.v1.UserService/DownloadHistory"
+)
+
+// UserServiceClient is the client API for UserService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type UserServiceClient interface {
+	// UpdateUserSegments adds and deletes user's segments.
+	UpdateUserSegments(ctx context.Context, in *UpdateUserSegmentsRequest, opts ...grpc.CallOption) (*UpdateUserSegmentsResponse, error)
+	// GetUserSegments returns user's active segments.
+	GetUserSegments(ctx context.Context, in *GetUserSegmentsRequest, opts ...grpc.CallOption) (*GetUserSegmentsResponse, error)
+	// RequestHistory starts generation of user's segments history report
+	// for half-interval [from, to) and returns report file name.
+	RequestHistory(ctx context.Context, in *RequestHistoryRequest, opts ...grpc.CallOption) (*RequestHistoryResponse, error)
+	// DownloadHistory streams generated csv report by chunks.
+	DownloadHistory(ctx context.Context, in *DownloadHistoryRequest, opts ...grpc.CallOption) (UserService_DownloadHistoryClient, error)
+}
+
+type userServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewUserServiceClient(cc grpc.ClientConnInterface) UserServiceClient {
+	return &userServiceClient{cc}
+}
+
+func (c *userServiceClient) UpdateUserSegments(ctx context.Context, in *UpdateUserSegmentsRequest, opts ...grpc.CallOption) (*UpdateUserSegmentsResponse, error) {
+	out := new(UpdateUserSegmentsResponse)
+	err := c.cc.Invoke(ctx, UserService_UpdateUserSegments_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userServiceClient) GetUserSegments(ctx context.Context, in *GetUserSegmentsRequest, opts ...grpc.CallOption) (*GetUserSegmentsResponse, error) {
+	out := new(GetUserSegmentsResponse)
+	err := c.cc.Invoke(ctx, UserService_GetUserSegments_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userServiceClient) RequestHistory(ctx context.Context, in *RequestHistoryRequest, opts ...grpc.CallOption) (*RequestHistoryResponse, error) {
+	out := new(RequestHistoryResponse)
+	err := c.cc.Invoke(ctx, UserService_RequestHistory_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userServiceClient) DownloadHistory(ctx context.Context, in *DownloadHistoryRequest, opts ...grpc.CallOption) (UserService_DownloadHistoryClient, error) {
+	stream, err := c.cc.NewStream(ctx, &UserService_ServiceDesc.Streams[0], UserService_DownloadHistory_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userServiceDownloadHistoryClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type UserService_DownloadHistoryClient interface {
+	Recv() (*DownloadHistoryChunk, error)
+	grpc.ClientStream
+}
+
+type userServiceDownloadHistoryClient struct {
+	grpc.ClientStream
+}
+
+func (x *userServiceDownloadHistoryClient) Recv() (*DownloadHistoryChunk, error) {
+	m := new(DownloadHistoryChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// UserServiceServer is the server API for UserService service.
+// All implementations must embed UnimplementedUserServiceServer
+// for forward compatibility
+type UserServiceServer interface {
+	// UpdateUserSegments adds and deletes user's segments.
+	UpdateUserSegments(context.Context, *UpdateUserSegmentsRequest) (*UpdateUserSegmentsResponse, error)
+	// GetUserSegments returns user's active segments.
+	GetUserSegments(context.Context, *GetUserSegmentsRequest) (*GetUserSegmentsResponse, error)
+	// RequestHistory starts generation of user's segments history report
+	// for half-interval [from, to) and returns report file name.
+	RequestHistory(context.Context, *RequestHistoryRequest) (*RequestHistoryResponse, error)
+	// DownloadHistory streams generated csv report by chunks.
+	DownloadHistory(*DownloadHistoryRequest, UserService_DownloadHistoryServer) error
+	mustEmbedUnimplementedUserServiceServer()
+}
+
+// UnimplementedUserServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedUserServiceServer struct {
+}
+
+func (UnimplementedUserServiceServer) UpdateUserSegments(context.Context, *UpdateUserSegmentsRequest) (*UpdateUserSegmentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateUserSegments not implemented")
+}
+func (UnimplementedUserServiceServer) GetUserSegments(context.Context, *GetUserSegmentsRequest) (*GetUserSegmentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUserSegments not implemented")
+}
+func (UnimplementedUserServiceServer) RequestHistory(context.Context, *RequestHistoryRequest) (*RequestHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestHistory not implemented")
+}
+func (UnimplementedUserServiceServer) DownloadHistory(*DownloadHistoryRequest, UserService_DownloadHistoryServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadHistory not implemented")
+}
+func (UnimplementedUserServiceServer) mustEmbedUnimplementedUserServiceServer() {}
+
+// UnsafeUserServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to UserServiceServer will
+// result in compilation errors.
+type UnsafeUserServiceServer interface {
+	mustEmbedUnimplementedUserServiceServer()
+}
+
+func RegisterUserServiceServer(s grpc.ServiceRegistrar, srv UserServiceServer) {
+	s.RegisterService(&UserService_ServiceDesc, srv)
+}
+
+func _UserService_UpdateUserSegments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateUserSegmentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).UpdateUserSegments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_UpdateUserSegments_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).UpdateUserSegments(ctx, req.(*UpdateUserSegmentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserService_GetUserSegments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserSegmentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).GetUserSegments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_GetUserSegments_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).GetUserSegments(ctx, req.(*GetUserSegmentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserService_RequestHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).RequestHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserService_RequestHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).RequestHistory(ctx, req.(*RequestHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserService_DownloadHistory_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadHistoryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UserServiceServer).DownloadHistory(m, &userServiceDownloadHistoryServer{stream})
+}
+
+type UserService_DownloadHistoryServer interface {
+	Send(*DownloadHistoryChunk) error
+	grpc.ServerStream
+}
+
+type userServiceDownloadHistoryServer struct {
+	grpc.ServerStream
+}
+
+func (x *userServiceDownloadHistoryServer) Send(m *DownloadHistoryChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// UserService_ServiceDesc is the grpc.ServiceDesc for UserService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var UserService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "segments.v1.UserService",
+	HandlerType: (*UserServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "UpdateUserSegments",
+			Handler:    _UserService_UpdateUserSegments_Handler,
+		},
+		{
+			MethodName: "GetUserSegments",
+			Handler:    _UserService_GetUserSegments_Handler,
+		},
+		{
+			MethodName: "RequestHistory",
+			Handler:    _UserService_RequestHistory_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "DownloadHistory",
+			Handler:       _UserService_DownloadHistory_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "segments.proto",
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+)
+
+// sweepInterval is how often idle buckets are removed.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	limit     config.RateLimit
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryLimiter is in-process ILimiter implementation with token bucket per client and route group.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	limits    map[string]config.RateLimit
+	buckets   map[string]*bucket
+	sweptAt   time.Time
+	nowFunc   func() time.Time
+	isEnabled bool
+}
+
+// NewMemoryLimiter returns new MemoryLimiter.
+func NewMemoryLimiter(cfg config.RateLimitConfig) *MemoryLimiter {
+	return &MemoryLimiter{
+		limits: map[string]config.RateLimit{
+			GroupRead:    cfg.Read,
+			GroupWrite:   cfg.Write,
+			GroupReports: cfg.Reports,
+		},
+		buckets:   make(map[string]*bucket),
+		nowFunc:   time.Now,
+		isEnabled: cfg.Enabled,
+	}
+}
+
+// SetConfig replaces the limits, clients' buckets take the new limits on their next request.
+func (l *MemoryLimiter) SetConfig(cfg config.RateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limits = map[string]config.RateLimit{
+		GroupRead:    cfg.Read,
+		GroupWrite:   cfg.Write,
+		GroupReports: cfg.Reports,
+	}
+	l.isEnabled = cfg.Enabled
+}
+
+// Allow refills the client's bucket for the elapsed time and takes a token from it if there is one.
+func (l *MemoryLimiter) Allow(group, key string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit, ok := l.limits[group]
+	if !l.isEnabled || !ok || limit.Rate <= 0 || limit.Burst <= 0 {
+		return Result{Allowed: true}
+	}
+
+	now := l.nowFunc()
+	l.sweep(now)
+
+	id := group + ":" + key
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), updatedAt: now}
+		l.buckets[id] = b
+	}
+
+	b.limit = limit
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+	b.updatedAt = now
+
+	result := Result{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = tokensDuration(1-b.tokens, limit.Rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = tokensDuration(float64(limit.Burst)-b.tokens, limit.Rate)
+
+	return result
+}
+
+// sweep removes buckets that have been refilled to full, they are equal to new ones.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < sweepInterval {
+		return
+	}
+	l.sweptAt = now
+
+	for id, b := range l.buckets {
+		if b.tokens+now.Sub(b.updatedAt).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+// tokensDuration returns time needed to refill given count of tokens.
+func tokensDuration(tokens, rate float64) time.Duration {
+	return time.Duration(tokens / rate * float64(time.Second))
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+)
+
+func newTestLimiter(now *time.Time) *MemoryLimiter {
+	cfg := config.NewRateLimitConfig()
+	cfg.Enabled = true
+	cfg.Write = config.RateLimit{Rate: 2, Burst: 3}
+	cfg.Reports = config.RateLimit{}
+
+	l := NewMemoryLimiter(cfg)
+	l.nowFunc = func() time.Time { return *now }
+	return l
+}
+
+func TestMemoryLimiter_Allow(t *testing.T) {
+	now := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now)
+
+	for i := 2; i >= 0; i-- {
+		res := l.Allow(GroupWrite, "client")
+		require.True(t, res.Allowed)
+		require.Equal(t, 3, res.Limit)
+		require.Equal(t, i, res.Remaining)
+	}
+
+	res := l.Allow(GroupWrite, "client")
+	require.False(t, res.Allowed)
+	require.Equal(t, 0, res.Remaining)
+	require.Equal(t, 500*time.Millisecond, res.RetryAfter)
+	require.Equal(t, 1500*time.Millisecond, res.Reset)
+
+	// other clients and groups have their own buckets
+	require.True(t, l.Allow(GroupWrite, "other").Allowed)
+	require.True(t, l.Allow(GroupRead, "client").Allowed)
+
+	now = now.Add(500 * time.Millisecond)
+	res = l.Allow(GroupWrite, "client")
+	require.True(t, res.Allowed)
+	require.Equal(t, 0, res.Remaining)
+	require.False(t, l.Allow(GroupWrite, "client").Allowed)
+
+	// bucket is not filled over the burst
+	now = now.Add(time.Hour)
+	res = l.Allow(GroupWrite, "client")
+	require.True(t, res.Allowed)
+	require.Equal(t, 2, res.Remaining)
+}
+
+func TestMemoryLimiter_Unlimited(t *testing.T) {
+	now := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now)
+
+	for i := 0; i < 100; i++ {
+		res := l.Allow(GroupReports, "client")
+		require.True(t, res.Allowed)
+		require.Zero(t, res.Limit)
+	}
+
+	disabled := NewMemoryLimiter(config.RateLimitConfig{Write: config.RateLimit{Rate: 1, Burst: 1}})
+	for i := 0; i < 100; i++ {
+		require.True(t, disabled.Allow(GroupWrite, "client").Allowed)
+	}
+}
+
+func TestMemoryLimiter_Sweep(t *testing.T) {
+	now := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now)
+
+	l.Allow(GroupWrite, "idle")
+	now = now.Add(sweepInterval)
+	l.Allow(GroupWrite, "active")
+	require.Len(t, l.buckets, 1)
+	require.Contains(t, l.buckets, GroupWrite+":active")
+}
+
+func TestMemoryLimiter_SetConfig(t *testing.T) {
+	now := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	l := newTestLimiter(&now)
+
+	require.Equal(t, 2, l.Allow(GroupWrite, "client").Remaining)
+
+	// existing buckets are cut to the new burst
+	cfg := config.NewRateLimitConfig()
+	cfg.Enabled = true
+	cfg.Write = config.RateLimit{Rate: 1, Burst: 1}
+	l.SetConfig(cfg)
+
+	res := l.Allow(GroupWrite, "client")
+	require.True(t, res.Allowed)
+	require.Equal(t, 1, res.Limit)
+	require.Equal(t, 0, res.Remaining)
+	require.False(t, l.Allow(GroupWrite, "client").Allowed)
+
+	cfg.Enabled = false
+	l.SetConfig(cfg)
+	require.True(t, l.Allow(GroupWrite, "client").Allowed)
+}
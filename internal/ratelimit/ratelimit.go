@@ -0,0 +1,31 @@
+// Package ratelimit describes limiters of API clients requests.
+package ratelimit
+
+import "time"
+
+// Route groups limited separately.
+const (
+	GroupRead    = "read"
+	GroupWrite   = "write"
+	GroupReports = "reports"
+)
+
+// Result describes limiter decision and the state of client's bucket after the request.
+type Result struct {
+	Allowed bool
+	// Limit is the bucket capacity, zero if the group is not limited.
+	Limit int
+	// Remaining is count of requests the client can make right now.
+	Remaining int
+	// Reset is the time left until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time left until the next request is allowed, zero if the request is allowed.
+	RetryAfter time.Duration
+}
+
+// ILimiter describes the rate limiter of API clients requests.
+// Implementations must be safe for concurrent use.
+type ILimiter interface {
+	// Allow takes a token from the client's bucket of the route group.
+	Allow(group, key string) Result
+}
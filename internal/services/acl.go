@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+// restrictedPrincipal returns principal from context if segments ACL applies to it.
+func restrictedPrincipal(ctx context.Context) (*model.Principal, bool) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.HasScope(model.ScopeAdmin) {
+		return nil, false
+	}
+	return principal, true
+}
+
+// checkSegmentsAccess checks that caller has the permission on every segment given by slugs.
+func checkSegmentsAccess(ctx context.Context, db database.IDatabase, slugs []model.Slug, permission model.Permission) error {
+	principal, ok := restrictedPrincipal(ctx)
+	if !ok || len(slugs) == 0 {
+		return nil
+	}
+
+	segments, err := db.GetSegments(ctx, slugs)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if !segment.Allows(principal, permission) {
+			return fmt.Errorf("%w: no %s grant on segment %s", ErrForbidden, permission, segment.Slug)
+		}
+	}
+	return nil
+}
+
+// segmentsAuthorizer returns authorizer of segments changed implicitly along with the caller's change,
+// i.e. replaced in exclusion group or removed by prerequisites cascade, the caller must have the permission on every such segment.
+func segmentsAuthorizer(ctx context.Context, permission model.Permission) database.SegmentsAuthorizer {
+	principal, ok := restrictedPrincipal(ctx)
+	if !ok {
+		return nil
+	}
+
+	return func(segments []*model.Segment) error {
+		for _, segment := range segments {
+			if !segment.Allows(principal, permission) {
+				return fmt.Errorf("%w: no %s grant on segment %s", ErrForbidden, permission, segment.Slug)
+			}
+		}
+		return nil
+	}
+}
+
+// defaultGrants returns grants for segment created by caller without explicit ACL:
+// caller's teams own the segment and everyone else can read it.
+func defaultGrants(ctx context.Context) []model.SegmentGrant {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || len(principal.Teams) == 0 {
+		return nil
+	}
+
+	grants := make([]model.SegmentGrant, 0, len(principal.Teams)+1)
+	for _, team := range principal.Teams {
+		grants = append(grants, model.SegmentGrant{Team: team, Permission: model.PermissionWrite})
+	}
+	return append(grants, model.SegmentGrant{Team: model.AnyTeam, Permission: model.PermissionRead})
+}
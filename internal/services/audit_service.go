@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+type AuditService struct {
+	db database.IDatabase
+}
+
+func NewAuditService(db database.IDatabase) (*AuditService, error) {
+	return &AuditService{db: db}, nil
+}
+
+func (s AuditService) Record(ctx context.Context, record *model.AuditRecord) error {
+	return s.db.CreateAuditRecord(ctx, record)
+}
+
+func (s AuditService) GetAuditRecords(ctx context.Context, filter model.AuditFilter) (model.AuditRecords, error) {
+	records, err := s.db.GetAuditRecords(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if records == nil {
+		records = model.AuditRecords{}
+	}
+	return records, nil
+}
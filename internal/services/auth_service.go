@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+type principalCtxKey struct{}
+
+// ContextWithPrincipal returns context carrying authenticated principal.
+func ContextWithPrincipal(ctx context.Context, principal *model.Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, principal)
+}
+
+// PrincipalFromContext returns authenticated principal, ok is false for anonymous requests.
+func PrincipalFromContext(ctx context.Context) (*model.Principal, bool) {
+	principal, ok := ctx.Value(principalCtxKey{}).(*model.Principal)
+	return principal, ok
+}
+
+// jwtClaims describes accepted JWT claims, scopes are space separated as in RFC 8693,
+// teams are used for segments access control.
+type jwtClaims struct {
+	Scope string   `json:"scope"`
+	Teams []string `json:"teams"`
+	jwt.RegisteredClaims
+}
+
+type AuthService struct {
+	db        database.IDatabase
+	cfg       config.AuthConfig
+	publicKey *rsa.PublicKey
+}
+
+func NewAuthService(db database.IDatabase, cfg config.AuthConfig) (*AuthService, error) {
+	s := &AuthService{db: db, cfg: cfg}
+
+	if cfg.JWTPublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.JWTPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read jwt public key: %w", err)
+		}
+		s.publicKey, err = jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse jwt public key: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Enabled reports whether requests must be authenticated.
+func (s AuthService) Enabled() bool {
+	return s.cfg.Enabled
+}
+
+// Authenticate returns principal for bearer JWT from authorization header or for API key.
+func (s AuthService) Authenticate(ctx context.Context, authorization, apiKey string) (*model.Principal, error) {
+	if apiKey != "" {
+		return s.authenticateAPIKey(ctx, apiKey)
+	}
+
+	token, found := strings.CutPrefix(authorization, "Bearer ")
+	if !found || token == "" {
+		return nil, fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+	}
+	return s.authenticateJWT(token)
+}
+
+// Authorize checks that principal was granted the scope.
+func (s AuthService) Authorize(principal *model.Principal, scope model.Scope) error {
+	if !principal.HasScope(scope) {
+		return fmt.Errorf("%w: %s scope required", ErrForbidden, scope)
+	}
+	return nil
+}
+
+func (s AuthService) authenticateAPIKey(ctx context.Context, apiKey string) (*model.Principal, error) {
+	key, err := s.db.GetAPIKeyByHash(ctx, hashAPIKey(apiKey))
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, fmt.Errorf("%w: invalid api key", ErrUnauthenticated)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.Principal{
+		Subject:  key.Name,
+		ClientID: "key:" + strconv.FormatUint(key.ID, 10),
+		Scopes:   key.ScopesList(),
+		Teams:    key.TeamsList(),
+	}, nil
+}
+
+func (s AuthService) authenticateJWT(token string) (*model.Principal, error) {
+	options := []jwt.ParserOption{jwt.WithValidMethods([]string{"HS256", "RS256"}), jwt.WithExpirationRequired()}
+	if s.cfg.JWTIssuer != "" {
+		options = append(options, jwt.WithIssuer(s.cfg.JWTIssuer))
+	}
+	if s.cfg.JWTAudience != "" {
+		options = append(options, jwt.WithAudience(s.cfg.JWTAudience))
+	}
+
+	claims := &jwtClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, s.jwtKey, options...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	principal := &model.Principal{Subject: claims.Subject, ClientID: "jwt:" + claims.Subject, Teams: claims.Teams}
+	for _, scope := range strings.Fields(claims.Scope) {
+		principal.Scopes = append(principal.Scopes, model.Scope(scope))
+	}
+	return principal, nil
+}
+
+// jwtKey returns verification key for token signing method.
+func (s AuthService) jwtKey(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case "HS256":
+		if s.cfg.JWTSecret == "" {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return []byte(s.cfg.JWTSecret), nil
+	case "RS256":
+		if s.publicKey == nil {
+			return nil, errors.New("RS256 tokens are not accepted")
+		}
+		return s.publicKey, nil
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+}
+
+// CreateAPIKey generates new API key, only its hash is saved.
+func (s AuthService) CreateAPIKey(ctx context.Context, input *model.CreateAPIKeyInput) (*model.APIKeyOutput, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	apiKey := hex.EncodeToString(buf)
+
+	scopes := make([]string, 0, len(input.Scopes))
+	for _, scope := range input.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	key, err := s.db.CreateAPIKey(ctx, &model.APIKey{
+		Name:    input.Name,
+		KeyHash: hashAPIKey(apiKey),
+		Scopes:  strings.Join(scopes, ","),
+		Teams:   strings.Join(input.Teams, ","),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.APIKeyOutput{
+		ID:     key.ID,
+		Name:   key.Name,
+		Key:    apiKey,
+		Scopes: key.ScopesList(),
+		Teams:  key.TeamsList(),
+	}, nil
+}
+
+func (s AuthService) DeleteAPIKey(ctx context.Context, input *model.APIKeyInput) error {
+	return s.db.DeleteAPIKey(ctx, &model.APIKey{ID: input.ID})
+}
+
+func hashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,14 @@
+package services
+
+import "errors"
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+
+	ErrIdempotencyKeyReused     = errors.New("idempotency key is already used for another request")
+	ErrIdempotencyKeyInProgress = errors.New("request with the idempotency key is in progress")
+
+	ErrSchemaVersionMismatch = errors.New("unexpected schema version")
+	ErrWorkersNotRunning     = errors.New("workers pool is not running")
+)
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+type EventService struct {
+	db   database.IDatabase
+	cfg  config.EventsConfig
+	done chan struct{}
+}
+
+func NewEventService(db database.IDatabase, cfg config.EventsConfig) (*EventService, error) {
+	return &EventService{db: db, cfg: cfg, done: make(chan struct{})}, nil
+}
+
+// Shutdown finishes all active streams, so server can close their connections.
+func (s EventService) Shutdown() {
+	close(s.done)
+}
+
+// StreamEvents polls the event log for events matching the filter and passes them to send
+// until ctx is done, service is shut down or send fails. Events of segments the caller has no read grant on
+// are skipped. When there are no new events for the heartbeat interval send is called with empty batch,
+// so the caller can keep the connection alive.
+func (s EventService) StreamEvents(ctx context.Context, filter model.EventsFilter, send func(events []model.Event) error) error {
+	filter.Limit = s.cfg.BatchSize
+
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+
+	lastSent := time.Now()
+	for {
+		events, err := s.db.GetEvents(ctx, filter)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		read := len(events)
+		if read > 0 {
+			filter.AfterID = events[read-1].ID
+			events, err = s.readableEvents(ctx, events)
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(events) > 0 {
+			if err = send(events); err != nil {
+				return err
+			}
+			lastSent = time.Now()
+		} else if time.Since(lastSent) >= s.cfg.Heartbeat {
+			if err = send(nil); err != nil {
+				return err
+			}
+			lastSent = time.Now()
+		}
+
+		// the batch is full, there may be more events to read right away
+		if read == filter.Limit {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.done:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// readableEvents returns events of segments the caller has read grant on.
+func (s EventService) readableEvents(ctx context.Context, events []model.Event) ([]model.Event, error) {
+	principal, restricted := restrictedPrincipal(ctx)
+	if !restricted {
+		return events, nil
+	}
+
+	slugs := make([]model.Slug, 0, len(events))
+	seen := make(map[model.Slug]bool, len(events))
+	for _, event := range events {
+		if !seen[event.SegmentSlug] {
+			seen[event.SegmentSlug] = true
+			slugs = append(slugs, event.SegmentSlug)
+		}
+	}
+
+	segments, err := s.db.ListSegments(ctx, model.SegmentsFilter{Slugs: slugs, WithDeleted: true})
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[model.Slug]bool, len(segments))
+	for _, segment := range segments {
+		allowed[segment.Slug] = segment.Allows(principal, model.PermissionRead)
+	}
+
+	readable := events[:0]
+	for _, event := range events {
+		if allowed[event.SegmentSlug] {
+			readable = append(readable, event)
+		}
+	}
+	return readable, nil
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+// Readiness checks names.
+const (
+	CheckDatabase = "database"
+	CheckSchema   = "schema"
+	CheckFiles    = "files"
+	CheckWorkers  = "workers"
+)
+
+// IWorkersPool describes the workers pool state used by readiness checks.
+type IWorkersPool interface {
+	Running() bool
+}
+
+type HealthService struct {
+	db       database.IDatabase
+	wp       IWorkersPool
+	fileDir  string
+	cfg      config.HealthConfig
+	draining atomic.Bool
+}
+
+func NewHealthService(db database.IDatabase, wp IWorkersPool, fileDir string, cfg config.HealthConfig) (*HealthService, error) {
+	return &HealthService{db: db, wp: wp, fileDir: fileDir, cfg: cfg}, nil
+}
+
+// Live reports the process is able to serve requests.
+func (s *HealthService) Live() model.HealthOutput {
+	return model.HealthOutput{HttpCode: http.StatusOK, Status: model.HealthOK}
+}
+
+// Ready checks dependencies needed to serve requests concurrently.
+// The application is not ready while it is draining before shutdown.
+func (s *HealthService) Ready(ctx context.Context) model.HealthOutput {
+	if s.draining.Load() {
+		return model.HealthOutput{HttpCode: http.StatusServiceUnavailable, Status: model.HealthDraining}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.CheckTimeout)
+	defer cancel()
+
+	checks := map[string]func(ctx context.Context) error{
+		CheckDatabase: s.db.Ping,
+		CheckSchema:   s.checkSchema,
+		CheckFiles:    s.checkFiles,
+		CheckWorkers:  s.checkWorkers,
+	}
+
+	output := model.HealthOutput{
+		HttpCode: http.StatusOK,
+		Status:   model.HealthReady,
+		Checks:   make(map[string]string, len(checks)),
+	}
+
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+
+			// the probe is unauthenticated, so errors details are not exposed
+			result := model.HealthOK
+			if err := check(ctx); err != nil {
+				log.Warnf("HealthService.Ready check %s failed: %v", name, err)
+				result = model.HealthFailed
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			output.Checks[name] = result
+			if result != model.HealthOK {
+				output.HttpCode = http.StatusServiceUnavailable
+				output.Status = model.HealthNotReady
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return output
+}
+
+// Drain marks the application not ready, so load balancers stop routing traffic to it,
+// and waits configured delay for them to notice.
+func (s *HealthService) Drain() {
+	s.draining.Store(true)
+	time.Sleep(s.cfg.DrainDelay)
+}
+
+// checkSchema checks the schema is migrated at least to the version the application works with,
+// newer schema is compatible, so instances are ready during rolling update.
+func (s *HealthService) checkSchema(ctx context.Context) error {
+	version, err := s.db.GetSchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version < database.SchemaVersion {
+		return fmt.Errorf("%w: %d, expected at least %d", ErrSchemaVersionMismatch, version, database.SchemaVersion)
+	}
+	return nil
+}
+
+// checkFiles checks reports directory is writable.
+func (s *HealthService) checkFiles(_ context.Context) error {
+	file, err := os.CreateTemp(s.fileDir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+func (s *HealthService) checkWorkers(_ context.Context) error {
+	if !s.wp.Running() {
+		return ErrWorkersNotRunning
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+type stubWorkersPool bool
+
+func (s stubWorkersPool) Running() bool {
+	return bool(s)
+}
+
+func TestHealthService_Ready(t *testing.T) {
+	tests := []struct {
+		name       string
+		buildStubs func(db *mock_database.MockIDatabase)
+		fileDir    func(t *testing.T) string
+		running    bool
+		wantStatus string
+		wantFailed []string
+	}{
+		{
+			name: "Ready",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().Ping(gomock.Any()).Return(nil)
+				db.EXPECT().GetSchemaVersion(gomock.Any()).Return(database.SchemaVersion, nil)
+			},
+			fileDir:    func(t *testing.T) string { return t.TempDir() },
+			running:    true,
+			wantStatus: model.HealthReady,
+		},
+		{
+			name: "Newer schema",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().Ping(gomock.Any()).Return(nil)
+				db.EXPECT().GetSchemaVersion(gomock.Any()).Return(database.SchemaVersion+1, nil)
+			},
+			fileDir:    func(t *testing.T) string { return t.TempDir() },
+			running:    true,
+			wantStatus: model.HealthReady,
+		},
+		{
+			name: "Dependencies are down",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().Ping(gomock.Any()).Return(database.ErrDB)
+				db.EXPECT().GetSchemaVersion(gomock.Any()).Return(database.SchemaVersion-1, nil)
+			},
+			fileDir:    func(t *testing.T) string { return filepath.Join(t.TempDir(), "missing") },
+			running:    false,
+			wantStatus: model.HealthNotReady,
+			wantFailed: []string{CheckDatabase, CheckSchema, CheckFiles, CheckWorkers},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock_database.NewMockIDatabase(ctrl)
+			tt.buildStubs(db)
+
+			s, err := NewHealthService(db, stubWorkersPool(tt.running), tt.fileDir(t), config.NewHealthConfig())
+			require.NoError(t, err)
+
+			output := s.Ready(context.Background())
+			require.Equal(t, tt.wantStatus, output.Status)
+			require.Len(t, output.Checks, 4)
+			for _, name := range tt.wantFailed {
+				require.Equal(t, model.HealthFailed, output.Checks[name], name)
+			}
+			if tt.wantFailed == nil {
+				require.Equal(t, http.StatusOK, output.HttpCode)
+			} else {
+				require.Equal(t, http.StatusServiceUnavailable, output.HttpCode)
+			}
+		})
+	}
+}
+
+func TestHealthService_Drain(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := mock_database.NewMockIDatabase(ctrl)
+	db.EXPECT().Ping(gomock.Any()).Times(0)
+
+	cfg := config.NewHealthConfig()
+	cfg.DrainDelay = 0
+	s, err := NewHealthService(db, stubWorkersPool(true), t.TempDir(), cfg)
+	require.NoError(t, err)
+
+	s.Drain()
+
+	output := s.Ready(context.Background())
+	require.Equal(t, http.StatusServiceUnavailable, output.HttpCode)
+	require.Equal(t, model.HealthDraining, output.Status)
+	require.Equal(t, http.StatusOK, s.Live().HttpCode)
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+type IdempotencyService struct {
+	db      database.IDatabase
+	ttl     time.Duration
+	lease   time.Duration
+	nowFunc func() time.Time
+}
+
+func NewIdempotencyService(db database.IDatabase, cfg config.IdempotencyConfig) (*IdempotencyService, error) {
+	return &IdempotencyService{db: db, ttl: cfg.TTL, lease: cfg.Lease, nowFunc: time.Now}, nil
+}
+
+// Begin reserves idempotency key for the request with given fingerprint.
+// If the same request was already made with the key, its record is returned with replay flag
+// to respond with saved response. The key is locked for the lease, a retry of the request
+// which is still in progress after the lease takes the key over.
+func (s IdempotencyService) Begin(ctx context.Context, key, fingerprint string) (*model.IdempotencyRecord, bool, error) {
+	// the database keeps microseconds, lock time is compared on completion
+	now := s.nowFunc().Truncate(time.Microsecond)
+	record, created, err := s.db.CreateIdempotencyRecord(ctx, &model.IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		LockedUntil: now.Add(s.lease),
+		ExpiresAt:   now.Add(s.ttl),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if created {
+		return record, false, nil
+	}
+
+	if record.Fingerprint != fingerprint {
+		return nil, false, ErrIdempotencyKeyReused
+	}
+	if !record.Completed {
+		return nil, false, ErrIdempotencyKeyInProgress
+	}
+	return record, true, nil
+}
+
+// Complete saves response of the request to replay it.
+func (s IdempotencyService) Complete(ctx context.Context, record *model.IdempotencyRecord, statusCode int, contentType string, body []byte) error {
+	record.StatusCode = statusCode
+	record.ContentType = contentType
+	record.Body = body
+	return s.db.CompleteIdempotencyRecord(ctx, record)
+}
+
+// Release frees idempotency key of the failed request, so it can be retried.
+func (s IdempotencyService) Release(ctx context.Context, record *model.IdempotencyRecord) error {
+	return s.db.DeleteIdempotencyRecord(ctx, record)
+}
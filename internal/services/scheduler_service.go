@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/tracing"
+)
+
+// SchedulerActor is the audit actor of segments deleted at the end of their active window.
+const SchedulerActor = "scheduler"
+
+// SchedulerService deletes segments at the end of their active window. Segments are deleted
+// the same way as by API call, so users lose them with delete events and history records.
+type SchedulerService struct {
+	db  database.IDatabase
+	cfg config.SchedulerConfig
+
+	// ctx is cancelled on Shutdown, so the deletion in progress is interrupted.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+func NewSchedulerService(db database.IDatabase, cfg config.SchedulerConfig) (*SchedulerService, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SchedulerService{
+		db:       db,
+		cfg:      cfg,
+		ctx:      ctx,
+		cancel:   cancel,
+		finished: make(chan struct{}),
+	}, nil
+}
+
+// Run periodically deletes expired segments until Shutdown is called.
+func (s *SchedulerService) Run() {
+	defer close(s.finished)
+
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.deleteExpired(s.ctx, time.Now()); err != nil {
+				log.Errorf("SchedulerService.deleteExpired got error: %v", err)
+			}
+		}
+	}
+}
+
+// Shutdown stops the scheduler, cancels the deletion in progress and waits for Run to return.
+func (s *SchedulerService) Shutdown() {
+	s.cancel()
+	<-s.finished
+}
+
+// deleteExpired deletes segments with active window ended by now, segments deleted concurrently are skipped.
+// Segment failed to delete is logged and left for the next run, the rest are deleted anyway.
+func (s *SchedulerService) deleteExpired(ctx context.Context, now time.Time) error {
+	ctx, span := tracing.Start(ctx, "SchedulerService.deleteExpired")
+	defer span.End()
+
+	segments, err := s.db.ListSegments(ctx, model.SegmentsFilter{ExpiredAt: now})
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.Int("segments.expired", len(segments)))
+
+	for _, segment := range segments {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		// the deletion writes the audit record along with removed memberships
+		record := &model.AuditRecord{
+			Action:     model.AuditSegmentDelete,
+			Actor:      SchedulerActor,
+			Segments:   string(segment.Slug),
+			Reason:     "active window ended",
+			StatusCode: http.StatusOK,
+		}
+		err = s.db.DeleteSegment(database.ContextWithAudit(ctx, record), &model.Segment{Slug: segment.Slug}, nil)
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Errorf("SchedulerService.deleteExpired segment %s: %v", segment.Slug, err)
+			continue
+		}
+
+		log.Infof("segment %s is deleted at the end of its active window %s", segment.Slug, segment.ActiveUntil)
+		if record.ID > 0 {
+			continue
+		}
+		if err = s.db.CreateAuditRecord(ctx, record); err != nil {
+			log.Errorf("SchedulerService.deleteExpired audit record of segment %s: %v", segment.Slug, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+func TestSchedulerService_DeleteExpired(t *testing.T) {
+	now := time.Now()
+	ended := now.Add(-time.Minute)
+
+	tests := []struct {
+		name       string
+		buildStubs func(db *mock_database.MockIDatabase)
+		expected   error
+	}{
+		{
+			name: "Deleted",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().ListSegments(gomock.Any(), model.SegmentsFilter{ExpiredAt: now}).Return([]*model.Segment{
+					{Slug: "BLACK_FRIDAY", ActiveUntil: &ended},
+					{Slug: "CYBER_MONDAY", ActiveUntil: &ended},
+				}, nil)
+				db.EXPECT().DeleteSegment(gomock.Any(), &model.Segment{Slug: "BLACK_FRIDAY"}, nil).Return(nil)
+				db.EXPECT().DeleteSegment(gomock.Any(), &model.Segment{Slug: "CYBER_MONDAY"}, nil).Return(nil)
+				db.EXPECT().
+					CreateAuditRecord(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, record *model.AuditRecord) error {
+						require.Equal(t, model.AuditSegmentDelete, record.Action)
+						require.Equal(t, SchedulerActor, record.Actor)
+						return nil
+					}).
+					Times(2)
+			},
+		},
+		{
+			name: "Recorded with the deletion",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().ListSegments(gomock.Any(), gomock.Any()).Return([]*model.Segment{{Slug: "BLACK_FRIDAY"}}, nil)
+				db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, _ *model.Segment, _ database.SegmentsAuthorizer) error {
+						record, ok := database.AuditFromContext(ctx)
+						require.True(t, ok)
+						require.Equal(t, SchedulerActor, record.Actor)
+						record.ID = 1
+						return nil
+					})
+				db.EXPECT().CreateAuditRecord(gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+		{
+			name: "Deleted concurrently",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().ListSegments(gomock.Any(), gomock.Any()).Return([]*model.Segment{{Slug: "BLACK_FRIDAY"}}, nil)
+				db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Return(database.ErrNotFound)
+				db.EXPECT().CreateAuditRecord(gomock.Any(), gomock.Any()).Times(0)
+			},
+		},
+		{
+			name: "Database error does not stop the rest",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().ListSegments(gomock.Any(), gomock.Any()).Return([]*model.Segment{
+					{Slug: "BLACK_FRIDAY"},
+					{Slug: "CYBER_MONDAY"},
+				}, nil)
+				db.EXPECT().DeleteSegment(gomock.Any(), &model.Segment{Slug: "BLACK_FRIDAY"}, nil).Return(database.ErrDB)
+				db.EXPECT().DeleteSegment(gomock.Any(), &model.Segment{Slug: "CYBER_MONDAY"}, nil).Return(nil)
+				db.EXPECT().CreateAuditRecord(gomock.Any(), gomock.Any()).Times(1)
+			},
+		},
+		{
+			name: "List error",
+			buildStubs: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().ListSegments(gomock.Any(), gomock.Any()).Return(nil, database.ErrDB)
+			},
+			expected: database.ErrDB,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock_database.NewMockIDatabase(ctrl)
+			tt.buildStubs(db)
+
+			service, err := NewSchedulerService(db, config.NewSchedulerConfig())
+			require.NoError(t, err)
+
+			err = service.deleteExpired(context.Background(), now)
+			require.ErrorIs(t, err, tt.expected)
+		})
+	}
+}
+
+func TestSchedulerService_Shutdown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	started := make(chan struct{})
+	db := mock_database.NewMockIDatabase(ctrl)
+	db.EXPECT().ListSegments(gomock.Any(), gomock.Any()).Return([]*model.Segment{{Slug: "BLACK_FRIDAY"}}, nil)
+	db.EXPECT().ListSegments(gomock.Any(), gomock.Any()).Return(nil, context.Canceled).AnyTimes()
+	db.EXPECT().
+		DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, _ *model.Segment, _ database.SegmentsAuthorizer) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+	service, err := NewSchedulerService(db, config.SchedulerConfig{Interval: time.Millisecond})
+	require.NoError(t, err)
+	finished := make(chan struct{})
+	go func() {
+		service.Run()
+		close(finished)
+	}()
+
+	// the deletion in progress is cancelled, so shutdown doesn't wait for it
+	<-started
+	service.Shutdown()
+	<-finished
+}
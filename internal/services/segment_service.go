@@ -2,9 +2,14 @@ package services
 
 import (
 	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/unbeman/av-prac-task/internal/database"
 	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/tracing"
+	"github.com/unbeman/av-prac-task/pkg/evaluation"
 )
 
 type SegmentService struct {
@@ -15,28 +20,432 @@ func NewSegmentService(db database.IDatabase) (*SegmentService, error) {
 	return &SegmentService{db: db}, nil
 }
 
-func (s SegmentService) CreateSegment(ctx context.Context, input *model.CreateSegmentInput) (*model.Segment, error) {
-	var err error
-	segment := &model.Segment{Slug: input.Slug}
+func (s SegmentService) CreateSegment(ctx context.Context, input *model.CreateSegmentInput) (*model.CreateSegmentOutput, error) {
+	ctx, span := tracing.Start(ctx, "SegmentService.CreateSegment", attribute.String("segment.slug", string(input.Slug)))
+	defer span.End()
 
-	segment, err = s.db.CreateSegment(ctx, segment)
-	if err != nil {
-		return nil, err
+	var err error
+	segment := &model.Segment{
+		Slug:        input.Slug,
+		Grants:      input.Grants,
+		Rollout:     input.Rollout,
+		Rules:       input.Rules,
+		ActiveFrom:  input.ActiveFrom,
+		ActiveUntil: input.ActiveUntil,
+	}
+	if len(segment.Grants) == 0 {
+		segment.Grants = defaultGrants(ctx)
+	}
+	if input.Group != nil {
+		group, err := s.db.GetExclusionGroup(ctx, &model.ExclusionGroup{Slug: *input.Group})
+		if err != nil {
+			return nil, err
+		}
+		// the new segment of the group with reject policy blocks adding users to the other segments
+		if err = checkSegmentsAccess(ctx, s.db, groupSlugs(group), model.PermissionWrite); err != nil {
+			return nil, err
+		}
+		segment.GroupID = &group.ID
+	}
+	if len(input.Prerequisites) > 0 {
+		segment.Prerequisites, err = s.resolvePrerequisites(ctx, input.Prerequisites)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if input.Selection == nil {
-		return segment, nil
+		segment, err = s.db.CreateSegment(ctx, segment)
+		if err != nil {
+			return nil, err
+		}
+		return &model.CreateSegmentOutput{Slug: segment.Slug}, nil
 	}
 
-	err = s.db.AddSegmentToRandomUsers(ctx, segment, *input.Selection)
+	output := &model.CreateSegmentOutput{}
+	segment, output.AssignedUsers, err = s.db.CreateSegmentForRandomUsers(ctx, segment, *input.Selection)
 	if err != nil {
 		return nil, err
 	}
+	output.Slug = segment.Slug
 
-	return segment, nil
+	return output, nil
 }
 
 func (s SegmentService) DeleteSegment(ctx context.Context, input *model.SegmentInput) error {
+	ctx, span := tracing.Start(ctx, "SegmentService.DeleteSegment", attribute.String("segment.slug", string(input.Slug)))
+	defer span.End()
+
+	if err := checkSegmentsAccess(ctx, s.db, []model.Slug{input.Slug}, model.PermissionWrite); err != nil {
+		return err
+	}
+
 	segment := model.Segment{Slug: input.Slug}
-	return s.db.DeleteSegment(ctx, &segment)
+	return s.db.DeleteSegment(ctx, &segment, segmentsAuthorizer(ctx, model.PermissionWrite))
+}
+
+// RestoreSegment restores deleted segment without its former users.
+func (s SegmentService) RestoreSegment(ctx context.Context, input *model.SegmentInput) error {
+	ctx, span := tracing.Start(ctx, "SegmentService.RestoreSegment", attribute.String("segment.slug", string(input.Slug)))
+	defer span.End()
+
+	if principal, ok := restrictedPrincipal(ctx); ok {
+		segments, err := s.db.ListSegments(ctx, model.SegmentsFilter{Slugs: []model.Slug{input.Slug}, WithDeleted: true})
+		if err != nil {
+			return err
+		}
+		if len(segments) == 1 && !segments[0].Allows(principal, model.PermissionWrite) {
+			return fmt.Errorf("%w: no %s grant on segment %s", ErrForbidden, model.PermissionWrite, input.Slug)
+		}
+	}
+
+	segment := model.Segment{Slug: input.Slug}
+	return s.db.RestoreSegment(ctx, &segment)
+}
+
+// ListSegments returns segments ordered by slug, segments the caller has no read grant on are omitted.
+func (s SegmentService) ListSegments(ctx context.Context, filter model.SegmentsFilter) ([]*model.Segment, error) {
+	ctx, span := tracing.Start(ctx, "SegmentService.ListSegments")
+	defer span.End()
+
+	segments, err := s.db.ListSegments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	principal, restricted := restrictedPrincipal(ctx)
+	if !restricted {
+		return segments, nil
+	}
+	allowed := make([]*model.Segment, 0, len(segments))
+	for _, segment := range segments {
+		if segment.Allows(principal, model.PermissionRead) {
+			allowed = append(allowed, segment)
+		}
+	}
+	return allowed, nil
+}
+
+// GetSnapshot returns definitions of segments changed since the input version, zero version requests all
+// active segments. In delta deleted segments and segments the caller has lost read grant on are marked deleted.
+func (s SegmentService) GetSnapshot(ctx context.Context, input *model.SnapshotInput) (*model.SegmentsSnapshot, error) {
+	ctx, span := tracing.Start(ctx, "SegmentService.GetSnapshot", attribute.Int64("snapshot.since", int64(input.Since)))
+	defer span.End()
+
+	version, err := s.db.GetSegmentsVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &model.SegmentsSnapshot{Version: version, Segments: []model.SegmentDefinition{}}
+	since := input.Since
+	// the client is ahead of the storage, e.g. it was restored from backup
+	if since > version {
+		since = 0
+	}
+	snapshot.Full = since == 0
+
+	segments, err := s.db.ListSegments(ctx, model.SegmentsFilter{SinceVersion: since, WithDeleted: !snapshot.Full})
+	if err != nil {
+		return nil, err
+	}
+
+	principal, restricted := restrictedPrincipal(ctx)
+	for _, segment := range segments {
+		// the segment is changed after the version was read
+		if segment.Version > snapshot.Version {
+			snapshot.Version = segment.Version
+		}
+
+		definition := model.NewSegmentDefinition(segment)
+		if restricted && !segment.Allows(principal, model.PermissionRead) {
+			if snapshot.Full {
+				continue
+			}
+			definition = model.SegmentDefinition{Slug: segment.Slug, Version: segment.Version, Deleted: true}
+		}
+		snapshot.Segments = append(snapshot.Segments, definition)
+	}
+
+	return snapshot, nil
+}
+
+// resolvePrerequisites returns prerequisites relations of the new segment, prerequisites must be explicit segments.
+// Prerequisite blocks removal of users from it, so the caller must have write grant on it.
+func (s SegmentService) resolvePrerequisites(ctx context.Context, input []model.PrerequisiteInput) ([]model.SegmentPrerequisite, error) {
+	slugs := make([]model.Slug, 0, len(input))
+	policies := make(map[model.Slug]model.PrerequisitePolicy, len(input))
+	for _, prerequisite := range input {
+		slugs = append(slugs, prerequisite.Slug)
+		policies[prerequisite.Slug] = prerequisite.OnRemove
+	}
+
+	segments, err := s.db.GetSegments(ctx, slugs)
+	if err != nil {
+		return nil, err
+	}
+
+	principal, restricted := restrictedPrincipal(ctx)
+	prerequisites := make([]model.SegmentPrerequisite, 0, len(segments))
+	for _, segment := range segments {
+		if restricted && !segment.Allows(principal, model.PermissionWrite) {
+			return nil, fmt.Errorf("%w: no %s grant on segment %s", ErrForbidden, model.PermissionWrite, segment.Slug)
+		}
+		if segment.IsDynamic() {
+			return nil, fmt.Errorf("segment with slug (%s) users are evaluated from its definition, %w", segment.Slug, database.ErrDynamicSegment)
+		}
+		prerequisites = append(prerequisites, model.SegmentPrerequisite{PrerequisiteID: segment.ID, OnRemove: policies[segment.Slug]})
+	}
+	return prerequisites, nil
+}
+
+func (s SegmentService) GetSegmentGrants(ctx context.Context, input *model.SegmentInput) (*model.SegmentGrantsOutput, error) {
+	ctx, span := tracing.Start(ctx, "SegmentService.GetSegmentGrants", attribute.String("segment.slug", string(input.Slug)))
+	defer span.End()
+
+	segments, err := s.db.GetSegments(ctx, []model.Slug{input.Slug})
+	if err != nil {
+		return nil, err
+	}
+	segment := segments[0]
+
+	if principal, ok := restrictedPrincipal(ctx); ok && !segment.Allows(principal, model.PermissionRead) {
+		return nil, fmt.Errorf("%w: no %s grant on segment %s", ErrForbidden, model.PermissionRead, segment.Slug)
+	}
+
+	grants := segment.Grants
+	if grants == nil {
+		grants = []model.SegmentGrant{}
+	}
+	return &model.SegmentGrantsOutput{Slug: segment.Slug, Grants: grants}, nil
+}
+
+func (s SegmentService) SetSegmentGrants(ctx context.Context, input *model.SegmentGrantsInput) error {
+	ctx, span := tracing.Start(ctx, "SegmentService.SetSegmentGrants", attribute.String("segment.slug", string(input.Slug)))
+	defer span.End()
+
+	segments, err := s.db.GetSegments(ctx, []model.Slug{input.Slug})
+	if err != nil {
+		return err
+	}
+	segment := segments[0]
+
+	if principal, ok := restrictedPrincipal(ctx); ok && !segment.Allows(principal, model.PermissionWrite) {
+		return fmt.Errorf("%w: no %s grant on segment %s", ErrForbidden, model.PermissionWrite, segment.Slug)
+	}
+
+	return s.db.SetSegmentGrants(ctx, segment, input.Grants)
+}
+
+// CreateExperiment creates the experiment and its variants segments with disjoint parts of users by weights.
+func (s SegmentService) CreateExperiment(ctx context.Context, input *model.CreateExperimentInput) (*model.ExperimentOutput, error) {
+	ctx, span := tracing.Start(ctx, "SegmentService.CreateExperiment", attribute.String("experiment.slug", string(input.Slug)))
+	defer span.End()
+
+	weights := make([]float64, 0, len(input.Variants))
+	for _, variant := range input.Variants {
+		weights = append(weights, variant.Weight)
+	}
+	allocation, err := evaluation.Allocate(make([][]evaluation.Range, len(weights)), weights)
+	if err != nil {
+		return nil, err
+	}
+
+	grants := input.Grants
+	if len(grants) == 0 {
+		grants = defaultGrants(ctx)
+	}
+
+	experiment := &model.Experiment{Slug: input.Slug, Variants: make([]model.Segment, 0, len(input.Variants))}
+	for idx, variant := range input.Variants {
+		experiment.Variants = append(experiment.Variants, model.Segment{
+			Slug:   variant.Slug,
+			Grants: append([]model.SegmentGrant(nil), grants...),
+			Variant: &evaluation.Variant{
+				Experiment: string(input.Slug),
+				Weight:     variant.Weight,
+				Ranges:     allocation[idx],
+			},
+		})
+	}
+
+	experiment, err = s.db.CreateExperiment(ctx, experiment)
+	if err != nil {
+		return nil, err
+	}
+	return model.NewExperimentOutput(experiment), nil
+}
+
+// GetExperiment returns the experiment variants with their weights, variants the caller has no read grant on are omitted.
+func (s SegmentService) GetExperiment(ctx context.Context, input *model.ExperimentInput) (*model.ExperimentOutput, error) {
+	ctx, span := tracing.Start(ctx, "SegmentService.GetExperiment", attribute.String("experiment.slug", string(input.Slug)))
+	defer span.End()
+
+	experiment, err := s.db.GetExperiment(ctx, &model.Experiment{Slug: input.Slug})
+	if err != nil {
+		return nil, err
+	}
+
+	if principal, ok := restrictedPrincipal(ctx); ok {
+		allowed := experiment.Variants[:0]
+		for _, variant := range experiment.Variants {
+			if variant.Allows(principal, model.PermissionRead) {
+				allowed = append(allowed, variant)
+			}
+		}
+		experiment.Variants = allowed
+	}
+	return model.NewExperimentOutput(experiment), nil
+}
+
+// UpdateExperimentWeights changes weights of the experiment variants, users stay in their variants
+// unless its weight decreases. Requires write grant on every variant.
+func (s SegmentService) UpdateExperimentWeights(ctx context.Context, input *model.ExperimentWeightsInput) (*model.ExperimentOutput, error) {
+	ctx, span := tracing.Start(ctx, "SegmentService.UpdateExperimentWeights", attribute.String("experiment.slug", string(input.Slug)))
+	defer span.End()
+
+	if principal, ok := restrictedPrincipal(ctx); ok {
+		experiment, err := s.db.GetExperiment(ctx, &model.Experiment{Slug: input.Slug})
+		if err != nil {
+			return nil, err
+		}
+		for _, variant := range experiment.Variants {
+			if !variant.Allows(principal, model.PermissionWrite) {
+				return nil, fmt.Errorf("%w: no %s grant on segment %s", ErrForbidden, model.PermissionWrite, variant.Slug)
+			}
+		}
+	}
+
+	experiment, err := s.db.UpdateExperimentWeights(ctx, &model.Experiment{Slug: input.Slug}, input.Weights())
+	if err != nil {
+		return nil, err
+	}
+	return model.NewExperimentOutput(experiment), nil
+}
+
+// GetUserVariants returns the user's variant of every experiment, variants the caller has no read grant on are omitted.
+func (s SegmentService) GetUserVariants(ctx context.Context, input *model.UserInput) (model.UserVariants, error) {
+	ctx, span := tracing.Start(ctx, "SegmentService.GetUserVariants", attribute.Int64("user.id", int64(input.UserID)))
+	defer span.End()
+
+	experiments, err := s.db.ListExperiments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	principal, restricted := restrictedPrincipal(ctx)
+	variants := make(model.UserVariants, 0, len(experiments))
+	for _, experiment := range experiments {
+		userVariant := model.UserVariant{Experiment: experiment.Slug}
+		for idx := range experiment.Variants {
+			variant := &experiment.Variants[idx]
+			if restricted && !variant.Allows(principal, model.PermissionRead) {
+				continue
+			}
+			if variant.Matches(input.UserID, evaluation.Attributes{}) {
+				userVariant.Variant = &variant.Slug
+				break
+			}
+		}
+		variants = append(variants, userVariant)
+	}
+	return variants, nil
+}
+
+// CreateExclusionGroup creates the exclusion group of segments, requires write grant on every segment.
+func (s SegmentService) CreateExclusionGroup(ctx context.Context, input *model.CreateExclusionGroupInput) (*model.ExclusionGroupOutput, error) {
+	ctx, span := tracing.Start(ctx, "SegmentService.CreateExclusionGroup", attribute.String("group.slug", string(input.Slug)))
+	defer span.End()
+
+	if err := checkSegmentsAccess(ctx, s.db, input.Segments, model.PermissionWrite); err != nil {
+		return nil, err
+	}
+
+	group, err := s.db.CreateExclusionGroup(ctx, &model.ExclusionGroup{Slug: input.Slug, Policy: input.Policy}, input.Segments)
+	if err != nil {
+		return nil, err
+	}
+	return model.NewExclusionGroupOutput(group), nil
+}
+
+// GetExclusionGroup returns the exclusion group with its active segments.
+func (s SegmentService) GetExclusionGroup(ctx context.Context, input *model.ExclusionGroupInput) (*model.ExclusionGroupOutput, error) {
+	ctx, span := tracing.Start(ctx, "SegmentService.GetExclusionGroup", attribute.String("group.slug", string(input.Slug)))
+	defer span.End()
+
+	group, err := s.db.GetExclusionGroup(ctx, &model.ExclusionGroup{Slug: input.Slug})
+	if err != nil {
+		return nil, err
+	}
+	return model.NewExclusionGroupOutput(group), nil
+}
+
+// DeleteExclusionGroup deletes the exclusion group, requires write grant on every segment of the group.
+func (s SegmentService) DeleteExclusionGroup(ctx context.Context, input *model.ExclusionGroupInput) error {
+	ctx, span := tracing.Start(ctx, "SegmentService.DeleteExclusionGroup", attribute.String("group.slug", string(input.Slug)))
+	defer span.End()
+
+	if _, ok := restrictedPrincipal(ctx); ok {
+		group, err := s.db.GetExclusionGroup(ctx, &model.ExclusionGroup{Slug: input.Slug})
+		if err != nil {
+			return err
+		}
+		if err = checkSegmentsAccess(ctx, s.db, groupSlugs(group), model.PermissionWrite); err != nil {
+			return err
+		}
+	}
+
+	return s.db.DeleteExclusionGroup(ctx, &model.ExclusionGroup{Slug: input.Slug})
+}
+
+// groupSlugs returns slugs of the group segments, they are loaded without grants to check access.
+func groupSlugs(group *model.ExclusionGroup) []model.Slug {
+	slugs := make([]model.Slug, 0, len(group.Segments))
+	for _, segment := range group.Segments {
+		slugs = append(slugs, segment.Slug)
+	}
+	return slugs
+}
+
+// GetSegmentPrerequisites returns the segment prerequisites, requires read grant on the segment.
+func (s SegmentService) GetSegmentPrerequisites(ctx context.Context, input *model.SegmentInput) (*model.SegmentPrerequisitesOutput, error) {
+	ctx, span := tracing.Start(ctx, "SegmentService.GetSegmentPrerequisites", attribute.String("segment.slug", string(input.Slug)))
+	defer span.End()
+
+	segment, err := s.db.GetSegmentPrerequisites(ctx, &model.Segment{Slug: input.Slug})
+	if err != nil {
+		return nil, err
+	}
+
+	if principal, ok := restrictedPrincipal(ctx); ok && !segment.Allows(principal, model.PermissionRead) {
+		return nil, fmt.Errorf("%w: no %s grant on segment %s", ErrForbidden, model.PermissionRead, segment.Slug)
+	}
+	return model.NewSegmentPrerequisitesOutput(segment), nil
+}
+
+// SetSegmentPrerequisites replaces the segment prerequisites, requires write grant on the segment
+// and its prerequisites. Users already in the segment are not checked.
+func (s SegmentService) SetSegmentPrerequisites(ctx context.Context, input *model.SegmentPrerequisitesInput) error {
+	ctx, span := tracing.Start(ctx, "SegmentService.SetSegmentPrerequisites", attribute.String("segment.slug", string(input.Slug)))
+	defer span.End()
+
+	segments, err := s.db.GetSegments(ctx, []model.Slug{input.Slug})
+	if err != nil {
+		return err
+	}
+	segment := segments[0]
+
+	if principal, ok := restrictedPrincipal(ctx); ok && !segment.Allows(principal, model.PermissionWrite) {
+		return fmt.Errorf("%w: no %s grant on segment %s", ErrForbidden, model.PermissionWrite, segment.Slug)
+	}
+
+	slugs := make([]model.Slug, 0, len(input.Prerequisites))
+	for _, prerequisite := range input.Prerequisites {
+		slugs = append(slugs, prerequisite.Slug)
+	}
+	if err = checkSegmentsAccess(ctx, s.db, slugs, model.PermissionWrite); err != nil {
+		return err
+	}
+
+	return s.db.SetSegmentPrerequisites(ctx, segment, input.Prerequisites)
 }
@@ -4,18 +4,44 @@ import (
 	"context"
 	"errors"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"github.com/unbeman/av-prac-task/internal/database"
 	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/tracing"
 	"github.com/unbeman/av-prac-task/internal/utils"
 	"github.com/unbeman/av-prac-task/internal/worker"
+	"github.com/unbeman/av-prac-task/pkg/evaluation"
 )
 
+// DynamicSegmentsHorizon is how long deleted dynamic segments count in the change time of users segments,
+// clients revalidating older responses by If-Modified-Since only may miss the deletion.
+const DynamicSegmentsHorizon = 24 * time.Hour
+
+// dynamicSegmentsCheckInterval limits how often the segments version is read to check cached dynamic segments,
+// segment changes are seen by users segments requests within the interval.
+const dynamicSegmentsCheckInterval = time.Second
+
 type UserService struct {
 	db      database.IDatabase
 	wp      *worker.WorkersPool
 	fileDir string
+	dynamic *dynamicSegments
+}
+
+// dynamicSegments caches dynamic segments definitions by the segments version,
+// every segment change bumps the version. The version is checked once in dynamicSegmentsCheckInterval.
+type dynamicSegments struct {
+	mu        sync.Mutex
+	loaded    bool
+	version   uint64
+	checkedAt time.Time
+	segments  []*model.Segment
+	nowFunc   func() time.Time
 }
 
 func NewUserService(db database.IDatabase, wp *worker.WorkersPool, fileDir string) (*UserService, error) {
@@ -25,47 +51,158 @@ func NewUserService(db database.IDatabase, wp *worker.WorkersPool, fileDir strin
 			return nil, err
 		}
 	}
-	return &UserService{db: db, wp: wp, fileDir: fileDir}, nil
+	return &UserService{db: db, wp: wp, fileDir: fileDir, dynamic: &dynamicSegments{nowFunc: time.Now}}, nil
 }
 
 func (s UserService) UpdateUserSegments(ctx context.Context, input *model.UserSegmentsInput) error {
+	ctx, span := tracing.Start(ctx, "UserService.UpdateUserSegments", attribute.Int64("user.id", int64(input.UserID)))
+	defer span.End()
+
+	slugs := make([]model.Slug, 0, len(input.SegmentsToAdd)+len(input.SegmentsToDelete))
+	slugs = append(slugs, input.SegmentsToAdd...)
+	slugs = append(slugs, input.SegmentsToDelete...)
+	if err := checkSegmentsAccess(ctx, s.db, slugs, model.PermissionWrite); err != nil {
+		return err
+	}
+
 	user := model.User{}
 	user.ID = input.UserID
 
-	return s.db.CreateDeleteUserSegments(ctx, &user, input.SegmentsToAdd, input.SegmentsToDelete)
+	return s.db.CreateDeleteUserSegments(ctx, &user, input.SegmentsToAdd, input.SegmentsToDelete,
+		segmentsAuthorizer(ctx, model.PermissionWrite))
 }
 
-func (s UserService) GetUserActiveSegments(ctx context.Context, input *model.UserInput) (model.Slugs, error) {
+// GetUserActiveSegments returns user's active segments slugs and the time of their last change,
+// segments the caller has no read grant on are omitted. Dynamic segments are evaluated by user id only,
+// segments with rules on other attributes are omitted as the client evaluates them.
+// Segments outside their active window are omitted, the window start and end passed since the last change
+// count as the change time.
+func (s UserService) GetUserActiveSegments(ctx context.Context, input *model.UserInput) (model.Slugs, time.Time, error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUserActiveSegments", attribute.Int64("user.id", int64(input.UserID)))
+	defer span.End()
+
 	user := &model.User{}
 	user.ID = input.UserID
 
 	user, err := s.db.GetUserWithActiveSegments(ctx, user)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
+	dynamic, err := s.getDynamicSegments(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	principal, restricted := restrictedPrincipal(ctx)
+
+	now := time.Now()
+	updatedAt := user.SegmentsUpdatedAt
 	slugs := make(model.Slugs, 0, len(user.Segments))
-	for _, segment := range user.Segments {
+	for idx := range user.Segments {
+		segment := &user.Segments[idx]
+		updatedAt = activeWindowUpdatedAt(segment, updatedAt, now)
+		if !segment.IsActive(now) || restricted && !segment.Allows(principal, model.PermissionRead) {
+			continue
+		}
+		slugs = append(slugs, segment.Slug)
+	}
+	for _, segment := range dynamic {
+		if segment.UpdatedAt.After(updatedAt) {
+			updatedAt = segment.UpdatedAt
+		}
+		if segment.DeletedAt.Valid || segment.NeedsAttributes() || !segment.Matches(input.UserID, evaluation.Attributes{}) {
+			continue
+		}
+		updatedAt = activeWindowUpdatedAt(segment, updatedAt, now)
+		if !segment.IsActive(now) || restricted && !segment.Allows(principal, model.PermissionRead) {
+			continue
+		}
 		slugs = append(slugs, segment.Slug)
 	}
 
-	return slugs, nil
+	return slugs, updatedAt, nil
+}
+
+// getDynamicSegments returns dynamic segments definitions, cached until segments version changes.
+// Segments deleted within DynamicSegmentsHorizon are returned too, their deletion changes users segments.
+func (s UserService) getDynamicSegments(ctx context.Context) ([]*model.Segment, error) {
+	s.dynamic.mu.Lock()
+	now := s.dynamic.nowFunc()
+	if s.dynamic.loaded && now.Sub(s.dynamic.checkedAt) < dynamicSegmentsCheckInterval {
+		segments := s.dynamic.segments
+		s.dynamic.mu.Unlock()
+		return segments, nil
+	}
+	s.dynamic.mu.Unlock()
+
+	version, err := s.db.GetSegmentsVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.dynamic.mu.Lock()
+	if s.dynamic.loaded && s.dynamic.version == version {
+		s.dynamic.checkedAt = now
+		segments := s.dynamic.segments
+		s.dynamic.mu.Unlock()
+		return segments, nil
+	}
+	s.dynamic.mu.Unlock()
+
+	segments, err := s.db.ListSegments(ctx, model.SegmentsFilter{
+		Dynamic:       true,
+		WithDeleted:   true,
+		DeletedWithin: DynamicSegmentsHorizon,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.dynamic.mu.Lock()
+	defer s.dynamic.mu.Unlock()
+	// segments of the later version may be loaded concurrently
+	if !s.dynamic.loaded || s.dynamic.version <= version {
+		s.dynamic.loaded, s.dynamic.version, s.dynamic.segments = true, version, segments
+		s.dynamic.checkedAt = now
+	}
+	return segments, nil
 }
 
-func (s UserService) generateUserSegmentsHistoryFile(input model.UserSegmentsHistoryInput, filePath string) error {
+// activeWindowUpdatedAt returns the latest of updatedAt and the segment active window bounds passed by now.
+func activeWindowUpdatedAt(segment *model.Segment, updatedAt time.Time, now time.Time) time.Time {
+	for _, bound := range []*time.Time{segment.ActiveFrom, segment.ActiveUntil} {
+		if bound != nil && bound.After(updatedAt) && !bound.After(now) {
+			updatedAt = *bound
+		}
+	}
+	return updatedAt
+}
+
+func (s UserService) generateUserSegmentsHistoryFile(ctx context.Context, input model.UserSegmentsHistoryInput, filePath string) error {
+	ctx, span := tracing.Start(ctx, "UserService.generateUserSegmentsHistoryFile", attribute.Int64("user.id", int64(input.UserID)))
+	defer span.End()
+
 	user := &model.User{}
 	user.ID = input.UserID
 
-	ctx := context.TODO()
 	userSegments, err := s.db.GetUserSegmentsHistory(ctx, user, input.FromDate, input.ToDate)
 	if err != nil {
 		return err
 	}
 
-	return utils.SaveCSVHistory(input, filePath, userSegments)
+	_, csvSpan := tracing.Start(ctx, "utils.SaveCSVHistory", attribute.Int("rows", len(userSegments)))
+	defer csvSpan.End()
+
+	err = utils.SaveCSVHistory(input, filePath, userSegments)
+	tracing.RecordError(csvSpan, err)
+	return err
 }
 
 func (s UserService) GenerateUserSegmentsHistoryFile(ctx context.Context, input *model.UserSegmentsHistoryInput) (string, error) {
+	ctx, span := tracing.Start(ctx, "UserService.GenerateUserSegmentsHistoryFile", attribute.Int64("user.id", int64(input.UserID)))
+	defer span.End()
+
 	user := &model.User{}
 	user.ID = input.UserID
 
@@ -78,24 +215,26 @@ func (s UserService) GenerateUserSegmentsHistoryFile(ctx context.Context, input
 
 	filePath := utils.FormatCSVFilePath(s.fileDir, filename)
 
-	// if the history is requested for the interval, including today, then history will gen again
-	if input.ToDate.After(time.Now()) {
-		s.wp.AddTask(worker.NewGenHistoryTask(*input, filePath, s.generateUserSegmentsHistoryFile))
-		return filename, nil
-	}
-
+	// if the history is requested for the interval, including today, then history will gen again,
 	// if file already exist, no need gen the new one
-	if err = utils.CheckFileExists(filePath); err == nil {
+	if !input.ToDate.After(time.Now()) && utils.CheckFileExists(filePath) == nil {
 		return filename, nil
 	}
 
 	// adding task to workers for gen history
-	s.wp.AddTask(worker.NewGenHistoryTask(*input, filePath, s.generateUserSegmentsHistoryFile))
+	err = s.wp.AddTask(ctx, worker.NewGenHistoryTask(*input, filePath, s.generateUserSegmentsHistoryFile))
+	if err != nil {
+		return "", err
+	}
 
 	return filename, nil
 }
 
 func (s UserService) DownloadUserSegmentsHistory(filename string) (string, error) {
+	// only files from the reports directory can be downloaded
+	if filepath.Base(filename) != filename {
+		return "", utils.ErrFileNotFound
+	}
 	filePath := utils.FormatCSVFilePath(s.fileDir, filename)
 	if err := utils.CheckFileExists(filePath); err != nil {
 		return "", err
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/worker"
+)
+
+func TestUserService_GenerateHistoryAfterShutdown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := mock_database.NewMockIDatabase(ctrl)
+	db.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(&model.User{}, nil)
+	db.EXPECT().GetUserSegmentsHistory(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	wp := worker.NewWorkersPool(config.NewWorkerPoolConfig())
+	go wp.Run()
+	require.NoError(t, wp.Shutdown(context.Background()))
+
+	service, err := NewUserService(db, wp, t.TempDir())
+	require.NoError(t, err)
+
+	_, err = service.GenerateUserSegmentsHistoryFile(context.Background(), &model.UserSegmentsHistoryInput{
+		UserID:   1,
+		FromDate: time.Now().Add(-time.Hour),
+		ToDate:   time.Now().Add(time.Hour),
+	})
+	require.ErrorIs(t, err, worker.ErrPoolClosed)
+}
+
+func TestUserService_UpdateUserSegmentsAuthorizesImplicit(t *testing.T) {
+	principal := &model.Principal{Subject: "promo-service", Scopes: []model.Scope{model.ScopeMembershipsWrite}, Teams: []string{"promo"}}
+	checkoutA := &model.Segment{Slug: "CHECKOUT_A", Grants: []model.SegmentGrant{{Team: "checkout", Permission: model.PermissionWrite}}}
+	promo5 := &model.Segment{Slug: "PROMO_5", Grants: []model.SegmentGrant{{Team: "promo", Permission: model.PermissionWrite}}}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		removed  *model.Segment
+		expected error
+	}{
+		{
+			name:    "Removed segment with write grant",
+			ctx:     ContextWithPrincipal(context.Background(), principal),
+			removed: promo5,
+		},
+		{
+			name:     "Removed segment without write grant",
+			ctx:      ContextWithPrincipal(context.Background(), principal),
+			removed:  checkoutA,
+			expected: ErrForbidden,
+		},
+		{
+			name:    "Unauthenticated",
+			ctx:     context.Background(),
+			removed: checkoutA,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			db := mock_database.NewMockIDatabase(ctrl)
+			db.EXPECT().GetSegments(gomock.Any(), gomock.Any()).Return([]*model.Segment{promo5}, nil).AnyTimes()
+			db.EXPECT().
+				CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(
+					ctx context.Context, user *model.User, toIn, toDel []model.Slug, authorize database.SegmentsAuthorizer,
+				) error {
+					if authorize == nil {
+						return nil
+					}
+					return authorize([]*model.Segment{tt.removed})
+				})
+
+			service, err := NewUserService(db, nil, t.TempDir())
+			require.NoError(t, err)
+
+			err = service.UpdateUserSegments(tt.ctx, &model.UserSegmentsInput{UserID: 1, SegmentsToAdd: []model.Slug{promo5.Slug}})
+			require.ErrorIs(t, err, tt.expected)
+		})
+	}
+}
+
+func TestUserService_GetUserActiveSegmentsCachesDynamic(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	everyone := 1.0
+	db := mock_database.NewMockIDatabase(ctrl)
+	db.EXPECT().GetUserWithActiveSegments(gomock.Any(), gomock.Any()).Return(&model.User{}, nil).Times(4)
+	gomock.InOrder(
+		db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(1), nil).Times(2),
+		db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(2), nil),
+	)
+	gomock.InOrder(
+		db.EXPECT().ListSegments(gomock.Any(), gomock.Any()).Return([]*model.Segment{{Slug: "EVERYONE", Rollout: &everyone}}, nil),
+		db.EXPECT().ListSegments(gomock.Any(), gomock.Any()).Return(nil, nil),
+	)
+
+	service, err := NewUserService(db, nil, t.TempDir())
+	require.NoError(t, err)
+	now := time.Now()
+	service.dynamic.nowFunc = func() time.Time { return now }
+
+	// the version is read once in the check interval, the definitions are reloaded when it changes
+	for _, step := range []struct {
+		elapsed  time.Duration
+		expected model.Slugs
+	}{
+		{0, model.Slugs{"EVERYONE"}},
+		{dynamicSegmentsCheckInterval / 2, model.Slugs{"EVERYONE"}},
+		{dynamicSegmentsCheckInterval, model.Slugs{"EVERYONE"}},
+		{dynamicSegmentsCheckInterval, model.Slugs{}},
+	} {
+		now = now.Add(step.elapsed)
+		slugs, _, err := service.GetUserActiveSegments(context.Background(), &model.UserInput{UserID: 1})
+		require.NoError(t, err)
+		require.Equal(t, step.expected, slugs)
+	}
+}
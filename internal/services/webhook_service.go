@@ -0,0 +1,286 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+// Webhook delivery headers. Signature is hex encoded HMAC-SHA256 of "<timestamp>.<body>"
+// computed with subscription secret and prefixed with "sha256=".
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+)
+
+// WebhookService manages webhook subscriptions and delivers events from the event log to subscribers.
+// Events are written to the log in the same transaction as memberships changes,
+// so the log serves as transactional outbox, each subscription keeps its own cursor in it.
+// Subscriptions are claimed in the database before delivery, so application instances don't deliver
+// the same events concurrently.
+type WebhookService struct {
+	db     database.IDatabase
+	cfg    config.WebhooksConfig
+	client *http.Client
+
+	// deliveries is the queue of claimed subscriptions with their pending events read by cfg.Workers workers,
+	// it is written only by dispatch.
+	deliveries chan webhookDelivery
+
+	// ctx is the base context of dispatching and deliveries, it is cancelled when shutdown deadline is exceeded.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	finished chan struct{}
+}
+
+// webhookDelivery is the claimed subscription with events to deliver.
+type webhookDelivery struct {
+	webhook *model.WebhookSubscription
+	events  []model.Event
+}
+
+func NewWebhookService(db database.IDatabase, cfg config.WebhooksConfig) (*WebhookService, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookService{
+		db:         db,
+		cfg:        cfg,
+		client:     &http.Client{Timeout: cfg.Timeout},
+		deliveries: make(chan webhookDelivery, cfg.Workers),
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		finished:   make(chan struct{}),
+	}, nil
+}
+
+func (s *WebhookService) CreateWebhook(ctx context.Context, input *model.CreateWebhookInput) (*model.WebhookOutput, error) {
+	secret := input.Secret
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(buf)
+	}
+
+	types := make([]string, 0, len(input.EventTypes))
+	for _, eventType := range input.EventTypes {
+		types = append(types, string(eventType))
+	}
+
+	webhook := &model.WebhookSubscription{
+		URL:        input.URL,
+		EventTypes: strings.Join(types, ","),
+		Secret:     secret,
+	}
+
+	webhook, err := s.db.CreateWebhook(ctx, webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	output := model.NewWebhookOutput(webhook)
+	output.Secret = webhook.Secret
+	return &output, nil
+}
+
+func (s *WebhookService) GetWebhooks(ctx context.Context) (model.WebhooksOutput, error) {
+	webhooks, err := s.db.GetWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(model.WebhooksOutput, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		output = append(output, model.NewWebhookOutput(webhook))
+	}
+	return output, nil
+}
+
+func (s *WebhookService) DeleteWebhook(ctx context.Context, input *model.WebhookInput) error {
+	return s.db.DeleteWebhook(ctx, &model.WebhookSubscription{ID: input.ID})
+}
+
+// Run starts delivery workers and periodically dispatches pending events to them until Shutdown is called,
+// then waits for the queued deliveries.
+func (s *WebhookService) Run() {
+	defer close(s.finished)
+
+	var workers sync.WaitGroup
+	for i := 0; i < s.cfg.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for delivery := range s.deliveries {
+				if err := s.deliver(s.ctx, delivery.webhook, delivery.events); err != nil {
+					log.Errorf("WebhookService.deliver got error: %v", err)
+				}
+			}
+		}()
+	}
+	defer workers.Wait()
+	defer close(s.deliveries)
+
+	ticker := time.NewTicker(s.cfg.DispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.dispatch(s.ctx); err != nil {
+				log.Errorf("WebhookService.dispatch got error: %v", err)
+			}
+		}
+	}
+}
+
+// Shutdown stops dispatching and waits for the queued deliveries until ctx is done,
+// then cancels them. Claims of cancelled deliveries are released without counting a failed attempt.
+func (s *WebhookService) Shutdown(ctx context.Context) {
+	defer s.cancel()
+
+	close(s.done)
+	select {
+	case <-s.finished:
+	case <-ctx.Done():
+		log.Errorf("webhooks shutdown: %v", ctx.Err())
+		s.cancel()
+		<-s.finished
+	}
+}
+
+// dispatch claims subscriptions as many as the deliveries queue has free place for
+// and queues delivery of each claimed subscription that has pending events.
+func (s *WebhookService) dispatch(ctx context.Context) error {
+	free := cap(s.deliveries) - len(s.deliveries)
+	if free == 0 {
+		return nil
+	}
+
+	webhooks, err := s.db.ClaimWebhooks(ctx, s.cfg.Lease, free)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, webhook := range webhooks {
+		events, err := s.db.GetEvents(ctx, model.EventsFilter{
+			AfterID: webhook.LastEventID,
+			Types:   webhook.Types(),
+			Limit:   s.cfg.BatchSize,
+		})
+		if err != nil || len(events) == 0 {
+			errs = append(errs, err, s.release(webhook))
+			continue
+		}
+
+		s.deliveries <- webhookDelivery{webhook: webhook, events: events}
+	}
+	return errors.Join(errs...)
+}
+
+// deliver sends events to the subscriber and moves subscription cursor on success,
+// on failure the next attempt is delayed with exponential backoff.
+// The claim is released without changes if ctx is cancelled.
+func (s *WebhookService) deliver(ctx context.Context, webhook *model.WebhookSubscription, events []model.Event) error {
+	if ctx.Err() != nil {
+		return s.release(webhook)
+	}
+
+	deliveryErr := s.send(ctx, webhook, events)
+	if ctx.Err() != nil {
+		return s.release(webhook)
+	}
+
+	if deliveryErr == nil {
+		webhook.LastEventID = events[len(events)-1].ID
+		webhook.FailedAttempts = 0
+		webhook.NextAttemptAt = time.Time{}
+		webhook.LastError = ""
+	} else {
+		webhook.FailedAttempts++
+		webhook.NextAttemptAt = time.Now().Add(s.backoff(webhook.FailedAttempts))
+		webhook.LastError = deliveryErr.Error()
+	}
+
+	if err := s.db.UpdateWebhookDelivery(ctx, webhook); err != nil {
+		return err
+	}
+	return deliveryErr
+}
+
+// send posts signed events batch to the subscription url.
+func (s *WebhookService) send(ctx context.Context, webhook *model.WebhookSubscription, events []model.Event) error {
+	body, err := json.Marshal(model.WebhookPayload{SubscriptionID: webhook.ID, Events: events})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, SignWebhookPayload(webhook.Secret, timestamp, body))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("webhook %d delivery failed: %w", webhook.ID, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook %d delivery failed: unexpected status %d", webhook.ID, response.StatusCode)
+	}
+	return nil
+}
+
+// backoff returns delay before the next delivery attempt.
+func (s *WebhookService) backoff(attempts int) time.Duration {
+	if attempts > 20 {
+		return s.cfg.MaxBackoff
+	}
+	delay := time.Second << (attempts - 1)
+	if delay > s.cfg.MaxBackoff {
+		return s.cfg.MaxBackoff
+	}
+	return delay
+}
+
+// release releases the subscription claim keeping its delivery state,
+// it is done even if the service is shutting down.
+func (s *WebhookService) release(webhook *model.WebhookSubscription) error {
+	return s.db.UpdateWebhookDelivery(context.Background(), webhook)
+}
+
+// SignWebhookPayload returns signature of webhook delivery body.
+func SignWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+func TestWebhookService_Dispatch(t *testing.T) {
+	userID := uint64(1)
+	events := []model.Event{
+		{ID: 11, Type: model.EventAdd, UserID: &userID, SegmentSlug: "SEGMENT-A"},
+		{ID: 12, Type: model.EventAdd, UserID: &userID, SegmentSlug: "SEGMENT-B"},
+	}
+
+	tests := []struct {
+		name           string
+		receiverStatus int
+		checkWebhook   func(t *testing.T, webhook *model.WebhookSubscription)
+	}{
+		{
+			name:           "Delivered",
+			receiverStatus: http.StatusOK,
+			checkWebhook: func(t *testing.T, webhook *model.WebhookSubscription) {
+				require.Equal(t, uint64(12), webhook.LastEventID)
+				require.Zero(t, webhook.FailedAttempts)
+				require.Empty(t, webhook.LastError)
+			},
+		},
+		{
+			name:           "Receiver failed",
+			receiverStatus: http.StatusInternalServerError,
+			checkWebhook: func(t *testing.T, webhook *model.WebhookSubscription) {
+				require.Equal(t, uint64(10), webhook.LastEventID)
+				require.Equal(t, 1, webhook.FailedAttempts)
+				require.True(t, webhook.NextAttemptAt.After(time.Now()))
+				require.NotEmpty(t, webhook.LastError)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			received := make(chan model.WebhookPayload, 1)
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+
+				signature := SignWebhookPayload("secret", r.Header.Get(WebhookTimestampHeader), body)
+				require.Equal(t, signature, r.Header.Get(WebhookSignatureHeader))
+
+				var payload model.WebhookPayload
+				require.NoError(t, json.Unmarshal(body, &payload))
+				received <- payload
+
+				w.WriteHeader(tt.receiverStatus)
+			}))
+			defer receiver.Close()
+
+			webhook := &model.WebhookSubscription{
+				ID:          1,
+				URL:         receiver.URL,
+				EventTypes:  "add",
+				Secret:      "secret",
+				LastEventID: 10,
+			}
+
+			updated := make(chan *model.WebhookSubscription, 1)
+			cfg := config.NewWebhooksConfig()
+			db := mock_database.NewMockIDatabase(ctrl)
+			db.EXPECT().ClaimWebhooks(gomock.Any(), cfg.Lease, cfg.Workers).Return([]*model.WebhookSubscription{webhook}, nil)
+			db.EXPECT().
+				GetEvents(gomock.Any(), model.EventsFilter{AfterID: 10, Types: []model.EventType{model.EventAdd}, Limit: 100}).
+				Return(events, nil)
+			db.EXPECT().
+				UpdateWebhookDelivery(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, webhook *model.WebhookSubscription) error {
+					updated <- webhook
+					return nil
+				})
+
+			s, err := NewWebhookService(db, cfg)
+			require.NoError(t, err)
+
+			require.NoError(t, s.dispatch(context.Background()))
+			delivery := <-s.deliveries
+			s.deliver(context.Background(), delivery.webhook, delivery.events)
+
+			payload := <-received
+			require.Equal(t, webhook.ID, payload.SubscriptionID)
+			require.Len(t, payload.Events, len(events))
+
+			tt.checkWebhook(t, <-updated)
+		})
+	}
+}
+
+func TestWebhookService_DispatchReleasesIdle(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	webhook := &model.WebhookSubscription{ID: 1, LastEventID: 10}
+	cfg := config.NewWebhooksConfig()
+	db := mock_database.NewMockIDatabase(ctrl)
+	db.EXPECT().ClaimWebhooks(gomock.Any(), cfg.Lease, cfg.Workers).Return([]*model.WebhookSubscription{webhook}, nil)
+	db.EXPECT().GetEvents(gomock.Any(), gomock.Any()).Return(nil, nil)
+	db.EXPECT().UpdateWebhookDelivery(gomock.Any(), webhook).Return(nil)
+
+	s, err := NewWebhookService(db, cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, s.dispatch(context.Background()))
+	require.Empty(t, s.deliveries)
+}
+
+func TestWebhookService_Shutdown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userID := uint64(1)
+	events := []model.Event{{ID: 11, Type: model.EventAdd, UserID: &userID, SegmentSlug: "SEGMENT-A"}}
+
+	requested := make(chan struct{})
+	stop := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		close(requested)
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	defer receiver.Close()
+	defer close(stop)
+
+	webhook := &model.WebhookSubscription{ID: 1, URL: receiver.URL, Secret: "secret", LastEventID: 10}
+
+	released := make(chan *model.WebhookSubscription, 1)
+	cfg := config.NewWebhooksConfig()
+	cfg.DispatchInterval = 10 * time.Millisecond
+	db := mock_database.NewMockIDatabase(ctrl)
+	gomock.InOrder(
+		db.EXPECT().ClaimWebhooks(gomock.Any(), cfg.Lease, gomock.Any()).Return([]*model.WebhookSubscription{webhook}, nil),
+		db.EXPECT().ClaimWebhooks(gomock.Any(), cfg.Lease, gomock.Any()).Return(nil, nil).AnyTimes(),
+	)
+	db.EXPECT().GetEvents(gomock.Any(), gomock.Any()).Return(events, nil)
+	db.EXPECT().
+		UpdateWebhookDelivery(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, webhook *model.WebhookSubscription) error {
+			require.NoError(t, ctx.Err(), "claim is released on shutdown")
+			released <- webhook
+			return nil
+		})
+
+	s, err := NewWebhookService(db, cfg)
+	require.NoError(t, err)
+	go s.Run()
+	<-requested
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s.Shutdown(ctx)
+
+	webhook = <-released
+	require.Equal(t, uint64(10), webhook.LastEventID)
+	require.Zero(t, webhook.FailedAttempts, "cancelled delivery is not a failed attempt")
+}
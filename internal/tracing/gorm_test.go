@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/unbeman/av-prac-task/internal/model"
+)
+
+func TestGORMPlugin(t *testing.T) {
+	recorder := setupRecorder(t)
+
+	// dry run builds statements without connecting to the database
+	db, err := gorm.Open(
+		postgres.New(postgres.Config{DSN: "host=localhost"}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true},
+	)
+	require.NoError(t, err)
+	require.NoError(t, db.Use(GORMPlugin{}))
+
+	// queries without trace are not recorded
+	db.Find(&[]model.Segment{})
+	require.Empty(t, recorder.Ended())
+
+	ctx, parent := Start(context.Background(), "parent")
+	db.WithContext(ctx).Where("slug = ?", "PROMO").Find(&[]model.Segment{})
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	query := spans[0]
+	require.Equal(t, "gorm.query", query.Name())
+	require.Equal(t, trace.SpanKindClient, query.SpanKind())
+	require.Equal(t, parent.SpanContext().SpanID(), query.Parent().SpanID())
+	require.Contains(t, query.Attributes(), attribute.String("db.sql.table", "segments"))
+	require.Contains(t, query.Attributes(), attribute.String("db.statement", `SELECT * FROM "segments" WHERE slug = $1 AND "segments"."deleted_at" IS NULL`))
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	recorder := setupRecorder(t)
+
+	var handlerSpan trace.SpanContext
+	router := chi.NewRouter()
+	router.Use(HTTPMiddleware)
+	router.Get("/segments/user/{user_id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+	})
+	router.Post("/segment", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/segments/user/1", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/segment", nil))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	get := spans[0]
+	require.Equal(t, "GET /segments/user/{user_id}", get.Name())
+	require.Equal(t, trace.SpanKindServer, get.SpanKind())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", get.SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", get.Parent().SpanID().String())
+	require.Equal(t, get.SpanContext(), handlerSpan)
+	require.Contains(t, get.Attributes(), attribute.Int("http.status_code", http.StatusOK))
+	require.Equal(t, codes.Unset, get.Status().Code)
+
+	post := spans[1]
+	require.Equal(t, "POST /segment", post.Name())
+	require.False(t, post.Parent().IsValid())
+	require.Equal(t, codes.Error, post.Status().Code)
+}
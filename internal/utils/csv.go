@@ -4,11 +4,14 @@ import (
 	"encoding/csv"
 	"errors"
 	"fmt"
-	log "github.com/sirupsen/logrus"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 
+	log "github.com/sirupsen/logrus"
+
 	"github.com/unbeman/av-prac-task/internal/model"
 )
 
@@ -39,19 +42,35 @@ func CheckFileExists(filePath string) error {
 	return nil
 }
 
+// SaveCSVHistory writes the report to temporary file and renames it to filePath when it is complete,
+// so interrupted generation doesn't leave partial report.
 func SaveCSVHistory(input model.UserSegmentsHistoryInput, filePath string, userSegments []model.UserSegment) error {
-	file, err := os.Create(filePath)
+	file, err := os.CreateTemp(filepath.Dir(filePath), ".tmp-"+filepath.Base(filePath)+"-*")
 	if err != nil {
 		return err
 	}
 	defer func(file *os.File) {
-		err = file.Close()
-		if err != nil {
+		if err := file.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			log.Error("SaveCSVHistory: ", err)
+		}
+		if err := os.Remove(file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
 			log.Error("SaveCSVHistory: ", err)
 		}
 	}(file)
 
-	csvFile := csv.NewWriter(file)
+	if err = writeCSVHistory(file, input, userSegments); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filePath)
+}
+
+func writeCSVHistory(w io.Writer, input model.UserSegmentsHistoryInput, userSegments []model.UserSegment) error {
+	var err error
+
+	csvFile := csv.NewWriter(w)
 
 	head := []string{"user_id", "segment_slug", "operation", "date"}
 	if err = csvFile.Write(head); err != nil {
@@ -89,5 +108,5 @@ func SaveCSVHistory(input model.UserSegmentsHistoryInput, filePath string, userS
 	}
 
 	csvFile.Flush()
-	return nil
+	return csvFile.Error()
 }
@@ -0,0 +1,9 @@
+package worker
+
+import "errors"
+
+var (
+	ErrPoolClosed      = errors.New("workers pool is shut down")
+	ErrShutdownTimeout = errors.New("workers pool shutdown timed out")
+	ErrWorkersCount    = errors.New("workers count must be positive")
+)
@@ -1,31 +1,36 @@
 package worker
 
 import (
-	log "github.com/sirupsen/logrus"
+	"context"
 
 	"github.com/unbeman/av-prac-task/internal/model"
 )
 
+// ITask describes the task processed by the workers pool.
 type ITask interface {
-	Do()
+	// Name returns the task kind used in logs and metrics.
+	Name() string
+	// Do processes the task, ctx carries trace of the request that added the task.
+	Do(ctx context.Context) error
 }
 
 type GenHistoryTask struct {
 	input    model.UserSegmentsHistoryInput
 	filePath string
-	doFunc   func(input model.UserSegmentsHistoryInput, filePath string) error
+	doFunc   func(ctx context.Context, input model.UserSegmentsHistoryInput, filePath string) error
 }
 
 func NewGenHistoryTask(
 	input model.UserSegmentsHistoryInput,
 	filePath string,
-	doFunc func(input model.UserSegmentsHistoryInput, filePath string) error) *GenHistoryTask {
+	doFunc func(ctx context.Context, input model.UserSegmentsHistoryInput, filePath string) error) *GenHistoryTask {
 	return &GenHistoryTask{input: input, filePath: filePath, doFunc: doFunc}
 }
 
-func (t GenHistoryTask) Do() {
-	err := t.doFunc(t.input, t.filePath)
-	if err != nil {
-		log.Errorf("GenHistoryTask.Do got error: %v", err)
-	}
+func (t GenHistoryTask) Name() string {
+	return "gen_history"
+}
+
+func (t GenHistoryTask) Do(ctx context.Context) error {
+	return t.doFunc(ctx, t.input, t.filePath)
 }
@@ -1,52 +1,243 @@
 package worker
 
 import (
-	log "github.com/sirupsen/logrus"
+	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/logging"
+	"github.com/unbeman/av-prac-task/internal/metrics"
+	"github.com/unbeman/av-prac-task/internal/tracing"
 )
 
+// queuedTask is the task with trace and id of the request that added it.
+type queuedTask struct {
+	task        ITask
+	spanContext trace.SpanContext
+	requestID   string
+	queuedAt    time.Time
+}
+
 type WorkersPool struct {
 	wokersCount int
-	tasks       chan ITask
+	tasks       chan queuedTask
 	tasksSize   int
 	waitGroup   sync.WaitGroup
+	running     atomic.Bool
+
+	// mu guards closed flag, senders are added only while the pool is open.
+	// closing is closed on Shutdown to wake up senders waiting for free place in the queue,
+	// tasks channel is closed after all of them return.
+	mu      sync.RWMutex
+	closed  bool
+	closing chan struct{}
+	senders sync.WaitGroup
+
+	// workersMu guards the set of running workers, quits stops them one by one when the pool is scaled down.
+	workersMu sync.Mutex
+	started   bool
+	quits     []chan struct{}
+	nextIdx   int
+
+	// ctx is the base context of tasks, it is cancelled when shutdown deadline is exceeded.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
 }
 
 func NewWorkersPool(cfg config.WorkerPoolConfig) *WorkersPool {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &WorkersPool{
 		wokersCount: cfg.WorkersCount,
-		tasks:       make(chan ITask, cfg.TasksSize),
+		tasks:       make(chan queuedTask, cfg.TasksSize),
+		closing:     make(chan struct{}),
+		ctx:         ctx,
+		cancel:      cancel,
+		finished:    make(chan struct{}),
 	}
 }
 
+// Run starts workers and waits until the queue is drained after Shutdown.
 func (wp *WorkersPool) Run() {
+	wp.running.Store(true)
+	defer func() {
+		wp.running.Store(false)
+		metrics.WorkersCount(0)
+		close(wp.finished)
+	}()
+
+	wp.workersMu.Lock()
 	log.Infof("starting worker pool %d workers", wp.wokersCount)
-	for idx := 0; idx < wp.wokersCount; idx++ {
-		wp.waitGroup.Add(1)
+	wp.started = true
+	wp.scale()
+	wp.workersMu.Unlock()
 
-		go func(idx int, tasks chan ITask) {
-			defer wp.waitGroup.Done()
-			log.Infof("worker %d started", idx)
+	wp.waitGroup.Wait()
+}
+
+// Resize scales the running pool up or down to n workers. Stopped workers finish their current task,
+// the queued tasks are left for the rest of workers, so none are lost.
+func (wp *WorkersPool) Resize(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("%w: %d", ErrWorkersCount, n)
+	}
 
-			for task := range tasks {
-				log.Infof("worker %d: starting task", idx)
+	wp.workersMu.Lock()
+	defer wp.workersMu.Unlock()
 
-				task.Do() //todo: try N times if err
+	// workers exit once the queue is closed, the new ones must not be added after it
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+	if wp.closed {
+		return ErrPoolClosed
+	}
+	log.Infof("resizing worker pool from %d to %d workers", wp.wokersCount, n)
+	wp.wokersCount = n
+	if wp.started {
+		wp.scale()
+	}
+	return nil
+}
 
-				log.Infof("worker %d: ends task", idx)
+// scale starts or stops workers to match wokersCount, wp.workersMu must be held.
+func (wp *WorkersPool) scale() {
+	for len(wp.quits) < wp.wokersCount {
+		quit := make(chan struct{})
+		wp.quits = append(wp.quits, quit)
+		wp.waitGroup.Add(1)
+		go wp.work(wp.nextIdx, quit)
+		wp.nextIdx++
+	}
+	for len(wp.quits) > wp.wokersCount {
+		last := len(wp.quits) - 1
+		close(wp.quits[last])
+		wp.quits = wp.quits[:last]
+	}
+	metrics.WorkersCount(len(wp.quits))
+}
+
+// work processes queued tasks until the queue is closed and drained or quit is closed.
+func (wp *WorkersPool) work(idx int, quit chan struct{}) {
+	defer wp.waitGroup.Done()
+	log.Infof("worker %d started", idx)
+
+	for {
+		select {
+		case <-quit:
+			log.Infof("worker %d: stopped", idx)
+			return
+		case queued, ok := <-wp.tasks:
+			if !ok {
+				log.Infof("worker %d: finished", idx)
+				return
+			}
+			if wp.ctx.Err() != nil {
+				log.Warnf("worker %d: task %s dropped on shutdown", idx, queued.task.Name())
+				metrics.TaskDropped(queued.task.Name())
+				continue
 			}
-			log.Infof("worker %d: finished", idx)
-		}(idx, wp.tasks)
+			wp.do(idx, queued)
+		}
+	}
+}
+
+// do processes the task in the span continuing trace of the request that added the task,
+// task logs are correlated with the request by its id.
+func (wp *WorkersPool) do(idx int, queued queuedTask) {
+	task := queued.task
+	metrics.TaskStarted()
+	start := time.Now()
+
+	ctx := trace.ContextWithRemoteSpanContext(wp.ctx, queued.spanContext)
+	if queued.requestID != "" {
+		ctx = context.WithValue(ctx, middleware.RequestIDKey, queued.requestID)
+	}
+	ctx, span := tracing.Start(
+		ctx,
+		"worker."+task.Name(),
+		attribute.Int("worker.id", idx),
+		attribute.Int64("worker.queue_wait_ms", start.Sub(queued.queuedAt).Milliseconds()),
+	)
+	logger := logging.FromContext(ctx).WithFields(log.Fields{"worker": idx, "task": task.Name()})
+	logger.Info("task started")
+
+	err := task.Do(ctx) //todo: try N times if err
+	if err != nil {
+		logger.WithError(err).Error("task failed")
+	}
+
+	tracing.RecordError(span, err)
+	span.End()
+	metrics.TaskFinished(task.Name(), time.Since(start), err)
+	logger.WithField("duration_ms", time.Since(start).Milliseconds()).Info("task finished")
+}
+
+// AddTask queues the task, trace of ctx is continued by the task processing.
+// It waits for free place in the queue until ctx is done
+// and returns ErrPoolClosed if the pool is shut down.
+func (wp *WorkersPool) AddTask(ctx context.Context, task ITask) error {
+	wp.mu.RLock()
+	if wp.closed {
+		wp.mu.RUnlock()
+		return ErrPoolClosed
+	}
+	wp.senders.Add(1)
+	wp.mu.RUnlock()
+	defer wp.senders.Done()
+
+	metrics.TaskQueued()
+	queued := queuedTask{
+		task:        task,
+		spanContext: trace.SpanContextFromContext(ctx),
+		requestID:   middleware.GetReqID(ctx),
+		queuedAt:    time.Now(),
+	}
+	select {
+	case wp.tasks <- queued:
+		return nil
+	case <-ctx.Done():
+		metrics.TaskDropped(task.Name())
+		return ctx.Err()
+	case <-wp.closing:
+		metrics.TaskDropped(task.Name())
+		return ErrPoolClosed
 	}
-	wp.waitGroup.Wait()
 }
 
-func (wp *WorkersPool) AddTask(task ITask) {
-	wp.tasks <- task
+// Running reports whether workers are processing tasks.
+func (wp *WorkersPool) Running() bool {
+	return wp.running.Load()
 }
 
-func (wp *WorkersPool) Shutdown() {
-	close(wp.tasks)
+// Shutdown stops accepting new tasks and waits until the queued ones are processed.
+// If ctx is done first, in-progress tasks are cancelled, the queued ones are dropped
+// and ErrShutdownTimeout is returned.
+func (wp *WorkersPool) Shutdown(ctx context.Context) error {
+	wp.mu.Lock()
+	if !wp.closed {
+		wp.closed = true
+		close(wp.closing)
+		go func() {
+			wp.senders.Wait()
+			close(wp.tasks)
+		}()
+	}
+	wp.mu.Unlock()
+
+	select {
+	case <-wp.finished:
+		return nil
+	case <-ctx.Done():
+		dropped := len(wp.tasks)
+		wp.cancel()
+		return fmt.Errorf("%w: %d queued tasks dropped: %v", ErrShutdownTimeout, dropped, ctx.Err())
+	}
 }
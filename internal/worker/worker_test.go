@@ -0,0 +1,245 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/tracing"
+)
+
+type funcTask func(ctx context.Context) error
+
+func (f funcTask) Name() string {
+	return "func"
+}
+
+func (f funcTask) Do(ctx context.Context) error {
+	return f(ctx)
+}
+
+// runPool starts the pool and returns channel closed when Run returns.
+func runPool(wp *WorkersPool) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		wp.Run()
+		close(done)
+	}()
+	return done
+}
+
+func waitDone(t *testing.T, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("workers pool is not finished")
+	}
+}
+
+func TestWorkersPool_TracePropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer provider.Shutdown(context.Background())
+
+	wp := NewWorkersPool(config.NewWorkerPoolConfig())
+	done := runPool(wp)
+
+	ctx, request := tracing.Start(context.Background(), "request")
+	var taskSpan trace.SpanContext
+	err := wp.AddTask(ctx, NewGenHistoryTask(
+		model.UserSegmentsHistoryInput{UserID: 1},
+		"file.csv",
+		func(ctx context.Context, input model.UserSegmentsHistoryInput, filePath string) error {
+			taskSpan = trace.SpanContextFromContext(ctx)
+			return nil
+		},
+	))
+	require.NoError(t, err)
+	request.End()
+
+	require.NoError(t, wp.Shutdown(context.Background()))
+	waitDone(t, done)
+
+	require.Equal(t, request.SpanContext().TraceID(), taskSpan.TraceID())
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	require.Equal(t, "worker.gen_history", spans[1].Name())
+	require.Equal(t, request.SpanContext().SpanID(), spans[1].Parent().SpanID())
+}
+
+func TestWorkersPool_AddTaskRacesShutdown(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		wp := NewWorkersPool(config.WorkerPoolConfig{WorkersCount: 2, TasksSize: 4})
+		done := runPool(wp)
+
+		var accepted, processed atomic.Int64
+		task := funcTask(func(ctx context.Context) error {
+			processed.Add(1)
+			return nil
+		})
+
+		wg := sync.WaitGroup{}
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					err := wp.AddTask(context.Background(), task)
+					if errors.Is(err, ErrPoolClosed) {
+						return
+					}
+					require.NoError(t, err)
+					accepted.Add(1)
+				}
+			}()
+		}
+
+		time.Sleep(time.Millisecond)
+		require.NoError(t, wp.Shutdown(context.Background()))
+		wg.Wait()
+		waitDone(t, done)
+
+		// every accepted task is processed, no task is lost or sent to the closed queue
+		require.Equal(t, accepted.Load(), processed.Load())
+	}
+}
+
+func TestWorkersPool_ShutdownDrainsQueue(t *testing.T) {
+	wp := NewWorkersPool(config.WorkerPoolConfig{WorkersCount: 1, TasksSize: 10})
+
+	var processed atomic.Int64
+	for i := 0; i < 5; i++ {
+		err := wp.AddTask(context.Background(), funcTask(func(ctx context.Context) error {
+			time.Sleep(time.Millisecond)
+			processed.Add(1)
+			return nil
+		}))
+		require.NoError(t, err)
+	}
+
+	done := runPool(wp)
+	require.NoError(t, wp.Shutdown(context.Background()))
+	waitDone(t, done)
+
+	require.Equal(t, int64(5), processed.Load())
+	require.ErrorIs(t, wp.AddTask(context.Background(), funcTask(nil)), ErrPoolClosed)
+}
+
+func TestWorkersPool_ShutdownTimeout(t *testing.T) {
+	wp := NewWorkersPool(config.WorkerPoolConfig{WorkersCount: 1, TasksSize: 10})
+	done := runPool(wp)
+
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	err := wp.AddTask(context.Background(), funcTask(func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return ctx.Err()
+	}))
+	require.NoError(t, err)
+
+	var processed atomic.Int64
+	for i := 0; i < 3; i++ {
+		err = wp.AddTask(context.Background(), funcTask(func(ctx context.Context) error {
+			processed.Add(1)
+			return nil
+		}))
+		require.NoError(t, err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = wp.Shutdown(ctx)
+	require.ErrorIs(t, err, ErrShutdownTimeout)
+	require.Contains(t, err.Error(), "3 queued tasks dropped")
+
+	require.ErrorIs(t, <-cancelled, context.Canceled)
+	waitDone(t, done)
+	require.Zero(t, processed.Load())
+}
+
+func TestWorkersPool_AddTaskToFullQueue(t *testing.T) {
+	wp := NewWorkersPool(config.WorkerPoolConfig{WorkersCount: 1, TasksSize: 1})
+	require.NoError(t, wp.AddTask(context.Background(), funcTask(nil)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, wp.AddTask(ctx, funcTask(nil)), context.DeadlineExceeded)
+}
+
+func TestWorkersPool_Resize(t *testing.T) {
+	wp := NewWorkersPool(config.WorkerPoolConfig{WorkersCount: 1, TasksSize: 20})
+	done := runPool(wp)
+
+	release := make(chan struct{})
+	var running, maxRunning, processed atomic.Int64
+	task := funcTask(func(ctx context.Context) error {
+		n := running.Add(1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		<-release
+		running.Add(-1)
+		processed.Add(1)
+		return nil
+	})
+	for i := 0; i < 10; i++ {
+		require.NoError(t, wp.AddTask(context.Background(), task))
+	}
+
+	require.NoError(t, wp.Resize(3))
+	require.Eventually(t, func() bool { return running.Load() == 3 }, time.Second, time.Millisecond)
+
+	// stopped workers finish their tasks, the queued ones are processed by the rest
+	require.NoError(t, wp.Resize(1))
+	close(release)
+	require.NoError(t, wp.Shutdown(context.Background()))
+	waitDone(t, done)
+
+	require.Equal(t, int64(10), processed.Load())
+	require.Equal(t, int64(3), maxRunning.Load())
+
+	require.ErrorIs(t, wp.Resize(2), ErrPoolClosed)
+	require.ErrorIs(t, wp.Resize(0), ErrWorkersCount)
+}
+
+func TestWorkersPool_ShutdownWakesUpWaitingSender(t *testing.T) {
+	wp := NewWorkersPool(config.WorkerPoolConfig{WorkersCount: 1, TasksSize: 1})
+	require.NoError(t, wp.AddTask(context.Background(), funcTask(nil)))
+
+	added := make(chan error, 1)
+	go func() {
+		added <- wp.AddTask(context.Background(), funcTask(nil))
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// the pool is not running, so the queue is never drained and shutdown is not blocked by the sender
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, wp.Shutdown(ctx), ErrShutdownTimeout)
+
+	select {
+	case err := <-added:
+		require.ErrorIs(t, err, ErrPoolClosed)
+	case <-time.After(time.Second):
+		t.Fatal("sender is not woken up by shutdown")
+	}
+}
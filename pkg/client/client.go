@@ -0,0 +1,247 @@
+// Package client is the Go client of the segments API /api/v1.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MaxRetriesDefault   = 3
+	MinBackoffDefault   = 100 * time.Millisecond
+	MaxBackoffDefault   = 5 * time.Second
+	PollIntervalDefault = time.Second
+	TimeoutDefault      = 30 * time.Second
+)
+
+// API headers.
+const (
+	APIKeyHeader         = "X-API-Key"
+	IdempotencyKeyHeader = "Idempotency-Key"
+	RequestIDHeader      = "X-Request-Id"
+	RetryAfterHeader     = "Retry-After"
+)
+
+const apiPrefix = "/api/v1"
+
+// Config describes the API client. The client authenticates with APIKey or with JWT Token if they are set.
+// Failed with network error, 429, 502, 503 or 504 requests are retried MaxRetries times with exponential backoff
+// from MinBackoff up to MaxBackoff, mutating requests are retried with the same Idempotency-Key.
+type Config struct {
+	BaseURL    string
+	APIKey     string
+	Token      string
+	HTTPClient *http.Client
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is how often the history report readiness is checked.
+	PollInterval time.Duration
+}
+
+// NewConfig returns the config with default settings of the API at baseURL, e.g. http://localhost:8080.
+func NewConfig(baseURL string) Config {
+	return Config{
+		BaseURL:      baseURL,
+		HTTPClient:   &http.Client{Timeout: TimeoutDefault},
+		MaxRetries:   MaxRetriesDefault,
+		MinBackoff:   MinBackoffDefault,
+		MaxBackoff:   MaxBackoffDefault,
+		PollInterval: PollIntervalDefault,
+	}
+}
+
+// Client calls the segments API.
+type Client struct {
+	cfg     Config
+	baseURL *url.URL
+}
+
+// NewClient returns the API client.
+func NewClient(cfg Config) (*Client, error) {
+	baseURL, err := url.Parse(strings.TrimSuffix(cfg.BaseURL, "/"))
+	if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("%w: base url %q must be absolute", ErrInvalidConfig, cfg.BaseURL)
+	}
+	if cfg.MaxRetries < 0 || cfg.MinBackoff < 0 || cfg.MaxBackoff < cfg.MinBackoff {
+		return nil, fmt.Errorf("%w: retries and backoff must not be negative, max backoff must not be less than min", ErrInvalidConfig)
+	}
+	if cfg.PollInterval <= 0 {
+		return nil, fmt.Errorf("%w: poll interval must be positive", ErrInvalidConfig)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	return &Client{cfg: cfg, baseURL: baseURL}, nil
+}
+
+// request describes the API call.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   any
+}
+
+// do sends the request with retries and decodes json response into out if it is not nil.
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	response, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if out == nil {
+		_, err = io.Copy(io.Discard, response.Body)
+		return err
+	}
+	if err = json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("segments api: can't decode %s %s response: %w", req.method, req.path, err)
+	}
+	return nil
+}
+
+// send sends the request with retries, returns successful response or error.
+// The caller must close the response body.
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+
+	target := c.baseURL.JoinPath(apiPrefix, req.path)
+	target.RawQuery = req.query.Encode()
+
+	// the same key makes retries of mutating request safe
+	idempotencyKey := ""
+	if req.method != http.MethodGet {
+		idempotencyKey = newIdempotencyKey()
+	}
+
+	for attempt := 0; ; attempt++ {
+		httpRequest, err := http.NewRequestWithContext(ctx, req.method, target.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		c.setHeaders(httpRequest, body != nil, idempotencyKey)
+
+		response, err := c.cfg.HTTPClient.Do(httpRequest)
+		if err == nil && response.StatusCode < http.StatusBadRequest {
+			return response, nil
+		}
+
+		var delay time.Duration
+		if err == nil {
+			err = readAPIError(response)
+			delay = retryAfter(response)
+		}
+		if attempt >= c.cfg.MaxRetries || ctx.Err() != nil || !retryable(response) {
+			return nil, err
+		}
+
+		if backoff := c.backoff(attempt); delay < backoff {
+			delay = backoff
+		}
+		if err = sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) setHeaders(request *http.Request, hasBody bool, idempotencyKey string) {
+	if hasBody {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.cfg.APIKey != "" {
+		request.Header.Set(APIKeyHeader, c.cfg.APIKey)
+	}
+	if c.cfg.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	if idempotencyKey != "" {
+		request.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+	}
+}
+
+// retryable checks if the request may succeed on retry, nil response means network error.
+func retryable(response *http.Response) bool {
+	if response == nil {
+		return true
+	}
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns random delay before the retry in [d/2, d], d grows exponentially with attempts.
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.cfg.MaxBackoff
+	if attempt < 30 && c.cfg.MinBackoff<<attempt < c.cfg.MaxBackoff {
+		delay = c.cfg.MinBackoff << attempt
+	}
+	if delay <= 1 {
+		return delay
+	}
+	jitter, err := rand.Int(rand.Reader, big.NewInt(int64(delay/2)))
+	if err != nil {
+		return delay
+	}
+	return delay/2 + time.Duration(jitter.Int64())
+}
+
+// retryAfter returns delay the server asks to wait before the retry.
+func retryAfter(response *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(response.Header.Get(RetryAfterHeader))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// readAPIError reads error response and closes its body.
+func readAPIError(response *http.Response) error {
+	defer response.Body.Close()
+
+	apiErr := &APIError{StatusCode: response.StatusCode, RequestID: response.Header.Get(RequestIDHeader)}
+	var output struct {
+		Message string `json:"message"`
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+	if err == nil && json.Unmarshal(body, &output) == nil {
+		apiErr.Message = output.Message
+	}
+	return apiErr
+}
+
+func newIdempotencyKey() string {
+	key := make([]byte, 16)
+	_, _ = rand.Read(key)
+	return hex.EncodeToString(key)
+}
@@ -0,0 +1,375 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unbeman/av-prac-task/internal/cache"
+	"github.com/unbeman/av-prac-task/internal/config"
+	"github.com/unbeman/av-prac-task/internal/database"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/handlers"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/internal/ratelimit"
+	"github.com/unbeman/av-prac-task/internal/services"
+	"github.com/unbeman/av-prac-task/internal/worker"
+)
+
+const testJWTSecret = "test-secret"
+
+// setupServer returns client of the test server with HTTPHandler, wrap decorates the handler if it is not nil.
+func setupServer(
+	t *testing.T,
+	authCfg config.AuthConfig,
+	setupDB func(db *mock_database.MockIDatabase),
+	wrap func(next http.Handler) http.Handler,
+) *Client {
+	ctrl := gomock.NewController(t)
+	db := mock_database.NewMockIDatabase(ctrl)
+	setupDB(db)
+	db.EXPECT().CreateAuditRecord(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	db.EXPECT().
+		ListSegments(gomock.Any(), model.SegmentsFilter{
+			Dynamic:       true,
+			WithDeleted:   true,
+			DeletedWithin: services.DynamicSegmentsHorizon,
+		}).
+		Return(nil, nil).
+		AnyTimes()
+	db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(0), nil).AnyTimes()
+	fakeIdempotency(db)
+
+	wp := worker.NewWorkersPool(config.NewWorkerPoolConfig())
+	go wp.Run()
+	t.Cleanup(func() { _ = wp.Shutdown(context.Background()) })
+
+	segmentServ, err := services.NewSegmentService(db)
+	require.NoError(t, err)
+	userServ, err := services.NewUserService(db, wp, t.TempDir())
+	require.NoError(t, err)
+	eventServ, err := services.NewEventService(db, config.NewEventsConfig())
+	require.NoError(t, err)
+	webhookServ, err := services.NewWebhookService(db, config.NewWebhooksConfig())
+	require.NoError(t, err)
+	authServ, err := services.NewAuthService(db, authCfg)
+	require.NoError(t, err)
+	auditServ, err := services.NewAuditService(db)
+	require.NoError(t, err)
+	idempotencyServ, err := services.NewIdempotencyService(db, config.NewIdempotencyConfig())
+	require.NoError(t, err)
+	healthServ, err := services.NewHealthService(db, wp, t.TempDir(), config.NewHealthConfig())
+	require.NoError(t, err)
+
+	rateLimitCfg := config.NewRateLimitConfig()
+	rateLimitCfg.Enabled = false
+	h, err := handlers.GetHandler(
+		userServ,
+		segmentServ,
+		eventServ,
+		webhookServ,
+		authServ,
+		auditServ,
+		idempotencyServ,
+		healthServ,
+		cache.NewLRUCache(config.NewCacheConfig()),
+		ratelimit.NewMemoryLimiter(rateLimitCfg),
+	)
+	require.NoError(t, err)
+
+	var handler http.Handler = h
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := NewConfig(server.URL)
+	cfg.MinBackoff = time.Millisecond
+	cfg.MaxBackoff = 5 * time.Millisecond
+	cfg.PollInterval = 10 * time.Millisecond
+	c, err := NewClient(cfg)
+	require.NoError(t, err)
+	return c
+}
+
+func noAuth() config.AuthConfig {
+	cfg := config.NewAuthConfig()
+	cfg.Enabled = false
+	return cfg
+}
+
+// fakeIdempotency keeps idempotency records in memory.
+func fakeIdempotency(db *mock_database.MockIDatabase) {
+	var mu sync.Mutex
+	records := map[string]*model.IdempotencyRecord{}
+
+	db.EXPECT().CreateIdempotencyRecord(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, record *model.IdempotencyRecord) (*model.IdempotencyRecord, bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if saved, ok := records[record.Key]; ok {
+				return saved, false, nil
+			}
+			records[record.Key] = record
+			return record, true, nil
+		}).AnyTimes()
+	db.EXPECT().CompleteIdempotencyRecord(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, record *model.IdempotencyRecord) error {
+			mu.Lock()
+			defer mu.Unlock()
+			record.Completed = true
+			return nil
+		}).AnyTimes()
+	db.EXPECT().DeleteIdempotencyRecord(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, record *model.IdempotencyRecord) error {
+			mu.Lock()
+			defer mu.Unlock()
+			delete(records, record.Key)
+			return nil
+		}).AnyTimes()
+}
+
+// flaky responds with status to the first failures requests, the rest are passed to the handler.
+type flaky struct {
+	status     int
+	retryAfter string
+	failures   int32
+
+	requests atomic.Int32
+	mu       sync.Mutex
+	keys     []string
+}
+
+func (f *flaky) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		f.mu.Lock()
+		f.keys = append(f.keys, request.Header.Get(IdempotencyKeyHeader))
+		f.mu.Unlock()
+
+		if f.requests.Add(1) <= f.failures {
+			if f.retryAfter != "" {
+				writer.Header().Set(RetryAfterHeader, f.retryAfter)
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(f.status)
+			writer.Write([]byte(`{"message":"try later"}`))
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func TestClient_CreateSegment(t *testing.T) {
+	c := setupServer(t, noAuth(), func(db *mock_database.MockIDatabase) {
+		db.EXPECT().CreateSegmentForRandomUsers(gomock.Any(), gomock.Any(), 0.5).Return(&model.Segment{Slug: "PROMO_5"}, int64(5), nil)
+	}, nil)
+
+	selection := 0.5
+	output, err := c.CreateSegment(context.Background(), CreateSegmentInput{Slug: "promo_5", Selection: &selection})
+	require.NoError(t, err)
+	assert.Equal(t, &CreateSegmentOutput{Slug: "PROMO_5", AssignedUsers: 5}, output)
+}
+
+func TestClient_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		setupDB  func(db *mock_database.MockIDatabase)
+		call     func(c *Client) error
+		expected error
+		status   int
+	}{
+		{
+			name: "conflict",
+			setupDB: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().CreateSegment(gomock.Any(), gomock.Any()).
+					Return(nil, database.ErrAlreadyExists)
+			},
+			call: func(c *Client) error {
+				_, err := c.CreateSegment(context.Background(), CreateSegmentInput{Slug: "PROMO_5"})
+				return err
+			},
+			expected: ErrConflict,
+			status:   http.StatusConflict,
+		},
+		{
+			name: "not found",
+			setupDB: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().DeleteSegment(gomock.Any(), gomock.Any(), gomock.Any()).Return(database.ErrNotFound)
+			},
+			call: func(c *Client) error {
+				return c.DeleteSegment(context.Background(), "PROMO_5")
+			},
+			expected: ErrNotFound,
+			status:   http.StatusNotFound,
+		},
+		{
+			name:    "bad request",
+			setupDB: func(db *mock_database.MockIDatabase) {},
+			call: func(c *Client) error {
+				return c.UpdateUserSegments(context.Background(), 1, UserSegmentsInput{
+					SegmentsToAdd:    []string{"PROMO_5"},
+					SegmentsToDelete: []string{"PROMO_5"},
+				})
+			},
+			expected: ErrBadRequest,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name: "server error is not retried",
+			setupDB: func(db *mock_database.MockIDatabase) {
+				db.EXPECT().GetUserWithActiveSegments(gomock.Any(), gomock.Any()).Return(nil, database.ErrDB).Times(1)
+			},
+			call: func(c *Client) error {
+				_, err := c.GetUserSegments(context.Background(), 1)
+				return err
+			},
+			expected: ErrServer,
+			status:   http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := setupServer(t, noAuth(), tt.setupDB, nil)
+
+			err := tt.call(c)
+			require.ErrorIs(t, err, tt.expected)
+
+			var apiErr *APIError
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, tt.status, apiErr.StatusCode)
+			assert.NotEmpty(t, apiErr.Message)
+			assert.NotEmpty(t, apiErr.RequestID)
+		})
+	}
+}
+
+func TestClient_Authentication(t *testing.T) {
+	authCfg := config.NewAuthConfig()
+	authCfg.JWTSecret = testJWTSecret
+
+	user := &model.User{Segments: []model.Segment{{Slug: "PROMO_5"}}}
+	c := setupServer(t, authCfg, func(db *mock_database.MockIDatabase) {
+		db.EXPECT().GetUserWithActiveSegments(gomock.Any(), gomock.Any()).Return(user, nil)
+	}, nil)
+
+	_, err := c.GetUserSegments(context.Background(), 1)
+	require.ErrorIs(t, err, ErrUnauthorized)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   "test-client",
+		"scope": model.ScopeMembershipsRead,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(testJWTSecret))
+	require.NoError(t, err)
+	c.cfg.Token = token
+
+	slugs, err := c.GetUserSegments(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PROMO_5"}, slugs)
+}
+
+func TestClient_Retries(t *testing.T) {
+	f := &flaky{status: http.StatusServiceUnavailable, failures: 2}
+	c := setupServer(t, noAuth(), func(db *mock_database.MockIDatabase) {
+		db.EXPECT().CreateDeleteUserSegments(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	}, f.wrap)
+
+	err := c.UpdateUserSegments(context.Background(), 1, UserSegmentsInput{SegmentsToAdd: []string{"PROMO_5"}})
+	require.NoError(t, err)
+
+	require.Len(t, f.keys, 3)
+	assert.NotEmpty(t, f.keys[0])
+	assert.Equal(t, f.keys[0], f.keys[1], "retries must reuse idempotency key")
+	assert.Equal(t, f.keys[0], f.keys[2], "retries must reuse idempotency key")
+}
+
+func TestClient_RetriesExhausted(t *testing.T) {
+	f := &flaky{status: http.StatusTooManyRequests, retryAfter: "0", failures: 100}
+	c := setupServer(t, noAuth(), func(db *mock_database.MockIDatabase) {}, f.wrap)
+
+	err := c.DeleteSegment(context.Background(), "PROMO_5")
+	require.ErrorIs(t, err, ErrTooManyRequests)
+	assert.Equal(t, int32(MaxRetriesDefault+1), f.requests.Load())
+}
+
+func TestClient_RetryCanceled(t *testing.T) {
+	f := &flaky{status: http.StatusServiceUnavailable, retryAfter: "10", failures: 100}
+	c := setupServer(t, noAuth(), func(db *mock_database.MockIDatabase) {}, f.wrap)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.GetUserSegments(ctx, 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(1), f.requests.Load())
+}
+
+func TestClient_UserSegmentsHistory(t *testing.T) {
+	from := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	history := []model.UserSegment{
+		{UserID: 1, Segment: model.Segment{Slug: "PROMO_5"}, CreatedAt: from.Add(time.Hour)},
+	}
+
+	c := setupServer(t, noAuth(), func(db *mock_database.MockIDatabase) {
+		db.EXPECT().GetUser(gomock.Any(), gomock.Any()).Return(&model.User{}, nil)
+		db.EXPECT().GetUserSegmentsHistory(gomock.Any(), gomock.Any(), from, to).
+			DoAndReturn(func(ctx context.Context, user *model.User, from, to time.Time) ([]model.UserSegment, error) {
+				// the report is polled until it is written
+				time.Sleep(50 * time.Millisecond)
+				return history, nil
+			})
+	}, nil)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, c.UserSegmentsHistory(context.Background(), 1, from, to, out))
+	assert.True(t, strings.HasPrefix(out.String(), "user_id,segment_slug,operation,date\n"))
+	assert.Contains(t, out.String(), "1,PROMO_5,add,")
+
+	err := c.DownloadHistory(context.Background(), "user-2_2023-08-01_2023-09-01.csv", &bytes.Buffer{})
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestClient_WaitHistoryCanceled(t *testing.T) {
+	c := setupServer(t, noAuth(), func(db *mock_database.MockIDatabase) {}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := c.WaitHistory(ctx, "user-1_2023-08-01_2023-09-01.csv", &bytes.Buffer{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+	}{
+		{name: "relative url", modify: func(cfg *Config) { cfg.BaseURL = "localhost:8080" }},
+		{name: "negative retries", modify: func(cfg *Config) { cfg.MaxRetries = -1 }},
+		{name: "max backoff less than min", modify: func(cfg *Config) { cfg.MaxBackoff = cfg.MinBackoff / 2 }},
+		{name: "zero poll interval", modify: func(cfg *Config) { cfg.PollInterval = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig("http://localhost:8080")
+			tt.modify(&cfg)
+			_, err := NewClient(cfg)
+			require.ErrorIs(t, err, ErrInvalidConfig)
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/unbeman/av-prac-task/pkg/evaluation"
+)
+
+const RefreshIntervalDefault = 30 * time.Second
+
+var ErrSnapshotNotLoaded = errors.New("segments snapshot is not loaded")
+
+// EvaluatorConfig describes the local evaluator. The snapshot is refreshed every RefreshInterval,
+// refresh errors are passed to OnError if it is set, the evaluator keeps the last loaded snapshot.
+type EvaluatorConfig struct {
+	RefreshInterval time.Duration
+	OnError         func(err error)
+}
+
+// NewEvaluatorConfig returns the evaluator config with default settings.
+func NewEvaluatorConfig() EvaluatorConfig {
+	return EvaluatorConfig{RefreshInterval: RefreshIntervalDefault}
+}
+
+// Evaluator evaluates users segments locally with the segments snapshot kept in memory.
+// Users of explicit segments are requested from the API.
+type Evaluator struct {
+	client   *Client
+	cfg      EvaluatorConfig
+	mu       sync.RWMutex
+	loaded   bool
+	version  uint64
+	segments map[string]SegmentDefinition
+	done     chan struct{}
+	finished chan struct{}
+	cancel   context.CancelFunc
+	ctx      context.Context
+}
+
+// NewEvaluator returns the evaluator, call Refresh to load the snapshot and Run to refresh it in the background.
+func NewEvaluator(c *Client, cfg EvaluatorConfig) (*Evaluator, error) {
+	if cfg.RefreshInterval <= 0 {
+		return nil, fmt.Errorf("%w: refresh interval must be positive", ErrInvalidConfig)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Evaluator{
+		client:   c,
+		cfg:      cfg,
+		segments: make(map[string]SegmentDefinition),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// Refresh loads changes of segments since the loaded snapshot.
+func (e *Evaluator) Refresh(ctx context.Context) error {
+	e.mu.RLock()
+	since := e.version
+	e.mu.RUnlock()
+
+	snapshot, err := e.client.Snapshot(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// concurrent refresh has already applied newer snapshot
+	if e.loaded && snapshot.Version < e.version {
+		return nil
+	}
+	if snapshot.Full {
+		e.segments = make(map[string]SegmentDefinition, len(snapshot.Segments))
+	}
+	for _, segment := range snapshot.Segments {
+		if segment.Deleted {
+			delete(e.segments, segment.Slug)
+			continue
+		}
+		e.segments[segment.Slug] = segment
+	}
+	e.version = snapshot.Version
+	e.loaded = true
+	return nil
+}
+
+// Run periodically refreshes the snapshot until Shutdown is called.
+func (e *Evaluator) Run() {
+	defer close(e.finished)
+
+	ticker := time.NewTicker(e.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+			if err := e.Refresh(e.ctx); err != nil && e.cfg.OnError != nil && e.ctx.Err() == nil {
+				e.cfg.OnError(err)
+			}
+		}
+	}
+}
+
+// Shutdown stops refreshing, cancels the refresh in progress and waits for Run to return.
+func (e *Evaluator) Shutdown() {
+	close(e.done)
+	e.cancel()
+	<-e.finished
+}
+
+// Version returns the version of the loaded snapshot.
+func (e *Evaluator) Version() uint64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.version
+}
+
+// UserSegments returns slugs of the user's segments ordered by slug. Dynamic segments are evaluated locally
+// with the user attributes within their active window, explicit segments are requested from the API
+// only if the snapshot has any.
+func (e *Evaluator) UserSegments(ctx context.Context, userID uint64, attrs evaluation.Attributes) ([]string, error) {
+	e.mu.RLock()
+	if !e.loaded {
+		e.mu.RUnlock()
+		return nil, ErrSnapshotNotLoaded
+	}
+	var slugs []string
+	hasExplicit := false
+	dynamic := make(map[string]bool)
+	now := time.Now()
+	for slug, segment := range e.segments {
+		if segment.Explicit {
+			hasExplicit = true
+			continue
+		}
+		dynamic[slug] = true
+		if segment.Active(now) && segment.Matches(userID, attrs) {
+			slugs = append(slugs, slug)
+		}
+	}
+	e.mu.RUnlock()
+
+	if hasExplicit {
+		explicit, err := e.explicitSegments(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		// the API evaluates dynamic segments without attributes, the local result is used instead
+		for _, slug := range explicit {
+			if !dynamic[slug] {
+				slugs = append(slugs, slug)
+			}
+		}
+	}
+
+	sort.Strings(slugs)
+	return slugs, nil
+}
+
+// IsMember checks if the user is in the segment, unknown segment has no users.
+// Dynamic segment is evaluated locally with the user attributes, explicit one is requested from the API.
+func (e *Evaluator) IsMember(ctx context.Context, userID uint64, slug string, attrs evaluation.Attributes) (bool, error) {
+	e.mu.RLock()
+	loaded := e.loaded
+	segment, ok := e.segments[slug]
+	e.mu.RUnlock()
+
+	if !loaded {
+		return false, ErrSnapshotNotLoaded
+	}
+	if !ok {
+		return false, nil
+	}
+	if !segment.Explicit {
+		return segment.Active(time.Now()) && segment.Matches(userID, attrs), nil
+	}
+
+	explicit, err := e.explicitSegments(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range explicit {
+		if s == slug {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Variant returns the user's variant of the experiment evaluated locally,
+// empty string is returned if the user is not in any variant.
+func (e *Evaluator) Variant(userID uint64, experiment string) (string, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.loaded {
+		return "", ErrSnapshotNotLoaded
+	}
+	now := time.Now()
+	for slug, segment := range e.segments {
+		if segment.Variant != nil && segment.Variant.Experiment == experiment && segment.Active(now) &&
+			segment.Matches(userID, nil) {
+			return slug, nil
+		}
+	}
+	return "", nil
+}
+
+// explicitSegments requests the user's segments from the API, unknown user has no segments.
+func (e *Evaluator) explicitSegments(ctx context.Context, userID uint64) ([]string, error) {
+	slugs, err := e.client.GetUserSegments(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return slugs, err
+}
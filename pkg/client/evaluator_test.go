@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/unbeman/av-prac-task/internal/database"
+	mock_database "github.com/unbeman/av-prac-task/internal/database/mock"
+	"github.com/unbeman/av-prac-task/internal/model"
+	"github.com/unbeman/av-prac-task/pkg/evaluation"
+)
+
+func setupEvaluator(t *testing.T, c *Client) *Evaluator {
+	cfg := NewEvaluatorConfig()
+	cfg.RefreshInterval = 10 * time.Millisecond
+	e, err := NewEvaluator(c, cfg)
+	require.NoError(t, err)
+	return e
+}
+
+func countryRule(values ...string) []model.SegmentRule {
+	return []model.SegmentRule{{Attribute: "country", Operator: evaluation.OperatorIn, Values: values}}
+}
+
+func TestEvaluator_UserSegments(t *testing.T) {
+	full := 1.0
+	tomorrow, yesterday := time.Now().Add(24*time.Hour), time.Now().Add(-24*time.Hour)
+	segments := []*model.Segment{
+		{Slug: "EXPLICIT", Version: 1},
+		{Slug: "RU", Version: 2, Rules: countryRule("RU")},
+		{Slug: "EVERYONE", Version: 3, Rollout: &full},
+		{Slug: "UPCOMING", Version: 3, Rollout: &full, ActiveFrom: &tomorrow},
+	}
+	user := &model.User{Segments: []model.Segment{{Slug: "EXPLICIT"}, {Slug: "ENDED", ActiveUntil: &yesterday}}}
+
+	c := setupServer(t, noAuth(), func(db *mock_database.MockIDatabase) {
+		db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(3), nil)
+		db.EXPECT().ListSegments(gomock.Any(), model.SegmentsFilter{}).Return(segments, nil)
+		db.EXPECT().GetUserWithActiveSegments(gomock.Any(), gomock.Any()).Return(user, nil).Times(2)
+		db.EXPECT().GetUserWithActiveSegments(gomock.Any(), gomock.Any()).Return(nil, database.ErrNotFound)
+	}, nil)
+	e := setupEvaluator(t, c)
+	ctx := context.Background()
+
+	_, err := e.UserSegments(ctx, 1, nil)
+	require.ErrorIs(t, err, ErrSnapshotNotLoaded)
+
+	require.NoError(t, e.Refresh(ctx))
+	assert.Equal(t, uint64(3), e.Version())
+
+	slugs, err := e.UserSegments(ctx, 1, evaluation.Attributes{"country": "RU"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"EVERYONE", "EXPLICIT", "RU"}, slugs)
+
+	member, err := e.IsMember(ctx, 1, "EXPLICIT", nil)
+	require.NoError(t, err)
+	assert.True(t, member)
+
+	// unknown user has no explicit segments
+	slugs, err = e.UserSegments(ctx, 2, evaluation.Attributes{"country": "KZ"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"EVERYONE"}, slugs)
+
+	// dynamic and unknown segments are evaluated without the API
+	member, err = e.IsMember(ctx, 2, "RU", evaluation.Attributes{"country": "RU"})
+	require.NoError(t, err)
+	assert.True(t, member)
+	member, err = e.IsMember(ctx, 2, "UNKNOWN", nil)
+	require.NoError(t, err)
+	assert.False(t, member)
+
+	// segments outside their active window have no users
+	member, err = e.IsMember(ctx, 2, "UPCOMING", nil)
+	require.NoError(t, err)
+	assert.False(t, member)
+}
+
+func TestEvaluator_Variant(t *testing.T) {
+	c := setupServer(t, noAuth(), func(db *mock_database.MockIDatabase) {
+		db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(2), nil)
+		db.EXPECT().ListSegments(gomock.Any(), model.SegmentsFilter{}).Return([]*model.Segment{
+			{Slug: "CHECKOUT_A", Version: 1, Variant: &evaluation.Variant{
+				Experiment: "CHECKOUT", Weight: 0.5, Ranges: []evaluation.Range{{From: 0, To: 5000}},
+			}},
+			{Slug: "CHECKOUT_B", Version: 2, Variant: &evaluation.Variant{
+				Experiment: "CHECKOUT", Weight: 0.5, Ranges: []evaluation.Range{{From: 5000, To: 10000}},
+			}},
+		}, nil)
+	}, nil)
+	e := setupEvaluator(t, c)
+	ctx := context.Background()
+
+	_, err := e.Variant(1, "CHECKOUT")
+	require.ErrorIs(t, err, ErrSnapshotNotLoaded)
+	require.NoError(t, e.Refresh(ctx))
+
+	counts := map[string]int{}
+	for userID := uint64(0); userID < 1000; userID++ {
+		variant, err := e.Variant(userID, "CHECKOUT")
+		require.NoError(t, err)
+		counts[variant]++
+
+		slugs, err := e.UserSegments(ctx, userID, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{variant}, slugs)
+	}
+	assert.InDelta(t, 500, counts["CHECKOUT_A"], 60)
+	assert.InDelta(t, 500, counts["CHECKOUT_B"], 60)
+
+	variant, err := e.Variant(1, "UNKNOWN")
+	require.NoError(t, err)
+	assert.Empty(t, variant)
+}
+
+func TestEvaluator_RefreshDelta(t *testing.T) {
+	full := 1.0
+	c := setupServer(t, noAuth(), func(db *mock_database.MockIDatabase) {
+		gomock.InOrder(
+			db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(2), nil),
+			db.EXPECT().GetSegmentsVersion(gomock.Any()).Return(uint64(4), nil),
+		)
+		db.EXPECT().ListSegments(gomock.Any(), model.SegmentsFilter{}).Return([]*model.Segment{
+			{Slug: "RU", Version: 1, Rules: countryRule("RU")},
+			{Slug: "EVERYONE", Version: 2, Rollout: &full},
+		}, nil)
+		db.EXPECT().ListSegments(gomock.Any(), model.SegmentsFilter{SinceVersion: 2, WithDeleted: true}).Return([]*model.Segment{
+			{Slug: "RU", Version: 3, Rules: countryRule("RU", "KZ")},
+			{Slug: "EVERYONE", Version: 4, Rollout: &full, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}},
+		}, nil)
+	}, nil)
+	e := setupEvaluator(t, c)
+	ctx := context.Background()
+
+	require.NoError(t, e.Refresh(ctx))
+	slugs, err := e.UserSegments(ctx, 1, evaluation.Attributes{"country": "KZ"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"EVERYONE"}, slugs)
+
+	require.NoError(t, e.Refresh(ctx))
+	assert.Equal(t, uint64(4), e.Version())
+	slugs, err = e.UserSegments(ctx, 1, evaluation.Attributes{"country": "KZ"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"RU"}, slugs)
+}
+
+func TestEvaluator_Run(t *testing.T) {
+	var refreshes atomic.Int32
+	c := setupServer(t, noAuth(), func(db *mock_database.MockIDatabase) {
+		db.EXPECT().GetSegmentsVersion(gomock.Any()).DoAndReturn(func(ctx context.Context) (uint64, error) {
+			if refreshes.Add(1) == 1 {
+				return 0, database.ErrDB
+			}
+			return 1, nil
+		}).MinTimes(2)
+		db.EXPECT().ListSegments(gomock.Any(), gomock.Any()).Return([]*model.Segment{{Slug: "EXPLICIT", Version: 1}}, nil).AnyTimes()
+	}, nil)
+	e := setupEvaluator(t, c)
+
+	var errs atomic.Int32
+	e.cfg.OnError = func(err error) {
+		assert.ErrorIs(t, err, ErrServer)
+		errs.Add(1)
+	}
+	go e.Run()
+	require.Eventually(t, func() bool { return e.Version() == 1 }, time.Second, 5*time.Millisecond)
+	e.Shutdown()
+	assert.Equal(t, int32(1), errs.Load())
+}
+
+func TestNewEvaluator(t *testing.T) {
+	c, err := NewClient(NewConfig("http://localhost"))
+	require.NoError(t, err)
+
+	_, err = NewEvaluator(c, EvaluatorConfig{})
+	require.ErrorIs(t, err, ErrInvalidConfig)
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// RequestHistory starts generation of the user's segments history report for [from, to) dates interval
+// and returns the report file name.
+func (c *Client) RequestHistory(ctx context.Context, userID uint64, from, to time.Time) (string, error) {
+	query := url.Values{}
+	query.Set("from", from.Format(time.DateOnly))
+	query.Set("to", to.Format(time.DateOnly))
+
+	var output struct {
+		Link string `json:"link"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: userPath(userID) + "/csv", query: query}, &output)
+	if err != nil {
+		return "", err
+	}
+
+	filename := path.Base(output.Link)
+	if output.Link == "" || filename == "/" || filename == "." {
+		return "", fmt.Errorf("segments api: unexpected history link %q", output.Link)
+	}
+	return filename, nil
+}
+
+// DownloadHistory writes the report csv to w, ErrNotFound is returned while the report is not ready.
+func (c *Client) DownloadHistory(ctx context.Context, filename string, w io.Writer) error {
+	response, err := c.send(ctx, request{
+		method: http.MethodGet,
+		path:   "/segments/user/history/" + url.PathEscape(filename),
+	})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+// WaitHistory polls the report until it is ready and writes it to w.
+func (c *Client) WaitHistory(ctx context.Context, filename string, w io.Writer) error {
+	ticker := time.NewTicker(c.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := c.DownloadHistory(ctx, filename, w)
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting history %s: %w", filename, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// UserSegmentsHistory requests the user's segments history report for [from, to) dates interval,
+// waits until it is generated and writes it to w.
+func (c *Client) UserSegmentsHistory(ctx context.Context, userID uint64, from, to time.Time, w io.Writer) error {
+	filename, err := c.RequestHistory(ctx, userID, from, to)
+	if err != nil {
+		return err
+	}
+	return c.WaitHistory(ctx, filename, w)
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Permissions of segment grants, write grant implies read.
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+)
+
+// SegmentGrant describes team access to segment, team "*" matches every caller.
+type SegmentGrant struct {
+	Team       string `json:"team"`
+	Permission string `json:"permission"`
+}
+
+// CreateSegmentInput describes new segment. Selection is the part of users [0, 1] the segment is added to.
+type CreateSegmentInput struct {
+	Slug      string         `json:"slug"`
+	Selection *float64       `json:"selection,omitempty"`
+	Grants    []SegmentGrant `json:"grants,omitempty"`
+	Reason    string         `json:"reason,omitempty"`
+}
+
+// CreateSegmentOutput describes created segment.
+type CreateSegmentOutput struct {
+	Slug          string `json:"slug"`
+	AssignedUsers int64  `json:"assigned_users"`
+}
+
+// UserSegmentsInput describes user's segments change.
+type UserSegmentsInput struct {
+	SegmentsToAdd    []string `json:"segments_to_add"`
+	SegmentsToDelete []string `json:"segments_to_delete"`
+	Reason           string   `json:"reason,omitempty"`
+}
+
+// SegmentGrants describes segment ACL.
+type SegmentGrants struct {
+	Slug   string         `json:"slug"`
+	Grants []SegmentGrant `json:"grants"`
+}
+
+// CreateSegment creates the segment.
+func (c *Client) CreateSegment(ctx context.Context, input CreateSegmentInput) (*CreateSegmentOutput, error) {
+	output := &CreateSegmentOutput{}
+	err := c.do(ctx, request{method: http.MethodPost, path: "/segment", body: input}, output)
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// DeleteSegment deletes the segment, its users lose it.
+func (c *Client) DeleteSegment(ctx context.Context, slug string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/segment/" + url.PathEscape(slug)}, nil)
+}
+
+// GetSegmentGrants returns segment ACL.
+func (c *Client) GetSegmentGrants(ctx context.Context, slug string) (*SegmentGrants, error) {
+	output := &SegmentGrants{}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/segment/" + url.PathEscape(slug) + "/acl"}, output)
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// SetSegmentGrants replaces segment ACL.
+func (c *Client) SetSegmentGrants(ctx context.Context, slug string, grants []SegmentGrant, reason string) error {
+	body := struct {
+		Grants []SegmentGrant `json:"grants"`
+		Reason string         `json:"reason,omitempty"`
+	}{Grants: grants, Reason: reason}
+	return c.do(ctx, request{method: http.MethodPut, path: "/segment/" + url.PathEscape(slug) + "/acl", body: body}, nil)
+}
+
+// GetUserSegments returns user's active segments slugs. Segments with rules on user attributes
+// other than user id are not returned, Evaluator evaluates them by the attributes.
+func (c *Client) GetUserSegments(ctx context.Context, userID uint64) ([]string, error) {
+	var slugs []string
+	err := c.do(ctx, request{method: http.MethodGet, path: userPath(userID)}, &slugs)
+	if err != nil {
+		return nil, err
+	}
+	return slugs, nil
+}
+
+// UpdateUserSegments adds and deletes user's segments.
+func (c *Client) UpdateUserSegments(ctx context.Context, userID uint64, input UserSegmentsInput) error {
+	return c.do(ctx, request{method: http.MethodPost, path: userPath(userID), body: input}, nil)
+}
+
+func userPath(userID uint64) string {
+	return "/segments/user/" + strconv.FormatUint(userID, 10)
+}
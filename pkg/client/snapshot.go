@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/unbeman/av-prac-task/pkg/evaluation"
+)
+
+// SegmentDefinition describes segment in the snapshot. Explicit segment users are stored per user
+// and must be requested from the API, users of other segments are evaluated by the definition
+// within the ActiveFrom and ActiveUntil window.
+type SegmentDefinition struct {
+	evaluation.Definition
+	Version     uint64     `json:"version"`
+	Explicit    bool       `json:"explicit"`
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	Deleted     bool       `json:"deleted,omitempty"`
+}
+
+// Active checks if the time is in the segment active window.
+func (d SegmentDefinition) Active(now time.Time) bool {
+	if d.ActiveFrom != nil && now.Before(*d.ActiveFrom) {
+		return false
+	}
+	return d.ActiveUntil == nil || now.Before(*d.ActiveUntil)
+}
+
+// Snapshot describes segments definitions at the Version. Full snapshot contains all active segments,
+// delta contains segments changed since the requested version, deleted ones included.
+type Snapshot struct {
+	Version  uint64              `json:"version"`
+	Full     bool                `json:"full"`
+	Segments []SegmentDefinition `json:"segments"`
+}
+
+// Snapshot returns segments definitions changed since the version, zero version requests full snapshot.
+func (c *Client) Snapshot(ctx context.Context, since uint64) (*Snapshot, error) {
+	query := url.Values{}
+	if since > 0 {
+		query.Set("since", strconv.FormatUint(since, 10))
+	}
+
+	snapshot := &Snapshot{}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/segments/snapshot", query: query}, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,124 @@
+// Package evaluation describes how membership of users in segments defined by rules and rollout is evaluated.
+// The server and the client SDK evaluate segments with it, so they agree on every user.
+package evaluation
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+// Buckets is count of buckets users are distributed to.
+const Buckets = 10000
+
+// UserIDAttribute is the attribute that is always set to the evaluated user id.
+const UserIDAttribute = "user_id"
+
+// Rule operators.
+const (
+	OperatorIn    = "in"
+	OperatorNotIn = "not_in"
+)
+
+var (
+	ErrInvalidRule    = errors.New("invalid rule")
+	ErrInvalidRollout = errors.New("invalid rollout")
+)
+
+// Attributes describes the user, e.g. country or platform, attributes are compared as strings.
+type Attributes map[string]string
+
+// Rule describes condition on user attribute.
+type Rule struct {
+	Attribute string   `json:"attribute"`
+	Operator  string   `json:"operator"`
+	Values    []string `json:"values"`
+}
+
+// Validate checks the rule.
+func (r Rule) Validate() error {
+	if r.Attribute == "" {
+		return fmt.Errorf("%w: attribute is required", ErrInvalidRule)
+	}
+	if r.Operator != OperatorIn && r.Operator != OperatorNotIn {
+		return fmt.Errorf("%w: unknown operator %q, use %s or %s", ErrInvalidRule, r.Operator, OperatorIn, OperatorNotIn)
+	}
+	if len(r.Values) == 0 {
+		return fmt.Errorf("%w: values of %s are required", ErrInvalidRule, r.Attribute)
+	}
+	return nil
+}
+
+// Matches checks the rule on attributes, missing attribute matches only not_in rule.
+func (r Rule) Matches(attrs Attributes) bool {
+	value, ok := attrs[r.Attribute]
+	found := false
+	if ok {
+		for _, v := range r.Values {
+			if v == value {
+				found = true
+				break
+			}
+		}
+	}
+	if r.Operator == OperatorNotIn {
+		return !found
+	}
+	return found
+}
+
+// Definition describes segment with membership evaluated from user attributes.
+// User is in the segment if all rules match, the user's bucket is in the rollout part of users
+// and the user is in the experiment variant if the segment is the one.
+type Definition struct {
+	Slug    string   `json:"slug"`
+	Rollout *float64 `json:"rollout,omitempty"`
+	Rules   []Rule   `json:"rules,omitempty"`
+	Variant *Variant `json:"variant,omitempty"`
+}
+
+// ValidateRollout checks the rollout part of users.
+func ValidateRollout(rollout float64) error {
+	if rollout < 0 || rollout > 1 {
+		return fmt.Errorf("%w: %v must be in [0, 1]", ErrInvalidRollout, rollout)
+	}
+	return nil
+}
+
+// Matches checks if the user is in the segment.
+func (d Definition) Matches(userID uint64, attrs Attributes) bool {
+	if len(d.Rules) > 0 {
+		withID := make(Attributes, len(attrs)+1)
+		for k, v := range attrs {
+			withID[k] = v
+		}
+		withID[UserIDAttribute] = strconv.FormatUint(userID, 10)
+
+		for _, rule := range d.Rules {
+			if !rule.Matches(withID) {
+				return false
+			}
+		}
+	}
+	if d.Variant != nil && !d.Variant.Contains(userID) {
+		return false
+	}
+	return d.Rollout == nil || InRollout(d.Slug, userID, *d.Rollout)
+}
+
+// Bucket returns the stable bucket [0, Buckets) of the user for the seed, e.g. segment slug.
+// Different seeds distribute users independently.
+func Bucket(seed string, userID uint64) int {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	h.Write([]byte{'/'})
+	h.Write([]byte(strconv.FormatUint(userID, 10)))
+	return int(h.Sum64() % Buckets)
+}
+
+// InRollout checks if the user's bucket is in the rollout part [0, 1] of users. Users in the part
+// stay in it when the rollout grows.
+func InRollout(seed string, userID uint64, rollout float64) bool {
+	return Bucket(seed, userID) < int(rollout*Buckets+0.5)
+}
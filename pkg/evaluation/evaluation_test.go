@@ -0,0 +1,106 @@
+package evaluation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rollout(r float64) *float64 {
+	return &r
+}
+
+func TestBucket(t *testing.T) {
+	assert.Equal(t, Bucket("PROMO_5", 42), Bucket("PROMO_5", 42), "bucket must be stable")
+
+	counts := make([]int, 10)
+	for userID := uint64(0); userID < 100000; userID++ {
+		bucket := Bucket("PROMO_5", userID)
+		require.True(t, bucket >= 0 && bucket < Buckets)
+		counts[bucket*10/Buckets]++
+	}
+	for _, count := range counts {
+		assert.InDelta(t, 10000, count, 500, "users must be distributed evenly")
+	}
+}
+
+func TestInRollout(t *testing.T) {
+	in := 0
+	for userID := uint64(0); userID < 10000; userID++ {
+		if InRollout("PROMO_5", userID, 0.2) {
+			in++
+			assert.True(t, InRollout("PROMO_5", userID, 0.5), "users must stay in growing rollout")
+		}
+	}
+	assert.InDelta(t, 2000, in, 200)
+
+	assert.False(t, InRollout("PROMO_5", 1, 0))
+	assert.True(t, InRollout("PROMO_5", 1, 1))
+}
+
+func TestDefinition_Matches(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition Definition
+		userID     uint64
+		attrs      Attributes
+		expected   bool
+	}{
+		{
+			name:       "in rule",
+			definition: Definition{Slug: "RU", Rules: []Rule{{Attribute: "country", Operator: OperatorIn, Values: []string{"RU", "KZ"}}}},
+			attrs:      Attributes{"country": "KZ"},
+			expected:   true,
+		},
+		{
+			name:       "in rule missing attribute",
+			definition: Definition{Slug: "RU", Rules: []Rule{{Attribute: "country", Operator: OperatorIn, Values: []string{"RU"}}}},
+			expected:   false,
+		},
+		{
+			name:       "not in rule missing attribute",
+			definition: Definition{Slug: "NOT_RU", Rules: []Rule{{Attribute: "country", Operator: OperatorNotIn, Values: []string{"RU"}}}},
+			expected:   true,
+		},
+		{
+			name:       "user id rule",
+			definition: Definition{Slug: "STAFF", Rules: []Rule{{Attribute: UserIDAttribute, Operator: OperatorIn, Values: []string{"7", "9"}}}},
+			userID:     9,
+			attrs:      Attributes{UserIDAttribute: "1"},
+			expected:   true,
+		},
+		{
+			name: "all rules must match",
+			definition: Definition{Slug: "RU_IOS", Rules: []Rule{
+				{Attribute: "country", Operator: OperatorIn, Values: []string{"RU"}},
+				{Attribute: "platform", Operator: OperatorIn, Values: []string{"ios"}},
+			}},
+			attrs:    Attributes{"country": "RU", "platform": "android"},
+			expected: false,
+		},
+		{
+			name:       "zero rollout",
+			definition: Definition{Slug: "PROMO_5", Rollout: rollout(0)},
+			expected:   false,
+		},
+		{
+			name:       "full rollout",
+			definition: Definition{Slug: "PROMO_5", Rollout: rollout(1)},
+			expected:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.definition.Matches(tt.userID, tt.attrs))
+		})
+	}
+}
+
+func TestRule_Validate(t *testing.T) {
+	assert.NoError(t, Rule{Attribute: "country", Operator: OperatorIn, Values: []string{"RU"}}.Validate())
+	assert.ErrorIs(t, Rule{Operator: OperatorIn, Values: []string{"RU"}}.Validate(), ErrInvalidRule)
+	assert.ErrorIs(t, Rule{Attribute: "country", Operator: "eq", Values: []string{"RU"}}.Validate(), ErrInvalidRule)
+	assert.ErrorIs(t, Rule{Attribute: "country", Operator: OperatorIn}.Validate(), ErrInvalidRule)
+	assert.ErrorIs(t, ValidateRollout(1.5), ErrInvalidRollout)
+}
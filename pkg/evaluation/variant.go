@@ -0,0 +1,147 @@
+package evaluation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+var ErrInvalidWeights = errors.New("invalid variants weights")
+
+// Range is the part [From, To) of buckets.
+type Range struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// Variant describes the variant of the experiment. Users of the experiment are distributed to buckets
+// with the experiment as seed, the user is in the variant if the bucket is in its ranges.
+// Ranges of variants of the same experiment don't intersect, so the user is in one variant at most.
+type Variant struct {
+	Experiment string  `json:"experiment"`
+	Weight     float64 `json:"weight"`
+	Ranges     []Range `json:"ranges"`
+}
+
+// Contains checks if the user is in the variant.
+func (v Variant) Contains(userID uint64) bool {
+	bucket := Bucket(v.Experiment, userID)
+	for _, r := range v.Ranges {
+		if bucket >= r.From && bucket < r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateWeights checks weights of the experiment variants, every weight is in [0, 1] and their sum is at most 1.
+func ValidateWeights(weights []float64) error {
+	sum := 0.0
+	for _, weight := range weights {
+		if weight < 0 || weight > 1 {
+			return fmt.Errorf("%w: %v must be in [0, 1]", ErrInvalidWeights, weight)
+		}
+		sum += weight
+	}
+	if sum > 1+1e-9 {
+		return fmt.Errorf("%w: sum %v is greater than 1", ErrInvalidWeights, sum)
+	}
+	return nil
+}
+
+// Allocate distributes buckets between variants by weights, current[i] are ranges of the i-th variant,
+// nil for the new one. Variant keeps its current buckets up to its new size and gets free buckets
+// if it grows, so only users of shrunk variants change their variant.
+func Allocate(current [][]Range, weights []float64) ([][]Range, error) {
+	if len(current) != len(weights) {
+		return nil, fmt.Errorf("%w: got %d weights for %d variants", ErrInvalidWeights, len(weights), len(current))
+	}
+	if err := ValidateWeights(weights); err != nil {
+		return nil, err
+	}
+
+	// sizes are rounded cumulatively, so their sum doesn't exceed Buckets
+	sizes := make([]int, len(weights))
+	sum, allocated := 0.0, 0
+	for i, weight := range weights {
+		sum += weight
+		total := int(math.Min(math.Round(sum*Buckets), Buckets))
+		sizes[i] = total - allocated
+		allocated = total
+	}
+
+	used := make([]bool, Buckets)
+	allocation := make([][]Range, len(weights))
+	for i, ranges := range current {
+		allocation[i], sizes[i] = keep(ranges, sizes[i], used)
+	}
+
+	free := freeRanges(used)
+	for i := range allocation {
+		for sizes[i] > 0 && len(free) > 0 {
+			r := free[0]
+			if r.To-r.From > sizes[i] {
+				r.To = r.From + sizes[i]
+				free[0].From = r.To
+			} else {
+				free = free[1:]
+			}
+			allocation[i] = append(allocation[i], r)
+			sizes[i] -= r.To - r.From
+		}
+		allocation[i] = merge(allocation[i])
+	}
+	return allocation, nil
+}
+
+// keep returns at most size buckets of ranges not used by other variants and marks them used,
+// the rest of size is returned too.
+func keep(ranges []Range, size int, used []bool) ([]Range, int) {
+	var kept []Range
+	for _, r := range ranges {
+		for bucket := r.From; bucket < r.To && size > 0; bucket++ {
+			if bucket < 0 || bucket >= Buckets || used[bucket] {
+				continue
+			}
+			used[bucket] = true
+			size--
+			if n := len(kept); n > 0 && kept[n-1].To == bucket {
+				kept[n-1].To++
+			} else {
+				kept = append(kept, Range{From: bucket, To: bucket + 1})
+			}
+		}
+	}
+	return kept, size
+}
+
+// freeRanges returns ordered ranges of unused buckets.
+func freeRanges(used []bool) []Range {
+	var free []Range
+	for bucket, ok := range used {
+		if ok {
+			continue
+		}
+		if n := len(free); n > 0 && free[n-1].To == bucket {
+			free[n-1].To++
+		} else {
+			free = append(free, Range{From: bucket, To: bucket + 1})
+		}
+	}
+	return free
+}
+
+// merge orders ranges and joins adjacent ones.
+func merge(ranges []Range) []Range {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].From < ranges[j].From })
+	var merged []Range
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && merged[n-1].To == r.From {
+			merged[n-1].To = r.To
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
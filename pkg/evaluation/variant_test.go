@@ -0,0 +1,85 @@
+package evaluation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func size(ranges []Range) int {
+	n := 0
+	for _, r := range ranges {
+		n += r.To - r.From
+	}
+	return n
+}
+
+func variants(experiment string, weights []float64, allocation [][]Range) []Variant {
+	out := make([]Variant, len(allocation))
+	for i, ranges := range allocation {
+		out[i] = Variant{Experiment: experiment, Weight: weights[i], Ranges: ranges}
+	}
+	return out
+}
+
+func TestAllocate(t *testing.T) {
+	weights := []float64{0.5, 0.25, 0.25}
+	allocation, err := Allocate(make([][]Range, 3), weights)
+	require.NoError(t, err)
+	assert.Equal(t, [][]Range{{{0, 5000}}, {{5000, 7500}}, {{7500, 10000}}}, allocation)
+
+	counts := make([]int, 3)
+	before := make(map[uint64]int)
+	for userID := uint64(0); userID < 10000; userID++ {
+		in := 0
+		for i, variant := range variants("EXP", weights, allocation) {
+			if variant.Contains(userID) {
+				in++
+				counts[i]++
+				before[userID] = i
+			}
+		}
+		require.Equal(t, 1, in, "user must be in exactly one variant")
+	}
+	assert.InDelta(t, 5000, counts[0], 300)
+	assert.InDelta(t, 2500, counts[1], 300)
+
+	weights = []float64{0.3, 0.35, 0.35}
+	allocation, err = Allocate(allocation, weights)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3000, 3500, 3500}, []int{size(allocation[0]), size(allocation[1]), size(allocation[2])})
+
+	for userID, i := range before {
+		if i == 0 {
+			continue
+		}
+		assert.True(t, variants("EXP", weights, allocation)[i].Contains(userID), "users of grown variants must stay")
+	}
+}
+
+func TestAllocate_Sizes(t *testing.T) {
+	allocation, err := Allocate(make([][]Range, 3), []float64{1.0 / 3, 1.0 / 3, 1.0 / 3})
+	require.NoError(t, err)
+	assert.Equal(t, Buckets, size(allocation[0])+size(allocation[1])+size(allocation[2]))
+
+	// the new variant gets only free buckets
+	allocation, err = Allocate([][]Range{{{0, 5000}}, nil}, []float64{0.5, 0.2})
+	require.NoError(t, err)
+	assert.Equal(t, [][]Range{{{0, 5000}}, {{5000, 7000}}}, allocation)
+
+	// shrunk variant keeps the prefix of its buckets
+	allocation, err = Allocate([][]Range{{{0, 2000}, {5000, 7000}}}, []float64{0.3})
+	require.NoError(t, err)
+	assert.Equal(t, [][]Range{{{0, 2000}, {5000, 6000}}}, allocation)
+}
+
+func TestValidateWeights(t *testing.T) {
+	assert.NoError(t, ValidateWeights([]float64{0.5, 0.5}))
+	assert.NoError(t, ValidateWeights([]float64{0.1, 0.2}))
+	assert.ErrorIs(t, ValidateWeights([]float64{0.6, 0.5}), ErrInvalidWeights)
+	assert.ErrorIs(t, ValidateWeights([]float64{-0.1}), ErrInvalidWeights)
+
+	_, err := Allocate([][]Range{nil}, []float64{0.5, 0.5})
+	assert.ErrorIs(t, err, ErrInvalidWeights)
+}